@@ -0,0 +1,49 @@
+package gostore
+
+import "fmt"
+
+// AccessOp is the kind of access to a key that is authorized.
+type AccessOp int
+
+const (
+	AccessRead   AccessOp = iota // the value of the key is read
+	AccessWrite                  // the key is set or updated
+	AccessDelete                 // the key is deleted
+)
+
+func (op AccessOp) String() string {
+	switch op {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("AccessOp(%d)", int(op))
+}
+
+// Authorizer decides whether a transaction may access key k, given the
+// identity it was begun with (see TransactionOptions). It is called before any
+// lock on k is taken, and must not use the store. Each operation is authorized
+// once for its own op: e.g. Delete is authorized only for AccessDelete.
+type Authorizer func(identity string, op AccessOp, k Key) bool
+
+// authorize returns ErrAccessDenied if the authorizer of the store denies the
+// transaction op on key k. Transactions that are not running are left to fail
+// when their locks are taken.
+func (lm *logManager) authorize(tid TransactionID, op AccessOp, k Key) error {
+	if lm.config.authorizer == nil {
+		return nil
+	}
+	lm.stateLock.Lock()
+	ts, ok := lm.transactions[tid]
+	lm.stateLock.Unlock()
+	if !ok {
+		return nil
+	}
+	if !lm.config.authorizer(ts.identity, op, k) {
+		return fmt.Errorf("%w: %s of key %q by %q", ErrAccessDenied, op, k, ts.identity)
+	}
+	return nil
+}
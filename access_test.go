@@ -0,0 +1,137 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizer(t *testing.T) {
+	authorizer := func(identity string, op AccessOp, k Key) bool {
+		return identity == "admin" || op == AccessRead || !strings.HasPrefix(string(k), "secret/")
+	}
+	s := newStoreForTest(t, WithAuthorizer(authorizer), WithLockTimeout(50*time.Millisecond))
+	secretKey := Key("secret/" + sampleKey1)
+	admin := TransactionOptions{Identity: "admin"}
+	guest := TransactionOptions{Identity: "guest"}
+
+	tid := s.BeginTransactionWithOptions(admin)
+	if err := s.Set(tid, secretKey, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", secretKey, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Reads are allowed, writes to the prefix are denied
+	tid = s.BeginTransactionWithOptions(guest)
+	if v, err := s.Get(tid, secretKey); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", secretKey, err)
+	} else if !bytes.Equal(v, sampleValue1) {
+		t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue1, v)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	denied := map[string]error{
+		"Set":    s.Set(tid, secretKey, CopyByteArray(sampleValue2)),
+		"Delete": s.Delete(tid, secretKey),
+		"Update": s.Update(tid, secretKey, func(old Value) (Value, error) {
+			t.Errorf("found update function called for denied key='%s'", secretKey)
+			return old, nil
+		}),
+		"Rename": s.Rename(tid, secretKey, sampleKey3, false),
+	}
+	_, denied["DeleteIf"] = s.DeleteIf(tid, secretKey, sampleValue1)
+	_, denied["DeletePrefix"] = s.DeletePrefix(tid, "secret/")
+	_, denied["CheckAndSet"] = s.CheckAndSet(tid, map[Key]Value{secretKey: sampleValue1}, nil)
+	for name, err := range denied {
+		if !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("did not get expected error from %s. expected=%v, actual=%v", name, ErrAccessDenied, err)
+		}
+	}
+
+	// Denied operations take no locks, so another transaction can write the key
+	otherKey := Key("secret/" + sampleKey2)
+	if err := s.Set(tid, otherKey, CopyByteArray(sampleValue2)); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("did not get expected error while setting value for key='%s'. expected=%v, actual=%v", otherKey, ErrAccessDenied, err)
+	}
+	if _, err := s.CheckAndSet(tid, map[Key]Value{otherKey: nil}, nil); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("did not get expected error while checking key='%s'. expected=%v, actual=%v", otherKey, ErrAccessDenied, err)
+	}
+	other := s.BeginTransactionWithOptions(admin)
+	if err := s.Set(other, otherKey, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", otherKey, err)
+	}
+	if err := s.Commit(other); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, secretKey, sampleValue1)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+	checkStoreValue(t, s, otherKey, sampleValue3)
+}
+
+func TestAuthorizerDeleteOnly(t *testing.T) {
+	authorizer := func(identity string, op AccessOp, k Key) bool {
+		return identity == "admin" || op != AccessWrite
+	}
+	s := newStoreForTest(t, WithAuthorizer(authorizer), WithLockTimeout(50*time.Millisecond))
+	admin := TransactionOptions{Identity: "admin"}
+	cleaner := TransactionOptions{Identity: "cleaner"}
+	keys := []Key{"a/" + sampleKey1, "a/" + sampleKey2, "a/" + sampleKey3, sampleKey1, sampleKey2}
+
+	tid := s.BeginTransactionWithOptions(admin)
+	for _, k := range keys {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Deletes are authorized only for deleting, even though they write the key
+	tid = s.BeginTransactionWithOptions(cleaner)
+	if err := s.Delete(tid, keys[0]); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", keys[0], err)
+	}
+	if deleted, err := s.DeleteIf(tid, keys[1], sampleValue1); err != nil || !deleted {
+		t.Errorf("did not delete key='%s'. deleted=%v, err=%v", keys[1], deleted, err)
+	}
+	if v, err := s.GetDelete(tid, keys[2]); err != nil || !bytes.Equal(v, sampleValue1) {
+		t.Errorf("did not get expected value while deleting key='%s'. expected=%v, actual=%v, err=%v", keys[2], sampleValue1, v, err)
+	}
+	if n, err := s.DeletePrefix(tid, "a/"); err != nil || n != 0 {
+		t.Errorf("did not get expected number of keys deleted. expected=0, actual=%d, err=%v", n, err)
+	}
+
+	// Denied operations take no locks, so another transaction can write the keys
+	if err := s.Set(tid, keys[3], CopyByteArray(sampleValue2)); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("did not get expected error while setting value for key='%s'. expected=%v, actual=%v", keys[3], ErrAccessDenied, err)
+	}
+	if err := s.Rename(tid, keys[3], keys[4], true); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("did not get expected error while renaming key='%s'. expected=%v, actual=%v", keys[3], ErrAccessDenied, err)
+	}
+	other := s.BeginTransactionWithOptions(admin)
+	for _, k := range keys[3:] {
+		if err := s.Set(other, k, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(other); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	for _, k := range keys[:3] {
+		checkStoreValue(t, s, k, nil)
+	}
+	checkStoreValue(t, s, keys[3], sampleValue3)
+	checkStoreValue(t, s, keys[4], sampleValue3)
+}
@@ -0,0 +1,69 @@
+package gostore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+)
+
+// ExecuteBatch executes the operations of batch request req in order, in one
+// transaction owned by the session, and returns all of their results in one
+// response, so that a client of a server exposing the store needs only one
+// round trip for a transaction of many operations. A new transaction is begun
+// unless req names a running transaction owned by the session, and the
+// transaction is committed once the operations are executed if req asks for
+// it. If an operation fails, the later operations are not executed and the
+// transaction is aborted; the results up to and including that of the failed
+// operation are returned along with the error.
+func (ss *Session) ExecuteBatch(req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	tid := TransactionID(req.GetTid())
+	if req.Tid == nil {
+		var err error
+		if tid, err = ss.Begin(TransactionOptions{}); err != nil {
+			return nil, err
+		}
+	} else if err := ss.checkOwned(tid); err != nil {
+		return nil, err
+	}
+
+	resp := &pb.BatchResponse{Tid: proto.Int64(int64(tid))}
+	for i, op := range req.GetOperation() {
+		result, err := ss.executeBatchOperation(tid, op)
+		resp.Result = append(resp.Result, result)
+		if err != nil {
+			result.Error = proto.String(err.Error())
+			ss.Abort(tid)
+			return resp, fmt.Errorf("operation %d of batch failed: %w", i, err)
+		}
+	}
+	if req.GetCommit() {
+		if err := ss.Commit(tid); err != nil {
+			return resp, fmt.Errorf("could not commit batch: %w", err)
+		}
+		resp.Committed = proto.Bool(true)
+	}
+	return resp, nil
+}
+
+// executeBatchOperation executes operation op of a batch in transaction tid. A
+// key that does not exist is not an error for a GET; its result reports that
+// the key was not found.
+func (ss *Session) executeBatchOperation(tid TransactionID, op *pb.BatchOperation) (*pb.BatchResult, error) {
+	k := Key(op.GetKey())
+	switch op.GetOpType() {
+	case pb.BatchOperation_GET:
+		v, err := ss.s.Get(tid, k)
+		if errors.Is(err, ErrKeyNotFound) {
+			return &pb.BatchResult{Found: proto.Bool(false)}, nil
+		} else if err != nil {
+			return &pb.BatchResult{}, err
+		}
+		return &pb.BatchResult{Value: v, Found: proto.Bool(true)}, nil
+	case pb.BatchOperation_SET:
+		return &pb.BatchResult{}, ss.s.Set(tid, k, op.GetValue())
+	case pb.BatchOperation_DELETE:
+		return &pb.BatchResult{}, ss.s.Delete(tid, k)
+	}
+	return &pb.BatchResult{}, fmt.Errorf("unknown batch operation type %v", op.GetOpType())
+}
@@ -0,0 +1,136 @@
+package gostore
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"testing"
+)
+
+func newBatchOperationForTest(opType pb.BatchOperation_OperationType, k Key, v Value) *pb.BatchOperation {
+	return &pb.BatchOperation{
+		OpType: opType.Enum(),
+		Key:    proto.String(string(k)),
+		Value:  CopyByteArray(v),
+	}
+}
+
+// executeBatchForTest executes batch request req through session ss, passing
+// the request and response through their wire format as a server would.
+func executeBatchForTest(t *testing.T, ss *Session, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("could not marshal batch request: %v", err)
+	}
+	var received pb.BatchRequest
+	if err := proto.Unmarshal(data, &received); err != nil {
+		t.Fatalf("could not unmarshal batch request: %v", err)
+	}
+	resp, execErr := ss.ExecuteBatch(&received)
+	if resp == nil {
+		return nil, execErr
+	}
+	if data, err = proto.Marshal(resp); err != nil {
+		t.Fatalf("could not marshal batch response: %v", err)
+	}
+	var sent pb.BatchResponse
+	if err := proto.Unmarshal(data, &sent); err != nil {
+		t.Fatalf("could not unmarshal batch response: %v", err)
+	}
+	return &sent, execErr
+}
+
+// checkBatchResults checks the results of a batch against the values expected
+// to be read by its operations (nil for a write or a key that is not found).
+func checkBatchResults(t *testing.T, resp *pb.BatchResponse, wantFound []bool, wantValues []Value) {
+	if len(resp.GetResult()) != len(wantFound) {
+		t.Fatalf("did not get expected number of batch results. expected=%d, actual=%d", len(wantFound), len(resp.GetResult()))
+	}
+	for i, result := range resp.GetResult() {
+		if result.GetFound() != wantFound[i] || string(result.GetValue()) != string(wantValues[i]) {
+			t.Errorf("did not get expected result of batch operation %d. expected=(%v, %q), actual=(%v, %q)", i, wantFound[i], wantValues[i], result.GetFound(), result.GetValue())
+		}
+	}
+}
+
+func TestExecuteBatch(t *testing.T) {
+	s := newStoreForTest(t)
+	ss := s.NewSession()
+	defer ss.Close()
+
+	// A mixed batch in a new transaction, whose operations see the earlier ones
+	resp, err := executeBatchForTest(t, ss, &pb.BatchRequest{
+		Operation: []*pb.BatchOperation{
+			newBatchOperationForTest(pb.BatchOperation_SET, sampleKey1, sampleValue1),
+			newBatchOperationForTest(pb.BatchOperation_GET, sampleKey1, nil),
+			newBatchOperationForTest(pb.BatchOperation_GET, sampleKey2, nil),
+			newBatchOperationForTest(pb.BatchOperation_SET, sampleKey2, sampleValue2),
+			newBatchOperationForTest(pb.BatchOperation_DELETE, sampleKey1, nil),
+			newBatchOperationForTest(pb.BatchOperation_GET, sampleKey1, nil),
+			newBatchOperationForTest(pb.BatchOperation_GET, sampleKey2, nil),
+		},
+		Commit: proto.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("got an error while executing batch: %v", err)
+	}
+	checkBatchResults(t, resp,
+		[]bool{false, true, false, false, false, false, true},
+		[]Value{nil, sampleValue1, nil, nil, nil, nil, sampleValue2})
+	if !resp.GetCommitted() {
+		t.Error("found that batch was not committed.")
+	}
+	checkStoreValue(t, s, sampleKey1, nil)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+
+	// Batches in a running transaction, committed by the last one
+	tid, err := ss.Begin(TransactionOptions{})
+	if err != nil {
+		t.Fatalf("got an error while beginning transaction: %v", err)
+	}
+	values := []Value{sampleValue1, sampleValue2}
+	for i, commit := range []bool{false, true} {
+		resp, err := executeBatchForTest(t, ss, &pb.BatchRequest{
+			Tid: proto.Int64(int64(tid)),
+			Operation: []*pb.BatchOperation{
+				newBatchOperationForTest(pb.BatchOperation_SET, sampleKey3, values[i]),
+			},
+			Commit: proto.Bool(commit),
+		})
+		if err != nil {
+			t.Fatalf("got an error while executing batch: %v", err)
+		}
+		if TransactionID(resp.GetTid()) != tid || resp.GetCommitted() != commit {
+			t.Errorf("did not get expected batch response. expected=(%d, %v), actual=(%d, %v)", tid, commit, resp.GetTid(), resp.GetCommitted())
+		}
+	}
+	checkStoreValue(t, s, sampleKey3, values[1])
+
+	// A failed operation stops the batch and aborts the transaction
+	resp, err = executeBatchForTest(t, ss, &pb.BatchRequest{
+		Operation: []*pb.BatchOperation{
+			newBatchOperationForTest(pb.BatchOperation_SET, sampleKey4, sampleValue1),
+			newBatchOperationForTest(pb.BatchOperation_SET, sampleKey1, nil),
+			newBatchOperationForTest(pb.BatchOperation_SET, sampleKey1, sampleValue1),
+		},
+		Commit: proto.Bool(true),
+	})
+	if !errors.Is(err, ErrNilValue) {
+		t.Errorf("did not get expected error while executing batch. expected=%v, actual=%v", ErrNilValue, err)
+	}
+	checkBatchResults(t, resp, []bool{false, false}, []Value{nil, nil})
+	if results := resp.GetResult(); len(results) == 2 && (results[0].Error != nil || results[1].GetError() == "") {
+		t.Errorf("did not get the error of the failed operation only. actual=%v", results)
+	}
+	if resp.GetCommitted() || s.lm.running(TransactionID(resp.GetTid())) {
+		t.Error("found that failed batch was not aborted.")
+	}
+	checkStoreValue(t, s, sampleKey4, nil)
+
+	// Transactions not owned by the session can not be used
+	otherTID := s.BeginTransaction()
+	defer s.Abort(otherTID)
+	if _, err := executeBatchForTest(t, ss, &pb.BatchRequest{Tid: proto.Int64(int64(otherTID))}); !errors.Is(err, ErrTransactionNotOwned) {
+		t.Errorf("did not get expected error while executing batch. expected=%v, actual=%v", ErrTransactionNotOwned, err)
+	}
+}
@@ -0,0 +1,117 @@
+package gostore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchConfigs are the configurations of the write path that the benchmarks
+// are run with: in memory (without any I/O), and with the log flushed to disk
+// with and without syncing, or in the background.
+var benchConfigs = []struct {
+	name string
+	opts []Option
+}{
+	{"memory", []Option{WithInMemory(true)}},
+	{"nofsync", []Option{WithFsync(false)}},
+	{"fsync", nil},
+	{"batched", []Option{WithCommitBatching(100, 10*time.Millisecond)}},
+}
+
+// benchTransactionOptions are the modes of transactions (how they lock and
+// buffer their writes) that the benchmarks are run with.
+var benchTransactionOptions = []struct {
+	name string
+	opts TransactionOptions
+}{
+	{"locking", TransactionOptions{}},
+	{"deferred", deferWritesOptions},
+	{"blind", blindWritesOptions},
+}
+
+// runBenchmarkConfigs runs benchmark fn for each configuration of the write
+// path and mode of transactions, with a fresh store.
+func runBenchmarkConfigs(b *testing.B, fn func(b *testing.B, s *Store, opts TransactionOptions)) {
+	for _, c := range benchConfigs {
+		for _, t := range benchTransactionOptions {
+			b.Run(fmt.Sprintf("%s/%s", c.name, t.name), func(b *testing.B) {
+				dir, err := ioutil.TempDir(testLogDir, "bench_")
+				if err != nil {
+					b.Fatalf("could not create log directory: %v", err)
+				}
+				s, err := NewStore(dir, c.opts...)
+				if err != nil {
+					b.Fatalf("could not create store instance: %v", err)
+				}
+				defer s.Close()
+				b.ReportAllocs()
+				b.ResetTimer()
+				fn(b, s, t.opts)
+			})
+		}
+	}
+}
+
+// setCommitForBenchmark sets key k to v in a new transaction and commits it.
+func setCommitForBenchmark(b *testing.B, s *Store, opts TransactionOptions, k Key, v Value) {
+	tid := s.BeginTransactionWithOptions(opts)
+	if err := s.Set(tid, k, v); err != nil {
+		b.Fatalf("got an error while setting value for key='%s': %v", k, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		b.Fatalf("got an error while committing transaction: %v", err)
+	}
+}
+
+func BenchmarkSetCommit(b *testing.B) {
+	runBenchmarkConfigs(b, func(b *testing.B, s *Store, opts TransactionOptions) {
+		for n := 0; n < b.N; n++ {
+			setCommitForBenchmark(b, s, opts, sampleKey1, sampleValue1)
+		}
+	})
+}
+
+func BenchmarkConcurrentSetCommit(b *testing.B) {
+	runBenchmarkConfigs(b, func(b *testing.B, s *Store, opts TransactionOptions) {
+		var workers int64
+		b.RunParallel(func(pb *testing.PB) {
+			k := Key(fmt.Sprintf("key_%d", atomic.AddInt64(&workers, 1)))
+			for pb.Next() {
+				setCommitForBenchmark(b, s, opts, k, sampleValue1)
+			}
+		})
+	})
+}
+
+func BenchmarkHotKeySetCommit(b *testing.B) {
+	runBenchmarkConfigs(b, func(b *testing.B, s *Store, opts TransactionOptions) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				setCommitForBenchmark(b, s, opts, sampleKey1, sampleValue1)
+			}
+		})
+	})
+}
+
+func BenchmarkSetAbortConfigs(b *testing.B) {
+	keys := make([]Key, 10)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("key_%d", i))
+	}
+	runBenchmarkConfigs(b, func(b *testing.B, s *Store, opts TransactionOptions) {
+		for n := 0; n < b.N; n++ {
+			tid := s.BeginTransactionWithOptions(opts)
+			for _, k := range keys {
+				if err := s.Set(tid, k, sampleValue1); err != nil {
+					b.Fatalf("got an error while setting value for key='%s': %v", k, err)
+				}
+			}
+			if err := s.Abort(tid); err != nil {
+				b.Fatalf("got an error while aborting transaction: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package gostore
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"sort"
+	"sync/atomic"
+)
+
+// A transaction with blind writes buffers its writes like one that defers
+// them, but does not lock the keys it writes until it is committed. At commit,
+// the write locks are taken in key order and the buffered writes are logged
+// and applied as for deferred writes. The locks are held only until the
+// transaction ends, which keeps recovery correct: the store is not modified
+// before the COMMIT entry is flushed, and no other transaction can modify the
+// keys in between.
+
+// stageBlindValue buffers the update of key k to value v with metadata meta (or
+// the deletion of k if v is nil) in transaction ts without taking any locks.
+// If meta is keepMeta, it is resolved when the write is applied.
+func (lm *logManager) stageBlindValue(ts *transactionState, k Key, v Value, meta *pb.Meta) {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	if staged, ok := ts.writeBuffer[k]; ok {
+		ts.memory -= int64(len(k) + len(staged))
+		if meta == keepMeta && staged == nil {
+			// The key was deleted by the transaction
+			meta = nil
+		} else if meta == keepMeta {
+			meta = ts.metaBuffer[k]
+		}
+	}
+	ts.writeBuffer[k] = CopyByteArray(v)
+	ts.metaBuffer[k] = meta
+	ts.memory += int64(len(k) + len(v))
+	ts.modifiedKeys[k] = struct{}{}
+	if smv, ok := lm.store[k]; ok {
+		atomic.AddUint64(&smv.writes, 1)
+		lm.touch(smv)
+	}
+}
+
+// logBlindWrites takes the write locks on the keys written blindly by
+// transaction tid and logs the updates of its buffered writes.
+func (lm *logManager) logBlindWrites(tid TransactionID, ts *transactionState) error {
+	lm.stateLock.Lock()
+	keys := make([]Key, 0, len(ts.writeBuffer))
+	for k := range ts.writeBuffer {
+		keys = append(keys, k)
+	}
+	lm.stateLock.Unlock()
+	_, smvs, err := lm.acquireLocks(tid, keys, true)
+	if err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, k := range keys {
+		smv := smvs[k]
+		lm.stateLock.Lock()
+		e := &pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_UPDATE.Enum(),
+			Key:       proto.String(string(k)),
+			OldValue:  smv.value,
+			NewValue:  ts.writeBuffer[k],
+			OldMeta:   smv.meta,
+			NewMeta:   resolveMeta(ts.metaBuffer[k], smv.meta, ts.writeBuffer[k]),
+		}
+		if ts.bulkLoad {
+			e.OldValue, e.OldMeta = nil, nil
+		}
+		if e.NewValue != nil {
+			e.Version = proto.Uint64(smv.version + 1)
+		}
+		lm.stateLock.Unlock()
+		if err := lm.addTransactionLogEntry(tid, ts, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
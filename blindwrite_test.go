@@ -0,0 +1,81 @@
+package gostore
+
+import (
+	"testing"
+	"time"
+)
+
+var blindWritesOptions = TransactionOptions{BlindWrites: true}
+
+// setWithTimeoutForTest sets the value of a key in a transaction, failing the
+// test if the write blocks.
+func setWithTimeoutForTest(t *testing.T, s *Store, tid TransactionID, k Key, v Value) {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Set(tid, k, CopyByteArray(v))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("found that blind write of key='%s' was blocked.", k)
+	}
+}
+
+func TestBlindWrites(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	// Blind writers to distinct keys do not wait for a reader of those keys
+	readerTID := s.BeginTransaction()
+	if _, err := s.Get(readerTID, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	}
+	tid1 := s.BeginTransactionWithOptions(blindWritesOptions)
+	tid2 := s.BeginTransactionWithOptions(blindWritesOptions)
+	setWithTimeoutForTest(t, s, tid1, sampleKey1, sampleValue2)
+	setWithTimeoutForTest(t, s, tid2, sampleKey2, sampleValue2)
+	checkStoreValue(t, s, sampleKey2, nil)
+	if err := s.Commit(readerTID); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	for _, tid := range []TransactionID{tid2, tid1} {
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue2)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+
+	// The last committed blind writer of a key wins
+	tid1 = s.BeginTransactionWithOptions(blindWritesOptions)
+	tid2 = s.BeginTransactionWithOptions(blindWritesOptions)
+	setWithTimeoutForTest(t, s, tid1, sampleKey1, sampleValue1)
+	setWithTimeoutForTest(t, s, tid2, sampleKey1, sampleValue3)
+	if gotV, err := s.Get(tid1, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	} else if string(gotV) != string(sampleValue1) {
+		t.Errorf("did not get back the buffered value. expected=%v, actual=%v.", sampleValue1, gotV)
+	}
+	for _, tid := range []TransactionID{tid2, tid1} {
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+
+	// Aborted blind writes are discarded
+	tid := s.BeginTransactionWithOptions(blindWritesOptions)
+	setWithTimeoutForTest(t, s, tid, sampleKey1, sampleValue3)
+	setWithTimeoutForTest(t, s, tid, sampleKey3, sampleValue3)
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, sampleValue2)
+		checkStoreValue(t, s, sampleKey3, nil)
+	}
+}
@@ -0,0 +1,207 @@
+package gostore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Values larger than the chunk threshold of the store (see WithChunkStore) are
+// kept out of memory and out of the log, in chunk files named by the SHA-256
+// hash of their contents in the chunk directory of the log directory. The
+// store and the log hold a chunk reference in place of such a value, so the
+// references are restored by recovery and undone like any other value, and
+// the value is read from its chunk file only when it is read. A value that
+// happens to look like a chunk reference is stored as a chunk too, so that it
+// is never mistaken for one. Chunk files are content-addressed, so a value
+// written more than once is stored once; they are removed by collectChunks
+// once neither the store nor the log refers to them.
+
+// chunkDirName is the name of the directory of chunk files in the log
+// directory.
+var chunkDirName = "chunks"
+
+// chunkRefMagic prefixes a chunk reference, which is followed by the SHA-256
+// hash of the value and its size.
+var chunkRefMagic = []byte("\x00gostore-chunk\x00")
+
+// chunkRefSize is the size of a chunk reference.
+var chunkRefSize = len(chunkRefMagic) + sha256.Size + 8
+
+// isChunkRef returns whether v is a chunk reference.
+func isChunkRef(v Value) bool {
+	return len(v) == chunkRefSize && bytes.HasPrefix(v, chunkRefMagic)
+}
+
+// newChunkRef returns the chunk reference of value v.
+func newChunkRef(v Value) Value {
+	hash := sha256.Sum256(v)
+	ref := make(Value, chunkRefSize)
+	n := copy(ref, chunkRefMagic)
+	n += copy(ref[n:], hash[:])
+	binary.BigEndian.PutUint64(ref[n:], uint64(len(v)))
+	return ref
+}
+
+// chunkRefName returns the name of the chunk file of chunk reference ref.
+func chunkRefName(ref Value) string {
+	return hex.EncodeToString(ref[len(chunkRefMagic) : len(chunkRefMagic)+sha256.Size])
+}
+
+// chunkStoreEnabled returns whether large values are stored as chunks.
+func (lm *logManager) chunkStoreEnabled() bool {
+	return lm.config.chunkThreshold > 0 && !lm.config.inMemory
+}
+
+// chunkPath returns the path of the chunk file named name.
+func (lm *logManager) chunkPath(name string) string {
+	return filepath.Join(lm.logDir, chunkDirName, name)
+}
+
+// chunkValue returns the value to be stored and logged for value v being
+// written: the chunk reference of v, once it has been written to its chunk
+// file, if v is to be stored as a chunk, and v itself otherwise. The chunk is
+// protected from collectChunks until release is called, which must be once
+// the reference is in the store or the log.
+func (lm *logManager) chunkValue(v Value) (stored Value, release func(), err error) {
+	if !lm.chunkStoreEnabled() || v == nil || (len(v) <= lm.config.chunkThreshold && !isChunkRef(v)) {
+		return v, func() {}, nil
+	}
+	ref := newChunkRef(v)
+	name := chunkRefName(ref)
+
+	lm.chunkLock.Lock()
+	defer lm.chunkLock.Unlock()
+	if err := lm.writeChunk(name, v); err != nil {
+		return nil, nil, fmt.Errorf("could not store value as chunk: %v", err)
+	}
+	if lm.pendingChunks == nil {
+		lm.pendingChunks = make(map[string]int)
+	}
+	lm.pendingChunks[name]++
+	return ref, func() {
+		lm.chunkLock.Lock()
+		defer lm.chunkLock.Unlock()
+		if lm.pendingChunks[name]--; lm.pendingChunks[name] == 0 {
+			delete(lm.pendingChunks, name)
+		}
+	}, nil
+}
+
+// writeChunk writes chunk file name with contents v, unless it already
+// exists. The chunk file is written under a temporary name and renamed, so
+// that a chunk file is never partially written. It must be called with
+// chunkLock held.
+func (lm *logManager) writeChunk(name string, v Value) error {
+	filename := lm.chunkPath(name)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), lm.config.dirMode); err != nil {
+		return err
+	}
+	tmpFilename := filename + ".tmp"
+	if err := lm.writeLogFile(tmpFilename, v); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	return nil
+}
+
+// resolveChunk returns the value of stored value v: the contents of its chunk
+// file if v is a chunk reference, and v itself otherwise.
+func (lm *logManager) resolveChunk(v Value) (Value, error) {
+	if !lm.chunkStoreEnabled() || !isChunkRef(v) {
+		return v, nil
+	}
+	name := chunkRefName(v)
+	data, err := ioutil.ReadFile(lm.chunkPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not read chunk %s: %v", name, err)
+	}
+	if !bytes.Equal(newChunkRef(data), v) {
+		return nil, fmt.Errorf("chunk %s is corrupted", name)
+	}
+	return data, nil
+}
+
+// resolveChunks replaces the stored values in values with their values (see
+// resolveChunk). A value whose chunk can not be read is left as it is stored,
+// and the first such error is returned.
+func (lm *logManager) resolveChunks(values map[Key]Value) error {
+	var firstErr error
+	for k, v := range values {
+		resolved, err := lm.resolveChunk(v)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		values[k] = resolved
+	}
+	return firstErr
+}
+
+// collectChunks removes the chunk files that are referred to neither by the
+// store (including the write buffers of running transactions) nor by the log,
+// nor by writes in progress, and returns the number of chunk files removed.
+func (lm *logManager) collectChunks() (int, error) {
+	if !lm.chunkStoreEnabled() {
+		return 0, nil
+	}
+	lm.chunkLock.Lock()
+	defer lm.chunkLock.Unlock()
+
+	referenced := make(map[string]struct{}, len(lm.pendingChunks))
+	for name := range lm.pendingChunks {
+		referenced[name] = struct{}{}
+	}
+	refer := func(v Value) {
+		if isChunkRef(v) {
+			referenced[chunkRefName(v)] = struct{}{}
+		}
+	}
+	lm.logLock.Lock()
+	for _, e := range lm.log.Entry {
+		refer(e.OldValue)
+		refer(e.NewValue)
+	}
+	lm.logLock.Unlock()
+	lm.stateLock.Lock()
+	for _, smv := range lm.store {
+		refer(smv.value)
+	}
+	for _, ts := range lm.transactions {
+		for _, v := range ts.writeBuffer {
+			refer(v)
+		}
+	}
+	lm.stateLock.Unlock()
+
+	files, err := ioutil.ReadDir(filepath.Join(lm.logDir, chunkDirName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not collect chunks: %v", err)
+	}
+	removed := 0
+	for _, file := range files {
+		if _, ok := referenced[file.Name()]; ok {
+			continue
+		}
+		if err := os.Remove(lm.chunkPath(file.Name())); err != nil {
+			return removed, fmt.Errorf("could not collect chunks: %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
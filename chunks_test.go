@@ -0,0 +1,107 @@
+package gostore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunkFilesForTest returns the names of the chunk files of store s.
+func chunkFilesForTest(t *testing.T, s *Store) []string {
+	files, err := ioutil.ReadDir(filepath.Join(s.lm.logDir, chunkDirName))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("could not list chunk files: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+func TestChunkStore(t *testing.T) {
+	threshold := 1024
+	s := newStoreForTest(t, WithChunkStore(threshold))
+	large1 := bytes.Repeat([]byte("large value 1 "), 1<<14)
+	large2 := bytes.Repeat([]byte("large value 2 "), 1<<14)
+	setForTest(t, s, sampleKey1, large1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	checkStoreValue(t, s, sampleKey1, large1)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+
+	// Only a reference to the large value is held in memory and in the log
+	if stats := s.Stats(); stats.ValueBytes > int64(2*threshold) {
+		t.Errorf("found large value held in memory. value bytes=%d", stats.ValueBytes)
+	}
+	if v := s.lm.store[sampleKey1].value; !isChunkRef(v) {
+		t.Errorf("did not find chunk reference in store for key='%s'. actual=%d bytes", sampleKey1, len(v))
+	}
+	for _, e := range s.lm.log.Entry {
+		if len(e.NewValue) > threshold {
+			t.Errorf("found large value in log entry with LSN %d", e.GetLsn())
+		}
+	}
+	if names := chunkFilesForTest(t, s); len(names) != 1 {
+		t.Errorf("did not get expected number of chunk files. expected=1, actual=%d", len(names))
+	}
+
+	// Values are read through every interface
+	tid := s.BeginTransaction()
+	if v, err := s.GetRange(tid, sampleKey1, 14, 13); err != nil || string(v) != "large value 1" {
+		t.Errorf("did not get expected range of chunked value. expected=%q, actual=%q (err=%v)", "large value 1", v, err)
+	}
+	if err := s.Set(tid, sampleKey2, large2); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if ops, err := s.TransactionLog(tid); err != nil || len(ops) != 1 || !bytes.Equal(ops[0].NewValue, large2) {
+		t.Errorf("did not get chunked value from transaction log (err=%v)", err)
+	}
+	s.Abort(tid)
+	if ops, err := s.TransactionLog(tid); err != nil || len(ops) != 2 || !bytes.Equal(ops[1].OldValue, large2) {
+		t.Errorf("did not get chunked value from transaction log of aborted transaction (err=%v)", err)
+	}
+	if values, _, err := s.SnapshotRead([]Key{sampleKey1}); err != nil || !bytes.Equal(values[sampleKey1], large1) {
+		t.Errorf("did not get chunked value from snapshot read (err=%v)", err)
+	}
+
+	// An aborted overwrite restores the reference to the old chunk
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, large2); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, large1)
+
+	// A small value that looks like a chunk reference is not mistaken for one
+	fakeRef := newChunkRef(large2)
+	setForTest(t, s, sampleKey3, fakeRef)
+	checkStoreValue(t, s, sampleKey3, fakeRef)
+
+	// Recovery restores the references
+	s = reopenStoreForTest(t, s, WithChunkStore(threshold))
+	if v := s.lm.store[sampleKey1].value; !isChunkRef(v) {
+		t.Errorf("did not recover chunk reference for key='%s'. actual=%d bytes", sampleKey1, len(v))
+	}
+	checkStoreValue(t, s, sampleKey1, large1)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+	checkStoreValue(t, s, sampleKey3, fakeRef)
+
+	// Chunks are collected once the log no longer refers to them
+	setForTest(t, s, sampleKey1, large2)
+	if removed, err := s.CollectChunks(); err != nil || removed != 0 {
+		t.Errorf("found that chunks referred to by the log were collected. removed=%d (err=%v)", removed, err)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	// The chunks of large2 and of the fake reference remain
+	if names := chunkFilesForTest(t, s); len(names) != 2 {
+		t.Errorf("did not get expected number of chunk files after compaction. expected=2, actual=%d", len(names))
+	}
+	checkStoreValue(t, s, sampleKey1, large2)
+	checkStoreValue(t, reopenStoreForTest(t, s, WithChunkStore(threshold)), sampleKey1, large2)
+}
@@ -0,0 +1,21 @@
+package gostore
+
+import "time"
+
+// Clock is the source of time of a store: the timestamps of log entries, the
+// ages of transactions, lock timeouts and the latencies recorded in metrics are
+// all read from it, rather than from the time package, so that they can be
+// tested deterministically. Implementations must be safe for concurrent use.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep pauses the calling goroutine for at least d.
+	Sleep(d time.Duration)
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
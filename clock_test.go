@@ -0,0 +1,86 @@
+package gostore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when it is advanced, or when a
+// goroutine sleeps (which advances it instead of waiting).
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.advance(d)
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClockTimestamps(t *testing.T) {
+	clock := newFakeClock()
+	s := newStoreForTest(t, WithClock(clock))
+	start := clock.Now()
+	setForTest(t, s, sampleKey1, sampleValue1)
+	for _, e := range s.lm.log.Entry {
+		if e.GetTimestamp() != start.UnixNano() {
+			t.Errorf("did not get expected timestamp of log entry with LSN %d. expected=%d, actual=%d", e.GetLsn(), start.UnixNano(), e.GetTimestamp())
+		}
+	}
+
+	tid := s.BeginTransaction()
+	clock.advance(time.Hour)
+	gotTID, age, ok := s.OldestActiveTransaction()
+	if !ok || gotTID != tid {
+		t.Errorf("did not get expected oldest transaction. expected=%d, actual=%d", tid, gotTID)
+	}
+	if age != time.Hour {
+		t.Errorf("did not get expected age of transaction. expected=%v, actual=%v", time.Hour, age)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if last := s.lm.log.Entry[len(s.lm.log.Entry)-1]; last.GetTimestamp() != start.Add(time.Hour).UnixNano() {
+		t.Errorf("did not get expected timestamp of log entry. expected=%d, actual=%d", start.Add(time.Hour).UnixNano(), last.GetTimestamp())
+	}
+	s.Commit(tid)
+}
+
+func TestClockLockTimeout(t *testing.T) {
+	clock := newFakeClock()
+	s := newStoreForTest(t, WithClock(clock), WithLockTimeout(time.Hour))
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	// The hour-long timeout elapses on the fake clock, without waiting for it
+	start := clock.Now()
+	other := s.BeginTransaction()
+	if _, err := s.Get(other, sampleKey1); !errors.Is(err, ErrTimeout) {
+		t.Errorf("did not get expected error while getting locked key='%s'. expected=%v, actual=%v", sampleKey1, ErrTimeout, err)
+	}
+	if waited := clock.Now().Sub(start); waited != time.Hour {
+		t.Errorf("did not get expected time waited for lock. expected=%v, actual=%v", time.Hour, waited)
+	}
+	s.Abort(other)
+	s.Commit(tid)
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"github.com/mDibyo/gostore"
 	"fmt"
+	"flag"
 )
 
 func main() {
+	flag.Parse()
+
 	k := "a"
 	v := []byte{0, 1, 2, 1, 0}
 	tid := gostore.NewTransaction()
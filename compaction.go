@@ -0,0 +1,278 @@
+package gostore
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Compaction rewrites the log as a single transaction that sets the latest
+// committed value of each key, followed by the entries of the transactions
+// that have not ended. The compacted log is first written to a file whose name
+// is prefixed with compactedLogPrefix. Once that file exists, it supersedes the
+// other log files in the log directory, which are removed before it is renamed
+// to a regular log file name. An interrupted compaction is finished when the
+// store is next opened. The entries of the compacted log are numbered on from
+// the LSNs of the log it replaces, so that LSNs are never reused; the LSNs
+// before them refer to entries that have been removed.
+
+// compactedLogPrefix prefixes the name of the log file written by a
+// compaction until it replaces the other log files.
+var compactedLogPrefix = "compacted_"
+
+// minCompactionLogLength is the minimum number of entries in the log for it to
+// be compacted automatically.
+var minCompactionLogLength = 100
+
+// compactLogEntries returns the entries of a compacted log equivalent to
+// entries, in which the committed values of keys are set by transaction tid,
+// and the new LSN of each entry of a transaction that has not ended. The
+// entries of the compacted log have LSNs from firstLSN onward. entries are not
+// modified.
+func compactLogEntries(entries []*pb.LogEntry, firstLSN int64, tid TransactionID, timestamp int64) ([]*pb.LogEntry, map[int64]int64) {
+	analysis := analyzeLogEntries(entries)
+	var ended, running []*pb.LogEntry
+	for _, e := range entries {
+		if analysis[TransactionID(e.GetTid())].ended {
+			ended = append(ended, e)
+		} else {
+			running = append(running, e)
+		}
+	}
+	committed := make(storeMap)
+	redoLogEntries(ended, analysis, committed, nil)
+	keys := make([]string, 0, len(committed))
+	for k := range committed {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+
+	compacted := make([]*pb.LogEntry, 0, len(keys)+len(running)+3)
+	addEntry := func(e *pb.LogEntry) {
+		e.Lsn = proto.Int64(firstLSN + int64(len(compacted)))
+		e.Timestamp = proto.Int64(timestamp)
+		compacted = append(compacted, e)
+	}
+	addEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_BEGIN.Enum(),
+	})
+	for _, k := range keys {
+		addEntry(&pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_UPDATE.Enum(),
+			Key:       proto.String(k),
+			NewValue:  committed[Key(k)].value,
+			NewMeta:   committed[Key(k)].meta,
+			Version:   proto.Uint64(committed[Key(k)].version),
+		})
+	}
+	addEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_COMMIT.Enum(),
+	})
+	addEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_END.Enum(),
+	})
+
+	lsns := make(map[int64]int64)
+	for _, e := range running {
+		lsns[e.GetLsn()] = firstLSN + int64(len(compacted))
+		e = proto.Clone(e).(*pb.LogEntry)
+		e.Lsn = proto.Int64(lsns[e.GetLsn()])
+		if e.UndoLsn != nil {
+			e.UndoLsn = proto.Int64(lsns[e.GetUndoLsn()])
+		}
+		compacted = append(compacted, e)
+	}
+	return compacted, lsns
+}
+
+// compact compacts the log and replaces the log files with the compacted log.
+// Tombstones and chunks are collected once it is done, since the log no longer
+// records the deletes and overwritten values.
+func (lm *logManager) compact() (err error) {
+	defer func() {
+		if err == nil {
+			lm.collectTombstones()
+			lm.collectChunks()
+		}
+	}()
+	lm.mergeLock.Lock()
+	defer lm.mergeLock.Unlock()
+	lm.flushLock.Lock()
+	defer lm.flushLock.Unlock()
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+
+	entries := lm.log.GetEntry()
+	firstLSN := lm.nextLSN
+	compacted, lsns := compactLogEntries(entries, int64(firstLSN), lm.nextTransactionID(), lm.lastTimestamp)
+	if !lm.config.inMemory {
+		if err := lm.writeCompactedLog(compacted); err != nil {
+			return err
+		}
+	}
+
+	// The compacted log supersedes the old log from here on. The entries of
+	// running transactions are renumbered in place, since their states refer
+	// to them.
+	for _, e := range entries {
+		lsn, ok := lsns[e.GetLsn()]
+		if !ok {
+			continue
+		}
+		if e.UndoLsn != nil {
+			e.UndoLsn = proto.Int64(lsns[e.GetUndoLsn()])
+		}
+		e.Lsn = proto.Int64(lsn)
+		compacted[lsn-int64(firstLSN)] = e
+	}
+	lm.log.Entry = compacted
+	lm.index = newLogIndex(compacted)
+	lm.firstLSN = firstLSN
+	lm.nextLSN = firstLSN + len(compacted)
+	lm.nextLSNToFlush = lm.nextLSN
+	lm.compactions++
+	lm.signalLogFlushedUnsafe()
+	lm.compactPending = true
+	return lm.finishCompaction()
+}
+
+// writeCompactedLog writes out the entries of a compacted log as the log file
+// that supersedes the other log files.
+func (lm *logManager) writeCompactedLog(compacted []*pb.LogEntry) error {
+	data, err := lm.marshalLogEntries(compacted)
+	if err != nil {
+		return fmt.Errorf("error while marshalling compacted log: %v", err)
+	}
+	startLSN := int(compacted[0].GetLsn())
+	filename := compactedLogPrefix + fmt.Sprintf(lm.config.logFileFmt, startLSN, startLSN+len(compacted)-1)
+	filename = fmt.Sprintf("%s/%s", lm.logDir, filename)
+	tmpFilename := filename + ".tmp"
+	if err := lm.writeLogFile(tmpFilename, data); err != nil {
+		return fmt.Errorf("error while writing out compacted log: %v", err)
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error while writing out compacted log: %v", err)
+	}
+	return nil
+}
+
+// maybeCompact compacts the log if automatic compaction is enabled and the
+// fraction of log entries that would be removed by compacting it (estimated
+// from the number of keys in the store) exceeds the configured threshold.
+// Errors are ignored, since the log remains valid if a compaction fails.
+func (lm *logManager) maybeCompact() {
+	if lm.config.compactionThreshold <= 0 {
+		return
+	}
+	lm.stateLock.Lock()
+	live := lm.numKeys
+	lm.stateLock.Unlock()
+	lm.logLock.Lock()
+	n := len(lm.log.Entry)
+	lm.logLock.Unlock()
+	if n < minCompactionLogLength {
+		return
+	}
+	if superseded := 1 - float64(live+3)/float64(n); superseded > lm.config.compactionThreshold {
+		lm.compact()
+	}
+}
+
+// finishCompaction replaces the log files in the log directory with the log
+// file written by a compaction, if there is one. It must be called with
+// flushLock held, or before the log is retrieved.
+func (lm *logManager) finishCompaction() error {
+	if lm.config.inMemory {
+		lm.compactPending = false
+		return nil
+	}
+	files, err := ioutil.ReadDir(lm.logDir)
+	if err != nil {
+		return fmt.Errorf("could not finish compaction: %v", err)
+	}
+	var compacted string
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, compactedLogPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// Left behind by an interrupted compaction
+			os.Remove(fmt.Sprintf("%s/%s", lm.logDir, name))
+			continue
+		}
+		compacted = name
+	}
+	if compacted == "" {
+		lm.compactPending = false
+		return nil
+	}
+	startLSN, endLSN, ok := parseLogFileNameFmt(strings.TrimPrefix(compacted, compactedLogPrefix), lm.config.logFileFmt)
+	if !ok {
+		return fmt.Errorf("could not finish compaction: log file %s was not in the expected format", compacted)
+	}
+
+	logFiles, err := lm.logFiles()
+	if err != nil {
+		return fmt.Errorf("could not finish compaction: %v", err)
+	}
+	for _, file := range logFiles {
+		filename := fmt.Sprintf("%s/%s", lm.logDir, file.path)
+		if err := os.Remove(filename); err != nil {
+			return fmt.Errorf("could not finish compaction: %v", err)
+		}
+		if dir := filepath.Dir(file.path); dir != "." {
+			// Remove the shard directory once it is empty
+			os.Remove(fmt.Sprintf("%s/%s", lm.logDir, dir))
+		}
+	}
+	filename := fmt.Sprintf("%s/%s", lm.logDir, compacted)
+	newFilename := fmt.Sprintf("%s/%s", lm.logDir, lm.logFilePath(startLSN, endLSN))
+	if lm.config.shardSize > 0 {
+		if err := os.MkdirAll(filepath.Dir(newFilename), lm.config.dirMode); err != nil {
+			return fmt.Errorf("could not finish compaction: %v", err)
+		}
+	}
+	if err := os.Rename(filename, newFilename); err != nil {
+		return fmt.Errorf("could not finish compaction: %v", err)
+	}
+	lm.compactPending = false
+	lm.segmentCount = 1
+	return nil
+}
+
+// collectTombstones removes the values of deleted keys (and of keys that were
+// locked but never set) from the store, unless a running transaction refers
+// to them.
+func (lm *logManager) collectTombstones() {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	referenced := make(map[Key]struct{})
+	for _, cm := range lm.currMutexes {
+		cm.forEach(func(k Key, _ *rwMutexWrapper) {
+			referenced[k] = struct{}{}
+		})
+	}
+	for k, smv := range lm.store {
+		if smv.value != nil {
+			continue
+		}
+		if _, ok := referenced[k]; ok {
+			continue
+		}
+		if smv.deleted {
+			lm.tombstones--
+		}
+		delete(lm.store, k)
+	}
+}
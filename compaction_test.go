@@ -0,0 +1,249 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayLogDirForTest replays the log files in dir, returning the number of
+// log files, the entries of the log and the store that it describes.
+func replayLogDirForTest(t *testing.T, dir string) (int, []*pb.LogEntry, map[Key]Value) {
+	files, err := readLogDirForTest(dir)
+	if err != nil {
+		t.Fatalf("could not read log directory: %v", err)
+	}
+	var data []byte
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), compactedLogPrefix) {
+			t.Errorf("found compacted log file %s in log directory.", file.Name())
+		}
+		d, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatalf("could not read log file: %v", err)
+		}
+		data = append(data, d...)
+	}
+	entries, store, err := ReplayLog(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("got an error while replaying log: %v", err)
+	}
+	return len(files), entries, store
+}
+
+// overwriteForTest sets the values of keys k1 and k2 n times each.
+func overwriteForTest(t *testing.T, s *Store, n int, k1, k2 Key) {
+	for i := 0; i < n; i++ {
+		tid := s.BeginTransaction()
+		for _, k := range []Key{k1, k2} {
+			if err := s.Set(tid, k, Value(fmt.Sprintf("%s_%d", k, i))); err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			}
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	s := newStoreForTest(t)
+	overwriteForTest(t, s, 50, sampleKey1, sampleKey2)
+	setForTest(t, s, sampleKey3, sampleValue3)
+	tid := s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Transactions running during the compaction
+	committedTID := s.BeginTransaction()
+	if err := s.Set(committedTID, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Set(committedTID, sampleKey4, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey4, err)
+	}
+	abortedTID := s.BeginTransaction()
+	if err := s.Set(abortedTID, sampleKey2, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	s.lm.flushLog()
+
+	_, wantEntries, wantStore := replayLogDirForTest(t, s.lm.logDir)
+	firstLSN := s.lm.nextLSN
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	gotFiles, gotEntries, gotStore := replayLogDirForTest(t, s.lm.logDir)
+	if gotFiles != 1 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", 1, gotFiles)
+	}
+	if len(gotEntries) >= len(wantEntries) {
+		t.Errorf("found that compacted log was not shorter. before=%d, after=%d", len(wantEntries), len(gotEntries))
+	}
+	if !reflect.DeepEqual(gotStore, wantStore) {
+		t.Errorf("did not get the expected store. expected=%v, actual=%v", wantStore, gotStore)
+	}
+	// LSNs are not reused
+	for i, e := range s.lm.log.Entry {
+		if e.GetLsn() != int64(firstLSN+i) {
+			t.Errorf("did not get expected LSN for log entry. expected=%d, actual=%d", firstLSN+i, e.GetLsn())
+		}
+	}
+
+	if err := s.Abort(abortedTID); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	if err := s.Commit(committedTID); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	wantValues := map[Key]Value{
+		sampleKey1: sampleValue3,
+		sampleKey2: Value(fmt.Sprintf("%s_%d", sampleKey2, 49)),
+		sampleKey3: nil,
+		sampleKey4: sampleValue1,
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		for k, v := range wantValues {
+			checkStoreValue(t, s, k, v)
+		}
+	}
+}
+
+func TestCompactionThreshold(t *testing.T) {
+	s := newStoreForTest(t, WithCompactionThreshold(0.5))
+	overwriteForTest(t, s, 100, sampleKey1, sampleKey2)
+
+	if gotLenLog := len(s.lm.log.Entry); gotLenLog >= minCompactionLogLength {
+		t.Errorf("found that log was not compacted. length=%d", gotLenLog)
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 99)))
+		checkStoreValue(t, s, sampleKey2, Value(fmt.Sprintf("%s_%d", sampleKey2, 99)))
+	}
+}
+
+func TestCompactOnClose(t *testing.T) {
+	s := newStoreForTest(t, WithCompactOnClose(true))
+	overwriteForTest(t, s, 50, sampleKey1, sampleKey2)
+	if err := s.Close(); err != nil {
+		t.Fatalf("got an error while closing store: %v", err)
+	}
+
+	gotFiles, gotEntries, _ := replayLogDirForTest(t, s.lm.logDir)
+	if gotFiles != 1 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", 1, gotFiles)
+	}
+	// BEGIN, two UPDATE entries, COMMIT and END
+	if len(gotEntries) != 5 {
+		t.Errorf("did not get expected number of log entries. expected=%d, actual=%d", 5, len(gotEntries))
+	}
+
+	// The directory lock was released after compacting the log
+	reopened, err := NewStore(s.lm.logDir)
+	if err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	defer reopened.Close()
+	if info := reopened.RecoveryInfo(); info.Segments != 1 || info.Entries != len(gotEntries) {
+		t.Errorf("did not get expected recovery info. expected=%d segments and %d entries, actual=%+v", 1, len(gotEntries), info)
+	}
+	checkStoreValue(t, reopened, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 49)))
+	checkStoreValue(t, reopened, sampleKey2, Value(fmt.Sprintf("%s_%d", sampleKey2, 49)))
+}
+
+func TestInterruptedCompaction(t *testing.T) {
+	s := newStoreForTest(t)
+	overwriteForTest(t, s, 10, sampleKey1, sampleKey2)
+
+	// Write out a compacted log without replacing the log files
+	firstLSN := s.lm.nextLSN
+	compacted, _ := compactLogEntries(s.lm.log.Entry, int64(firstLSN), s.lm.nextTransactionID(), 0)
+	data, err := proto.Marshal(&pb.Log{Entry: compacted})
+	if err != nil {
+		t.Fatalf("could not marshal log: %v", err)
+	}
+	filename := compactedLogPrefix + fmt.Sprintf(logFileFmt, firstLSN, firstLSN+len(compacted)-1)
+	for _, filename := range []string{filename, filename + ".tmp"} {
+		if err := ioutil.WriteFile(filepath.Join(s.lm.logDir, filename), data, 0644); err != nil {
+			t.Fatalf("could not write log file: %v", err)
+		}
+	}
+
+	s = reopenStoreForTest(t, s)
+	if gotLenLog := len(s.lm.log.Entry); gotLenLog != len(compacted) {
+		t.Errorf("did not get expected log length. expected=%d, actual=%d", len(compacted), gotLenLog)
+	}
+	if gotFiles, _, _ := replayLogDirForTest(t, s.lm.logDir); gotFiles != 1 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", 1, gotFiles)
+	}
+	checkStoreValue(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 9)))
+	checkStoreValue(t, s, sampleKey2, Value(fmt.Sprintf("%s_%d", sampleKey2, 9)))
+}
+
+func TestCompactionLSNs(t *testing.T) {
+	s := newStoreForTest(t)
+	overwriteForTest(t, s, 10, sampleKey1, sampleKey2)
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	lsn, err := s.CommitWithLSN(tid)
+	if err != nil {
+		t.Fatalf("got an error while committing transaction: %v", err)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+
+	// The entries before the compaction are durable, and their LSNs are not
+	// reused
+	if err := s.WaitDurable(lsn); err != nil {
+		t.Errorf("got an error while waiting for log entry to be durable: %v", err)
+	}
+	if _, err := s.GetAsOf(sampleKey3, lsn); !errors.Is(err, ErrCompacted) {
+		t.Errorf("did not get expected error while getting value as of LSN %d. expected=%v, actual=%v", lsn, ErrCompacted, err)
+	}
+	c, cancel := s.LogTail(lsn)
+	defer cancel()
+	select {
+	case e, ok := <-c:
+		if ok {
+			t.Errorf("got a log entry from log tail from compacted LSN: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("found that log tail from compacted LSN was not closed.")
+	}
+	// including once the store is reopened
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			s = reopenStoreForTest(t, s)
+		}
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+		}
+		nextLSN, err := s.CommitWithLSN(tid)
+		if err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		if nextLSN <= lsn {
+			t.Errorf("found that LSN was reused after compaction. before=%d, after=%d", lsn, nextLSN)
+		}
+		if v, err := s.GetAsOf(sampleKey3, nextLSN); err != nil || !bytes.Equal(v, sampleValue1) {
+			t.Errorf("did not get back the correct value for key='%s' as of LSN %d. expected=%v, actual=%v (err=%v)", sampleKey3, nextLSN, sampleValue1, v, err)
+		}
+		lsn = nextLSN
+	}
+}
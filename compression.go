@@ -0,0 +1,55 @@
+package gostore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// compressedLogMagic prefixes the contents of compressed log files. It can not
+// be the start of a marshalled log, since it does not begin with a valid
+// protobuf tag.
+var compressedLogMagic = []byte("GSZ1")
+
+// compressLogData compresses marshalled log data to be written to a log file.
+func compressLogData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(compressedLogMagic)
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressLogData returns the marshalled log data in the contents of a log
+// file, decompressing it if required. The contents of several log files
+// concatenated together are decompressed file by file, as long as the
+// compressed files precede any uncompressed ones.
+func decompressLogData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressedLogMagic) {
+		return data, nil
+	}
+	var decompressed []byte
+	for bytes.HasPrefix(data, compressedLogMagic) {
+		br := bytes.NewReader(data[len(compressedLogMagic):])
+		r, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress log data: %v", err)
+		}
+		// Stop at the end of the compressed data of this file.
+		r.Multistream(false)
+		d, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress log data: %v", err)
+		}
+		decompressed = append(decompressed, d...)
+		data = data[len(data)-br.Len():]
+	}
+	return append(decompressed, data...), nil
+}
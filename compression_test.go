@@ -0,0 +1,60 @@
+package gostore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressLogData(t *testing.T) {
+	data := bytes.Repeat(sampleValue1, 100)
+	compressed, err := compressLogData(data)
+	if err != nil {
+		t.Fatalf("got an error while compressing log data: %v", err)
+	}
+	if !bytes.HasPrefix(compressed, compressedLogMagic) {
+		t.Error("did not find magic header in compressed log data.")
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("found that compressed log data was not smaller. original=%d, compressed=%d", len(data), len(compressed))
+	}
+
+	for _, d := range [][]byte{compressed, data} {
+		if gotData, err := decompressLogData(d); err != nil {
+			t.Errorf("got an error while decompressing log data: %v", err)
+		} else if !bytes.Equal(gotData, data) {
+			t.Errorf("did not get back the original log data. expected=%v, actual=%v", data, gotData)
+		}
+	}
+
+	corrupt := append(CopyByteArray(compressedLogMagic), 1, 2, 3)
+	if _, err := decompressLogData(corrupt); err == nil {
+		t.Error("did not get an error while decompressing corrupt log data.")
+	}
+}
+
+func TestCompressedLogRecovery(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	s = reopenStoreForTest(t, s, WithLogCompression(true))
+	filesBefore, _ := readLogDirForTest(s.lm.logDir)
+	setForTest(t, s, sampleKey2, bytes.Repeat(sampleValue2, 100))
+	filesAfter, _ := readLogDirForTest(s.lm.logDir)
+	if len(filesAfter) != len(filesBefore)+1 {
+		t.Fatalf("did not get expected number of log files. expected=%d, actual=%d", len(filesBefore)+1, len(filesAfter))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.lm.logDir, filesAfter[len(filesAfter)-1].Name()))
+	if err != nil {
+		t.Fatalf("could not read log file: %v", err)
+	}
+	if !bytes.HasPrefix(data, compressedLogMagic) {
+		t.Error("found that log file was not compressed.")
+	}
+
+	// Both compressed and uncompressed log files are recovered
+	s = reopenStoreForTest(t, s)
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	checkStoreValue(t, s, sampleKey2, bytes.Repeat(sampleValue2, 100))
+}
@@ -0,0 +1,99 @@
+package gostore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+)
+
+// With delta encoding, the new value of an UPDATE or UNDO entry is written to
+// log files as a delta from its old value, when that is shorter than the new
+// value itself. Entries are delta-encoded only when they are written out, and
+// decoded as soon as they are read back, so the entries of the log in memory
+// always hold full values.
+//
+// A delta is the length of the prefix and of the suffix that the new value
+// shares with the old value (as uvarints), followed by the bytes of the new
+// value between them.
+
+// errInvalidDelta is returned when a delta can not be applied to a value.
+var errInvalidDelta = errors.New("invalid delta")
+
+// encodeDelta returns the delta that turns oldValue into newValue.
+func encodeDelta(oldValue, newValue []byte) []byte {
+	prefix := 0
+	for prefix < len(oldValue) && prefix < len(newValue) && oldValue[prefix] == newValue[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldValue)-prefix && suffix < len(newValue)-prefix &&
+		oldValue[len(oldValue)-1-suffix] == newValue[len(newValue)-1-suffix] {
+		suffix++
+	}
+
+	middle := newValue[prefix : len(newValue)-suffix]
+	delta := make([]byte, 0, 2*binary.MaxVarintLen64+len(middle))
+	delta = binary.AppendUvarint(delta, uint64(prefix))
+	delta = binary.AppendUvarint(delta, uint64(suffix))
+	return append(delta, middle...)
+}
+
+// applyDelta returns the value that delta turns oldValue into.
+func applyDelta(oldValue, delta []byte) ([]byte, error) {
+	prefix, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, errInvalidDelta
+	}
+	delta = delta[n:]
+	suffix, n := binary.Uvarint(delta)
+	if n <= 0 || prefix+suffix > uint64(len(oldValue)) {
+		return nil, errInvalidDelta
+	}
+	middle := delta[n:]
+
+	newValue := make([]byte, 0, int(prefix)+len(middle)+int(suffix))
+	newValue = append(newValue, oldValue[:prefix]...)
+	newValue = append(newValue, middle...)
+	return append(newValue, oldValue[uint64(len(oldValue))-suffix:]...), nil
+}
+
+// deltaEncodeLogEntries returns the entries to be written to a log file in
+// place of entries, in which the new value of each entry is replaced by a
+// delta from its old value if the delta is shorter. entries are not modified.
+func deltaEncodeLogEntries(entries []*pb.LogEntry) []*pb.LogEntry {
+	encoded := make([]*pb.LogEntry, len(entries))
+	for i, e := range entries {
+		encoded[i] = e
+		if e.OldValue == nil || e.NewValue == nil {
+			continue
+		}
+		delta := encodeDelta(e.OldValue, e.NewValue)
+		if len(delta) >= len(e.NewValue) {
+			continue
+		}
+		e = proto.Clone(e).(*pb.LogEntry)
+		e.NewValue = nil
+		e.NewValueDelta = delta
+		encoded[i] = e
+	}
+	return encoded
+}
+
+// deltaDecodeLogEntries restores the new values of delta-encoded entries read
+// from a log file in place.
+func deltaDecodeLogEntries(entries []*pb.LogEntry) error {
+	for _, e := range entries {
+		if e.NewValueDelta == nil {
+			continue
+		}
+		newValue, err := applyDelta(e.OldValue, e.NewValueDelta)
+		if err != nil {
+			return fmt.Errorf("could not decode new value of log entry with LSN %d: %v", e.GetLsn(), err)
+		}
+		e.NewValue = newValue
+		e.NewValueDelta = nil
+	}
+	return nil
+}
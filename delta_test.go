@@ -0,0 +1,120 @@
+package gostore
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	long := bytes.Repeat([]byte("0123456789"), 10)
+	tests := []struct {
+		oldValue, newValue []byte
+	}{
+		{[]byte{}, []byte{}},
+		{[]byte{}, []byte("value")},
+		{[]byte("value"), []byte{}},
+		{[]byte("value"), []byte("value")},
+		{[]byte("value"), []byte("valve")},
+		{[]byte("aaaa"), []byte("aaaaaa")},
+		{[]byte("aaaaaa"), []byte("aaaa")},
+		{long, append(CopyByteArray(long), 'x')},
+		{long, append([]byte("x"), long...)},
+		{long, append(append(CopyByteArray(long[:50]), "xyz"...), long[50:]...)},
+		{long, []byte("something else entirely")},
+	}
+	for _, test := range tests {
+		delta := encodeDelta(test.oldValue, test.newValue)
+		gotValue, err := applyDelta(test.oldValue, delta)
+		if err != nil {
+			t.Errorf("got an error while applying delta: %v", err)
+		} else if !bytes.Equal(gotValue, test.newValue) {
+			t.Errorf("did not get expected value. old=%q, expected=%q, actual=%q", test.oldValue, test.newValue, gotValue)
+		}
+	}
+	if delta := encodeDelta(long, append(CopyByteArray(long), 'x')); len(delta) >= 10 {
+		t.Errorf("found that delta of small change was not small. length=%d", len(delta))
+	}
+
+	for _, delta := range [][]byte{{}, {0x80}, {1}, {5, 5}} {
+		if _, err := applyDelta([]byte("value"), delta); err == nil {
+			t.Errorf("did not get an error while applying invalid delta %v.", delta)
+		}
+	}
+}
+
+func TestDeltaEncodedLogRecovery(t *testing.T) {
+	s := newStoreForTest(t, WithDeltaEncoding(true))
+	value := bytes.Repeat(sampleValue1, 100)
+	setForTest(t, s, sampleKey1, value)
+	for i := 0; i < 5; i++ {
+		value = append(CopyByteArray(value), byte('0'+i))
+		setForTest(t, s, sampleKey1, value)
+	}
+	setForTest(t, s, sampleKey2, sampleValue2)
+	// UNDO entries are delta-encoded too
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, append(CopyByteArray(value), 'x')); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// Log files hold deltas in place of new values
+	files, err := readLogDirForTest(s.lm.logDir)
+	if err != nil {
+		t.Fatalf("could not read log directory: %v", err)
+	}
+	deltas := map[pb.LogEntry_LogEntryType]int{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(s.lm.logDir, file.Name()))
+		if err != nil {
+			t.Fatalf("could not read log file: %v", err)
+		}
+		var log pb.Log
+		if err := proto.Unmarshal(data, &log); err != nil {
+			t.Fatalf("could not unmarshal log file: %v", err)
+		}
+		for _, e := range log.Entry {
+			if e.NewValueDelta != nil {
+				if e.NewValue != nil {
+					t.Errorf("found new value in delta-encoded log entry. actual=(%+v)", e)
+				}
+				deltas[e.GetEntryType()]++
+			}
+		}
+	}
+	if deltas[pb.LogEntry_UPDATE] != 6 || deltas[pb.LogEntry_UNDO] != 1 {
+		t.Errorf("did not get expected number of delta-encoded log entries. actual=%v", deltas)
+	}
+
+	// Recovery reconstructs identical entries, with or without the option
+	wantEntries := s.lm.log.Entry
+	_, gotEntries, gotStore := replayLogDirForTest(t, s.lm.logDir)
+	if !reflect.DeepEqual(gotEntries, wantEntries) {
+		t.Errorf("did not get expected log entries after replaying log. expected=%v, actual=%v", wantEntries, gotEntries)
+	}
+	if !bytes.Equal(gotStore[sampleKey1], value) {
+		t.Errorf("did not get expected value after replaying log. expected=%q, actual=%q", value, gotStore[sampleKey1])
+	}
+	s = reopenStoreForTest(t, s)
+	if !reflect.DeepEqual(s.lm.log.Entry, wantEntries) {
+		t.Errorf("did not get expected log entries after recovery. expected=%v, actual=%v", wantEntries, s.lm.log.Entry)
+	}
+	checkStoreValue(t, s, sampleKey1, value)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+
+	// Compacted logs are written with the option too
+	s = reopenStoreForTest(t, s, WithDeltaEncoding(true))
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	s = reopenStoreForTest(t, s)
+	checkStoreValue(t, s, sampleKey1, value)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+}
@@ -0,0 +1,150 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// A store holds a lock on its log directory while it is open, so that two
+// stores can not both append log files to the same directory and corrupt each
+// other's logs. The lock is an advisory lock on a lock file in the directory,
+// which records the ID of the process holding it, so that a store opened in
+// another process fails with ErrDirectoryInUse. The lock is released by the
+// operating system if the process exits without closing the store, and the
+// lock file left behind is then stolen by the next store to open the
+// directory, which reports it in its RecoveryInfo. Advisory locks do not
+// exclude the stores open in the same process, so the directories they have
+// locked are also recorded, and a second store opened over one of them fails
+// with ErrDirectoryLocked.
+
+// lockFileName is the name of the lock file in a log directory. It is not a
+// log file name, so it is ignored when the log files are listed.
+var lockFileName = "LOCK"
+
+// lockedDirs holds the log managers of the stores open in this process, by
+// the absolute paths of the log directories they have locked.
+var lockedDirs = struct {
+	lock sync.Mutex
+	dirs map[string]*logManager
+}{dirs: make(map[string]*logManager)}
+
+// lockDir locks the log directory for the store, failing with
+// ErrDirectoryLocked if another store open in this process has it locked, and
+// with ErrDirectoryInUse if a store in another process does.
+func (lm *logManager) lockDir() error {
+	if lm.config.inMemory {
+		return nil
+	}
+	dir, err := filepath.Abs(lm.logDir)
+	if err != nil {
+		return fmt.Errorf("could not lock log directory: %v", err)
+	}
+	lockedDirs.lock.Lock()
+	defer lockedDirs.lock.Unlock()
+	if _, ok := lockedDirs.dirs[dir]; ok {
+		return fmt.Errorf("%w: %s", ErrDirectoryLocked, lm.logDir)
+	}
+
+	f, err := lm.openLockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return err
+	}
+	lockedDirs.dirs[dir] = lm
+	lm.lockedDir = dir
+	lm.lockFile = f
+	return nil
+}
+
+// openLockFile opens and locks lock file filename, and records the ID of this
+// process in it. A lock file left behind by a process that exited without
+// closing its store is stolen.
+func (lm *logManager) openLockFile(filename string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, lm.config.fileMode)
+		if err != nil {
+			return nil, fmt.Errorf("could not lock log directory: %v", err)
+		}
+		owner, err := ioutil.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock log directory: %v", err)
+		}
+		owner = bytes.TrimSpace(owner)
+		locked, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock log directory: %v", err)
+		}
+		if !locked {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s (locked by process %s)", ErrDirectoryInUse, lm.logDir, owner)
+		}
+		// The store holding the lock may have removed the lock file while
+		// closing, before it was locked here.
+		if held, err := f.Stat(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock log directory: %v", err)
+		} else if current, err := os.Stat(filename); err != nil || !os.SameFile(held, current) {
+			f.Close()
+			continue
+		}
+
+		// A lock file that records an owner was left behind by a process
+		// that exited without closing its store.
+		lm.recovery.StaleLockOwner = string(owner)
+		if err := lm.writeLockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock log directory: %v", err)
+		}
+		return f, nil
+	}
+}
+
+// writeLockFile records the ID of this process in locked lock file f.
+func (lm *logManager) writeLockFile(f *os.File) error {
+	if err := f.Chmod(lm.config.fileMode); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// unlockDir releases the lock on the log directory, if the store holds it.
+func (lm *logManager) unlockDir() {
+	lockedDirs.lock.Lock()
+	defer lockedDirs.lock.Unlock()
+	if lm.lockedDir == "" {
+		return
+	}
+	if lockedDirs.dirs[lm.lockedDir] == lm {
+		os.Remove(filepath.Join(lm.lockedDir, lockFileName))
+		delete(lockedDirs.dirs, lm.lockedDir)
+	}
+	if lm.lockFile != nil {
+		lm.lockFile.Close()
+		lm.lockFile = nil
+	}
+	lm.lockedDir = ""
+}
+
+// checkDirLocked returns ErrDirectoryLocked if the store no longer holds the
+// lock on its log directory, e.g. since another store has stolen it.
+func (lm *logManager) checkDirLocked() error {
+	if lm.config.inMemory {
+		return nil
+	}
+	lockedDirs.lock.Lock()
+	defer lockedDirs.lock.Unlock()
+	if lm.lockedDir == "" || lockedDirs.dirs[lm.lockedDir] != lm {
+		return fmt.Errorf("%w: store no longer holds the lock on %s", ErrDirectoryLocked, lm.logDir)
+	}
+	return nil
+}
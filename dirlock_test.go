@@ -0,0 +1,126 @@
+package gostore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readLogDirForTest lists the files in log directory dir, other than its lock
+// file.
+func readLogDirForTest(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	var logFiles []os.FileInfo
+	for _, file := range files {
+		if file.Name() != lockFileName {
+			logFiles = append(logFiles, file)
+		}
+	}
+	return logFiles, err
+}
+
+func TestDirectoryLock(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	if _, err := os.Stat(filepath.Join(s.lm.logDir, lockFileName)); err != nil {
+		t.Errorf("did not find lock file in log directory: %v", err)
+	}
+
+	// A second store over the same directory (however it is named) fails
+	for _, dir := range []string{s.lm.logDir, filepath.Join(s.lm.logDir, ".")} {
+		if _, err := NewStore(dir); !errors.Is(err, ErrDirectoryLocked) {
+			t.Errorf("did not get expected error while opening locked directory %s. expected=%v, actual=%v", dir, ErrDirectoryLocked, err)
+		}
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// The directory can be opened once the store is closed
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.lm.logDir, lockFileName)); !os.IsNotExist(err) {
+		t.Errorf("found lock file in log directory after store was closed: %v", err)
+	}
+	reopened, err := NewStore(s.lm.logDir)
+	if err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	checkStoreValue(t, reopened, sampleKey1, sampleValue1)
+	// Closing the first store again does not unlock the directory
+	s.Close()
+	if _, err := NewStore(s.lm.logDir); !errors.Is(err, ErrDirectoryLocked) {
+		t.Errorf("did not get expected error while opening locked directory. expected=%v, actual=%v", ErrDirectoryLocked, err)
+	}
+	reopened.Close()
+
+	// In-memory stores do not lock their directories
+	for i := 0; i < 2; i++ {
+		if _, err := NewStore(s.lm.logDir, WithInMemory(true)); err != nil {
+			t.Errorf("got an error while opening in-memory store: %v", err)
+		}
+	}
+}
+
+func TestDefaultDir(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "default_")
+	if err != nil {
+		t.Fatalf("could not create log directory for store: %v", err)
+	}
+	s, err := NewStore("", WithDefaultDir(dir))
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	if s.lm.logDir != dir {
+		t.Errorf("did not get expected log directory. expected=%s, actual=%s", dir, s.lm.logDir)
+	}
+	setForTest(t, s, sampleKey1, sampleValue1)
+	s.Close()
+
+	s, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	s.Close()
+}
+
+func TestStaleDirectoryLock(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "stale_")
+	if err != nil {
+		t.Fatalf("could not create log directory for store: %v", err)
+	}
+	// A lock file left behind by a process that crashed
+	filename := filepath.Join(dir, lockFileName)
+	if err := ioutil.WriteFile(filename, []byte("12345\n"), 0644); err != nil {
+		t.Fatalf("could not create lock file: %v", err)
+	}
+
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not create store instance over stale lock: %v", err)
+	}
+	owner, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("could not read lock file: %v", err)
+	}
+	if expected := fmt.Sprintf("%d\n", os.Getpid()); string(owner) != expected {
+		t.Errorf("did not get expected lock file contents. expected=%q, actual=%q", expected, owner)
+	}
+	if info := s.RecoveryInfo(); info.StaleLockOwner != "12345" {
+		t.Errorf("did not get expected owner of stale lock. expected=%q, actual=%q", "12345", info.StaleLockOwner)
+	}
+	setForTest(t, s, sampleKey1, sampleValue1)
+	s.Close()
+
+	// The lock of a closed store is not stale
+	if s, err = NewStore(dir); err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	if info := s.RecoveryInfo(); info.StaleLockOwner != "" {
+		t.Errorf("found owner of stale lock after store was closed. owner=%q", info.StaleLockOwner)
+	}
+	s.Close()
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package gostore
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on file f without blocking,
+// returning false if another process holds it. The lock is released when f is
+// closed, including when the process exits.
+func lockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
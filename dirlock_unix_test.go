@@ -0,0 +1,42 @@
+//go:build unix
+
+package gostore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryInUse(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "in_use_")
+	if err != nil {
+		t.Fatalf("could not create log directory for store: %v", err)
+	}
+	// Lock the directory as a store in another process would
+	f, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("could not create lock file: %v", err)
+	}
+	f.WriteString("12345\n")
+	if locked, err := lockFile(f); !locked {
+		t.Fatalf("could not lock lock file: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := NewStore(dir); !errors.Is(err, ErrDirectoryInUse) {
+			t.Errorf("did not get expected error while opening directory in use. expected=%v, actual=%v", ErrDirectoryInUse, err)
+		}
+	}
+
+	// The lock is released when the other process exits
+	f.Close()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	setForTest(t, s, sampleKey1, sampleValue1)
+	s.Close()
+}
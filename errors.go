@@ -0,0 +1,79 @@
+package gostore
+
+import "errors"
+
+// Errors returned by store operations.
+var (
+	// ErrInvalidKey is returned when a key is rejected by key validation.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrKeyNotFound is returned when a key does not exist in the store.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrNilValue is returned when a key is set to a nil value. Keys are
+	// deleted with Delete; an empty value is set with Value{}.
+	ErrNilValue = errors.New("value is nil")
+	// ErrInvalidValue is returned when a value can not be converted to the
+	// requested type, e.g. by Value.Int.
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrKeyExists is returned when a key that should not exist already
+	// exists in the store.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrTransactionFinished is returned when a Txn is used after it has been
+	// committed or aborted.
+	ErrTransactionFinished = errors.New("transaction has already been committed or aborted")
+	// ErrTooManyTransactions is returned when a transaction can not be begun
+	// because the maximum number of transactions are running.
+	ErrTooManyTransactions = errors.New("too many running transactions")
+	// ErrVersionMismatch is returned when a conditional update finds that a
+	// key does not have the expected version.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrStorageUnavailable is returned when the log can not be flushed to
+	// storage (for example, because the disk is full). Once it is returned,
+	// writes fail with it until the log can be flushed again.
+	ErrStorageUnavailable = errors.New("storage unavailable")
+	// ErrReadOnly is returned when a store is written to after it has degraded
+	// to read-only, because its log directory became unwritable. Writes fail
+	// with it until Store.ResumeWrites succeeds.
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrDeadlock is returned when a transaction can not take a lock because
+	// doing so would deadlock with other transactions. The transaction should
+	// be aborted and retried.
+	ErrDeadlock = errors.New("deadlock")
+	// ErrTimeout is returned when a transaction times out waiting for a lock
+	// (see WithLockTimeout). The transaction should be aborted and retried.
+	ErrTimeout = errors.New("timed out waiting for lock")
+	// ErrRecoveryConflict is returned when a store can not be opened because
+	// an entry of its log conflicts with the state of the store recovered
+	// from the entries before it.
+	ErrRecoveryConflict = errors.New("log entry conflicts with recovered state")
+	// ErrStoreClosed is returned when a store is used after it has been
+	// closed.
+	ErrStoreClosed = errors.New("store is closed")
+	// ErrRangeOutOfBounds is returned when a range of a value does not lie
+	// within the value.
+	ErrRangeOutOfBounds = errors.New("range out of bounds")
+	// ErrTransactionTooLarge is returned when a transaction would modify more
+	// keys than allowed (see WithMaxTransactionKeys).
+	ErrTransactionTooLarge = errors.New("transaction too large")
+	// ErrReadOnlyReplica is returned when a replica is written to.
+	ErrReadOnlyReplica = errors.New("replica is read-only")
+	// ErrDirectoryLocked is returned when a store is opened over a log
+	// directory that another store open in this process has locked, or when
+	// a transaction is begun on a store that no longer holds the lock on its
+	// log directory.
+	ErrDirectoryLocked = errors.New("log directory is locked by another store")
+	// ErrDirectoryInUse is returned when a store is opened over a log
+	// directory that a store in another process has locked.
+	ErrDirectoryInUse = errors.New("log directory is in use by another process")
+	// ErrTransactionNotOwned is returned when a transaction is used through a
+	// session other than the one that began it.
+	ErrTransactionNotOwned = errors.New("transaction not owned by session")
+	// ErrSessionClosed is returned when a session is used after it has been
+	// closed.
+	ErrSessionClosed = errors.New("session is closed")
+	// ErrAccessDenied is returned when the authorizer of a store denies a
+	// transaction access to a key (see WithAuthorizer).
+	ErrAccessDenied = errors.New("access denied")
+	// ErrCompacted is returned when an entry of the log is requested by an
+	// LSN from before the last compaction, which removed the entry.
+	ErrCompacted = errors.New("log entry has been compacted")
+)
@@ -0,0 +1,101 @@
+package gostore
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// EvictionPolicy chooses the keys that are evicted when a store has more keys
+// than its limit (see WithMaxKeys).
+type EvictionPolicy int
+
+const (
+	EvictLRU    EvictionPolicy = iota // evict the least recently accessed keys
+	EvictRandom                       // evict keys at random
+)
+
+// touch records an access to the key whose value in the store is smv, for
+// choosing keys to evict.
+func (lm *logManager) touch(smv *storeMapValue) {
+	atomic.StoreUint64(&smv.lastAccess, atomic.AddUint64(&lm.accessClock, 1))
+}
+
+// evictionCandidates returns the keys with values in the store, in the order
+// in which they should be evicted. It must be called with stateLock held.
+func (lm *logManager) evictionCandidates() []Key {
+	keys := make([]Key, 0, lm.numKeys)
+	for k, smv := range lm.store {
+		if smv.value != nil {
+			keys = append(keys, k)
+		}
+	}
+	switch lm.config.eviction {
+	case EvictRandom:
+		rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	default:
+		lastAccess := make(map[Key]uint64, len(keys))
+		for _, k := range keys {
+			lastAccess[k] = atomic.LoadUint64(&lm.store[k].lastAccess)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lastAccess[keys[i]] < lastAccess[keys[j]] })
+	}
+	return keys
+}
+
+// maybeEvict deletes keys, chosen by the eviction policy, if the store has
+// more keys than its limit. The keys are deleted in a transaction of their
+// own, which skips keys that are locked by other transactions rather than
+// waiting for them. Errors are ignored, since the store remains valid if an
+// eviction fails.
+func (lm *logManager) maybeEvict() {
+	if lm.config.maxKeys <= 0 {
+		return
+	}
+	lm.stateLock.Lock()
+	excess := lm.numKeys - lm.config.maxKeys
+	var candidates []Key
+	if excess > 0 {
+		candidates = lm.evictionCandidates()
+	}
+	lm.stateLock.Unlock()
+	if excess <= 0 {
+		return
+	}
+
+	// The eviction transaction does not take an admission slot, since it
+	// does not wait for locks.
+	tid := lm.nextTransactionID()
+	lm.startTransaction(tid, newTransactionState(TransactionOptions{}, lm.config.clock.Now()))
+	evicted := 0
+	for _, k := range candidates {
+		if evicted == excess {
+			break
+		}
+		lm.stateLock.Lock()
+		smv, ok := lm.store[k]
+		if !ok || smv.value == nil {
+			lm.stateLock.Unlock()
+			continue
+		}
+		rw := lm.currMutexes[tid].getWrappedRWMutex(k, smv)
+		lm.stateLock.Unlock()
+		if !rw.tryWLock() {
+			continue
+		}
+		if smv.value == nil {
+			// Deleted before the lock was taken
+			continue
+		}
+		if err := lm.updateValue(tid, k, nil); err != nil {
+			lm.abortTransaction(tid)
+			return
+		}
+		evicted++
+	}
+	if evicted == 0 {
+		lm.abortTransaction(tid)
+		return
+	}
+	lm.commitTransaction(tid)
+}
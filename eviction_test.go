@@ -0,0 +1,65 @@
+package gostore
+
+import (
+	"testing"
+)
+
+func TestEvictLRU(t *testing.T) {
+	s := newStoreForTest(t, WithMaxKeys(3, EvictLRU))
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		setForTest(t, s, k, sampleValue1)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// The least recently accessed key is evicted
+	setForTest(t, s, sampleKey4, sampleValue1)
+	wantValues := map[Key]Value{
+		sampleKey1: sampleValue1,
+		sampleKey2: nil,
+		sampleKey3: sampleValue1,
+		sampleKey4: sampleValue1,
+	}
+	for k, v := range wantValues {
+		checkStoreValue(t, s, k, v)
+	}
+
+	// Keys locked by running transactions are spared
+	tid := s.BeginTransaction()
+	if _, err := s.Get(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey3, err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	checkStoreValue(t, s, sampleKey4, sampleValue1)
+	setForTest(t, s, sampleKey5, sampleValue1)
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	wantValues = map[Key]Value{
+		sampleKey1: nil,
+		sampleKey2: nil,
+		sampleKey3: sampleValue1,
+		sampleKey4: sampleValue1,
+		sampleKey5: sampleValue1,
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		if stats := s.Stats(); stats.Keys != 3 {
+			t.Errorf("did not get expected number of keys. expected=%d, actual=%d", 3, stats.Keys)
+		}
+		for k, v := range wantValues {
+			checkStoreValue(t, s, k, v)
+		}
+	}
+}
+
+func TestEvictRandom(t *testing.T) {
+	s := newStoreForTest(t, WithMaxKeys(2, EvictRandom))
+	keys := []Key{sampleKey1, sampleKey2, sampleKey3, sampleKey4, sampleKey5}
+	for _, k := range keys {
+		setForTest(t, s, k, sampleValue1)
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		if stats := s.Stats(); stats.Keys != 2 {
+			t.Errorf("did not get expected number of keys. expected=%d, actual=%d", 2, stats.Keys)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package gostore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flusher flushes the log in the background when commits are batched.
+type flusher struct {
+	lock     sync.Mutex    // lock to synchronize access to commits and err
+	commits  int           // the number of commits since the log was last flushed by the flusher
+	err      error         // the first error encountered while flushing the log
+	requests chan struct{} // requests to flush the log
+	done     chan struct{} // closed when the flusher has stopped
+}
+
+func newFlusher() *flusher {
+	return &flusher{
+		requests: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// runFlusher flushes the log whenever the flusher is requested to, and at the
+// configured interval, until stopping is closed.
+func (lm *logManager) runFlusher(stopping <-chan struct{}) {
+	f := lm.flusher
+	defer close(f.done)
+
+	var ticks <-chan time.Time
+	if lm.config.batchInterval > 0 {
+		ticker := time.NewTicker(lm.config.batchInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-f.requests:
+		case <-ticks:
+		case <-stopping:
+			return
+		}
+		f.lock.Lock()
+		f.commits = 0
+		f.lock.Unlock()
+		if err := lm.flushLog(); err != nil {
+			f.lock.Lock()
+			if f.err == nil {
+				f.err = err
+			}
+			f.lock.Unlock()
+		}
+	}
+}
+
+// flushCommit makes a commit durable by flushing the log. If commits are
+// batched, it only requests the flusher to flush the log once enough commits
+// are unflushed.
+func (lm *logManager) flushCommit() error {
+	f := lm.flusher
+	if f == nil {
+		return lm.flushLog()
+	}
+	f.lock.Lock()
+	f.commits++
+	full := lm.config.batchCommits > 0 && f.commits >= lm.config.batchCommits
+	f.lock.Unlock()
+	if full {
+		select {
+		case f.requests <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// sync flushes the log up to its last entry, whether or not commits are
+// batched, and returns once the entries have been written out (and synced, if
+// enabled).
+func (lm *logManager) sync() error {
+	if f := lm.flusher; f != nil {
+		f.lock.Lock()
+		f.commits = 0
+		f.lock.Unlock()
+	}
+	return lm.flushLog()
+}
+
+// waitDurable waits until the entry with LSN lsn has been flushed. If the log
+// is compacted in the meantime, every entry added before the compaction has
+// been flushed with the compacted log, whose entries have greater LSNs, so it
+// stops waiting.
+func (lm *logManager) waitDurable(lsn int64) error {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	if lsn >= int64(lm.nextLSN) {
+		return fmt.Errorf("log entry with LSN %d has not been added to the log", lsn)
+	}
+	for int64(lm.nextLSNToFlush) <= lsn {
+		flushed := lm.logFlushed
+		lm.logLock.Unlock()
+		<-flushed
+		lm.logLock.Lock()
+	}
+	return nil
+}
+
+// close stops the goroutines working in the background (including the
+// flusher, if any) and flushes the tail of the log. It returns any error
+// encountered while flushing the log in the background. If enabled, the log is
+// then compacted, before the lock on the log directory is released.
+func (lm *logManager) close() error {
+	defer lm.unlockDir()
+	lm.logLock.Lock()
+	lm.closed = true
+	lm.logLock.Unlock()
+	lm.maintenance.stop()
+	if err := lm.flushLog(); err != nil {
+		return err
+	}
+	if f := lm.flusher; f != nil {
+		f.lock.Lock()
+		err := f.err
+		f.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if lm.config.compactOnClose && !lm.config.inMemory {
+		return lm.compact()
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package gostore
+
+import (
+	"testing"
+	"time"
+)
+
+// unflushedForTest returns whether any part of the log of s is unflushed.
+func unflushedForTest(s *Store) bool {
+	s.lm.logLock.Lock()
+	defer s.lm.logLock.Unlock()
+	return s.lm.nextLSNToFlush != s.lm.nextLSN
+}
+
+// waitForFlushForTest waits for the log of s to be flushed in the background.
+func waitForFlushForTest(t *testing.T, s *Store) {
+	for deadline := time.Now().Add(time.Second); unflushedForTest(s); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("found that log was not flushed in the background.")
+		}
+	}
+}
+
+func TestCommitBatching(t *testing.T) {
+	// Flushed after a number of commits
+	s := newStoreForTest(t, WithCommitBatching(3, 0))
+	for _, k := range []Key{sampleKey1, sampleKey2} {
+		setForTest(t, s, k, sampleValue1)
+	}
+	if !unflushedForTest(s) {
+		t.Error("found that log was flushed before enough commits.")
+	}
+	setForTest(t, s, sampleKey3, sampleValue1)
+	waitForFlushForTest(t, s)
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+
+	// Flushed at an interval
+	s = newStoreForTest(t, WithCommitBatching(0, 10*time.Millisecond))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	waitForFlushForTest(t, s)
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+	checkStoreValue(t, reopenStoreForTest(t, s), sampleKey1, sampleValue1)
+}
+
+func TestCloseFlushesLog(t *testing.T) {
+	for _, opts := range [][]Option{
+		nil,
+		{WithCommitBatching(100, 0)},
+	} {
+		s := newStoreForTest(t, opts...)
+		setForTest(t, s, sampleKey1, sampleValue1)
+		setForTest(t, s, sampleKey2, sampleValue2)
+		if err := s.Close(); err != nil {
+			t.Errorf("got an error while closing store: %v", err)
+		}
+		if unflushedForTest(s) {
+			t.Error("found unflushed log after closing store.")
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("got an error while closing store again: %v", err)
+		}
+
+		s = reopenStoreForTest(t, s)
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, sampleValue2)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s := newStoreForTest(t, WithCommitBatching(0, time.Hour))
+	var lastLSN int64
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		lsn, err := s.CommitWithLSN(tid)
+		if err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		lastLSN = lsn
+	}
+	if !unflushedForTest(s) {
+		t.Error("found that log was flushed before Sync.")
+	}
+
+	if err := s.Sync(); err != nil {
+		t.Fatalf("got an error while syncing store: %v", err)
+	}
+	if unflushedForTest(s) {
+		t.Error("found that log was not flushed by Sync.")
+	}
+	// The log files cover every committed LSN
+	segments, err := s.Segments()
+	if err != nil {
+		t.Fatalf("got an error while listing segments: %v", err)
+	}
+	next := 0
+	for _, seg := range segments {
+		if seg.StartLSN != next {
+			t.Errorf("did not get expected start LSN of segment. expected=%d, actual=%d", next, seg.StartLSN)
+		}
+		next = seg.EndLSN + 1
+	}
+	if int64(next) <= lastLSN {
+		t.Errorf("found that segments did not cover committed LSNs. expected>%d, actual=%d", lastLSN, next)
+	}
+	reopened := reopenStoreForTest(t, s)
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		checkStoreValue(t, reopened, k, sampleValue1)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+}
+
+func TestWaitDurable(t *testing.T) {
+	s := newStoreForTest(t, WithCommitBatching(0, time.Hour))
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	lsn, err := s.CommitWithLSN(tid)
+	if err != nil {
+		t.Fatalf("got an error while committing transaction: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.WaitDurable(lsn)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("found that WaitDurable returned before the log was flushed. err=%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("got an error while syncing store: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got an error while waiting for log to be durable: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("found that WaitDurable did not return once the log was flushed.")
+	}
+	s.lm.logLock.Lock()
+	flushedLSN := int64(s.lm.nextLSNToFlush) - 1
+	s.lm.logLock.Unlock()
+	if flushedLSN < lsn {
+		t.Errorf("found that WaitDurable returned before LSN was flushed. expected>=%d, actual=%d", lsn, flushedLSN)
+	}
+
+	// Durable and unknown LSNs
+	if err := s.WaitDurable(lsn); err != nil {
+		t.Errorf("got an error while waiting for log to be durable: %v", err)
+	}
+	if err := s.WaitDurable(lsn + 100); err == nil {
+		t.Errorf("did not get an error while waiting for LSN that is not in the log.")
+	}
+}
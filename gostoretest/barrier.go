@@ -0,0 +1,48 @@
+package gostoretest
+
+import "sync"
+
+// Barrier holds back goroutines until a number of them are waiting at it, so
+// that they go on at the same time. A barrier can be used only once.
+type Barrier struct {
+	lock    sync.Mutex
+	waiting int           // the number of goroutines still to arrive at the barrier
+	release chan struct{} // closed once all of the goroutines have arrived
+}
+
+// NewBarrier creates a barrier for n goroutines.
+func NewBarrier(n int) *Barrier {
+	b := &Barrier{waiting: n, release: make(chan struct{})}
+	if n <= 0 {
+		close(b.release)
+	}
+	return b
+}
+
+// Wait waits until all of the goroutines of the barrier are waiting at it.
+func (b *Barrier) Wait() {
+	b.lock.Lock()
+	b.waiting--
+	if b.waiting == 0 {
+		close(b.release)
+	}
+	b.lock.Unlock()
+	<-b.release
+}
+
+// Parallel runs fn on n goroutines, with their indexes, and waits for them to
+// return. The goroutines are held back at a barrier until all of them have
+// started, so that they run fn at the same time, as far as possible.
+func Parallel(n int, fn func(i int)) {
+	start := NewBarrier(n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			start.Wait()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
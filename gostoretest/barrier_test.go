@@ -0,0 +1,44 @@
+package gostoretest
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBarrier(t *testing.T) {
+	n := 4
+	b := NewBarrier(n)
+	var arrived int32
+	released := make(chan struct{})
+	for i := 0; i < n-1; i++ {
+		go func() {
+			atomic.AddInt32(&arrived, 1)
+			b.Wait()
+			released <- struct{}{}
+		}()
+	}
+	select {
+	case <-released:
+		t.Error("found that barrier released goroutines before all arrived.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Wait()
+	for i := 0; i < n-1; i++ {
+		<-released
+	}
+	if arrived != int32(n-1) {
+		t.Errorf("did not get expected number of goroutines. expected=%d, actual=%d", n-1, arrived)
+	}
+
+	// Parallel runs fn on each goroutine once
+	var ran [8]int32
+	Parallel(len(ran), func(i int) {
+		atomic.AddInt32(&ran[i], 1)
+	})
+	for i := range ran {
+		if ran[i] != 1 {
+			t.Errorf("did not run goroutine %d once. actual=%d", i, ran[i])
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package gostoretest provides support for testing the concurrent use of a
+// gostore.Store, e.g. to check that the transactions of an application are
+// isolated from each other as it expects.
+//
+// The transactions under test are run by actors. Each actor runs its
+// operations on its own goroutine, one step at a time, so that a test
+// controls exactly how the transactions of its actors are interleaved. An
+// operation that has to wait for a lock held by another transaction is
+// detected as blocked, so that a test can assert which operations wait for
+// which, and then let the blocking transaction finish:
+//
+//	h := gostoretest.New(t, s)
+//	a, b := h.Actor("a"), h.Actor("b")
+//	a.Begin(gostore.TransactionOptions{})
+//	a.Set("k", gostore.Value("clean"))
+//	a.Commit()
+//	a.Begin(gostore.TransactionOptions{})
+//	b.Begin(gostore.TransactionOptions{})
+//	a.Set("k", gostore.Value("dirty"))
+//	read := b.StartGet("k")
+//	h.AssertBlocked(read)
+//	a.Abort()
+//	h.AssertValue(read, gostore.Value("clean"))
+package gostoretest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mDibyo/gostore"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultBlockTimeout is the default time for which an operation is waited for
+// before it is considered to be blocked.
+const DefaultBlockTimeout = 100 * time.Millisecond
+
+// DefaultWaitTimeout is the default time for which an operation that is
+// expected to finish is waited for before the test fails.
+const DefaultWaitTimeout = 10 * time.Second
+
+// Harness runs the actors of a test against a store.
+type Harness struct {
+	t     testing.TB
+	store *gostore.Store
+
+	// BlockTimeout is the time for which an operation is waited for before it
+	// is considered to be blocked (DefaultBlockTimeout by default).
+	BlockTimeout time.Duration
+	// WaitTimeout is the time for which an operation that is expected to
+	// finish is waited for before the test fails (DefaultWaitTimeout by
+	// default).
+	WaitTimeout time.Duration
+
+	lock   sync.Mutex // lock to synchronize access to actors
+	actors []*Actor   // the actors of the test
+}
+
+// New creates a harness running the actors of test t against store s. Once
+// the test is finished, the transactions that the actors left running are
+// aborted and their goroutines are stopped.
+func New(t testing.TB, s *gostore.Store) *Harness {
+	h := &Harness{
+		t:            t,
+		store:        s,
+		BlockTimeout: DefaultBlockTimeout,
+		WaitTimeout:  DefaultWaitTimeout,
+	}
+	t.Cleanup(h.stop)
+	return h
+}
+
+// Store returns the store that the harness runs actors against.
+func (h *Harness) Store() *gostore.Store {
+	return h.store
+}
+
+// stop stops the goroutines of the actors, once they have finished their
+// steps, aborting their running transactions.
+func (h *Harness) stop() {
+	h.lock.Lock()
+	actors := h.actors
+	h.actors = nil
+	h.lock.Unlock()
+	for _, a := range actors {
+		a.Start("abort on cleanup", func(tid gostore.TransactionID) (gostore.Value, error) {
+			if tid != 0 {
+				h.store.Abort(tid)
+				a.tid = 0
+			}
+			return nil, nil
+		})
+		close(a.steps)
+	}
+}
+
+// Actor creates a new actor named name, whose operations are run on its own
+// goroutine.
+func (h *Harness) Actor(name string) *Actor {
+	a := &Actor{h: h, name: name, steps: make(chan *Step, 16)}
+	go a.run()
+	h.lock.Lock()
+	h.actors = append(h.actors, a)
+	h.lock.Unlock()
+	return a
+}
+
+// Actor is a client of the store under test, which runs one transaction at a
+// time. The steps of an actor are run in the order in which they are started,
+// each once the previous one has finished.
+type Actor struct {
+	h     *Harness
+	name  string
+	steps chan *Step
+	tid   gostore.TransactionID // the running transaction of the actor, if any (accessed only by the steps of the actor)
+}
+
+// Step is an operation run by an actor.
+type Step struct {
+	a     *Actor
+	desc  string
+	fn    func(tid gostore.TransactionID) (gostore.Value, error)
+	done  chan struct{} // closed once the step has finished
+	value gostore.Value // the value read by the step, if any
+	err   error         // the error with which the step failed, if it did
+}
+
+// run runs the steps of the actor until they are no longer started.
+func (a *Actor) run() {
+	for st := range a.steps {
+		st.value, st.err = st.fn(a.tid)
+		close(st.done)
+	}
+}
+
+// Start starts step fn, described by desc, which is run with the ID of the
+// running transaction of the actor (0 if there is none) once the earlier steps
+// of the actor have finished. It returns without waiting for the step.
+func (a *Actor) Start(desc string, fn func(tid gostore.TransactionID) (gostore.Value, error)) *Step {
+	st := &Step{a: a, desc: desc, fn: fn, done: make(chan struct{})}
+	a.steps <- st
+	return st
+}
+
+// String returns a description of the step.
+func (st *Step) String() string {
+	return fmt.Sprintf("%s of actor %s", st.desc, st.a.name)
+}
+
+// Finished returns whether the step has finished, without waiting for it.
+func (st *Step) Finished() bool {
+	select {
+	case <-st.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitFor waits up to timeout for the step to finish, and returns whether it
+// did.
+func (st *Step) waitFor(timeout time.Duration) bool {
+	select {
+	case <-st.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Wait waits for the step to finish and returns the value that it read and
+// the error with which it failed, if any. The test fails if the step does not
+// finish within the wait timeout of the harness.
+func (st *Step) Wait() (gostore.Value, error) {
+	st.a.h.t.Helper()
+	if !st.waitFor(st.a.h.WaitTimeout) {
+		st.a.h.t.Fatalf("%s did not finish within %v", st, st.a.h.WaitTimeout)
+	}
+	return st.value, st.err
+}
+
+// AssertBlocked fails the test if step st finishes within the block timeout
+// of the harness, e.g. since it was expected to wait for a lock.
+func (h *Harness) AssertBlocked(st *Step) {
+	h.t.Helper()
+	if st.waitFor(h.BlockTimeout) {
+		h.t.Errorf("found that %s was not blocked (err=%v)", st, st.err)
+	}
+}
+
+// AssertNotBlocked fails the test if step st does not finish within the block
+// timeout of the harness, and returns the value that it read and the error
+// with which it failed, if any.
+func (h *Harness) AssertNotBlocked(st *Step) (gostore.Value, error) {
+	h.t.Helper()
+	if !st.waitFor(h.BlockTimeout) {
+		h.t.Errorf("found that %s was blocked", st)
+	}
+	return st.Wait()
+}
+
+// AssertValue waits for step st to finish, and fails the test unless it read
+// value v (or, if v is nil, found that the key does not exist).
+func (h *Harness) AssertValue(st *Step, v gostore.Value) {
+	h.t.Helper()
+	got, err := st.Wait()
+	if v == nil {
+		if !errors.Is(err, gostore.ErrKeyNotFound) {
+			h.t.Errorf("did not get expected error from %s. expected=%v, actual=%v", st, gostore.ErrKeyNotFound, err)
+		}
+		return
+	}
+	if err != nil {
+		h.t.Errorf("got an error from %s: %v", st, err)
+	} else if !bytes.Equal(got, v) {
+		h.t.Errorf("did not get expected value from %s. expected=%q, actual=%q", st, v, got)
+	}
+}
+
+// AssertCommitted reads the committed value of key k in a new transaction,
+// and fails the test unless it is v (or, if v is nil, unless k does not
+// exist).
+func (h *Harness) AssertCommitted(k gostore.Key, v gostore.Value) {
+	h.t.Helper()
+	tid := h.store.BeginTransaction()
+	defer h.store.Abort(tid)
+	got, err := h.store.Get(tid, k)
+	if v == nil {
+		if !errors.Is(err, gostore.ErrKeyNotFound) {
+			h.t.Errorf("found committed value for key='%s': %q", k, got)
+		}
+		return
+	}
+	if err != nil {
+		h.t.Errorf("got an error while getting committed value for key='%s': %v", k, err)
+	} else if !bytes.Equal(got, v) {
+		h.t.Errorf("did not get expected committed value for key='%s'. expected=%q, actual=%q", k, v, got)
+	}
+}
+
+// do runs step fn of actor a, described by desc, and fails the test unless it
+// finishes within the block timeout of the harness without an error.
+func (a *Actor) do(desc string, fn func(tid gostore.TransactionID) (gostore.Value, error)) gostore.Value {
+	a.h.t.Helper()
+	v, err := a.h.AssertNotBlocked(a.Start(desc, fn))
+	if err != nil {
+		a.h.t.Errorf("got an error from %s of actor %s: %v", desc, a.name, err)
+	}
+	return v
+}
+
+// Begin begins a new transaction configured by opts, which the later
+// operations of the actor are run in.
+func (a *Actor) Begin(opts gostore.TransactionOptions) {
+	a.h.t.Helper()
+	a.do("begin", func(gostore.TransactionID) (gostore.Value, error) {
+		tid, err := a.h.store.TryBeginTransaction(opts)
+		if err == nil {
+			a.tid = tid
+		}
+		return nil, err
+	})
+}
+
+// StartGet starts reading the value of key k.
+func (a *Actor) StartGet(k gostore.Key) *Step {
+	return a.Start(fmt.Sprintf("get of key='%s'", k), func(tid gostore.TransactionID) (gostore.Value, error) {
+		return a.h.store.Get(tid, k)
+	})
+}
+
+// Get reads the value of key k, failing the test if the read is blocked or
+// fails.
+func (a *Actor) Get(k gostore.Key) gostore.Value {
+	a.h.t.Helper()
+	v, err := a.h.AssertNotBlocked(a.StartGet(k))
+	if err != nil {
+		a.h.t.Errorf("got an error from get of key='%s' of actor %s: %v", k, a.name, err)
+	}
+	return v
+}
+
+// StartSet starts setting the value of key k to v.
+func (a *Actor) StartSet(k gostore.Key, v gostore.Value) *Step {
+	return a.Start(fmt.Sprintf("set of key='%s'", k), func(tid gostore.TransactionID) (gostore.Value, error) {
+		return nil, a.h.store.Set(tid, k, v)
+	})
+}
+
+// Set sets the value of key k to v, failing the test if the write is blocked
+// or fails.
+func (a *Actor) Set(k gostore.Key, v gostore.Value) {
+	a.h.t.Helper()
+	if _, err := a.h.AssertNotBlocked(a.StartSet(k, v)); err != nil {
+		a.h.t.Errorf("got an error from set of key='%s' of actor %s: %v", k, a.name, err)
+	}
+}
+
+// StartCommit starts committing the running transaction of the actor.
+func (a *Actor) StartCommit() *Step {
+	return a.Start("commit", func(tid gostore.TransactionID) (gostore.Value, error) {
+		err := a.h.store.Commit(tid)
+		if err == nil {
+			a.tid = 0
+		}
+		return nil, err
+	})
+}
+
+// Commit commits the running transaction of the actor, failing the test if
+// the commit is blocked, and returns the error with which it failed, if any.
+func (a *Actor) Commit() error {
+	a.h.t.Helper()
+	_, err := a.h.AssertNotBlocked(a.StartCommit())
+	return err
+}
+
+// Abort aborts the running transaction of the actor, failing the test if the
+// abort is blocked or fails. A transaction whose commit failed should be
+// aborted.
+func (a *Actor) Abort() {
+	a.h.t.Helper()
+	a.do("abort", func(tid gostore.TransactionID) (gostore.Value, error) {
+		a.tid = 0
+		return nil, a.h.store.Abort(tid)
+	})
+}
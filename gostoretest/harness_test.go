@@ -0,0 +1,165 @@
+package gostoretest
+
+import (
+	"errors"
+	"github.com/mDibyo/gostore"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	counterKey = gostore.Key("counter")
+	sampleKey  = gostore.Key("key")
+)
+
+// newStoreForTest creates a store configured by opts, backed by a fresh log
+// directory, which is closed and removed once the test is finished.
+func newStoreForTest(t *testing.T, opts ...gostore.Option) *gostore.Store {
+	dir, err := ioutil.TempDir("", "gostoretest_")
+	if err != nil {
+		t.Fatalf("could not create log directory for store: %v", err)
+	}
+	s, err := gostore.NewStore(dir, opts...)
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+// setForTest sets the value of key k in a new committed transaction.
+func setForTest(t *testing.T, s *gostore.Store, k gostore.Key, v gostore.Value) {
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, k, v); err != nil {
+		t.Fatalf("got an error while setting value for key='%s': %v", k, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Fatalf("got an error while committing transaction: %v", err)
+	}
+}
+
+// A transaction can not read the uncommitted writes of another, whatever its
+// isolation level: the read waits for the writer to finish.
+func TestDirtyRead(t *testing.T) {
+	for _, isolation := range []gostore.IsolationLevel{gostore.Serializable, gostore.ReadCommitted} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey, gostore.Value("clean"))
+		h := New(t, s)
+		writer, reader := h.Actor("writer"), h.Actor("reader")
+		writer.Begin(gostore.TransactionOptions{})
+		reader.Begin(gostore.TransactionOptions{Isolation: isolation})
+
+		writer.Set(sampleKey, gostore.Value("dirty"))
+		read := reader.StartGet(sampleKey)
+		h.AssertBlocked(read)
+		writer.Abort()
+		h.AssertValue(read, gostore.Value("clean"))
+		if err := reader.Commit(); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+}
+
+// Two transactions that both read a counter and then write it incremented do
+// not lose an increment: a read lock is promoted to a write lock without being
+// released, so the write of the first transaction waits for the second to
+// release its read lock, and the write of the second fails with ErrDeadlock.
+// Once the second is aborted, the first commits, and the second can be
+// retried.
+func TestNoLostUpdateOnLockPromotion(t *testing.T) {
+	s := newStoreForTest(t, gostore.WithLockTimeout(time.Second))
+	setForTest(t, s, counterKey, gostore.Value("0"))
+	h := New(t, s)
+	a, b := h.Actor("a"), h.Actor("b")
+	a.Begin(gostore.TransactionOptions{})
+	b.Begin(gostore.TransactionOptions{})
+
+	if v := a.Get(counterKey); string(v) != "0" {
+		t.Errorf("did not get expected counter. expected=0, actual=%s", v)
+	}
+	if v := b.Get(counterKey); string(v) != "0" {
+		t.Errorf("did not get expected counter. expected=0, actual=%s", v)
+	}
+	writeA := a.StartSet(counterKey, gostore.Value("1"))
+	h.AssertBlocked(writeA)
+	if _, err := h.AssertNotBlocked(b.StartSet(counterKey, gostore.Value("1"))); !errors.Is(err, gostore.ErrDeadlock) {
+		t.Errorf("did not get expected error while setting counter. expected=%v, actual=%v", gostore.ErrDeadlock, err)
+	}
+	b.Abort()
+	if _, err := h.AssertNotBlocked(writeA); err != nil {
+		t.Errorf("got an error while setting counter: %v", err)
+	}
+	if err := a.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// The retried increment reads the committed one
+	b.Begin(gostore.TransactionOptions{})
+	if v := b.Get(counterKey); string(v) != "1" {
+		t.Errorf("did not get expected counter. expected=1, actual=%s", v)
+	}
+	b.Set(counterKey, gostore.Value("2"))
+	if err := b.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	h.AssertCommitted(counterKey, gostore.Value("2"))
+}
+
+// Transactions with blind writes do not wait for each other, so an increment
+// of a counter is lost.
+func TestLostUpdateWithBlindWrites(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, counterKey, gostore.Value("0"))
+	h := New(t, s)
+	a, b := h.Actor("a"), h.Actor("b")
+	opts := gostore.TransactionOptions{Isolation: gostore.ReadCommitted, BlindWrites: true}
+	a.Begin(opts)
+	b.Begin(opts)
+
+	a.Get(counterKey)
+	b.Get(counterKey)
+	a.Set(counterKey, gostore.Value("1"))
+	b.Set(counterKey, gostore.Value("1"))
+	if err := a.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	// Two increments, but the counter is 1
+	h.AssertCommitted(counterKey, gostore.Value("1"))
+}
+
+// Concurrent increments of a counter that take the write lock before reading
+// it are not lost.
+func TestParallelIncrements(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, counterKey, gostore.Value("0"))
+	n := 8
+	errs := make(chan error, n)
+	Parallel(n, func(int) {
+		tid := s.BeginTransaction()
+		err := s.Update(tid, counterKey, func(old gostore.Value) (gostore.Value, error) {
+			count, err := strconv.Atoi(string(old))
+			return gostore.Value(strconv.Itoa(count + 1)), err
+		})
+		if err == nil {
+			err = s.Commit(tid)
+		} else {
+			s.Abort(tid)
+		}
+		errs <- err
+	})
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("got an error while incrementing counter: %v", err)
+		}
+	}
+	New(t, s).AssertCommitted(counterKey, gostore.Value(strconv.Itoa(n)))
+}
@@ -0,0 +1,52 @@
+package gostore
+
+import (
+	"fmt"
+	"os"
+)
+
+// pingFileName is the name of the file written to the log directory to check
+// that it is writable. It is not a log file name, so it is ignored if it is
+// left behind.
+var pingFileName = ".ping"
+
+// ping checks that the store is operational: it has not been closed, the log
+// could be flushed when it was last flushed, the log directory is writable,
+// and the background flusher (if commits are batched) is running.
+func (lm *logManager) ping() error {
+	lm.logLock.Lock()
+	closed, storageErr := lm.closed, lm.storageErr
+	lm.logLock.Unlock()
+	if closed {
+		return ErrStoreClosed
+	}
+	if storageErr != nil {
+		return fmt.Errorf("%w: %v", ErrStorageUnavailable, storageErr)
+	}
+
+	if f := lm.flusher; f != nil {
+		select {
+		case <-f.done:
+			return fmt.Errorf("background flusher of the log has stopped")
+		default:
+		}
+	}
+
+	if lm.config.inMemory {
+		return nil
+	}
+	filename := fmt.Sprintf("%s/%s", lm.logDir, pingFileName)
+	f, err := lm.config.storage.Create(filename, lm.config.fileMode)
+	if err != nil {
+		return fmt.Errorf("%w: log directory is not writable: %v", ErrStorageUnavailable, err)
+	}
+	_, err = f.Write([]byte{0})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(filename)
+	if err != nil {
+		return fmt.Errorf("%w: log directory is not writable: %v", ErrStorageUnavailable, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package gostore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	// Healthy
+	s := newStoreForTest(t, WithCommitBatching(10, 0))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	if err := s.Ping(); err != nil {
+		t.Errorf("got an error while pinging healthy store: %v", err)
+	}
+	if files, _ := readLogDirForTest(s.lm.logDir); len(files) != 0 {
+		t.Errorf("found files left behind in log directory. files=%d", len(files))
+	}
+
+	// Closed
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+	if err := s.Ping(); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("did not get expected error while pinging closed store. expected=%v, actual=%v", ErrStoreClosed, err)
+	}
+
+	// Unwritable log directory
+	s = newStoreForTest(t)
+	if err := os.RemoveAll(s.lm.logDir); err != nil {
+		t.Fatalf("could not remove log directory: %v", err)
+	}
+	if err := ioutil.WriteFile(s.lm.logDir, nil, 0644); err != nil {
+		t.Fatalf("could not replace log directory: %v", err)
+	}
+	defer os.Remove(s.lm.logDir)
+	if err := s.Ping(); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("did not get expected error while pinging store with unwritable log directory. expected=%v, actual=%v", ErrStorageUnavailable, err)
+	}
+
+	// Failing writes
+	storage := &testStorage{writeErr: errors.New("disk full")}
+	s = newStoreForTest(t, WithStorageBackend(storage))
+	if err := s.Ping(); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("did not get expected error while pinging store with failing writes. expected=%v, actual=%v", ErrStorageUnavailable, err)
+	}
+}
@@ -0,0 +1,98 @@
+package gostore
+
+import (
+	"fmt"
+	pb "github.com/mDibyo/gostore/pb"
+	"sort"
+)
+
+// logIndex indexes the entries of the log by key and by transaction, so that
+// the history of a key can be read without replaying the whole log. Since the
+// whole log is held in memory, entries are located by their LSNs alone. It is
+// guarded by logLock, and is rebuilt whenever the log is replaced (when it is
+// retrieved or compacted).
+type logIndex struct {
+	keys     map[Key][]int64           // the LSNs of the UPDATE and UNDO entries of each key, in order
+	outcomes map[TransactionID][]int64 // the LSNs of the entries that decide the outcome of each transaction (BEGIN of bulk loads, PREPARE, COMMIT, ABORT and END), in order
+}
+
+func newLogIndex(entries []*pb.LogEntry) *logIndex {
+	li := &logIndex{
+		keys:     make(map[Key][]int64),
+		outcomes: make(map[TransactionID][]int64),
+	}
+	for _, e := range entries {
+		li.add(e)
+	}
+	return li
+}
+
+// add indexes entry e, which must follow the entries already indexed.
+func (li *logIndex) add(e *pb.LogEntry) {
+	switch e.GetEntryType() {
+	case pb.LogEntry_UPDATE, pb.LogEntry_UNDO:
+		k := Key(e.GetKey())
+		li.keys[k] = append(li.keys[k], e.GetLsn())
+	case pb.LogEntry_BEGIN:
+		if e.GetBulkLoad() {
+			tid := TransactionID(e.GetTid())
+			li.outcomes[tid] = append(li.outcomes[tid], e.GetLsn())
+		}
+	case pb.LogEntry_PREPARE, pb.LogEntry_COMMIT, pb.LogEntry_ABORT, pb.LogEntry_END:
+		tid := TransactionID(e.GetTid())
+		li.outcomes[tid] = append(li.outcomes[tid], e.GetLsn())
+	}
+}
+
+// lsnsUpTo returns the prefix of lsns (which are in order) up to and including
+// lsn.
+func lsnsUpTo(lsns []int64, lsn int64) []int64 {
+	return lsns[:sort.Search(len(lsns), func(i int) bool { return lsns[i] > lsn })]
+}
+
+// keyEntriesUpTo returns the entries of log entries (the first of which has
+// LSN firstLSN) that decide the committed value of key k as of LSN lsn, in
+// order: the UPDATE and UNDO entries of k up to lsn, and the entries up to lsn
+// that decide the outcomes of the transactions that wrote them. Replaying them
+// gives the value of k that replaying the log up to lsn does.
+func (li *logIndex) keyEntriesUpTo(entries []*pb.LogEntry, firstLSN int64, k Key, lsn int64) []*pb.LogEntry {
+	keyLSNs := lsnsUpTo(li.keys[k], lsn)
+	lsns := append([]int64(nil), keyLSNs...)
+	tids := make(map[TransactionID]struct{})
+	for _, l := range keyLSNs {
+		tid := TransactionID(entries[l-firstLSN].GetTid())
+		if _, ok := tids[tid]; !ok {
+			tids[tid] = struct{}{}
+			lsns = append(lsns, lsnsUpTo(li.outcomes[tid], lsn)...)
+		}
+	}
+	sort.Slice(lsns, func(i, j int) bool { return lsns[i] < lsns[j] })
+
+	keyEntries := make([]*pb.LogEntry, len(lsns))
+	for i, l := range lsns {
+		keyEntries[i] = entries[l-firstLSN]
+	}
+	return keyEntries
+}
+
+// getValueAsOf returns the committed value of key k as of LSN lsn, i.e. the
+// value it would have if the log ended at the entry with that LSN. It is
+// reconstructed by replaying the entries of the log that concern k up to that
+// entry, found with the log index, with logLock held since a compaction may
+// replace the entries. LSNs from before the last compaction fail with
+// ErrCompacted.
+func (lm *logManager) getValueAsOf(k Key, lsn int64) (Value, error) {
+	lm.logLock.Lock()
+	if err := lm.checkLSN(lsn); err != nil {
+		lm.logLock.Unlock()
+		return nil, err
+	}
+	sm := replayLogEntries(lm.index.keyEntriesUpTo(lm.log.Entry, int64(lm.firstLSN), k, lsn))
+	lm.logLock.Unlock()
+
+	smv, err := sm.storeMapValue(k, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve value: %w", err)
+	}
+	return lm.resolveChunk(smv.value)
+}
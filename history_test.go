@@ -0,0 +1,144 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGetAsOf(t *testing.T) {
+	s := newStoreForTest(t)
+	lastLSN := func() int64 {
+		s.lm.logLock.Lock()
+		defer s.lm.logLock.Unlock()
+		return int64(s.lm.nextLSN - 1)
+	}
+
+	setForTest(t, s, sampleKey2, sampleValue2)
+	var lsns []int64
+	values := []Value{sampleValue1, sampleValue2, sampleValue3}
+	for _, v := range values {
+		setForTest(t, s, sampleKey1, v)
+		lsns = append(lsns, lastLSN())
+	}
+
+	// Uncommitted writes are not visible as of any LSN
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, Value("uncommitted")); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	lsns = append(lsns, lastLSN())
+	values = append(values, sampleValue3)
+
+	for i, lsn := range lsns {
+		if gotV, err := s.GetAsOf(sampleKey1, lsn); err != nil {
+			t.Errorf("got an error while getting value for key='%s' as of LSN %d: %v", sampleKey1, lsn, err)
+		} else if !bytes.Equal(gotV, values[i]) {
+			t.Errorf("did not get back the correct value as of LSN %d. expected=%v, actual=%v.", lsn, values[i], gotV)
+		}
+	}
+	if gotV, err := s.GetAsOf(sampleKey2, lastLSN()); err != nil || !bytes.Equal(gotV, sampleValue2) {
+		t.Errorf("did not get back the correct value. expected=%v, actual=%v (err=%v).", sampleValue2, gotV, err)
+	}
+	// before the COMMIT and END entries of the first update of the key
+	if _, err := s.GetAsOf(sampleKey1, lsns[0]-2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for key that did not exist. expected=%v, actual=%v", ErrKeyNotFound, err)
+	}
+	for _, lsn := range []int64{-1, lastLSN() + 1} {
+		if _, err := s.GetAsOf(sampleKey1, lsn); err == nil {
+			t.Errorf("did not get an error for LSN %d outside the log.", lsn)
+		}
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+}
+
+// checkGetAsOfForTest checks that the value of each of keys as of each LSN in
+// the log of s is the value given by replaying the whole log up to that LSN.
+func checkGetAsOfForTest(t *testing.T, s *Store, keys []Key) {
+	entries := s.lm.log.Entry
+	for i := range entries {
+		lsn := s.lm.firstLSN + i
+		sm := replayLogEntries(entries[:i+1])
+		for _, k := range keys {
+			var wantV Value
+			if smv, ok := sm[k]; ok {
+				wantV = smv.value
+			}
+			gotV, err := s.GetAsOf(k, int64(lsn))
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("got an error while getting value for key='%s' as of LSN %d: %v", k, lsn, err)
+			}
+			if !bytes.Equal(gotV, wantV) || (gotV == nil) != (wantV == nil) {
+				t.Errorf("did not get back the correct value for key='%s' as of LSN %d. expected=%v, actual=%v.", k, lsn, wantV, gotV)
+			}
+		}
+	}
+}
+
+func TestGetAsOfIndex(t *testing.T) {
+	s := newStoreForTest(t)
+	keys := []Key{sampleKey1, sampleKey2, sampleKey3}
+	setForTest(t, s, sampleKey1, sampleValue1)
+	for i, v := range []Value{sampleValue2, sampleValue3, nil, sampleValue1} {
+		tid := s.BeginTransaction()
+		k := keys[i%len(keys)]
+		var err error
+		if v == nil {
+			err = s.Delete(tid, sampleKey1)
+		} else {
+			err = s.Set(tid, k, CopyByteArray(v))
+		}
+		if err != nil {
+			t.Errorf("got an error while writing: %v", err)
+		}
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+		}
+		if i%2 == 0 {
+			err = s.Commit(tid)
+		} else {
+			err = s.Abort(tid)
+		}
+		if err != nil {
+			t.Errorf("got an error while ending transaction: %v", err)
+		}
+	}
+	// A transaction that is still running
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey2, Value("uncommitted")); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	checkGetAsOfForTest(t, s, keys)
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	s.Close()
+
+	// The index is rebuilt when the log is retrieved
+	s = reopenStoreForTest(t, s)
+	if want := newLogIndex(s.lm.log.Entry); !reflect.DeepEqual(s.lm.index, want) {
+		t.Errorf("did not get expected log index after recovery. expected=%v, actual=%v", want, s.lm.index)
+	}
+	checkGetAsOfForTest(t, s, keys)
+
+	// and when the log is compacted
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	if want := newLogIndex(s.lm.log.Entry); !reflect.DeepEqual(s.lm.index, want) {
+		t.Errorf("did not get expected log index after compaction. expected=%v, actual=%v", want, s.lm.index)
+	}
+	checkGetAsOfForTest(t, s, keys)
+	// LSNs from before the compaction are rejected
+	for _, lsn := range []int64{0, int64(s.lm.firstLSN) - 1} {
+		if _, err := s.GetAsOf(sampleKey1, lsn); !errors.Is(err, ErrCompacted) {
+			t.Errorf("did not get expected error while getting value as of LSN %d. expected=%v, actual=%v", lsn, ErrCompacted, err)
+		}
+	}
+}
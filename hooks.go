@@ -0,0 +1,157 @@
+package gostore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// WriteSet maps the keys written by a transaction to the values that they are
+// committed with (nil for deleted keys).
+type WriteSet map[Key]Value
+
+// PreCommitHook is called synchronously just before a transaction commits,
+// with its write set. If it returns an error, the commit is vetoed: the
+// transaction is aborted instead, and the commit fails with the error. It is
+// called while the transaction holds its locks, so it must not wait for the
+// locks of the keys written.
+type PreCommitHook func(tid TransactionID, writes WriteSet) error
+
+// PostCommitHook is called synchronously just after a transaction commits,
+// with its write set, once its locks have been released.
+type PostCommitHook func(tid TransactionID, writes WriteSet)
+
+// commitHooks holds the hooks registered with a store, by the order in which
+// they were registered.
+type commitHooks struct {
+	lock   sync.Mutex
+	nextID int
+	pre    map[int]PreCommitHook
+	post   map[int]PostCommitHook
+}
+
+// add registers a hook under a new ID with register, and returns the function
+// that unregisters it with unregister.
+func (h *commitHooks) add(register, unregister func(id int)) func() {
+	h.lock.Lock()
+	id := h.nextID
+	h.nextID++
+	register(id)
+	h.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			unregister(id)
+		})
+	}
+}
+
+func (h *commitHooks) addPreCommit(hook PreCommitHook) func() {
+	return h.add(func(id int) {
+		if h.pre == nil {
+			h.pre = make(map[int]PreCommitHook)
+		}
+		h.pre[id] = hook
+	}, func(id int) {
+		delete(h.pre, id)
+	})
+}
+
+func (h *commitHooks) addPostCommit(hook PostCommitHook) func() {
+	return h.add(func(id int) {
+		if h.post == nil {
+			h.post = make(map[int]PostCommitHook)
+		}
+		h.post[id] = hook
+	}, func(id int) {
+		delete(h.post, id)
+	})
+}
+
+// snapshot returns the hooks registered, in the order in which they were
+// registered.
+func (h *commitHooks) snapshot() (pre []PreCommitHook, post []PostCommitHook) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ids := make([]int, 0, len(h.pre)+len(h.post))
+	for id := range h.pre {
+		ids = append(ids, id)
+	}
+	for id := range h.post {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if hook, ok := h.pre[id]; ok {
+			pre = append(pre, hook)
+		} else {
+			post = append(post, h.post[id])
+		}
+	}
+	return
+}
+
+// writeSet returns the write set of a running transaction, whose writes are
+// either buffered or applied to the store under its write locks. The values
+// are copied, so hooks can not modify them. It must be called with stateLock
+// held.
+func (lm *logManager) writeSet(ts *transactionState) WriteSet {
+	writes := make(WriteSet, len(ts.modifiedKeys)+len(ts.writeBuffer))
+	for k := range ts.modifiedKeys {
+		if smv, ok := lm.store[k]; ok {
+			writes[k] = CopyByteArray(smv.value)
+		} else {
+			writes[k] = nil
+		}
+	}
+	for k, v := range ts.writeBuffer {
+		writes[k] = CopyByteArray(v)
+	}
+	return writes
+}
+
+// hookWriteSet returns the write set of transaction tid for the commit hooks,
+// or nil if no hooks are registered.
+func (lm *logManager) hookWriteSet(ts *transactionState) WriteSet {
+	pre, post := lm.hooks.snapshot()
+	if len(pre) == 0 && len(post) == 0 {
+		return nil
+	}
+	lm.stateLock.Lock()
+	writes := lm.writeSet(ts)
+	lm.stateLock.Unlock()
+	// A chunked value that can not be read is left as its chunk reference.
+	lm.resolveChunks(writes)
+	return writes
+}
+
+// runPreCommitHooks calls the pre-commit hooks with the write set of
+// transaction tid, and returns the first error returned by a hook, if any.
+// The hooks after it are not called once one has vetoed the commit.
+func (lm *logManager) runPreCommitHooks(tid TransactionID, writes WriteSet) error {
+	if writes == nil {
+		return nil
+	}
+	pre, _ := lm.hooks.snapshot()
+	for _, hook := range pre {
+		if err := hook(tid, writes); err != nil {
+			return fmt.Errorf("commit was vetoed: %w", err)
+		}
+	}
+	return nil
+}
+
+// runPostCommitHooks calls the post-commit hooks with the write set of
+// transaction tid, which has committed.
+func (lm *logManager) runPostCommitHooks(tid TransactionID, writes WriteSet) {
+	if writes == nil {
+		return
+	}
+	_, post := lm.hooks.snapshot()
+	for _, hook := range post {
+		hook(tid, writes)
+	}
+}
@@ -0,0 +1,113 @@
+package gostore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPreCommitHook(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	errVeto := errors.New("veto")
+	var gotWrites []WriteSet
+	remove := s.OnPreCommit(func(tid TransactionID, writes WriteSet) error {
+		gotWrites = append(gotWrites, writes)
+		if _, ok := writes[sampleKey2]; ok {
+			return errVeto
+		}
+		return nil
+	})
+
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		gotWrites = nil
+		tid := s.BeginTransactionWithOptions(opts)
+		for _, k := range []Key{sampleKey1, sampleKey2} {
+			if err := s.Set(tid, k, CopyByteArray(sampleValue2)); err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			}
+		}
+		if err := s.Commit(tid); !errors.Is(err, errVeto) {
+			t.Errorf("did not get expected error while committing vetoed transaction. expected=%v, actual=%v", errVeto, err)
+		}
+		wantWrites := []WriteSet{{sampleKey1: sampleValue2, sampleKey2: sampleValue2}}
+		if !reflect.DeepEqual(gotWrites, wantWrites) {
+			t.Errorf("did not get expected write sets. expected=%v, actual=%v", wantWrites, gotWrites)
+		}
+		// The transaction was aborted
+		if s.lm.running(tid) {
+			t.Errorf("found vetoed transaction running.")
+		}
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, nil)
+	}
+
+	// A prepared transaction is vetoed when it is prepared
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Prepare(tid); !errors.Is(err, errVeto) {
+		t.Errorf("did not get expected error while preparing vetoed transaction. expected=%v, actual=%v", errVeto, err)
+	}
+	checkStoreValue(t, s, sampleKey2, nil)
+
+	// Commits that are not vetoed go ahead, as do all commits once the hook
+	// is removed
+	setForTest(t, s, sampleKey1, sampleValue3)
+	remove()
+	setForTest(t, s, sampleKey2, sampleValue3)
+	checkStoreValue(t, s, sampleKey1, sampleValue3)
+	checkStoreValue(t, s, sampleKey2, sampleValue3)
+}
+
+func TestPostCommitHook(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		var gotTID TransactionID
+		var gotWrites WriteSet
+		remove := s.OnPostCommit(func(tid TransactionID, writes WriteSet) {
+			// The transaction has committed, and released its locks
+			if s.lm.running(tid) {
+				t.Errorf("found transaction running in post-commit hook.")
+			}
+			checkStoreValue(t, s, sampleKey1, sampleValue1)
+			gotTID, gotWrites = tid, writes
+		})
+
+		tid := s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Delete(tid, sampleKey2); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		if gotTID != tid {
+			t.Errorf("did not get expected transaction ID in hook. expected=%d, actual=%d", tid, gotTID)
+		}
+		if wantWrites := (WriteSet{sampleKey1: sampleValue1, sampleKey2: nil}); !reflect.DeepEqual(gotWrites, wantWrites) {
+			t.Errorf("did not get expected write set. expected=%v, actual=%v", wantWrites, gotWrites)
+		}
+
+		// Aborted transactions are not reported
+		gotWrites = nil
+		tid = s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		s.Abort(tid)
+		if gotWrites != nil {
+			t.Errorf("found hook called for aborted transaction.")
+		}
+
+		remove()
+		setForTest(t, s, sampleKey2, sampleValue2)
+		if gotWrites != nil {
+			t.Errorf("found hook called after it was removed.")
+		}
+	}
+}
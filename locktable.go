@@ -0,0 +1,82 @@
+package gostore
+
+// currentMutexesMap is the lock table of a transaction: the wrapped mutexes of
+// the keys it has locked (or is locking). By default it is keyed on the keys
+// themselves. If the lock table is hashed (see WithHashedLockTable), it is
+// keyed on a hash of each key instead, and each mutex records its key, so that
+// the table does not hold a key of its own: the key recorded is the one held
+// by the store, which is shared by every transaction that locks it. Keys whose
+// hashes collide are chained in the same bucket, and told apart by the keys
+// recorded.
+type currentMutexesMap struct {
+	byKey  map[Key]*rwMutexWrapper      // the mutexes, if the lock table is not hashed
+	byHash map[uint64][]*rwMutexWrapper // the mutexes by the hash of their keys, if the lock table is hashed
+}
+
+// lockTableHash hashes keys for hashed lock tables.
+var lockTableHash = fnvHash
+
+// fnvHash returns the 64-bit FNV-1a hash of k.
+func fnvHash(k Key) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(k); i++ {
+		h ^= uint64(k[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func newCurrentMutexesMap(hashed bool) currentMutexesMap {
+	if hashed {
+		return currentMutexesMap{byHash: make(map[uint64][]*rwMutexWrapper)}
+	}
+	return currentMutexesMap{byKey: make(map[Key]*rwMutexWrapper)}
+}
+
+// get returns the wrapped mutex of key k, if the transaction has one.
+func (cm currentMutexesMap) get(k Key) (*rwMutexWrapper, bool) {
+	if cm.byHash == nil {
+		rw, ok := cm.byKey[k]
+		return rw, ok
+	}
+	for _, rw := range cm.byHash[lockTableHash(k)] {
+		if rw.key == k {
+			return rw, true
+		}
+	}
+	return nil, false
+}
+
+// getWrappedRWMutex returns the wrapped mutex of key k, whose value in the
+// store is smv, adding it to the lock table if the transaction does not have
+// one yet.
+func (cm currentMutexesMap) getWrappedRWMutex(k Key, smv *storeMapValue) *rwMutexWrapper {
+	if rw, ok := cm.get(k); ok {
+		return rw
+	}
+	if smv.key == k {
+		// Share the key held by the store, rather than holding on to k.
+		k = smv.key
+	}
+	_rw := wrapRWMutex(&smv.lock)
+	_rw.key = k
+	if cm.byHash == nil {
+		cm.byKey[k] = &_rw
+	} else {
+		h := lockTableHash(k)
+		cm.byHash[h] = append(cm.byHash[h], &_rw)
+	}
+	return &_rw
+}
+
+// forEach calls fn with each key in the lock table and its wrapped mutex.
+func (cm currentMutexesMap) forEach(fn func(k Key, rw *rwMutexWrapper)) {
+	for k, rw := range cm.byKey {
+		fn(k, rw)
+	}
+	for _, bucket := range cm.byHash {
+		for _, rw := range bucket {
+			fn(rw.key, rw)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package gostore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collideLockTableHashForTest makes the hashes of all keys collide, until the
+// returned function is called.
+func collideLockTableHashForTest() func() {
+	hash := lockTableHash
+	lockTableHash = func(Key) uint64 { return 0 }
+	return func() {
+		lockTableHash = hash
+	}
+}
+
+func TestCurrentMutexesMap(t *testing.T) {
+	defer collideLockTableHashForTest()()
+	for _, hashed := range []bool{false, true} {
+		cm := newCurrentMutexesMap(hashed)
+		smvs := map[Key]*storeMapValue{sampleKey1: newStoreMapValue(), sampleKey2: newStoreMapValue()}
+		for k, smv := range smvs {
+			if rw := cm.getWrappedRWMutex(k, smv); rw.smvLock != &smv.lock {
+				t.Errorf("did not get the lock of key='%s'.", k)
+			}
+		}
+		for k, smv := range smvs {
+			if rw, ok := cm.get(k); !ok || rw.smvLock != &smv.lock {
+				t.Errorf("did not get back the lock of key='%s' (hashed=%t).", k, hashed)
+			}
+		}
+		if _, ok := cm.get(sampleKey3); ok {
+			t.Errorf("got a lock for key='%s' that was not locked (hashed=%t).", sampleKey3, hashed)
+		}
+		var keys []string
+		cm.forEach(func(k Key, _ *rwMutexWrapper) {
+			keys = append(keys, string(k))
+		})
+		sort.Strings(keys)
+		if want := []string{string(sampleKey1), string(sampleKey2)}; strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Errorf("did not get expected keys of lock table (hashed=%t). expected=%v, actual=%v", hashed, want, keys)
+		}
+	}
+}
+
+func TestHashedLockTable(t *testing.T) {
+	defer collideLockTableHashForTest()()
+	s := newStoreForTest(t, WithHashedLockTable(true), WithLockTimeout(50*time.Millisecond))
+	prefix := strings.Repeat("k", 4096)
+	k1, k2 := Key(prefix+"1"), Key(prefix+"2")
+	setForTest(t, s, k1, sampleValue1)
+
+	// Keys whose hashes collide are locked independently
+	tid1, tid2 := s.BeginTransaction(), s.BeginTransaction()
+	if err := s.Set(tid1, k1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value: %v", err)
+	}
+	if err := s.Set(tid2, k2, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value: %v", err)
+	}
+	if _, err := s.Get(tid2, k1); !errors.Is(err, ErrTimeout) {
+		t.Errorf("did not get expected error while getting locked key. expected=%v, actual=%v", ErrTimeout, err)
+	}
+	if gotV, err := s.Get(tid1, k1); err != nil || string(gotV) != string(sampleValue2) {
+		t.Errorf("did not get expected value. expected=%v, actual=%v (err=%v)", sampleValue2, gotV, err)
+	}
+	if err := s.Commit(tid1); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := s.Commit(tid2); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, k1, sampleValue2)
+	checkStoreValue(t, s, k2, sampleValue3)
+
+	// Locks are released when transactions end
+	tid := s.BeginTransaction()
+	for _, k := range []Key{k1, k2} {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value: %v", err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, k1, sampleValue1)
+	checkStoreValue(t, s, k2, sampleValue1)
+}
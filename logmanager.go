@@ -6,27 +6,63 @@ locks on values.
 package gostore
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	pb "github.com/mDibyo/gostore/pb"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Key represents a key in the store
+// Key represents a key in the store. Keys are arbitrary byte strings, and are
+// ordered byte-wise, unless another order is set with WithKeyComparator.
 type Key string
 
-// Value represents the value for a key in the key store
+// KeyComparator compares two keys, returning a negative number if a orders
+// before b, a positive number if a orders after b and 0 if they are equal. It
+// must define a total order consistent with equality of keys.
+type KeyComparator func(a, b Key) int
+
+// sortKeys sorts keys in the order of the store, for operations that return
+// keys in order. (Locks are always taken in byte-wise order.)
+func (lm *logManager) sortKeys(keys []Key) {
+	if cmp := lm.config.compareKeys; cmp != nil {
+		sort.Slice(keys, func(i, j int) bool { return cmp(keys[i], keys[j]) < 0 })
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+}
+
+// Value represents the value for a key in the key store. A nil Value stands
+// for an absent key (in the log, a deleted one), while an empty, non-nil Value
+// is the value of a key that is present but empty.
 type Value []byte
 
 type storeMapValue struct {
-	value Value
+	// Access counters, updated atomically (first, so that they are 64-bit
+	// aligned)
+	reads      uint64 // the number of reads of the key
+	writes     uint64 // the number of writes of the key
+	lastAccess uint64 // the access clock of the store when the key was last accessed
+
+	key     Key      // the key, shared with the lock tables of transactions
+	value   Value    // nil if the key does not exist (it is deleted, or not yet set)
+	meta    *pb.Meta // the metadata of the value, if any
+	version uint64   // the number of committed updates since the key was created
+	deleted bool     // whether the key has been deleted (its value is a tombstone)
 
 	// RWMutex attributes
-	lock sync.RWMutex
+	lock keyLock
 
 	// ValueAccessor attributes
 	rAccessorChan chan *valueAccessor
@@ -49,301 +85,1660 @@ type storeMap map[Key]*storeMapValue
 
 func (sm storeMap) storeMapValue(k Key, addIfNotExist bool) (smv *storeMapValue, err error) {
 	smv, ok := sm[k]
-	if ok {
+	if ok && (smv.value != nil || addIfNotExist) {
+		// A nil value is present for a key that has been deleted (a
+		// tombstone), or that is locked for writing (or by a snapshot read)
+		// before it is first set.
 		return
 	}
 	if !addIfNotExist {
-		return smv, fmt.Errorf("key %s does not exist.", k)
+		return smv, fmt.Errorf("%w: %q", ErrKeyNotFound, k)
 	}
 
 	smv = newStoreMapValue()
+	smv.key = k
 	sm[k] = smv
 	return
 }
 
-type currentMutexesMap map[Key]*rwMutexWrapper
+// validateKey checks k against the key validation configured for the store.
+func (lm *logManager) validateKey(k Key) error {
+	if !lm.config.validateKeys {
+		return nil
+	}
+	if strings.IndexByte(string(k), 0) >= 0 {
+		return fmt.Errorf("%w: key %q contains a NUL byte", ErrInvalidKey, k)
+	}
+	if lm.config.maxKeyLength > 0 && len(k) > lm.config.maxKeyLength {
+		return fmt.Errorf("%w: key %q is longer than %d bytes", ErrInvalidKey, k, lm.config.maxKeyLength)
+	}
+	return nil
+}
+
+// transactionState holds the state of a running transaction, other than the
+// mutexes it holds.
+type transactionState struct {
+	isolation    IsolationLevel     // the isolation level of the transaction
+	modifiedKeys map[Key]struct{}   // the keys set or deleted by the transaction
+	aborted      bool               // whether an ABORT entry has been written
+	abort        *abortCall         // the abort of the transaction in progress, if any
+	updates      []*pb.LogEntry     // the UPDATE entries (not yet undone) written by the transaction
+	writeBuffer  map[Key]Value      // the buffered writes, if the transaction defers writes
+	blind        bool               // whether the transaction's writes are blind
+	admitted     bool               // whether the transaction holds an admission slot
+	prepared     bool               // whether the transaction is prepared (two-phase commit)
+	begun        time.Time          // when the transaction was begun (or restored, when the store was opened)
+	readCache    map[Key]cachedRead // the values read by the transaction under locks it still holds
+	metaBuffer   map[Key]*pb.Meta   // the metadata of the buffered writes, if the transaction defers writes
+	memory       int64              // the approximate number of bytes held by the updates and write buffer of the transaction
+	commitLogged bool               // whether a COMMIT entry has been written, and not superseded by an ABORT entry (guarded by logLock)
+	abortLogged  bool               // whether an ABORT entry has been written (guarded by logLock)
+	identity     string             // the identity of the user of the transaction, passed to the authorizer of the store
+	bulkLoad     bool               // whether the transaction is a bulk load, whose UPDATE entries omit old values
+}
+
+// abortCall is an abort of a transaction in progress, which other aborts of
+// the transaction wait for.
+type abortCall struct {
+	done chan struct{} // closed once the abort has finished
+	err  error         // the result of the abort (set before done is closed)
+}
+
+// cachedRead is a value read by a transaction, with its metadata and committed
+// version.
+type cachedRead struct {
+	value   Value
+	meta    *pb.Meta
+	version uint64
+}
 
-func (cm currentMutexesMap) getWrappedRWMutex(k Key, smv *storeMapValue) *rwMutexWrapper {
-	if rw, ok := cm[k]; ok {
-		return rw
+func newTransactionState(opts TransactionOptions, begun time.Time) *transactionState {
+	ts := &transactionState{
+		isolation:    opts.Isolation,
+		modifiedKeys: make(map[Key]struct{}),
+		begun:        begun,
+	}
+	if opts.DeferWrites || opts.BlindWrites || opts.BulkLoad {
+		ts.writeBuffer = make(map[Key]Value)
+		ts.metaBuffer = make(map[Key]*pb.Meta)
 	}
-	_rw := wrapRWMutex(&smv.lock)
-	cm[k] = &_rw
-	return &_rw
+	ts.blind = opts.BlindWrites
+	ts.identity = opts.Identity
+	ts.bulkLoad = opts.BulkLoad
+	return ts
 }
 
+// logFileFmt is the default format of the names of log files, given the LSNs
+// of their first and last entries.
 var logFileFmt = "%012d_%012d.log"
 
 type logManager struct {
+	accessClock    uint64                              // the number of accesses to keys, updated atomically (first, so that it is 64-bit aligned)
+	config         config                              // the configuration of the store
 	log            pb.Log                              // the log of transaction operations
 	logDir         string                              // the directory in which log is stored
 	logLock        sync.Mutex                          // lock to synchronize access to the log
+	flushLock      sync.Mutex                          // lock to serialize flushes of the log (taken before logLock)
+	firstLSN       int                                 // the LSN of the first log entry (0 unless the log has been compacted)
 	nextLSN        int                                 // the LSN for the next log entry
 	nextLSNToFlush int                                 // the LSN of the next log entry to be flushed
+	lastTimestamp  int64                               // the timestamp of the last log entry
+	index          *logIndex                           // the index of the log entries by key and by transaction (guarded by logLock)
+	nextTID        int64                               // the ID of the next transaction to be begun
+	compactPending bool                                // whether the log files have not yet been replaced by a compacted log
+	compactions    int                                 // the number of times the log has been compacted
+	segmentCount   int                                 // the number of log files in the log directory (guarded by flushLock)
+	merges         chan struct{}                       // requests to merge the log files, if their number is limited
+	mergeLock      sync.Mutex                          // lock to serialize merges of the log files with each other and with compactions (taken before flushLock)
+	flushingEarly  int32                               // whether the log is being flushed because of lock contention, updated atomically
+	logFlushed     chan struct{}                       // closed (and replaced) when log entries are flushed or the log is compacted
+	storageErr     error                               // the error with which the log last failed to be flushed, if it has not been flushed since
+	readOnly       bool                                // whether the store has degraded to read-only, since the log directory became unwritable
+	closed         bool                                // whether the store has been closed
+	lockedDir      string                              // the absolute path of the log directory, while the store holds its lock (guarded by the lock of lockedDirs)
+	lockFile       *os.File                            // the open lock file of the log directory, while the store holds its lock (guarded by the lock of lockedDirs)
+	maintenance    *maintenance                        // the goroutines working in the background, stopped when the store is closed
+	flusher        *flusher                            // the background flusher of the log, if commits are batched
 	currMutexes    map[TransactionID]currentMutexesMap // the mutexes held currently by running transactions
+	transactions   map[TransactionID]*transactionState // the state of running transactions
 	store          storeMap                            // the master copy of the current state of the store
+	numKeys        int                                 // the number of keys with values in store
+	tombstones     int                                 // the number of deleted keys in store
+	valueBytes     int64                               // the total size of the values in store
+	stateLock      sync.Mutex                          // lock to synchronize access to the store and transactions
+	admission      chan struct{}                       // the admission slots held by running transactions, if limited
+	watchers       watchersMap                         // the watchers subscribed to each key
+	watchersLock   sync.Mutex                          // lock to synchronize access to watchers
+	hooks          commitHooks                         // the hooks called when transactions commit
+	recovery       RecoveryInfo                        // the summary of the recovery of the store when it was opened
+	chunkLock      sync.Mutex                          // lock to synchronize writes and collection of chunk files
+	pendingChunks  map[string]int                      // the number of writes in progress of each chunk, not yet in the store or the log (guarded by chunkLock)
 }
 
-func newLogManager(ld string) (lm *logManager, err error) {
-	lm = &logManager{}
+func newLogManager(ld string, opts ...Option) (lm *logManager, err error) {
+	lm = &logManager{config: defaultConfig()}
+	for _, opt := range opts {
+		opt(&lm.config)
+	}
 	lm.logDir = ld
 	if lm.logDir == "" {
-		lm.logDir = "./data"
+		lm.logDir = lm.config.defaultDir
+	}
+	if !validLogFileFmt(lm.config.logFileFmt) {
+		return nil, fmt.Errorf("invalid log file name format %q", lm.config.logFileFmt)
 	}
+	if err = lm.createLogDir(); err != nil {
+		return
+	}
+	if err = lm.lockDir(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lm.unlockDir()
+		}
+	}()
 	lm.currMutexes = make(map[TransactionID]currentMutexesMap)
+	lm.transactions = make(map[TransactionID]*transactionState)
 	lm.store = make(storeMap)
+	lm.watchers = make(watchersMap)
+	lm.logFlushed = make(chan struct{})
+	lm.maintenance = newMaintenance()
+	if lm.config.maxTransactions > 0 {
+		lm.admission = make(chan struct{}, lm.config.maxTransactions)
+	}
 
-	// Retrieve old logs if they exist
-	err = lm.retrieveLog()
+	// Retrieve old logs if they exist (finishing any interrupted merge or
+	// compaction)
+	if err = lm.finishMerge(); err != nil {
+		return
+	}
+	if err = lm.finishCompaction(); err != nil {
+		return
+	}
+	if err = lm.retrieveLog(); err != nil {
+		return
+	}
+	lm.recovery.Segments = lm.segmentCount
+	lm.recovery.Entries = len(lm.log.Entry)
 
-	// Replay log over storeMap
+	// Resume transaction IDs after those in the log
+	lm.nextTID = 1
 	for _, e := range lm.log.Entry {
-		tid := TransactionID(*e.Tid)
-		switch *e.EntryType {
-		case pb.LogEntry_BEGIN:
-			lm.currMutexes[tid] = make(currentMutexesMap)
-		case pb.LogEntry_UPDATE:
-			fallthrough
-		case pb.LogEntry_UNDO:
-			lm.updateStoreMapValue(lm.currMutexes[tid], Key(*e.Key), Value(CopyByteArray(e.NewValue)))
-		case pb.LogEntry_COMMIT:
-		case pb.LogEntry_ABORT:
-		case pb.LogEntry_END:
-			for _, rw := range lm.currMutexes[tid] {
-				rw.unlock()
-			}
-			delete(lm.currMutexes, tid)
+		if tid := e.GetTid(); tid >= lm.nextTID {
+			lm.nextTID = tid + 1
 		}
 	}
 
-	// Abort incomplete transactions
-	for tid, _ := range lm.currMutexes {
-		lm.abortTransaction(tid)
+	// Replay log over storeMap
+	analysis := analyzeLogEntries(lm.log.Entry)
+	if err = redoLogEntries(lm.log.Entry, analysis, lm.store, lm.config.recoveryConflicts); err != nil {
+		return
+	}
+	lm.countStoreValues()
+
+	// End committed transactions, abort crashed transactions and restore
+	// in-doubt prepared transactions
+	unended := make(map[TransactionID]struct{})
+	for tid, ta := range analysis {
+		if ta.ended {
+			continue
+		}
+		if ta.status == statusCommitted {
+			lm.addLogEntry(&pb.LogEntry{
+				Tid:       proto.Int64(int64(tid)),
+				EntryType: pb.LogEntry_END.Enum(),
+			})
+			lm.recovery.RolledForward = append(lm.recovery.RolledForward, tid)
+			continue
+		}
+		lm.currMutexes[tid] = newCurrentMutexesMap(lm.config.hashLockTable)
+		// Bulk loads are restored with their writes deferred, so that they
+		// are aborted by discarding them.
+		lm.transactions[tid] = newTransactionState(TransactionOptions{BulkLoad: ta.bulkLoad}, lm.config.clock.Now())
+		lm.transactions[tid].aborted = ta.status == statusAborted
+		lm.transactions[tid].abortLogged = ta.status == statusAborted
+		unended[tid] = struct{}{}
 	}
+	for tid, updates := range pendingUpdates(lm.log.Entry, unended) {
+		ts := lm.transactions[tid]
+		ts.updates = updates
+		for _, e := range updates {
+			ts.memory += logEntryMemory(e)
+		}
+	}
+	for tid := range unended {
+		if analysis[tid].inDoubt() {
+			lm.restorePreparedTransaction(tid)
+			lm.recovery.InDoubt = append(lm.recovery.InDoubt, tid)
+		} else {
+			lm.abortTransaction(tid)
+			lm.recovery.RolledBack = append(lm.recovery.RolledBack, tid)
+		}
+	}
+	if lm.nextLSNToFlush != lm.nextLSN {
+		lm.flushLog()
+	}
+	for _, tids := range [][]TransactionID{lm.recovery.RolledForward, lm.recovery.RolledBack, lm.recovery.InDoubt} {
+		sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	}
+	lm.recovery.LastLSN = int64(lm.nextLSN) - 1
 
+	if lm.config.batchCommits > 0 || lm.config.batchInterval > 0 {
+		lm.flusher = newFlusher()
+		lm.maintenance.start(lm.runFlusher)
+	}
+	if lm.config.maxSegments > 0 {
+		lm.merges = make(chan struct{}, 1)
+		lm.maintenance.start(lm.runMerger)
+		lm.flushLock.Lock()
+		lm.requestMerge()
+		lm.flushLock.Unlock()
+	}
 	return
 }
 
 func (lm *logManager) addLogEntry(e *pb.LogEntry) {
 	lm.logLock.Lock()
 	defer lm.logLock.Unlock()
+	lm.addLogEntryUnsafe(e)
+}
+
+// addUndoLogEntry adds the UNDO entry e for the UPDATE entry undone. The LSN
+// of undone is read with the log locked, since compaction may renumber it.
+func (lm *logManager) addUndoLogEntry(e, undone *pb.LogEntry) {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	e.UndoLsn = proto.Int64(undone.GetLsn())
+	lm.addLogEntryUnsafe(e)
+}
+
+// addTransactionLogEntry adds entry e of transaction tid, whose state is ts, to
+// the log, enforcing the order of the entries of a transaction: since LSNs are
+// assigned in the order entries are added, its UPDATE and PREPARE entries are
+// rejected with ErrTransactionFinished once its COMMIT or ABORT entry has been
+// added (as is a second COMMIT entry). Its COMMIT entry therefore has a
+// greater LSN than all of its updates, and is only followed by its END entry,
+// unless the commit could not be flushed and is superseded by an ABORT entry
+// (see abortUnflushedCommit). ABORT entries are never rejected.
+func (lm *logManager) addTransactionLogEntry(tid TransactionID, ts *transactionState, e *pb.LogEntry) error {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	switch e.GetEntryType() {
+	case pb.LogEntry_UPDATE, pb.LogEntry_PREPARE, pb.LogEntry_COMMIT:
+		if ts.commitLogged || ts.abortLogged {
+			return fmt.Errorf("%w: could not log %s entry of transaction with ID %d", ErrTransactionFinished, e.GetEntryType(), tid)
+		}
+		ts.commitLogged = e.GetEntryType() == pb.LogEntry_COMMIT
+	case pb.LogEntry_ABORT:
+		ts.commitLogged = false
+		ts.abortLogged = true
+	}
+	lm.addLogEntryUnsafe(e)
+	return nil
+}
+
+func (lm *logManager) addLogEntryUnsafe(e *pb.LogEntry) {
+	// Timestamps do not go backwards, even if the wall clock does.
+	timestamp := lm.config.clock.Now().UnixNano()
+	if timestamp < lm.lastTimestamp {
+		timestamp = lm.lastTimestamp
+	}
+	lm.lastTimestamp = timestamp
 
 	entries := &lm.log.Entry
 	e.Lsn = proto.Int64(int64(lm.nextLSN))
+	e.Timestamp = proto.Int64(timestamp)
 	*entries = append(*entries, e)
+	lm.index.add(e)
 	lm.nextLSN++
+	lm.config.metrics.IncCounter(MetricLogEntries)
 }
 
+// logEntry returns the entry of the log with LSN lsn, which must be in the
+// log. It must be called with logLock held.
+func (lm *logManager) logEntry(lsn int64) *pb.LogEntry {
+	return lm.log.Entry[lsn-int64(lm.firstLSN)]
+}
+
+// checkLSN returns an error unless lsn is the LSN of an entry in the log:
+// ErrCompacted if the entry was removed by a compaction. It must be called
+// with logLock held.
+func (lm *logManager) checkLSN(lsn int64) error {
+	if lsn >= 0 && lsn < int64(lm.firstLSN) {
+		return fmt.Errorf("%w: LSN %d is before the first LSN %d of the log", ErrCompacted, lsn, lm.firstLSN)
+	}
+	if lsn < 0 || lsn >= int64(lm.nextLSN) {
+		return fmt.Errorf("LSN %d is not in the log", lsn)
+	}
+	return nil
+}
+
+func (lm *logManager) createLogDir() error {
+	if lm.config.inMemory {
+		return nil
+	}
+	if _, err := os.Stat(lm.logDir); !os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(lm.logDir, lm.config.dirMode); err != nil {
+		return fmt.Errorf("could not create log directory: %v", err)
+	}
+	if err := os.Chmod(lm.logDir, lm.config.dirMode); err != nil {
+		return fmt.Errorf("could not set permissions of log directory: %v", err)
+	}
+	return nil
+}
+
+// retrieveLog replaces the log with the entries read from the log files in the
+// log directory. Since readLogFile merges each file into the log, the log is
+// reset first, so that retrieving the log again does not duplicate entries.
 func (lm *logManager) retrieveLog() (err error) {
-	files, err := ioutil.ReadDir(lm.logDir)
+	lm.log = pb.Log{}
+	lm.firstLSN = 0
+	lm.nextLSN = 0
+	lm.nextLSNToFlush = 0
+	defer func() {
+		lm.index = newLogIndex(lm.log.Entry)
+	}()
+	if lm.config.inMemory {
+		return nil
+	}
+	files, err := lm.logFiles()
 	if err != nil {
 		return fmt.Errorf("could not retrieve old logs: %v", err)
 	}
 
+	// The log starts after the entries removed by the last compaction, if any
+	if len(files) > 0 {
+		lm.firstLSN = files[0].startLSN
+		lm.nextLSN = lm.firstLSN
+	}
 	for _, file := range files {
-		if !file.IsDir() {
-			var startLSN, endLSN = -1, -1
-			_, err = fmt.Sscanf(file.Name(), logFileFmt, &startLSN, &endLSN)
-			if err != nil {
-				continue
-			}
-			if startLSN != lm.nextLSN || endLSN < startLSN {
-				err = fmt.Errorf("log file %s was not in the expected format", file.Name())
-				break
-			}
-			filename := fmt.Sprintf("%s/%s", lm.logDir, file.Name())
-			data, err := ioutil.ReadFile(filename)
-			if err != nil {
-				err = fmt.Errorf("could not read log file %s: %v", filename, err)
-				break
-			}
-			if err = proto.UnmarshalMerge(data, &lm.log); err != nil {
-				err = fmt.Errorf("could not unmarshal log file %s: %v", filename, err)
-				break
-			}
-			lm.nextLSN = len(lm.log.Entry)
-			if nextLSN := endLSN + 1; nextLSN != lm.nextLSN {
-				err = fmt.Errorf("log file %s did not have the right number of entries", filename)
-				break
-			}
+		if file.startLSN != lm.nextLSN || file.endLSN < file.startLSN {
+			err = fmt.Errorf("log file %s was not in the expected format", file.path)
+			break
+		}
+		filename := fmt.Sprintf("%s/%s", lm.logDir, file.path)
+		if err = lm.readLogFile(filename); err != nil {
+			break
+		}
+		lm.nextLSN = lm.firstLSN + len(lm.log.Entry)
+		if nextLSN := file.endLSN + 1; nextLSN != lm.nextLSN {
+			err = fmt.Errorf("log file %s did not have the right number of entries", filename)
+			break
 		}
 	}
 	lm.nextLSNToFlush = lm.nextLSN
+	lm.segmentCount = len(files)
 	return err
 }
 
+// readLogFile reads the entries of log file filename into the log. If enabled,
+// the file is mapped into memory instead of being read, and unmarshalled
+// directly from the mapped region; unmarshalling copies bytes fields, so the
+// entries do not refer to the region once it is unmapped.
+func (lm *logManager) readLogFile(filename string) error {
+	var data []byte
+	var err error
+	if lm.config.mmapLog {
+		var unmap func() error
+		if data, unmap, err = mmapFile(filename); err != nil {
+			return fmt.Errorf("could not map log file %s: %v", filename, err)
+		}
+		defer unmap()
+	} else if data, err = ioutil.ReadFile(filename); err != nil {
+		return fmt.Errorf("could not read log file %s: %v", filename, err)
+	}
+	if data, err = decompressLogData(data); err != nil {
+		return fmt.Errorf("could not read log file %s: %v", filename, err)
+	}
+	n := len(lm.log.Entry)
+	if err = proto.UnmarshalMerge(data, &lm.log); err != nil {
+		return fmt.Errorf("could not unmarshal log file %s: %v", filename, err)
+	}
+	if err = deltaDecodeLogEntries(lm.log.Entry[n:]); err != nil {
+		return fmt.Errorf("could not read log file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// flushLog writes out the entries added to the log since it was last flushed
+// as a new log file. Only flushes are serialized with each other; entries can
+// be added to the log while it is being flushed.
 func (lm *logManager) flushLog() error {
 	lm.logLock.Lock()
-	defer lm.logLock.Unlock()
+	lsn := lm.nextLSN - 1
+	lm.logLock.Unlock()
+	return lm.flushLogUpTo(lsn)
+}
+
+// flushLogUpTo writes out the entries added to the log since it was last
+// flushed, up to and including the entry with LSN lsn, as a new log file. If
+// that entry has already been flushed, nothing is written. A later flush
+// starts from the entry after lsn.
+func (lm *logManager) flushLogUpTo(lsn int) error {
+	lm.flushLock.Lock()
+	defer lm.flushLock.Unlock()
+
+	if lm.compactPending {
+		if err := lm.finishCompaction(); err != nil {
+			return err
+		}
+	}
 
-	entries := lm.log.GetEntry()
+	// Snapshot the entries to be flushed. Entries are not modified once they
+	// are added to the log (except by compaction, which also holds flushLock).
+	lm.logLock.Lock()
+	startLSN, endLSN := lm.nextLSNToFlush, lm.nextLSN
+	if lsn+1 < endLSN {
+		endLSN = lsn + 1
+	}
+	if endLSN < startLSN {
+		endLSN = startLSN
+	}
 	logToFlush := &pb.Log{
-		Entry: entries[lm.nextLSNToFlush:],
+		Entry: lm.log.Entry[startLSN-lm.firstLSN : endLSN-lm.firstLSN],
 	}
-	data, err := proto.Marshal(logToFlush)
+	lm.logLock.Unlock()
+	if startLSN == endLSN {
+		lm.setStorageErr(nil)
+		return nil
+	}
+	// The entries flushed by each log file are marked as flushed once it is
+	// written, so that a failed flush is retried from the first entry that was
+	// not written.
+	for _, r := range lm.segmentRanges(startLSN, logToFlush.Entry) {
+		if !lm.config.inMemory {
+			segment := &pb.Log{Entry: logToFlush.Entry[r.start-startLSN : r.end-startLSN]}
+			if err := lm.writeLogEntries(r.start, r.end, segment); err != nil {
+				return err
+			}
+			lm.segmentCount++
+			lm.requestMerge()
+		}
+
+		lm.logLock.Lock()
+		lm.nextLSNToFlush = r.end
+		lm.storageErr = nil
+		lm.signalLogFlushedUnsafe()
+		lm.logLock.Unlock()
+	}
+	return nil
+}
+
+// lsnRange is a range of LSNs, from start up to (but not including) end.
+type lsnRange struct {
+	start, end int
+}
+
+// segmentRanges splits entries, the first of which has LSN startLSN, into the
+// contiguous ranges of LSNs to be flushed as separate log files, so that each
+// log file is at most the maximum segment size (see WithMaxSegmentSize). An
+// entry larger than the maximum segment size is flushed as a log file of its
+// own.
+func (lm *logManager) segmentRanges(startLSN int, entries []*pb.LogEntry) []lsnRange {
+	endLSN := startLSN + len(entries)
+	if lm.config.maxSegmentBytes <= 0 || lm.config.inMemory {
+		return []lsnRange{{startLSN, endLSN}}
+	}
+	var ranges []lsnRange
+	start, size := startLSN, int64(0)
+	for i, e := range entries {
+		// The size of the entry as a field of the marshalled log
+		n := proto.Size(e)
+		entrySize := int64(1 + proto.SizeVarint(uint64(n)) + n)
+		if lsn := startLSN + i; lsn > start && size+entrySize > lm.config.maxSegmentBytes {
+			ranges = append(ranges, lsnRange{start, lsn})
+			start, size = lsn, 0
+		}
+		size += entrySize
+	}
+	return append(ranges, lsnRange{start, endLSN})
+}
+
+// writeLogEntries writes out the entries of log, with LSNs from startLSN up to
+// endLSN, as a new log file.
+func (lm *logManager) writeLogEntries(startLSN, endLSN int, log *pb.Log) error {
+	data, err := lm.marshalLogEntries(log.Entry)
 	if err != nil {
 		return fmt.Errorf("error while marshalling log to be flushed: %v", err)
 	}
-	filename := fmt.Sprintf(logFileFmt, lm.nextLSNToFlush, lm.nextLSN-1)
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", lm.logDir, filename), data, 0644); err != nil {
+	filename := fmt.Sprintf("%s/%s", lm.logDir, lm.logFilePath(startLSN, endLSN-1))
+	if lm.config.shardSize > 0 {
+		if err := os.MkdirAll(filepath.Dir(filename), lm.config.dirMode); err != nil {
+			lm.setStorageErr(err)
+			lm.degradeIfUnwritable()
+			return fmt.Errorf("error while creating log shard directory: %v", err)
+		}
+	}
+	if err := lm.writeLogFileWithRetries(filename, data); err != nil {
+		lm.setStorageErr(err)
+		lm.degradeIfUnwritable()
 		return fmt.Errorf("error while writing out log: %v", err)
 	}
-	lm.nextLSNToFlush = lm.nextLSN
 	return nil
 }
 
+// marshalLogEntries marshals entries as the contents of a log file, delta
+// encoding and compressing them if enabled. entries are not modified.
+func (lm *logManager) marshalLogEntries(entries []*pb.LogEntry) ([]byte, error) {
+	if lm.config.deltaEncode {
+		entries = deltaEncodeLogEntries(entries)
+	}
+	data, err := proto.Marshal(&pb.Log{Entry: entries})
+	if err != nil {
+		return nil, err
+	}
+	if lm.config.compressLog {
+		if data, err = compressLogData(data); err != nil {
+			return nil, fmt.Errorf("could not compress log: %v", err)
+		}
+	}
+	return data, nil
+}
+
+// nextTransactionID returns a new TransactionID. IDs are assigned in
+// increasing order, starting after the largest ID in the log when the store
+// was opened, so they are never reused.
 func (lm *logManager) nextTransactionID() TransactionID {
-	return TransactionID(rand.Int63())
+	return TransactionID(atomic.AddInt64(&lm.nextTID, 1) - 1)
 }
 
-func (lm *logManager) beginTransaction(tid TransactionID) {
-	lm.currMutexes[tid] = make(currentMutexesMap)
-	lm.addLogEntry(&pb.LogEntry{
+func (lm *logManager) beginTransaction(tid TransactionID) error {
+	return lm.beginTransactionWithOptions(tid, lm.defaultTransactionOptions())
+}
+
+// defaultTransactionOptions returns the options of transactions begun without
+// options.
+func (lm *logManager) defaultTransactionOptions() TransactionOptions {
+	return TransactionOptions{Isolation: lm.config.isolation}
+}
+
+// beginTransactionWithOptions begins a transaction configured by opts, waiting
+// if the maximum number of transactions are running. It fails if no
+// transactions can be begun (see checkCanBegin).
+func (lm *logManager) beginTransactionWithOptions(tid TransactionID, opts TransactionOptions) error {
+	if err := lm.checkCanBegin(); err != nil {
+		return err
+	}
+	ts := newTransactionState(opts, lm.config.clock.Now())
+	if lm.admission != nil {
+		lm.admission <- struct{}{}
+		ts.admitted = true
+	}
+	return lm.startTransaction(tid, ts)
+}
+
+// tryBeginTransactionWithOptions begins a transaction like
+// beginTransactionWithOptions, but returns ErrTooManyTransactions instead of
+// waiting if the maximum number of transactions are running, and fails if no
+// transactions can be begun (see checkCanBegin).
+func (lm *logManager) tryBeginTransactionWithOptions(tid TransactionID, opts TransactionOptions) error {
+	if err := lm.checkCanBegin(); err != nil {
+		return err
+	}
+	ts := newTransactionState(opts, lm.config.clock.Now())
+	if lm.admission != nil {
+		select {
+		case lm.admission <- struct{}{}:
+			ts.admitted = true
+		default:
+			return ErrTooManyTransactions
+		}
+	}
+	return lm.startTransaction(tid, ts)
+}
+
+// checkCanBegin returns ErrStoreClosed if the store has been closed, and
+// ErrDirectoryLocked if it no longer holds the lock on its log directory.
+func (lm *logManager) checkCanBegin() error {
+	lm.logLock.Lock()
+	closed := lm.closed
+	lm.logLock.Unlock()
+	if closed {
+		return ErrStoreClosed
+	}
+	return lm.checkDirLocked()
+}
+
+// startTransaction starts transaction tid with state ts, and adds its BEGIN
+// entry to the log. It fails with ErrStoreClosed if the store was closed since
+// checkCanBegin was called, so that no BEGIN entry is added once it is closed.
+func (lm *logManager) startTransaction(tid TransactionID, ts *transactionState) error {
+	lm.stateLock.Lock()
+	lm.currMutexes[tid] = newCurrentMutexesMap(lm.config.hashLockTable)
+	lm.transactions[tid] = ts
+	lm.stateLock.Unlock()
+
+	begin := &pb.LogEntry{
 		Tid:       proto.Int64(int64(tid)),
 		EntryType: pb.LogEntry_BEGIN.Enum(),
-	})
+	}
+	if ts.bulkLoad {
+		begin.BulkLoad = proto.Bool(true)
+	}
+	lm.logLock.Lock()
+	closed := lm.closed
+	if !closed {
+		lm.addLogEntryUnsafe(begin)
+	}
+	lm.logLock.Unlock()
+	if closed {
+		lm.stateLock.Lock()
+		delete(lm.currMutexes, tid)
+		delete(lm.transactions, tid)
+		lm.stateLock.Unlock()
+		if ts.admitted {
+			<-lm.admission
+		}
+		return ErrStoreClosed
+	}
+	return nil
 }
 
 func (lm *logManager) getValue(tid TransactionID, k Key) (Value, error) {
+	v, _, err := lm.getValueWithVersion(tid, k)
+	return v, err
+}
+
+// getValueWithVersion retrieves the value of key k in a transaction, along
+// with its committed version.
+func (lm *logManager) getValueWithVersion(tid TransactionID, k Key) (value Value, version uint64, err error) {
+	err = lm.readValue(tid, k, func(v Value, ver uint64) {
+		value, version = CopyByteArray(v), ver
+	})
+	return value, version, err
+}
+
+// getValueRange retrieves length bytes of the value of key k in a transaction,
+// starting at offset. Only the requested bytes are copied.
+func (lm *logManager) getValueRange(tid TransactionID, k Key, offset, length int) (value Value, err error) {
+	rerr := lm.readValue(tid, k, func(v Value, _ uint64) {
+		if offset < 0 || length < 0 || offset > len(v) || length > len(v)-offset {
+			err = fmt.Errorf("%w: [%d, %d) of %d bytes for key %q", ErrRangeOutOfBounds, offset, offset+length, len(v), k)
+			return
+		}
+		value = CopyByteArray(v[offset : offset+length])
+	})
+	if rerr != nil {
+		return nil, rerr
+	}
+	return value, err
+}
+
+// readValue calls read with the value of key k in a transaction, and its
+// committed version, while the value is read-locked. read must not retain the
+// value.
+func (lm *logManager) readValue(tid TransactionID, k Key, read func(v Value, version uint64)) error {
+	return lm.readValueWithMeta(tid, k, func(v Value, _ *pb.Meta, version uint64) {
+		read(v, version)
+	})
+}
+
+// readValueWithMeta is readValue, but also calls read with the metadata of
+// the value.
+func (lm *logManager) readValueWithMeta(tid TransactionID, k Key, read func(v Value, meta *pb.Meta, version uint64)) error {
+	return lm.readValueWaiting(tid, k, true, read)
+}
+
+// tryGetValue retrieves the value of key k in a transaction like getValue, but
+// does not wait for the read lock on k. If k is write-locked by another
+// transaction, it returns false.
+func (lm *logManager) tryGetValue(tid TransactionID, k Key) (Value, bool, error) {
+	var value Value
+	err := lm.readValueWaiting(tid, k, false, func(v Value, _ *pb.Meta, _ uint64) {
+		value = CopyByteArray(v)
+	})
+	if errors.Is(err, errWouldBlock) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// errWouldBlock is returned by readValueWaiting when it is not to wait for the
+// read lock on a key, and the lock can not be taken immediately.
+var errWouldBlock = errors.New("key is write-locked")
+
+// readValueWaiting is readValueWithMeta, but if wait is not set, it returns
+// errWouldBlock instead of waiting for the read lock on k.
+func (lm *logManager) readValueWaiting(tid TransactionID, k Key, wait bool, read func(v Value, meta *pb.Meta, version uint64)) error {
+	if !lm.chunkStoreEnabled() {
+		return lm.readStoredValue(tid, k, wait, read)
+	}
+	var chunkErr error
+	err := lm.readStoredValue(tid, k, wait, func(v Value, meta *pb.Meta, version uint64) {
+		if v, chunkErr = lm.resolveChunk(v); chunkErr == nil {
+			read(v, meta, version)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return chunkErr
+}
+
+// readStoredValue is readValueWaiting, but calls read with the value of k as
+// it is stored (e.g. a chunk reference).
+func (lm *logManager) readStoredValue(tid TransactionID, k Key, wait bool, read func(v Value, meta *pb.Meta, version uint64)) error {
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessRead, k); err != nil {
+		return err
+	}
+	lm.stateLock.Lock()
 	cm, ok := lm.currMutexes[tid]
 	if !ok {
-		return nil, fmt.Errorf("transaction with ID %d is not currently running", tid)
+		lm.stateLock.Unlock()
+		return fmt.Errorf("transaction with ID %d is not currently running", tid)
 	}
+	ts := lm.transactions[tid]
+	if v, ok := ts.writeBuffer[k]; ok {
+		// Buffered writes are visible only to the transaction itself.
+		var version uint64
+		var current *pb.Meta
+		if smv, ok := lm.store[k]; ok {
+			version, current = smv.version, smv.meta
+		}
+		meta := resolveMeta(ts.metaBuffer[k], current, v)
+		lm.stateLock.Unlock()
+		if v == nil {
+			return fmt.Errorf("could not retrieve value: %w: %q", ErrKeyNotFound, k)
+		}
+		read(v, meta, version)
+		return nil
+	}
+	isolation := ts.isolation
 	smv, err := lm.store.storeMapValue(k, false)
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve value: %v", err)
+		lm.stateLock.Unlock()
+		return fmt.Errorf("could not retrieve value: %w", err)
+	}
+	atomic.AddUint64(&smv.reads, 1)
+	lm.touch(smv)
+	rw, held := cm.get(k)
+	if c, ok := ts.readCache[k]; ok && held && (rw.rLocked() || rw.wLocked()) {
+		// The value can not have changed since it was read, since the lock
+		// on it is still held and the transaction has not written it.
+		lm.stateLock.Unlock()
+		read(c.value, c.meta, c.version)
+		return nil
 	}
+	if !held && isolation == ReadCommitted {
+		// Take the read lock only for the duration of the read.
+		lm.stateLock.Unlock()
+		if !wait {
+			if !smv.lock.TryRLock() {
+				return errWouldBlock
+			}
+		} else if !lockWithTimeout(lm.config.clock, smv.lock.TryRLock, smv.lock.RLock, lm.config.lockTimeout) {
+			return lockTimeoutError(k)
+		}
+		defer smv.lock.RUnlock()
+		if smv.value == nil {
+			// Deleted while waiting for the lock
+			return fmt.Errorf("could not retrieve value: %w: %q", ErrKeyNotFound, k)
+		}
+		read(smv.value, smv.meta, smv.version)
+		return nil
+	}
+	if !held {
+		rw = cm.getWrappedRWMutex(k, smv)
+	}
+	lm.stateLock.Unlock()
 
-	rw := cm.getWrappedRWMutex(k, smv)
-	rw.rLock()
-	return smv.value, nil
+	if !wait {
+		if !rw.tryRLock() {
+			return errWouldBlock
+		}
+	} else if err := lm.rLock(rw, k); err != nil {
+		return err
+	}
+	if smv.value == nil {
+		// Deleted (or never set, if the transaction creating it aborted)
+		// while waiting for the lock
+		return fmt.Errorf("could not retrieve value: %w: %q", ErrKeyNotFound, k)
+	}
+	c := cachedRead{smv.value, smv.meta, smv.version}
+	lm.stateLock.Lock()
+	if ts.readCache == nil {
+		ts.readCache = make(map[Key]cachedRead)
+	}
+	ts.readCache[k] = c
+	lm.stateLock.Unlock()
+	read(c.value, c.meta, c.version)
+	return nil
 }
 
-func (lm *logManager) updateStoreMapValue(cm currentMutexesMap, k Key, v Value) (oldValue, newValue []byte, err error) {
+// updateStoreMapValue sets the value of key k in the store to v with metadata
+// meta (which may be keepMeta), taking the write lock on k for transaction ts.
+// It returns the old and new values and metadata of k to be logged.
+func (lm *logManager) updateStoreMapValue(cm currentMutexesMap, ts *transactionState, k Key, v Value, meta *pb.Meta) (oldValue, newValue []byte, oldMeta, newMeta *pb.Meta, err error) {
+	lm.stateLock.Lock()
 	smv, err := lm.store.storeMapValue(k, true)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not retrieve value: %v", err)
+		lm.stateLock.Unlock()
+		return nil, nil, nil, nil, fmt.Errorf("could not retrieve value: %w", err)
 	}
-
 	rw := cm.getWrappedRWMutex(k, smv)
-	rw.wLock()
+	lm.stateLock.Unlock()
+
+	if err := lm.wLock(ts, rw, k); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
 	if smv.value != nil {
 		oldValue = CopyByteArray(smv.value)
 	}
 	if v != nil {
-		smv.value = v
 		newValue = CopyByteArray(v)
-	} else {
-		delete(lm.store, k)
 	}
+	oldMeta, newMeta = smv.meta, resolveMeta(meta, smv.meta, v)
+	lm.setStoreValue(k, smv, v, newMeta)
 
 	return
 }
 
+// checkTransactionSize returns ErrTransactionTooLarge if modifying key k in
+// transaction ts would exceed the maximum number of keys modified by a
+// transaction. It must be called with stateLock held.
+func (lm *logManager) checkTransactionSize(ts *transactionState, k Key) error {
+	max := lm.config.maxTxnKeys
+	if max <= 0 {
+		return nil
+	}
+	if _, ok := ts.modifiedKeys[k]; !ok && len(ts.modifiedKeys) >= max {
+		return fmt.Errorf("%w: transaction can not modify more than %d keys", ErrTransactionTooLarge, max)
+	}
+	return nil
+}
+
 func (lm *logManager) updateValue(tid TransactionID, k Key, v Value) error {
+	return lm.updateValueWithMeta(tid, k, v, keepMeta)
+}
+
+// updateValueWithMeta sets the value of key k in a transaction to v (or
+// deletes k if v is nil) with metadata meta, which may be keepMeta to keep the
+// metadata of k. v is stored as a chunk if it is large (see WithChunkStore).
+func (lm *logManager) updateValueWithMeta(tid TransactionID, k Key, v Value, meta *pb.Meta) error {
+	v, release, err := lm.chunkValue(v)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return lm.updateStoredValueWithMeta(tid, k, v, meta)
+}
+
+// updateStoredValueWithMeta is updateValueWithMeta, but v is the value to be
+// stored and logged as is (e.g. a chunk reference). The update is not
+// authorized: the operations calling it authorize themselves.
+func (lm *logManager) updateStoredValueWithMeta(tid TransactionID, k Key, v Value, meta *pb.Meta) error {
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.checkStorageAvailable(); err != nil {
+		return err
+	}
+	lm.stateLock.Lock()
 	cm, ok := lm.currMutexes[tid]
+	ts := lm.transactions[tid]
+	err := checkNotPrepared(tid, ts)
+	if err == nil && ok {
+		err = lm.checkTransactionSize(ts, k)
+	}
+	lm.stateLock.Unlock()
 	if !ok {
 		return fmt.Errorf("transaction with ID %d is not currently running.", tid)
 	}
-	oldValue, newValue, err := lm.updateStoreMapValue(cm, k, v)
+	if err != nil {
+		return err
+	}
+	if ts.blind {
+		lm.stageBlindValue(ts, k, v, meta)
+		return nil
+	}
+	var oldValue, newValue []byte
+	var oldMeta, newMeta *pb.Meta
+	if ts.writeBuffer != nil {
+		oldValue, newValue, oldMeta, newMeta, err = lm.stageValue(cm, ts, k, v, meta)
+	} else {
+		oldValue, newValue, oldMeta, newMeta, err = lm.updateStoreMapValue(cm, ts, k, v, meta)
+	}
 	if err != nil {
 		return err
 	}
 
 	// Write log entry
-	lm.addLogEntry(&pb.LogEntry{
+	e := &pb.LogEntry{
 		Tid:       proto.Int64(int64(tid)),
 		EntryType: pb.LogEntry_UPDATE.Enum(),
 		Key:       proto.String(string(k)),
 		OldValue:  oldValue,
 		NewValue:  newValue,
-	})
+		OldMeta:   oldMeta,
+		NewMeta:   newMeta,
+	}
+	if ts.bulkLoad {
+		e.OldValue, e.OldMeta = nil, nil
+	}
+	if v != nil {
+		lm.stateLock.Lock()
+		e.Version = proto.Uint64(lm.store[k].version + 1)
+		lm.stateLock.Unlock()
+	}
+	if err := lm.addTransactionLogEntry(tid, ts, e); err != nil {
+		// The transaction committed or aborted concurrently.
+		if ts.writeBuffer == nil {
+			lm.updateStoreMapValue(cm, ts, k, Value(oldValue), oldMeta)
+		}
+		return err
+	}
 
+	lm.stateLock.Lock()
+	ts.modifiedKeys[k] = struct{}{}
+	ts.updates = append(ts.updates, e)
+	ts.memory += logEntryMemory(e)
+	delete(ts.readCache, k)
+	if smv, ok := lm.store[k]; ok {
+		atomic.AddUint64(&smv.writes, 1)
+		lm.touch(smv)
+	}
+	lm.stateLock.Unlock()
 	return nil
 }
 
 func (lm *logManager) setValue(tid TransactionID, k Key, v Value) error {
 	if v == nil {
-		return fmt.Errorf("value is nil.")
+		return ErrNilValue
+	}
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessWrite, k); err != nil {
+		return err
 	}
 	return lm.updateValue(tid, k, v)
 }
 
 func (lm *logManager) deleteValue(tid TransactionID, k Key) error {
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessDelete, k); err != nil {
+		return err
+	}
+	lm.stateLock.Lock()
 	_, err := lm.store.storeMapValue(k, false)
+	if ts, ok := lm.transactions[tid]; ok {
+		if v, staged := ts.writeBuffer[k]; staged && v == nil {
+			err = fmt.Errorf("%w: %q", ErrKeyNotFound, k)
+		} else if staged {
+			err = nil
+		}
+	}
+	lm.stateLock.Unlock()
 	if err != nil {
 		return err
 	}
 	return lm.updateValue(tid, k, nil)
 }
 
+// deletePrefix deletes the keys starting with prefix in a transaction, and
+// returns the number of keys deleted. The matching keys are found with the
+// store locked, then write-locked (through acquireLocks) and deleted in order.
+func (lm *logManager) deletePrefix(tid TransactionID, prefix Key) (int, error) {
+	lm.stateLock.Lock()
+	var keys []Key
+	for k := range lm.store {
+		if strings.HasPrefix(string(k), string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	if ts, ok := lm.transactions[tid]; ok {
+		for k := range ts.writeBuffer {
+			if _, ok := lm.store[k]; !ok && strings.HasPrefix(string(k), string(prefix)) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	lm.stateLock.Unlock()
+
+	for _, k := range keys {
+		if err := lm.authorize(tid, AccessDelete, k); err != nil {
+			return 0, err
+		}
+	}
+	ts, smvs, err := lm.acquireLocks(tid, keys, true)
+	if err != nil {
+		return 0, err
+	}
+	lm.stateLock.Lock()
+	var present []Key
+	for k, smv := range smvs {
+		v, staged := ts.writeBuffer[k]
+		if !staged {
+			v = smv.value
+		}
+		if v != nil {
+			present = append(present, k)
+		}
+	}
+	lm.stateLock.Unlock()
+	sort.Slice(present, func(i, j int) bool { return present[i] < present[j] })
+
+	for i, k := range present {
+		if err := lm.updateValue(tid, k, nil); err != nil {
+			return i, err
+		}
+	}
+	return len(present), nil
+}
+
+// getDeleteValue deletes key k in a transaction and returns the value it had.
+// The write lock on k is taken before it is read, so no lock is upgraded.
+func (lm *logManager) getDeleteValue(tid TransactionID, k Key) (Value, error) {
+	if err := lm.validateKey(k); err != nil {
+		return nil, err
+	}
+	if err := lm.authorize(tid, AccessDelete, k); err != nil {
+		return nil, err
+	}
+	var oldValue Value
+	err := lm.applyValueFunc(tid, k, func(v Value) (Value, error) {
+		if v == nil {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, k)
+		}
+		oldValue = v
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldValue, nil
+}
+
+// appendValue appends suffix to the value of key k in a transaction, creating k
+// if it does not exist, and returns the new length of the value. The UPDATE
+// entry records the value before the append, so it is undone like a set.
+func (lm *logManager) appendValue(tid TransactionID, k Key, suffix Value) (int, error) {
+	var n int
+	err := lm.updateValueFunc(tid, k, func(v Value) (Value, error) {
+		newValue := make(Value, 0, len(v)+len(suffix))
+		newValue = append(append(newValue, v...), suffix...)
+		n = len(newValue)
+		return newValue, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// updateValueFunc updates the value of key k in a transaction to the result of
+// calling fn with the current value of k (nil if it does not exist). The write
+// lock on k is taken before fn is called. If fn returns nil, k is deleted. If
+// fn returns an error, k is not updated.
+func (lm *logManager) updateValueFunc(tid TransactionID, k Key, fn func(Value) (Value, error)) error {
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessWrite, k); err != nil {
+		return err
+	}
+	return lm.applyValueFunc(tid, k, fn)
+}
+
+// applyValueFunc is updateValueFunc, but the update is not authorized.
+func (lm *logManager) applyValueFunc(tid TransactionID, k Key, fn func(Value) (Value, error)) error {
+	ts, smv, err := lm.wLockValue(tid, k)
+	if err != nil {
+		return err
+	}
+
+	lm.stateLock.Lock()
+	oldValue, staged := ts.writeBuffer[k]
+	if !staged {
+		oldValue = smv.value
+	}
+	oldValue = CopyByteArray(oldValue)
+	lm.stateLock.Unlock()
+	if oldValue, err = lm.resolveChunk(oldValue); err != nil {
+		return err
+	}
+
+	newValue, err := fn(oldValue)
+	if err != nil {
+		return err
+	}
+	if newValue == nil && oldValue == nil {
+		return nil
+	}
+	return lm.updateValue(tid, k, newValue)
+}
+
+// setValueIfVersion sets the value of key k in a transaction to v if the
+// committed version of k is expected (0 if k should not exist). The write lock
+// on k is taken before its version is checked.
+func (lm *logManager) setValueIfVersion(tid TransactionID, k Key, v Value, expected uint64) error {
+	if v == nil {
+		return ErrNilValue
+	}
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessWrite, k); err != nil {
+		return err
+	}
+	_, smv, err := lm.wLockValue(tid, k)
+	if err != nil {
+		return err
+	}
+
+	lm.stateLock.Lock()
+	version := smv.version
+	lm.stateLock.Unlock()
+	if version != expected {
+		return fmt.Errorf("%w: key %q has version %d, expected %d", ErrVersionMismatch, k, version, expected)
+	}
+	return lm.updateValue(tid, k, v)
+}
+
+// deleteValueIf deletes key k in a transaction if its value is expected, and
+// returns whether it was deleted. The write lock on k is taken before its
+// value is compared, so the value can not change in between.
+func (lm *logManager) deleteValueIf(tid TransactionID, k Key, expected Value) (bool, error) {
+	if err := lm.validateKey(k); err != nil {
+		return false, err
+	}
+	if err := lm.authorize(tid, AccessDelete, k); err != nil {
+		return false, err
+	}
+	ts, smv, err := lm.wLockValue(tid, k)
+	if err != nil {
+		return false, err
+	}
+
+	lm.stateLock.Lock()
+	current, staged := ts.writeBuffer[k]
+	if !staged {
+		current = smv.value
+	}
+	lm.stateLock.Unlock()
+	if current, err = lm.resolveChunk(current); err != nil {
+		return false, err
+	}
+	if current == nil {
+		return false, fmt.Errorf("%w: %q", ErrKeyNotFound, k)
+	}
+	if !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err := lm.updateValue(tid, k, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkAndSet sets the keys in sets to their values in a transaction if every
+// key in checks has the given value (nil if the key must not exist), and
+// returns whether they were set. The write locks on all of the keys are taken
+// (in order) before any of them is checked, so their values can not change in
+// between.
+func (lm *logManager) checkAndSet(tid TransactionID, checks, sets map[Key]Value) (bool, error) {
+	keys := make([]Key, 0, len(checks)+len(sets))
+	for k := range checks {
+		if err := lm.validateKey(k); err != nil {
+			return false, err
+		}
+		// Checked keys are locked for writing, so they are authorized for it too
+		if err := lm.authorize(tid, AccessWrite, k); err != nil {
+			return false, err
+		}
+		keys = append(keys, k)
+	}
+	for k, v := range sets {
+		if v == nil {
+			return false, ErrNilValue
+		}
+		if err := lm.validateKey(k); err != nil {
+			return false, err
+		}
+		if err := lm.authorize(tid, AccessWrite, k); err != nil {
+			return false, err
+		}
+		if _, ok := checks[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	ts, smvs, err := lm.acquireLocks(tid, keys, true)
+	if err != nil {
+		return false, err
+	}
+
+	lm.stateLock.Lock()
+	current := make(map[Key]Value, len(checks))
+	for k := range checks {
+		v, staged := ts.writeBuffer[k]
+		if !staged {
+			v = smvs[k].value
+		}
+		current[k] = v
+	}
+	lm.stateLock.Unlock()
+	for k, expected := range checks {
+		v, err := lm.resolveChunk(current[k])
+		if err != nil {
+			return false, err
+		}
+		if (v == nil) != (expected == nil) || !bytes.Equal(v, expected) {
+			return false, nil
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if v, ok := sets[k]; ok {
+			if err := lm.updateValue(tid, k, v); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// setValueIfChanged sets the value of key k in a transaction to v unless it
+// already has that value, and returns whether it was set. The current value is
+// read under a read lock, so an unchanged value is neither logged nor locked
+// for writing.
+func (lm *logManager) setValueIfChanged(tid TransactionID, k Key, v Value) (bool, error) {
+	if v == nil {
+		return false, ErrNilValue
+	}
+	if err := lm.validateKey(k); err != nil {
+		return false, err
+	}
+	if err := lm.authorize(tid, AccessWrite, k); err != nil {
+		return false, err
+	}
+	var unchanged bool
+	err := lm.readValue(tid, k, func(current Value, _ uint64) {
+		unchanged = bytes.Equal(current, v)
+	})
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return false, err
+	}
+	if unchanged {
+		return false, nil
+	}
+	if err := lm.updateValue(tid, k, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// renameValue moves the value of key from to key to in a transaction. The
+// write locks on both keys are taken (in order) before either is checked. If to
+// exists, it is overwritten only if overwrite is set.
+func (lm *logManager) renameValue(tid TransactionID, from, to Key, overwrite bool) error {
+	for _, k := range []Key{from, to} {
+		if err := lm.validateKey(k); err != nil {
+			return err
+		}
+	}
+	if err := lm.authorize(tid, AccessDelete, from); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessWrite, to); err != nil {
+		return err
+	}
+	ts, smvs, err := lm.acquireLocks(tid, []Key{from, to}, true)
+	if err != nil {
+		return err
+	}
+
+	lm.stateLock.Lock()
+	values := make(map[Key]Value)
+	var meta *pb.Meta
+	for k, smv := range smvs {
+		v, staged := ts.writeBuffer[k]
+		if !staged {
+			v = smv.value
+		}
+		values[k] = CopyByteArray(v)
+		if k == from {
+			meta = smv.meta
+			if staged {
+				meta = resolveMeta(ts.metaBuffer[k], smv.meta, v)
+			}
+		}
+	}
+	lm.stateLock.Unlock()
+
+	if values[from] == nil {
+		return fmt.Errorf("could not rename key: %w: %q", ErrKeyNotFound, from)
+	}
+	if from == to {
+		return nil
+	}
+	if values[to] != nil && !overwrite {
+		return fmt.Errorf("could not rename key: %w: %q", ErrKeyExists, to)
+	}
+	// The stored value is moved, so a chunked value is not stored again.
+	if err := lm.updateStoredValueWithMeta(tid, to, values[from], meta); err != nil {
+		return err
+	}
+	return lm.updateValue(tid, from, nil)
+}
+
+// wLockValue takes the write lock on key k in a transaction, adding k to the
+// store if it does not exist, and returns the state of the transaction and the
+// value of k in the store.
+func (lm *logManager) wLockValue(tid TransactionID, k Key) (*transactionState, *storeMapValue, error) {
+	ts, smvs, err := lm.acquireLocks(tid, []Key{k}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ts, smvs[k], nil
+}
+
+// acquireLocks takes the write locks (if write is set) or the read locks on
+// keys in a transaction. The locks are taken in key order, regardless of the
+// order of keys, so transactions locking overlapping sets of keys can not
+// deadlock with each other. For write locks, keys that do not exist are added
+// to the store; for read locks, they are skipped. It returns the state of the
+// transaction and the values of the locked keys in the store. Every operation
+// that locks more than one key must take its locks through acquireLocks.
+func (lm *logManager) acquireLocks(tid TransactionID, keys []Key, write bool) (*transactionState, map[Key]*storeMapValue, error) {
+	sorted := make([]Key, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	lm.stateLock.Lock()
+	cm, ok := lm.currMutexes[tid]
+	if !ok {
+		lm.stateLock.Unlock()
+		return nil, nil, fmt.Errorf("transaction with ID %d is not currently running.", tid)
+	}
+	ts := lm.transactions[tid]
+	if write {
+		if err := checkNotPrepared(tid, ts); err != nil {
+			lm.stateLock.Unlock()
+			return nil, nil, err
+		}
+	}
+	lm.stateLock.Unlock()
+
+	smvs := make(map[Key]*storeMapValue, len(sorted))
+	for i, k := range sorted {
+		if i > 0 && k == sorted[i-1] {
+			continue
+		}
+		lm.stateLock.Lock()
+		smv, err := lm.store.storeMapValue(k, write)
+		if err != nil {
+			lm.stateLock.Unlock()
+			continue
+		}
+		rw := cm.getWrappedRWMutex(k, smv)
+		lm.stateLock.Unlock()
+
+		if write {
+			err = lm.wLock(ts, rw, k)
+		} else {
+			err = lm.rLock(rw, k)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		smvs[k] = smv
+	}
+	return ts, smvs, nil
+}
+
+// lockTimeoutError returns the error with which taking a lock on key k fails
+// when it times out.
+func lockTimeoutError(k Key) error {
+	return fmt.Errorf("%w: key %q", ErrTimeout, k)
+}
+
+// rLock takes the read lock rw on key k, waiting for at most the lock timeout
+// of the store.
+func (lm *logManager) rLock(rw *rwMutexWrapper, k Key) error {
+	if lm.config.flushOnContention && !rw.tryRLock() {
+		lm.flushEarly()
+	}
+	if !rw.rLockTimeout(lm.config.clock, lm.config.lockTimeout) {
+		return lockTimeoutError(k)
+	}
+	return nil
+}
+
+// wLock takes the write lock rw on key k for transaction ts, waiting for at
+// most the lock timeout of the store. If a read lock on k is promoted, the
+// value read under it is no longer cached.
+func (lm *logManager) wLock(ts *transactionState, rw *rwMutexWrapper, k Key) error {
+	promoting := rw.rLocked()
+	if lm.config.flushOnContention && !rw.tryWLock() {
+		lm.flushEarly()
+	}
+	if err := rw.wLockTimeout(lm.config.clock, lm.config.lockTimeout); err != nil {
+		return fmt.Errorf("%w: key %q", err, k)
+	}
+	if promoting {
+		lm.stateLock.Lock()
+		delete(ts.readCache, k)
+		lm.stateLock.Unlock()
+	}
+	return nil
+}
+
+// flushEarly flushes the log in the background, unless it is already being
+// flushed early, when a transaction has to wait for a lock. The entries of the
+// transaction holding the lock are then (mostly) flushed before it commits,
+// so its commit has less of the log to flush, and it releases its locks
+// sooner. Flushing the entries of transactions that have not committed is
+// safe, since they are rolled back by recovery if the store crashes.
+func (lm *logManager) flushEarly() {
+	if !atomic.CompareAndSwapInt32(&lm.flushingEarly, 0, 1) {
+		return
+	}
+	started := lm.maintenance.start(func(<-chan struct{}) {
+		defer atomic.StoreInt32(&lm.flushingEarly, 0)
+		lm.flushLog()
+	})
+	if !started {
+		atomic.StoreInt32(&lm.flushingEarly, 0)
+	}
+}
+
 func (lm *logManager) commitTransaction(tid TransactionID) error {
+	_, err := lm.commitTransactionWithLSN(tid)
+	return err
+}
+
+// commitTransactionWithLSN commits and ends a transaction, and returns the LSN
+// of its END entry.
+func (lm *logManager) commitTransactionWithLSN(tid TransactionID) (int64, error) {
+	lm.stateLock.Lock()
 	cm, ok := lm.currMutexes[tid]
+	ts := lm.transactions[tid]
+	lm.stateLock.Unlock()
 	if !ok {
-		return fmt.Errorf("transaction with ID %d is not currently running", tid)
+		return 0, fmt.Errorf("transaction with ID %d is not currently running", tid)
+	}
+	if err := lm.checkStorageAvailable(); err != nil {
+		return 0, err
+	}
+	if ts.blind {
+		if err := lm.logBlindWrites(tid, ts); err != nil {
+			lm.abortTransaction(tid)
+			return 0, fmt.Errorf("transaction was aborted: %w", err)
+		}
+	}
+	// The pre-commit hooks of a prepared transaction were run when it was
+	// prepared, since it can no longer be vetoed.
+	writes := lm.hookWriteSet(ts)
+	if !ts.prepared {
+		if err := lm.runPreCommitHooks(tid, writes); err != nil {
+			lm.abortTransaction(tid)
+			return 0, fmt.Errorf("transaction was aborted: %w", err)
+		}
 	}
 
 	// Write out COMMIT and END log entries
-	lm.addLogEntry(&pb.LogEntry{
+	commit := &pb.LogEntry{
 		Tid:       proto.Int64(int64(tid)),
 		EntryType: pb.LogEntry_COMMIT.Enum(),
-	})
+	}
+	if err := lm.addTransactionLogEntry(tid, ts, commit); err != nil {
+		return 0, err
+	}
 
-	lm.addLogEntry(&pb.LogEntry{
+	end := &pb.LogEntry{
 		Tid:       proto.Int64(int64(tid)),
 		EntryType: pb.LogEntry_END.Enum(),
-	})
+	}
+	lm.addLogEntry(end)
+	lsn := end.GetLsn()
 
-	// Flush out log
-	if err := lm.flushLog(); err != nil {
-		return fmt.Errorf("error while flushing log: %v", err)
+	// Flush out log (or leave it to the flusher). If the log can not be
+	// flushed, the transaction is aborted.
+	if err := lm.flushCommit(); err != nil && lm.abortUnflushedCommit(tid, ts, commit) {
+		lm.abortTransaction(tid)
+		return 0, fmt.Errorf("%w: transaction was aborted: %v", ErrStorageUnavailable, err)
 	}
 
-	// Release all locks and remove from current transactions
-	for _, rw := range cm {
-		rw.unlock()
+	watched := lm.watchedValues(ts)
+	lm.stateLock.Lock()
+	if ts.writeBuffer != nil {
+		lm.commitWriteBuffer(ts)
 	}
-	delete(lm.currMutexes, tid)
-	return nil
+
+	// Update the versions of keys modified by the transaction, and notify
+	// their watchers
+	for k := range ts.modifiedKeys {
+		if smv, ok := lm.store[k]; ok && smv.value != nil {
+			smv.version++
+		} else if ok {
+			// A deleted key starts again from version 0, and its access
+			// counts are reset.
+			smv.version = 0
+			atomic.StoreUint64(&smv.reads, 0)
+			atomic.StoreUint64(&smv.writes, 0)
+		}
+		if v, ok := watched[k]; ok {
+			lm.notifyWatchers(k, v)
+		}
+	}
+
+	lm.endTransaction(tid, cm, ts)
+	lm.stateLock.Unlock()
+
+	lm.runPostCommitHooks(tid, writes)
+	lm.maybeEvict()
+	lm.maybeCompact()
+	return lsn, nil
 }
 
 func (lm *logManager) abortTransaction(tid TransactionID) (err error) {
+	return lm.abortTransactionWithProgress(context.Background(), tid, nil)
+}
+
+// abortTransactionWithProgress aborts a transaction, calling progress (if it
+// is not nil) after each of its updates is undone with the number of updates
+// undone and the total to be undone. If ctx is done before every update is
+// undone, the UNDO entries written so far are flushed and the context's error
+// is returned. The transaction is left partially undone, still holding its
+// locks; aborting it again (or recovery, if the store is closed) resumes the
+// abort.
+func (lm *logManager) abortTransactionWithProgress(ctx context.Context, tid TransactionID, progress func(undone, total int)) (err error) {
+	// Only one abort of the transaction runs at a time, since each undoes the
+	// updates remaining in its state. Another abort waits for it to finish,
+	// and returns its result.
+	lm.stateLock.Lock()
 	cm, ok := lm.currMutexes[tid]
 	if !ok {
+		lm.stateLock.Unlock()
 		err = fmt.Errorf("transaction with ID %d is not currently running", tid)
 		return
 	}
+	ts := lm.transactions[tid]
+	if running := ts.abort; running != nil {
+		lm.stateLock.Unlock()
+		select {
+		case <-running.done:
+			return running.err
+		case <-ctx.Done():
+			return fmt.Errorf("abort of transaction with ID %d was interrupted: %w", tid, ctx.Err())
+		}
+	}
+	call := &abortCall{done: make(chan struct{})}
+	ts.abort = call
+	lm.stateLock.Unlock()
+	defer func() {
+		lm.stateLock.Lock()
+		ts.abort = nil
+		lm.stateLock.Unlock()
+		call.err = err
+		close(call.done)
+	}()
 
-	// Write out ABORT entry
-	lm.addLogEntry(&pb.LogEntry{
-		Tid:       proto.Int64(int64(tid)),
-		EntryType: pb.LogEntry_ABORT.Enum(),
-	})
+	// Write out ABORT entry (unless the abort is being resumed)
+	lm.stateLock.Lock()
+	if ts.writeBuffer != nil {
+		lm.stateLock.Unlock()
+		return lm.abortDeferredTransaction(tid, cm, ts)
+	}
+	aborted := ts.aborted
+	ts.aborted = true
+	lm.stateLock.Unlock()
+	if !aborted {
+		lm.addTransactionLogEntry(tid, ts, &pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_ABORT.Enum(),
+		})
+	}
 
-	// Undo updates (and write log entries)
-	entries := &lm.log.Entry
-	iterateEntries := (*entries)[:]
-iterate:
-	for i := len(iterateEntries) - 1; i >= 0; i-- {
-		e := iterateEntries[i]
-		if *e.Tid == int64(tid) {
-			switch *e.EntryType {
-			case pb.LogEntry_UPDATE: // Undo UPDATE records
-				oldValue, newValue, err := lm.updateStoreMapValue(cm, Key(*e.Key), Value(e.OldValue))
-				if err != nil {
-					return err
-				}
-				lm.addLogEntry(&pb.LogEntry{
-					Tid:       proto.Int64(int64(tid)),
-					EntryType: pb.LogEntry_UNDO.Enum(),
-					Key:       e.Key,
-					OldValue:  oldValue, // e.NewValue
-					NewValue:  newValue, // e.OldValue
-					UndoLsn:   e.Lsn,
-				})
-			case pb.LogEntry_BEGIN: // Stop when BEGIN record is reached
-				break iterate
-			}
+	// Undo updates in reverse order (and write log entries). Undone updates
+	// are removed from the state of the transaction, so that a cancelled
+	// abort can be resumed.
+	lm.stateLock.Lock()
+	total := len(ts.updates)
+	lm.stateLock.Unlock()
+	for i := total - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			lm.flushLog()
+			return fmt.Errorf("abort of transaction with ID %d was interrupted: %w", tid, err)
+		}
+		lm.stateLock.Lock()
+		e := ts.updates[i]
+		lm.stateLock.Unlock()
+		oldValue, newValue, oldMeta, newMeta, err := lm.updateStoreMapValue(cm, ts, Key(*e.Key), Value(e.OldValue), e.OldMeta)
+		if err != nil {
+			return err
+		}
+		lm.addUndoLogEntry(&pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_UNDO.Enum(),
+			Key:       e.Key,
+			OldValue:  oldValue, // e.NewValue
+			NewValue:  newValue, // e.OldValue
+			OldMeta:   oldMeta,  // e.NewMeta
+			NewMeta:   newMeta,  // e.OldMeta
+		}, e)
+		lm.stateLock.Lock()
+		ts.updates = ts.updates[:i]
+		ts.memory -= logEntryMemory(e)
+		delete(ts.readCache, Key(e.GetKey()))
+		lm.stateLock.Unlock()
+		if progress != nil {
+			progress(total-i, total)
 		}
 	}
 
@@ -355,24 +1750,116 @@ iterate:
 	// Flush out log
 	lm.flushLog()
 
-	// Release all locks and remove from current transactions
-	for _, rw := range cm {
-		rw.unlock()
+	lm.stateLock.Lock()
+	lm.endTransaction(tid, cm, ts)
+	lm.stateLock.Unlock()
+	return
+}
+
+// abortAll aborts every running transaction that is not prepared. The IDs of
+// the transactions are collected first, since aborting a transaction removes
+// it from currMutexes. Transactions that end on their own in the meantime are
+// skipped. If some of the transactions could not be aborted, the others are
+// still aborted, and the first error is returned.
+func (lm *logManager) abortAll() error {
+	lm.stateLock.Lock()
+	var tids []TransactionID
+	for tid := range lm.currMutexes {
+		if !lm.transactions[tid].prepared {
+			tids = append(tids, tid)
+		}
+	}
+	lm.stateLock.Unlock()
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+
+	var firstErr error
+	for _, tid := range tids {
+		err := lm.abortTransaction(tid)
+		if err == nil {
+			continue
+		}
+		if lm.running(tid) && firstErr == nil {
+			firstErr = fmt.Errorf("could not abort transaction with ID %d: %w", tid, err)
+		}
+	}
+	return firstErr
+}
+
+// running returns whether transaction tid is running.
+func (lm *logManager) running(tid TransactionID) bool {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	_, ok := lm.currMutexes[tid]
+	return ok
+}
+
+// readWriteSets returns the keys read and written by a running transaction,
+// in order. Written keys are those it has set or deleted, or holds write locks
+// on (e.g. a key checked by a CheckAndSet whose condition failed). Read keys
+// are those it holds only read locks on, so keys read by ReadCommitted
+// transactions without holding locks are not included.
+func (lm *logManager) readWriteSets(tid TransactionID) (reads, writes []Key, err error) {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	cm, ok := lm.currMutexes[tid]
+	if !ok {
+		return nil, nil, fmt.Errorf("transaction with ID %d is not currently running", tid)
+	}
+	ts := lm.transactions[tid]
+	for k := range ts.modifiedKeys {
+		writes = append(writes, k)
 	}
+	cm.forEach(func(k Key, rw *rwMutexWrapper) {
+		if _, ok := ts.modifiedKeys[k]; ok {
+			return
+		}
+		if rw.wLocked() {
+			writes = append(writes, k)
+		} else if rw.rLocked() {
+			reads = append(reads, k)
+		}
+	})
+	lm.sortKeys(reads)
+	lm.sortKeys(writes)
+	return reads, writes, nil
+}
+
+// endTransaction releases all locks held by a transaction and removes it from
+// the current transactions. It must be called with stateLock held.
+func (lm *logManager) endTransaction(tid TransactionID, cm currentMutexesMap, ts *transactionState) {
+	cm.forEach(func(_ Key, rw *rwMutexWrapper) {
+		rw.unlock()
+	})
 	delete(lm.currMutexes, tid)
-	return
+	delete(lm.transactions, tid)
+	if ts.admitted {
+		<-lm.admission
+	}
 }
 
-var lmInstance logManager
+// The package-level log manager, used by Transaction and the package-level
+// functions, is opened on first use over the directory given by the -logDir
+// flag, which should be parsed (by the program) before then. An error opening
+// it is returned by the operation that first uses it, and by every later one.
+var (
+	logDirFlag     = flag.String("logDir", "", "the directory in which log files will be stored")
+	lmInstance     *logManager
+	lmInstanceErr  error
+	lmInstanceOnce sync.Once
+)
+
+// defaultLogManager returns the package-level log manager, opening it if it
+// has not been opened.
+func defaultLogManager() (*logManager, error) {
+	lmInstanceOnce.Do(func() {
+		lmInstance, lmInstanceErr = newLogManager(*logDirFlag)
+		if lmInstanceErr != nil {
+			lmInstanceErr = fmt.Errorf("could not open default store: %w", lmInstanceErr)
+		}
+	})
+	return lmInstance, lmInstanceErr
+}
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-
-	logDir := flag.String("logDir", "", "the directory in which log files will be stored")
-	flag.Parse()
-	if lmInstancePtr, err := newLogManager(*logDir); err != nil {
-		panic(err)
-	} else {
-		lmInstance = *lmInstancePtr
-	}
 }
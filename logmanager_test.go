@@ -2,13 +2,17 @@ package gostore
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	pb "github.com/mDibyo/gostore/pb"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Variables and functions used in tests
@@ -35,6 +39,9 @@ func init() {
 		panic(fmt.Errorf("could not create temporary directory for tests: %v", err))
 	}
 	newLogManagerForTest = func(t *testing.T) *logManager {
+		// The log directory is shared by the log managers of tests, which
+		// are not closed.
+		abandonDirForTest(testLogDir)
 		lm, err := newLogManager(testLogDir)
 		if err != nil {
 			t.Fatalf("could not create log manager instance: %v", err)
@@ -50,6 +57,11 @@ func TestMain(m *testing.M) {
 }
 
 func testLogEntry(t *testing.T, gotEntry, wantEntry *pb.LogEntry) {
+	if gotEntry.Timestamp == nil {
+		t.Errorf("did not find timestamp in log entry. actual=(%+v)", gotEntry)
+	} else if wantEntry.Timestamp == nil {
+		wantEntry.Timestamp = gotEntry.Timestamp
+	}
 	if !reflect.DeepEqual(gotEntry, wantEntry) {
 		t.Errorf("did not get the expected log entry. expected=(%+v), actual=(%+v)", wantEntry, gotEntry)
 	}
@@ -93,8 +105,266 @@ func TestAddLogEntry(t *testing.T) {
 	}
 }
 
+func TestAddLogEntryTimestamps(t *testing.T) {
+	lm := newStoreForTest(t).lm
+	lenLogBefore := len(lm.log.Entry)
+	timeBefore := time.Now().UnixNano()
+	tid := lm.nextTransactionID()
+	lm.beginTransaction(tid)
+	if err := lm.setValue(tid, sampleKey5, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value: %v", err)
+	}
+	if err := lm.commitTransaction(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	timeAfter := time.Now().UnixNano()
+
+	lastTimestamp := timeBefore
+	for _, e := range lm.log.Entry[lenLogBefore:] {
+		if e.Timestamp == nil {
+			t.Errorf("did not find timestamp in log entry. actual=(%+v)", e)
+			continue
+		}
+		if gotTimestamp := e.GetTimestamp(); gotTimestamp < lastTimestamp || gotTimestamp > timeAfter {
+			t.Errorf("found log entry timestamp out of order. previous=%d, actual=%d, end=%d", lastTimestamp, gotTimestamp, timeAfter)
+		}
+		lastTimestamp = e.GetTimestamp()
+	}
+
+	// Timestamps are not decreasing even if the clock goes backwards
+	lm.lastTimestamp = timeAfter + int64(time.Hour)
+	lm.addLogEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_END.Enum(),
+	})
+	if gotTimestamp := lm.log.Entry[len(lm.log.Entry)-1].GetTimestamp(); gotTimestamp != lm.lastTimestamp {
+		t.Errorf("found that log entry timestamp decreased. expected=%d, actual=%d", lm.lastTimestamp, gotTimestamp)
+	}
+}
+
+// checkLogOrderForTest checks that, for every transaction in entries, its
+// COMMIT entry follows all of its UPDATE and PREPARE entries, and that only
+// its END entry follows its COMMIT entry (unless an ABORT entry supersedes
+// it).
+func checkLogOrderForTest(t *testing.T, entries []*pb.LogEntry) {
+	committed := make(map[int64]bool)
+	for _, e := range entries {
+		tid := e.GetTid()
+		switch e.GetEntryType() {
+		case pb.LogEntry_UPDATE, pb.LogEntry_UNDO, pb.LogEntry_PREPARE, pb.LogEntry_COMMIT:
+			if committed[tid] {
+				t.Errorf("found %s entry with LSN %d after COMMIT entry of transaction with ID %d.", e.GetEntryType(), e.GetLsn(), tid)
+			}
+			committed[tid] = e.GetEntryType() == pb.LogEntry_COMMIT
+		case pb.LogEntry_ABORT:
+			committed[tid] = false
+		}
+	}
+}
+
+func TestLogEntryOrder(t *testing.T) {
+	s := newStoreForTest(t)
+	keys := []Key{sampleKey1, sampleKey2, sampleKey3, sampleKey4, sampleKey5}
+
+	// Concurrent transactions that commit or abort
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// Keys are locked in the same order, so there are no
+				// deadlocks.
+				tid := s.BeginTransaction()
+				for _, k := range keys[i%len(keys):] {
+					if err := s.Set(tid, k, Value(fmt.Sprintf("%d_%d", i, j))); err != nil {
+						t.Errorf("got an error while setting value for key='%s': %v", k, err)
+					}
+				}
+				if j%3 == 0 {
+					s.Abort(tid)
+				} else if err := s.Commit(tid); err != nil {
+					t.Errorf("got an error while committing transaction: %v", err)
+				}
+			}
+		}(i)
+	}
+
+	// A transaction that is written to while it is committed: writes either
+	// precede the COMMIT entry or are rejected.
+	for i := 0; i < 20; i++ {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, Key(fmt.Sprintf("key_race_%d", i)), CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value: %v", err)
+		}
+		set := make(chan error)
+		go func() {
+			set <- s.Set(tid, Key(fmt.Sprintf("key_race_%d", i)), CopyByteArray(sampleValue2))
+		}()
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		<-set
+	}
+	wg.Wait()
+
+	s.lm.logLock.Lock()
+	entries := append([]*pb.LogEntry(nil), s.lm.log.Entry...)
+	s.lm.logLock.Unlock()
+	checkLogOrderForTest(t, entries)
+
+	// A second COMMIT entry is rejected
+	tid := s.BeginTransaction()
+	s.lm.stateLock.Lock()
+	ts := s.lm.transactions[tid]
+	s.lm.stateLock.Unlock()
+	for i, wantErr := range []error{nil, ErrTransactionFinished} {
+		err := s.lm.addTransactionLogEntry(tid, ts, &pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_COMMIT.Enum(),
+		})
+		if !errors.Is(err, wantErr) {
+			t.Errorf("did not get expected error while logging COMMIT entry %d. expected=%v, actual=%v", i, wantErr, err)
+		}
+	}
+}
+
+func TestFlushLogConcurrentAddLogEntry(t *testing.T) {
+	lm := newStoreForTest(t).lm
+	tid := lm.nextTransactionID()
+	lm.beginTransaction(tid)
+
+	// Block the first flush while it is writing out the log
+	writing, unblock := make(chan struct{}), make(chan struct{})
+	lm.config.storage = &testStorage{beforeWrite: func() {
+		select {
+		case <-writing:
+		default:
+			close(writing)
+			<-unblock
+		}
+	}}
+	flushed := make(chan error)
+	go func() {
+		flushed <- lm.flushLog()
+	}()
+	<-writing
+
+	added := make(chan struct{})
+	go func() {
+		lm.addLogEntry(&pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_END.Enum(),
+		})
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Error("found that adding a log entry was blocked by a flush.")
+	}
+	close(unblock)
+	if err := <-flushed; err != nil {
+		t.Errorf("got an error while flushing log: %v", err)
+	}
+	<-added
+
+	// Only the entries snapshotted by the flush were flushed
+	if lm.nextLSNToFlush != lm.nextLSN-1 {
+		t.Errorf("did not get expected LSN of next entry to flush. expected=%d, actual=%d", lm.nextLSN-1, lm.nextLSNToFlush)
+	}
+	if err := lm.flushLog(); err != nil {
+		t.Errorf("got an error while flushing log: %v", err)
+	}
+	if gotLenLog := len(reopenStoreForTest(t, &Store{lm}).lm.log.Entry); gotLenLog != lm.nextLSN {
+		t.Errorf("did not get expected log length after reopening. expected=%d, actual=%d", lm.nextLSN, gotLenLog)
+	}
+}
+
+func TestFlushLogUpTo(t *testing.T) {
+	s := newStoreForTest(t)
+	lm := s.lm
+	tid := s.BeginTransaction()
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+
+	tests := []struct {
+		lsn                int
+		wantNextLSNToFlush int
+		wantSegments       [][2]int
+	}{
+		{1, 2, [][2]int{{0, 1}}},
+		{0, 2, [][2]int{{0, 1}}}, // already flushed
+		{lm.nextLSN + 5, lm.nextLSN, [][2]int{{0, 1}, {2, lm.nextLSN - 1}}},
+		{lm.nextLSN + 5, lm.nextLSN, [][2]int{{0, 1}, {2, lm.nextLSN - 1}}},
+	}
+	for _, test := range tests {
+		if err := lm.flushLogUpTo(test.lsn); err != nil {
+			t.Errorf("got an error while flushing log up to LSN %d: %v", test.lsn, err)
+		}
+		if lm.nextLSNToFlush != test.wantNextLSNToFlush {
+			t.Errorf("did not get expected LSN of next entry to flush. expected=%d, actual=%d", test.wantNextLSNToFlush, lm.nextLSNToFlush)
+		}
+		segments, err := lm.segments()
+		if err != nil {
+			t.Fatalf("got an error while listing segments: %v", err)
+		}
+		var gotSegments [][2]int
+		for _, segment := range segments {
+			gotSegments = append(gotSegments, [2]int{segment.StartLSN, segment.EndLSN})
+		}
+		if !reflect.DeepEqual(gotSegments, test.wantSegments) {
+			t.Errorf("did not get expected segments. expected=%v, actual=%v", test.wantSegments, gotSegments)
+		}
+	}
+
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, reopenStoreForTest(t, s), sampleKey3, sampleValue1)
+}
+
+func TestNextTransactionID(t *testing.T) {
+	s := newStoreForTest(t)
+	var maxTID TransactionID
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		tid := s.BeginTransaction()
+		if tid <= maxTID {
+			t.Errorf("found transaction ID not greater than previous ones. previous=%d, actual=%d", maxTID, tid)
+		}
+		maxTID = tid
+		if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+
+	// IDs are not reused after restarting, even if the log is compacted
+	for _, compact := range []bool{false, true} {
+		if compact {
+			if err := s.Compact(); err != nil {
+				t.Errorf("got an error while compacting log: %v", err)
+			}
+		}
+		s = reopenStoreForTest(t, s)
+		tid := s.BeginTransaction()
+		if tid <= maxTID {
+			t.Errorf("found transaction ID not greater than previous ones after restarting. previous=%d, actual=%d", maxTID, tid)
+		}
+		maxTID = tid
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+	}
+}
+
 func TestBeginTransaction(t *testing.T) {
-	lm := *newLogManagerForTest(t)
+	lm := newLogManagerForTest(t)
 	tid := lm.nextTransactionID()
 	lm.beginTransaction(tid)
 	wantLogEntry := &pb.LogEntry{
@@ -115,7 +385,7 @@ func TestBeginTransaction(t *testing.T) {
 }
 
 func TestGetValue(t *testing.T) {
-	lm := *newLogManagerForTest(t)
+	lm := newLogManagerForTest(t)
 	smv := newStoreMapValue()
 	smv.value = CopyByteArray(sampleValue1)
 	lm.store[sampleKey1] = smv
@@ -139,7 +409,7 @@ func TestGetValue(t *testing.T) {
 	// Check currMutexes
 	if cm, ok := lm.currMutexes[tid]; !ok {
 		t.Error("did not find transaction in current mutexes map as expected.")
-	} else if rw, ok := cm[sampleKey1]; !ok {
+	} else if rw, ok := cm.get(sampleKey1); !ok {
 		t.Error("did not find mutex for key in mutex map for transaction.")
 	} else if !rw.rLocked() {
 		t.Errorf("found that mutex for key was not read locked. mutex: %+v", rw)
@@ -160,6 +430,7 @@ func TestSetValue(t *testing.T) {
 				EntryType: pb.LogEntry_UPDATE.Enum(),
 				Key:       proto.String(string(sampleKey1)),
 				NewValue:  CopyByteArray(sampleValue1),
+				Version:   proto.Uint64(1),
 			},
 		},
 		{ // Change value for existing key
@@ -170,6 +441,7 @@ func TestSetValue(t *testing.T) {
 				Key:       proto.String(string(sampleKey2)),
 				OldValue:  CopyByteArray(sampleValue3),
 				NewValue:  CopyByteArray(sampleValue2),
+				Version:   proto.Uint64(3),
 			},
 		},
 		{
@@ -181,6 +453,7 @@ func TestSetValue(t *testing.T) {
 	lm := newLogManagerForTest(t)
 	smv := newStoreMapValue()
 	smv.value = CopyByteArray(sampleValue3)
+	smv.version = 2
 	lm.store[sampleKey2] = smv
 	for _, test := range tests {
 		tid := lm.nextTransactionID()
@@ -218,7 +491,7 @@ func TestSetValue(t *testing.T) {
 		// Check currMutexes
 		if cm, ok := lm.currMutexes[tid]; !ok {
 			t.Error("did not find transaction in current mutexes map as expected.")
-		} else if rw, ok := cm[test.key]; !ok {
+		} else if rw, ok := cm.get(test.key); !ok {
 			t.Errorf("did not find mutex for key='%s' in mutex map for transaction.", test.key)
 		} else if !rw.wLocked() {
 			t.Errorf("found that mutex for key='%s' was not write locked. mutex: %+v", test.key, rw)
@@ -243,7 +516,7 @@ func TestDeleteValue(t *testing.T) {
 		t.Errorf("did not get expected error when deleting non-existant key")
 	}
 	// Check storeMap
-	if _, ok := lm.store[sampleKey1]; ok {
+	if smv, ok := lm.store[sampleKey1]; ok && smv.value != nil {
 		t.Errorf("found value for key after deletion in storeMap.", sampleKey1)
 	}
 	// Check log
@@ -264,7 +537,7 @@ func TestDeleteValue(t *testing.T) {
 	// Check currMutexes
 	if cm, ok := lm.currMutexes[tid]; !ok {
 		t.Error("did not find transaction in current mutexes map as expected.")
-	} else if rw, ok := cm[sampleKey1]; !ok {
+	} else if rw, ok := cm.get(sampleKey1); !ok {
 		t.Error("did not find mutex for key in mutex map for transaction.")
 	} else if !rw.wLocked() {
 		t.Errorf("found that mutex was not write locked. mutex: %+v", rw)
@@ -393,8 +666,8 @@ func TestAbortTransaction(t *testing.T) {
 			} else if !bytes.Equal(gotSMV.value, v) {
 				t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, v, gotSMV.value)
 			}
-		} else { // key should not exist
-			if ok {
+		} else { // key should not exist (or be deleted)
+			if ok && gotSMV.value != nil {
 				t.Errorf("found value for key='%s' in storeMap: %v", k, gotSMV.value)
 			}
 		}
@@ -445,3 +718,265 @@ func TestAbortTransaction(t *testing.T) {
 	checkCommon(tid, lenLogBefore+5, 1)
 	checkStoreMapKey(sampleKey1, sampleValue1)
 }
+
+func TestAbortDelete(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, Value("a"))
+
+	tid := s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+	}
+	deleteLSN := int64(len(s.lm.log.Entry) - 1)
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// The UNDO entry re-establishes the key
+	var gotLogEntry *pb.LogEntry
+	for _, e := range s.lm.log.Entry {
+		if e.GetTid() == int64(tid) && e.GetEntryType() == pb.LogEntry_UNDO {
+			gotLogEntry = e
+		}
+	}
+	if gotLogEntry == nil {
+		t.Fatalf("did not find UNDO entry for delete of key='%s' in log.", sampleKey1)
+	}
+	wantLogEntry := &pb.LogEntry{
+		Lsn:       gotLogEntry.Lsn,
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_UNDO.Enum(),
+		Key:       proto.String(string(sampleKey1)),
+		NewValue:  Value("a"),
+		UndoLsn:   proto.Int64(deleteLSN),
+	}
+	testLogEntry(t, gotLogEntry, wantLogEntry)
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, Value("a"))
+	}
+}
+
+func TestConcurrentAborts(t *testing.T) {
+	s := newStoreForTest(t)
+	keys := make([][]Key, 2)
+	for i := range keys {
+		for j := 0; j < 20; j++ {
+			k := Key(fmt.Sprintf("key_%d_%d", i, j))
+			setForTest(t, s, k, sampleValue1)
+			keys[i] = append(keys[i], k)
+		}
+	}
+
+	// Two transactions are aborted simultaneously. The abort of the second is
+	// held up while it is aborted numAborts more times; those aborts wait for
+	// it, and return its result.
+	tids := make([]TransactionID, len(keys))
+	for i := range tids {
+		tids[i] = s.BeginTransaction()
+		for _, v := range []Value{sampleValue2, sampleValue3} {
+			for _, k := range keys[i] {
+				if err := s.Set(tids[i], k, CopyByteArray(v)); err != nil {
+					t.Errorf("got an error while setting value for key='%s': %v", k, err)
+				}
+			}
+		}
+	}
+	numAborts := 10
+	undoing, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	errs := make(chan error, numAborts+2)
+	go func() {
+		errs <- s.AbortWithProgress(context.Background(), tids[1], func(undone, total int) {
+			once.Do(func() {
+				close(undoing)
+				<-release
+			})
+		})
+	}()
+	<-undoing
+	for i := 0; i < numAborts; i++ {
+		go func() { errs <- s.Abort(tids[1]) }()
+	}
+	go func() { errs <- s.Abort(tids[0]) }()
+	time.Sleep(10 * time.Millisecond)
+	if n := len(errs); n > 1 {
+		t.Errorf("found that aborts did not wait for the abort in progress. returned=%d", n)
+	}
+	close(release)
+	for i := 0; i < numAborts+2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+	}
+	if err := s.Abort(tids[1]); err == nil {
+		t.Errorf("did not get an error while aborting aborted transaction with ID %d.", tids[1])
+	}
+
+	// Each update was undone once, by an UNDO entry of its own transaction
+	undone := make(map[int64]int)
+	for _, e := range s.lm.log.Entry {
+		if e.GetEntryType() != pb.LogEntry_UNDO {
+			continue
+		}
+		u := s.lm.log.Entry[e.GetUndoLsn()]
+		if u.GetEntryType() != pb.LogEntry_UPDATE || u.GetTid() != e.GetTid() || u.GetKey() != e.GetKey() {
+			t.Errorf("found UNDO entry not matching the entry it undoes. undo=%v, undone=%v", e, u)
+		}
+		undone[e.GetUndoLsn()]++
+	}
+	for _, e := range s.lm.log.Entry {
+		if e.GetEntryType() == pb.LogEntry_UPDATE && (e.GetTid() == int64(tids[0]) || e.GetTid() == int64(tids[1])) && undone[e.GetLsn()] != 1 {
+			t.Errorf("did not get expected number of UNDO entries for update. expected=%d, actual=%d, update=%v", 1, undone[e.GetLsn()], e)
+		}
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		for _, ks := range keys {
+			for _, k := range ks {
+				checkStoreValue(t, s, k, sampleValue1)
+			}
+		}
+	}
+}
+
+func TestNewLogManagerRepeated(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	setForTest(t, s, sampleKey1, sampleValue3)
+	if err := s.Close(); err != nil {
+		t.Fatalf("got an error while closing store: %v", err)
+	}
+
+	var lms []*logManager
+	for i := 0; i < 2; i++ {
+		abandonDirForTest(s.lm.logDir)
+		lm, err := newLogManager(s.lm.logDir)
+		if err != nil {
+			t.Fatalf("could not create log manager instance: %v", err)
+		}
+		lms = append(lms, lm)
+	}
+	// Retrieving the log again does not duplicate its entries
+	if err := lms[1].retrieveLog(); err != nil {
+		t.Fatalf("got an error while retrieving log: %v", err)
+	}
+	for _, lm := range lms {
+		if lm.nextLSN != s.lm.nextLSN {
+			t.Errorf("did not get expected next LSN. expected=%d, actual=%d", s.lm.nextLSN, lm.nextLSN)
+		}
+		if len(lm.log.Entry) != len(s.lm.log.Entry) {
+			t.Errorf("did not get expected log length. expected=%d, actual=%d", len(s.lm.log.Entry), len(lm.log.Entry))
+		}
+		if len(lm.store) != len(s.lm.store) {
+			t.Errorf("did not get expected number of keys. expected=%d, actual=%d", len(s.lm.store), len(lm.store))
+		}
+		for k, want := range s.lm.store {
+			got, ok := lm.store[k]
+			if !ok {
+				t.Errorf("did not find key='%s' in store.", k)
+			} else if !bytes.Equal(got.value, want.value) || got.version != want.version {
+				t.Errorf("did not get expected value for key='%s'. expected=%v (version %d), actual=%v (version %d)",
+					k, want.value, want.version, got.value, got.version)
+			}
+		}
+	}
+}
+
+func TestAcquireLocks(t *testing.T) {
+	lm := newStoreForTest(t).lm
+	orders := [][]Key{
+		{sampleKey1, sampleKey2, sampleKey3, sampleKey2},
+		{sampleKey3, sampleKey2, sampleKey4, sampleKey1},
+	}
+	done := make(chan struct{})
+	for _, keys := range orders {
+		go func(keys []Key) {
+			distinct := make(map[Key]struct{})
+			for _, k := range keys {
+				distinct[k] = struct{}{}
+			}
+			for i := 0; i < 100; i++ {
+				tid := lm.nextTransactionID()
+				lm.beginTransaction(tid)
+				if _, smvs, err := lm.acquireLocks(tid, keys, true); err != nil {
+					t.Errorf("got an error while acquiring locks: %v", err)
+				} else if len(smvs) != len(distinct) {
+					t.Errorf("did not get expected number of locked keys. expected=%d, actual=%d", len(distinct), len(smvs))
+				}
+				lm.abortTransaction(tid)
+			}
+			done <- struct{}{}
+		}(keys)
+	}
+	for range orders {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("found that transactions acquiring locks deadlocked.")
+		}
+	}
+}
+
+func benchmarkAbortTransaction(b *testing.B, lenLog int) {
+	dir, err := ioutil.TempDir(testLogDir, "bench_")
+	if err != nil {
+		b.Fatalf("could not create log directory: %v", err)
+	}
+	lm, err := newLogManager(dir)
+	if err != nil {
+		b.Fatalf("could not create log manager instance: %v", err)
+	}
+	// Pad the log with entries of other (ended) transactions.
+	for i := 0; i < lenLog; i++ {
+		lm.addLogEntry(&pb.LogEntry{
+			Tid:       proto.Int64(int64(i)),
+			EntryType: pb.LogEntry_END.Enum(),
+		})
+	}
+	lm.nextLSNToFlush = lm.nextLSN
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tid := lm.nextTransactionID()
+		lm.beginTransaction(tid)
+		lm.setValue(tid, sampleKey1, sampleValue1)
+		lm.abortTransaction(tid)
+	}
+}
+
+func BenchmarkAbortTransaction(b *testing.B) {
+	for _, lenLog := range []int{100, 100000} {
+		b.Run(fmt.Sprintf("log=%d", lenLog), func(b *testing.B) {
+			benchmarkAbortTransaction(b, lenLog)
+		})
+	}
+}
+
+func TestDefaultLogManagerError(t *testing.T) {
+	// Point the package-level store at a file, which can not be opened as a
+	// log directory.
+	f, err := ioutil.TempFile("", "gostore_not_a_dir_")
+	if err != nil {
+		t.Fatalf("could not create temporary file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	oldLogDir := *logDirFlag
+	*logDirFlag = f.Name()
+	lmInstanceOnce, lmInstance, lmInstanceErr = sync.Once{}, nil, nil
+	defer func() {
+		*logDirFlag = oldLogDir
+		lmInstanceOnce, lmInstance, lmInstanceErr = sync.Once{}, nil, nil
+	}()
+
+	if err := Set(sampleKey1, sampleValue1); err == nil {
+		t.Errorf("did not get an error while setting value in unopenable default store.")
+	}
+	txn := NewTransaction()
+	if _, err := txn.Get(sampleKey1); err == nil {
+		t.Errorf("did not get an error while getting value in unopenable default store.")
+	}
+	if err := txn.Commit(); err == nil {
+		t.Errorf("did not get an error while committing transaction in unopenable default store.")
+	}
+}
@@ -0,0 +1,47 @@
+package gostore
+
+import "sync"
+
+// maintenance owns the goroutines that work in the background for a store
+// (the flusher, tails of the log, replicas, ...). They are stopped together
+// when the store is closed: each returns once the stopping channel is closed,
+// and stop waits for all of them to return, so none outlive the store.
+type maintenance struct {
+	lock     sync.Mutex     // lock to synchronize starting goroutines with stopping them
+	stopped  bool           // whether the goroutines have been stopped
+	stopping chan struct{}  // closed to stop the goroutines
+	wg       sync.WaitGroup // the goroutines that are running
+}
+
+func newMaintenance() *maintenance {
+	return &maintenance{stopping: make(chan struct{})}
+}
+
+// start runs fn in a new goroutine owned by m, with the channel that is closed
+// when it should return. If the goroutines have already been stopped, fn is
+// not run, and false is returned.
+func (m *maintenance) start(fn func(stopping <-chan struct{})) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn(m.stopping)
+	}()
+	return true
+}
+
+// stop stops the goroutines owned by m, and waits for them to return. No more
+// goroutines are started once it is called.
+func (m *maintenance) stop() {
+	m.lock.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.stopping)
+	}
+	m.lock.Unlock()
+	m.wg.Wait()
+}
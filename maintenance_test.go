@@ -0,0 +1,54 @@
+package gostore
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := newMaintenance()
+	returned := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		if !m.start(func(stopping <-chan struct{}) {
+			<-stopping
+			returned <- struct{}{}
+		}) {
+			t.Errorf("did not start goroutine.")
+		}
+	}
+	m.stop()
+	if len(returned) != 2 {
+		t.Errorf("did not get expected number of stopped goroutines. expected=%d, actual=%d", 2, len(returned))
+	}
+	if m.start(func(<-chan struct{}) {}) {
+		t.Errorf("started goroutine after stopping.")
+	}
+	m.stop()
+}
+
+func TestCloseGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s := newStoreForTest(t, WithCommitBatching(100, time.Millisecond), WithFlushOnContention(true))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	tail, _ := s.LogTail(0)
+	r := NewReplica(s)
+	s.lm.flushEarly()
+	if err := s.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+
+	for range tail {
+	}
+	r.Close()
+	// Goroutines that have been stopped may take a moment to exit.
+	deadline := time.Now().Add(time.Second)
+	after := runtime.NumGoroutine()
+	for after > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		t.Errorf("found goroutines running after store was closed. before=%d, after=%d", before, after)
+	}
+}
@@ -0,0 +1,204 @@
+package gostore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Merging combines runs of adjacent log files (segments) in the log directory
+// into single log files covering the same LSNs, while there are more than the
+// configured maximum number of them (see WithMaxSegments). Each merge combines
+// the run of mergeFanIn adjacent log files that is smallest in total, so that
+// small log files are merged with each other rather than rewriting large ones.
+// The merged log file is first written to a file whose name is prefixed with
+// mergedLogPrefix. Once that file exists, it supersedes the log files it
+// covers, which are removed before it is renamed to a regular log file name.
+// An interrupted merge is finished when the store is next opened. The merged
+// log file is always synced before it is renamed, and the log directory after
+// it is renamed, so that the log files it covers are never removed before it
+// is durable.
+
+// mergedLogPrefix prefixes the name of the log file written by a merge until
+// it replaces the log files that it covers.
+var mergedLogPrefix = "merged_"
+
+// mergeFanIn is the number of adjacent log files combined by each merge.
+var mergeFanIn = 4
+
+// requestMerge requests the merger to merge the log files if there are more
+// than the maximum number of them. It must be called with flushLock held.
+func (lm *logManager) requestMerge() {
+	if lm.merges == nil || lm.segmentCount <= lm.config.maxSegments {
+		return
+	}
+	select {
+	case lm.merges <- struct{}{}:
+	default:
+	}
+}
+
+// runMerger merges the log files whenever it is requested to, until stopping
+// is closed. Errors are ignored, since the log files remain valid if a merge
+// fails.
+func (lm *logManager) runMerger(stopping <-chan struct{}) {
+	for {
+		select {
+		case <-lm.merges:
+			lm.mergeSegments()
+		case <-stopping:
+			return
+		}
+	}
+}
+
+// mergeSegments merges the smallest run of adjacent log files in the log
+// directory into a single log file, and requests another merge if there are
+// still too many log files. Since the whole log is held in memory, the merged
+// log file is written from the entries in the log rather than from the log
+// files. flushLock is held only to choose the log files and to replace them,
+// so that flushes are not held up while the merged log file is written.
+func (lm *logManager) mergeSegments() error {
+	// Compaction waits for a merge to finish, since it renumbers the entries
+	// of running transactions in place and replaces the log files.
+	lm.mergeLock.Lock()
+	defer lm.mergeLock.Unlock()
+
+	lm.flushLock.Lock()
+	if lm.compactPending {
+		if err := lm.finishCompaction(); err != nil {
+			lm.flushLock.Unlock()
+			return err
+		}
+	}
+	files, err := lm.logFiles()
+	if err != nil {
+		lm.flushLock.Unlock()
+		return fmt.Errorf("could not merge log files: %v", err)
+	}
+	if len(files) < 2 {
+		lm.flushLock.Unlock()
+		return nil
+	}
+	run := smallestRun(files, mergeFanIn)
+	startLSN, endLSN := run[0].startLSN, run[len(run)-1].endLSN
+	lm.logLock.Lock()
+	entries := lm.log.Entry[startLSN-lm.firstLSN : endLSN+1-lm.firstLSN]
+	lm.logLock.Unlock()
+	lm.flushLock.Unlock()
+
+	data, err := lm.marshalLogEntries(entries)
+	if err != nil {
+		return fmt.Errorf("error while marshalling merged log: %v", err)
+	}
+	filename := mergedLogPrefix + fmt.Sprintf(lm.config.logFileFmt, startLSN, endLSN)
+	filename = fmt.Sprintf("%s/%s", lm.logDir, filename)
+	tmpFilename := filename + ".tmp"
+	if err := lm.writeLogFileSyncing(tmpFilename, data, true); err != nil {
+		return fmt.Errorf("error while writing out merged log: %v", err)
+	}
+
+	// Flushes only add log files after the run, so the log files in the run
+	// are still there to be replaced.
+	lm.flushLock.Lock()
+	defer lm.flushLock.Unlock()
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error while writing out merged log: %v", err)
+	}
+	if err := lm.finishMerge(); err != nil {
+		return err
+	}
+	lm.requestMerge()
+	return nil
+}
+
+// smallestRun returns the run of n adjacent log files (or of all of files, if
+// there are fewer) whose total size is smallest, preferring earlier runs.
+func smallestRun(files []logFile, n int) []logFile {
+	if n > len(files) {
+		n = len(files)
+	}
+	var size, minSize int64
+	var start int
+	for i, file := range files {
+		size += file.info.Size()
+		if i >= n {
+			size -= files[i-n].info.Size()
+		}
+		if i == n-1 || (i >= n && size < minSize) {
+			minSize, start = size, i-n+1
+		}
+	}
+	return files[start : start+n]
+}
+
+// finishMerge replaces the log files covered by the log file written by a
+// merge, if there is one, with that log file. It must be called with
+// flushLock held, or before the log is retrieved.
+func (lm *logManager) finishMerge() error {
+	if lm.config.inMemory {
+		return nil
+	}
+	files, err := ioutil.ReadDir(lm.logDir)
+	if err != nil {
+		return fmt.Errorf("could not finish merge: %v", err)
+	}
+	var merged string
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, mergedLogPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// Left behind by an interrupted merge
+			os.Remove(fmt.Sprintf("%s/%s", lm.logDir, name))
+			continue
+		}
+		merged = name
+	}
+	if merged == "" {
+		return nil
+	}
+	startLSN, endLSN, ok := parseLogFileNameFmt(strings.TrimPrefix(merged, mergedLogPrefix), lm.config.logFileFmt)
+	if !ok {
+		return fmt.Errorf("could not finish merge: log file %s was not in the expected format", merged)
+	}
+
+	if err := syncDir(lm.logDir); err != nil {
+		return fmt.Errorf("could not finish merge: %v", err)
+	}
+	logFiles, err := lm.logFiles()
+	if err != nil {
+		return fmt.Errorf("could not finish merge: %v", err)
+	}
+	remaining := len(logFiles)
+	for _, file := range logFiles {
+		if file.startLSN < startLSN || file.endLSN > endLSN {
+			continue
+		}
+		filename := fmt.Sprintf("%s/%s", lm.logDir, file.path)
+		if err := os.Remove(filename); err != nil {
+			return fmt.Errorf("could not finish merge: %v", err)
+		}
+		if dir := filepath.Dir(file.path); dir != "." {
+			// Remove the shard directory once it is empty
+			os.Remove(fmt.Sprintf("%s/%s", lm.logDir, dir))
+		}
+		remaining--
+	}
+	filename := fmt.Sprintf("%s/%s", lm.logDir, merged)
+	newFilename := fmt.Sprintf("%s/%s", lm.logDir, lm.logFilePath(startLSN, endLSN))
+	if lm.config.shardSize > 0 {
+		if err := os.MkdirAll(filepath.Dir(newFilename), lm.config.dirMode); err != nil {
+			return fmt.Errorf("could not finish merge: %v", err)
+		}
+	}
+	if err := os.Rename(filename, newFilename); err != nil {
+		return fmt.Errorf("could not finish merge: %v", err)
+	}
+	lm.segmentCount = remaining + 1
+	return nil
+}
@@ -0,0 +1,177 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkSegmentsForTest checks that the log files of s cover its log
+// contiguously, and returns the number of log files.
+func checkSegmentsForTest(t *testing.T, s *Store) int {
+	segments, err := s.Segments()
+	if err != nil {
+		t.Fatalf("got an error while listing segments: %v", err)
+	}
+	next := 0
+	for _, seg := range segments {
+		if seg.StartLSN != next {
+			t.Errorf("did not get expected first LSN of segment %s. expected=%d, actual=%d", seg.Name, next, seg.StartLSN)
+		}
+		next = seg.EndLSN + 1
+	}
+	if next != s.lm.nextLSN {
+		t.Errorf("did not get expected end of segments. expected=%d, actual=%d", s.lm.nextLSN, next)
+	}
+	return len(segments)
+}
+
+func TestMergeSegments(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithMaxSegments(4)},
+		{WithMaxSegments(4), WithShardedSegments(10), WithLogCompression(true), WithDeltaEncoding(true)},
+	} {
+		s := newStoreForTest(t, opts...)
+		overwriteForTest(t, s, 20, sampleKey1, sampleKey2)
+
+		// The log files are merged in the background
+		deadline := time.Now().Add(time.Second)
+		for {
+			s.lm.flushLock.Lock()
+			n := s.lm.segmentCount
+			s.lm.flushLock.Unlock()
+			if n <= 4 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("got an error while closing store: %v", err)
+		}
+		if n := checkSegmentsForTest(t, s); n > 4 {
+			t.Errorf("found that log files were not merged. segments=%d", n)
+		}
+
+		reopened := reopenStoreForTest(t, s, opts...)
+		if gotLenLog := len(reopened.lm.log.Entry); gotLenLog != len(s.lm.log.Entry) {
+			t.Errorf("did not get expected log length. expected=%d, actual=%d", len(s.lm.log.Entry), gotLenLog)
+		}
+		checkSegmentsForTest(t, reopened)
+		checkStoreValue(t, reopened, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 19)))
+		checkStoreValue(t, reopened, sampleKey2, Value(fmt.Sprintf("%s_%d", sampleKey2, 19)))
+	}
+}
+
+func TestMergeSegmentsSynced(t *testing.T) {
+	storage := &testStorage{}
+	s := newStoreForTest(t, WithStorageBackend(storage), WithFsync(false))
+	overwriteForTest(t, s, 10, sampleKey1, sampleKey2)
+	files, err := s.lm.logFiles()
+	if err != nil {
+		t.Fatalf("could not list log files: %v", err)
+	}
+
+	// The directory is synced once the merged log file is renamed, before the
+	// log files it covers are removed.
+	var dirSyncs int
+	defer func(f func(string) error) { syncDir = f }(syncDir)
+	syncDir = func(dir string) error {
+		dirSyncs++
+		merged, err := filepath.Glob(filepath.Join(dir, mergedLogPrefix+"*"))
+		if err != nil || len(merged) != 1 || strings.HasSuffix(merged[0], ".tmp") {
+			t.Errorf("did not find merged log file when syncing log directory. found=%v", merged)
+		}
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(dir, file.path)); err != nil {
+				t.Errorf("found that log file was removed before log directory was synced: %v", err)
+			}
+		}
+		return nil
+	}
+	syncsBefore := storage.syncs
+	if err := s.lm.mergeSegments(); err != nil {
+		t.Fatalf("got an error while merging log files: %v", err)
+	}
+	if storage.syncs != syncsBefore+1 {
+		t.Errorf("did not get expected number of syncs. expected=%d, actual=%d", syncsBefore+1, storage.syncs)
+	}
+	if dirSyncs != 1 {
+		t.Errorf("did not get expected number of directory syncs. expected=%d, actual=%d", 1, dirSyncs)
+	}
+	if n := checkSegmentsForTest(t, s); n != len(files)-mergeFanIn+1 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", len(files)-mergeFanIn+1, n)
+	}
+}
+
+func TestMergeSegmentsSmallestRun(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey3, bytes.Repeat(sampleValue3, 1000))
+	overwriteForTest(t, s, 2*mergeFanIn, sampleKey1, sampleKey2)
+	files, err := s.lm.logFiles()
+	if err != nil {
+		t.Fatalf("could not list log files: %v", err)
+	}
+
+	// The large log file is not rewritten while there are small ones to merge
+	for i := 0; i < 2; i++ {
+		if err := s.lm.mergeSegments(); err != nil {
+			t.Fatalf("got an error while merging log files: %v", err)
+		}
+	}
+	segments, err := s.Segments()
+	if err != nil {
+		t.Fatalf("got an error while listing segments: %v", err)
+	}
+	if n := checkSegmentsForTest(t, s); n != 3 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", 3, n)
+	}
+	if segments[0].EndLSN != files[0].endLSN {
+		t.Errorf("did not get expected last LSN of first segment. expected=%d, actual=%d", files[0].endLSN, segments[0].EndLSN)
+	}
+
+	s = reopenStoreForTest(t, s)
+	checkSegmentsForTest(t, s)
+	checkStoreValue(t, s, sampleKey3, bytes.Repeat(sampleValue3, 1000))
+	checkStoreValue(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 2*mergeFanIn-1)))
+}
+
+func TestInterruptedMerge(t *testing.T) {
+	s := newStoreForTest(t)
+	overwriteForTest(t, s, 10, sampleKey1, sampleKey2)
+	s.Close()
+
+	// Write out a merged log, and remove some of the log files it covers
+	files, err := s.lm.logFiles()
+	if err != nil {
+		t.Fatalf("could not list log files: %v", err)
+	}
+	endLSN := files[len(files)-1].endLSN
+	data, err := s.lm.marshalLogEntries(s.lm.log.Entry)
+	if err != nil {
+		t.Fatalf("could not marshal log: %v", err)
+	}
+	filename := mergedLogPrefix + fmt.Sprintf(logFileFmt, 0, endLSN)
+	for _, filename := range []string{filename, filename + ".tmp"} {
+		if err := ioutil.WriteFile(filepath.Join(s.lm.logDir, filename), data, 0644); err != nil {
+			t.Fatalf("could not write log file: %v", err)
+		}
+	}
+	for _, file := range files[:len(files)/2] {
+		if err := os.Remove(filepath.Join(s.lm.logDir, file.path)); err != nil {
+			t.Fatalf("could not remove log file: %v", err)
+		}
+	}
+
+	s = reopenStoreForTest(t, s)
+	if gotFiles, _, _ := replayLogDirForTest(t, s.lm.logDir); gotFiles != 1 {
+		t.Errorf("did not get expected number of log files. expected=%d, actual=%d", 1, gotFiles)
+	}
+	checkSegmentsForTest(t, s)
+	checkStoreValue(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 9)))
+	checkStoreValue(t, s, sampleKey2, Value(fmt.Sprintf("%s_%d", sampleKey2, 9)))
+}
@@ -0,0 +1,85 @@
+package gostore
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+)
+
+// Meta is metadata carried by the value of a key, persisted in the log along
+// with the value. It is set with SetWithMeta. Other writes of the key keep its
+// metadata, and deleting the key removes it.
+type Meta struct {
+	ContentType string            // the content type of the value
+	Headers     map[string]string // arbitrary headers
+}
+
+// keepMeta is passed as the metadata of a write to keep the metadata of the
+// key. It is compared by identity, and never stored or logged.
+var keepMeta = &pb.Meta{}
+
+// resolveMeta returns the metadata of a key being written with value v and
+// metadata meta, given its current metadata.
+func resolveMeta(meta, current *pb.Meta, v Value) *pb.Meta {
+	if v == nil {
+		return nil
+	}
+	if meta == keepMeta {
+		return current
+	}
+	return meta
+}
+
+// toPB returns the metadata to be stored and logged for m, or nil if it is
+// empty.
+func (m Meta) toPB() *pb.Meta {
+	if m.ContentType == "" && len(m.Headers) == 0 {
+		return nil
+	}
+	pm := &pb.Meta{}
+	if m.ContentType != "" {
+		pm.ContentType = proto.String(m.ContentType)
+	}
+	if len(m.Headers) > 0 {
+		pm.Headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			pm.Headers[k] = v
+		}
+	}
+	return pm
+}
+
+// metaFromPB returns a copy of the stored metadata pm.
+func metaFromPB(pm *pb.Meta) Meta {
+	m := Meta{ContentType: pm.GetContentType()}
+	if headers := pm.GetHeaders(); len(headers) > 0 {
+		m.Headers = make(map[string]string, len(headers))
+		for k, v := range headers {
+			m.Headers[k] = v
+		}
+	}
+	return m
+}
+
+// setValueWithMeta sets the value of key k in a transaction to v with
+// metadata meta.
+func (lm *logManager) setValueWithMeta(tid TransactionID, k Key, v Value, meta Meta) error {
+	if v == nil {
+		return ErrNilValue
+	}
+	if err := lm.validateKey(k); err != nil {
+		return err
+	}
+	if err := lm.authorize(tid, AccessWrite, k); err != nil {
+		return err
+	}
+	return lm.updateValueWithMeta(tid, k, v, meta.toPB())
+}
+
+// getValueWithMeta retrieves the value of key k in a transaction, along with
+// its metadata.
+func (lm *logManager) getValueWithMeta(tid TransactionID, k Key) (value Value, meta Meta, err error) {
+	err = lm.readValueWithMeta(tid, k, func(v Value, m *pb.Meta, _ uint64) {
+		value, meta = CopyByteArray(v), metaFromPB(m)
+	})
+	return value, meta, err
+}
@@ -0,0 +1,122 @@
+package gostore
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// checkMetaForTest checks the value and metadata of key k in s.
+func checkMetaForTest(t *testing.T, s *Store, k Key, wantValue Value, wantMeta Meta) {
+	tid := beginForTest(s)
+	defer s.Commit(tid)
+	gotValue, gotMeta, err := s.GetWithMeta(tid, k)
+	if err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", k, err)
+		return
+	}
+	if !bytes.Equal(gotValue, wantValue) {
+		t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, wantValue, gotValue)
+	}
+	if !reflect.DeepEqual(gotMeta, wantMeta) {
+		t.Errorf("did not get expected metadata for key='%s'. expected=%+v, actual=%+v", k, wantMeta, gotMeta)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	meta1 := Meta{ContentType: "text/plain", Headers: map[string]string{"Cache-Control": "no-cache"}}
+	meta2 := Meta{ContentType: "application/json"}
+
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		s := newStoreForTest(t)
+
+		// Metadata is committed with the value
+		tid := s.BeginTransactionWithOptions(opts)
+		if err := s.SetWithMeta(tid, sampleKey1, CopyByteArray(sampleValue1), meta1); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.SetWithMeta(tid, sampleKey2, CopyByteArray(sampleValue2), meta2); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		if _, gotMeta, err := s.GetWithMeta(tid, sampleKey1); err != nil || !reflect.DeepEqual(gotMeta, meta1) {
+			t.Errorf("did not get expected metadata for key='%s'. expected=%+v, actual=(%+v, %v)", sampleKey1, meta1, gotMeta, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		// Writes that do not set metadata keep it, and deletes remove it
+		tid = s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Delete(tid, sampleKey2); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+		}
+		if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		reopened := reopenStoreForTest(t, s)
+		for _, s := range []*Store{s, reopened} {
+			checkMetaForTest(t, s, sampleKey1, sampleValue3, meta1)
+			checkMetaForTest(t, s, sampleKey2, sampleValue2, Meta{})
+		}
+		s = reopened
+
+		// Aborted writes of metadata are undone
+		tid = s.BeginTransactionWithOptions(opts)
+		if err := s.SetWithMeta(tid, sampleKey1, CopyByteArray(sampleValue1), meta2); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.SetWithMeta(tid, sampleKey2, CopyByteArray(sampleValue1), meta2); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkMetaForTest(t, s, sampleKey1, sampleValue3, meta1)
+			checkMetaForTest(t, s, sampleKey2, sampleValue2, Meta{})
+		}
+	}
+}
+
+func TestMetaRecovery(t *testing.T) {
+	meta := Meta{ContentType: "image/png", Headers: map[string]string{"X-Width": "10", "X-Height": "20"}}
+	s := newStoreForTest(t)
+	tid := s.BeginTransaction()
+	if err := s.SetWithMeta(tid, sampleKey1, CopyByteArray(sampleValue1), meta); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// A rename moves the metadata with the value
+	tid = s.BeginTransaction()
+	if err := s.Rename(tid, sampleKey1, sampleKey2, false); err != nil {
+		t.Errorf("got an error while renaming key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// A transaction that was running when the store crashed is rolled back
+	tid = s.BeginTransaction()
+	if err := s.SetWithMeta(tid, sampleKey2, CopyByteArray(sampleValue3), Meta{ContentType: "text/html"}); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	s.lm.flushLog()
+	s = reopenStoreForTest(t, s)
+	checkMetaForTest(t, s, sampleKey2, sampleValue1, meta)
+	checkStoreValue(t, s, sampleKey1, nil)
+
+	// Compaction keeps the metadata
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	checkMetaForTest(t, reopenStoreForTest(t, s), sampleKey2, sampleValue1, meta)
+}
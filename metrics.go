@@ -0,0 +1,39 @@
+package gostore
+
+import "time"
+
+// Metrics receives measurements of the operations of a store. Implementations
+// must be safe for concurrent use.
+type Metrics interface {
+	// ObserveDuration records a duration in the named histogram.
+	ObserveDuration(name string, d time.Duration)
+	// IncCounter increments the named counter.
+	IncCounter(name string)
+}
+
+// The names of the metrics recorded by a store.
+const (
+	// MetricLogWriteLatency is the histogram of the time taken to write log
+	// files.
+	MetricLogWriteLatency = "log_write_latency"
+	// MetricLogSyncLatency is the histogram of the time taken to sync log
+	// files to stable storage.
+	MetricLogSyncLatency = "log_sync_latency"
+	// MetricLogWriteFailures counts the log files that could not be created or
+	// written.
+	MetricLogWriteFailures = "log_write_failures"
+	// MetricLogSyncFailures counts the log files that could not be synced.
+	MetricLogSyncFailures = "log_sync_failures"
+	// MetricLogWriteRetries counts the retries of log files that could not be
+	// written or synced (see WithFlushRetry).
+	MetricLogWriteRetries = "log_write_retries"
+	// MetricLogEntries counts the entries added to the log.
+	MetricLogEntries = "log_entries"
+)
+
+// noMetrics discards all measurements.
+type noMetrics struct{}
+
+func (noMetrics) ObserveDuration(string, time.Duration) {}
+
+func (noMetrics) IncCounter(string) {}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package gostore
+
+import "io/ioutil"
+
+// mmapFile reads the contents of file filename, since files are not mapped
+// into memory on this platform.
+func mmapFile(filename string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,69 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMmapRecovery(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		s := newStoreForTest(t, WithLogCompression(compress))
+		setForTest(t, s, sampleKey1, sampleValue1)
+		setForTest(t, s, sampleKey2, sampleValue2)
+		tid := s.BeginTransaction()
+		if err := s.Delete(tid, sampleKey2); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		s = reopenStoreForTest(t, s, WithMmapRecovery(true))
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, nil)
+		want := reopenStoreForTest(t, s).lm.log.Entry
+		if len(s.lm.log.Entry) != len(want) {
+			t.Fatalf("did not get expected log length. expected=%d, actual=%d", len(want), len(s.lm.log.Entry))
+		}
+		for i, e := range s.lm.log.Entry {
+			testLogEntry(t, e, want[i])
+		}
+	}
+}
+
+// largeLogForTest writes a log with n updates of large values in dir.
+func largeLogForTest(b *testing.B, n int) string {
+	dir, err := ioutil.TempDir(testLogDir, "bench_")
+	if err != nil {
+		b.Fatalf("could not create log directory: %v", err)
+	}
+	s, err := NewStore(dir)
+	if err != nil {
+		b.Fatalf("could not create store instance: %v", err)
+	}
+	value := bytes.Repeat(sampleValue1, 100)
+	tid := s.BeginTransaction()
+	for i := 0; i < n; i++ {
+		s.Set(tid, Key(fmt.Sprintf("key_%d", i)), CopyByteArray(value))
+	}
+	if err := s.Commit(tid); err != nil {
+		b.Fatalf("got an error while committing transaction: %v", err)
+	}
+	return s.lm.logDir
+}
+
+func BenchmarkRecovery(b *testing.B) {
+	dir := largeLogForTest(b, 10000)
+	for _, mmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%t", mmap), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := newLogManager(dir, WithMmapRecovery(mmap)); err != nil {
+					b.Fatalf("could not create log manager instance: %v", err)
+				}
+			}
+		})
+	}
+}
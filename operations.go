@@ -0,0 +1,97 @@
+package gostore
+
+import (
+	"fmt"
+	pb "github.com/mDibyo/gostore/pb"
+)
+
+// OperationType is the type of an operation performed by a transaction.
+type OperationType int
+
+const (
+	OperationSet    OperationType = iota // the key was set
+	OperationDelete                      // the key was deleted
+	OperationUndo                        // an earlier operation on the key was undone (when the transaction was aborted)
+)
+
+// Operation is an operation performed by a transaction on a key, as recorded
+// in the log.
+type Operation struct {
+	Type     OperationType
+	Key      Key
+	OldValue Value // the value of the key before the operation (nil if it did not exist)
+	NewValue Value // the value of the key after the operation (nil if it does not exist)
+}
+
+// newOperation decodes the UPDATE or UNDO entry e.
+func newOperation(e *pb.LogEntry) Operation {
+	op := Operation{
+		Type:     OperationSet,
+		Key:      Key(e.GetKey()),
+		OldValue: Value(CopyByteArray(e.OldValue)),
+		NewValue: Value(CopyByteArray(e.NewValue)),
+	}
+	if e.GetEntryType() == pb.LogEntry_UNDO {
+		op.Type = OperationUndo
+	} else if e.NewValue == nil {
+		op.Type = OperationDelete
+	}
+	return op
+}
+
+// transactionLog returns the operations performed by transaction tid, in
+// order. The operations of a running transaction are those it has logged and
+// not undone; those of a transaction that has ended are read from the log,
+// which no longer has them once it is compacted.
+func (lm *logManager) transactionLog(tid TransactionID) ([]Operation, error) {
+	lm.stateLock.Lock()
+	if ts, ok := lm.transactions[tid]; ok {
+		ops := make([]Operation, 0, len(ts.updates))
+		for _, e := range ts.updates {
+			ops = append(ops, newOperation(e))
+		}
+		lm.stateLock.Unlock()
+		if err := lm.resolveOperations(ops); err != nil {
+			return nil, err
+		}
+		return ops, nil
+	}
+	lm.stateLock.Unlock()
+
+	lm.logLock.Lock()
+	var ops []Operation
+	found := false
+	for _, e := range lm.log.Entry {
+		if TransactionID(e.GetTid()) != tid {
+			continue
+		}
+		found = true
+		switch e.GetEntryType() {
+		case pb.LogEntry_UPDATE, pb.LogEntry_UNDO:
+			ops = append(ops, newOperation(e))
+		}
+	}
+	lm.logLock.Unlock()
+	if !found {
+		return nil, fmt.Errorf("transaction with ID %d was not found in the log", tid)
+	}
+	if err := lm.resolveOperations(ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// resolveOperations replaces the stored values of ops with their values (see
+// resolveChunk), so that chunk references are not returned.
+func (lm *logManager) resolveOperations(ops []Operation) error {
+	for i := range ops {
+		var err error
+		if ops[i].OldValue, err = lm.resolveChunk(ops[i].OldValue); err != nil {
+			return err
+		}
+		if ops[i].NewValue, err = lm.resolveChunk(ops[i].NewValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
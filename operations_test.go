@@ -0,0 +1,72 @@
+package gostore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransactionLog(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	tid := s.BeginTransaction()
+	for _, w := range []struct {
+		k Key
+		v Value
+	}{
+		{sampleKey2, sampleValue2},
+		{sampleKey1, sampleValue2},
+		{sampleKey2, sampleValue3},
+	} {
+		if err := s.Set(tid, w.k, CopyByteArray(w.v)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", w.k, err)
+		}
+	}
+	if err := s.Delete(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+	}
+	wantOps := []Operation{
+		{OperationSet, sampleKey2, nil, sampleValue2},
+		{OperationSet, sampleKey1, sampleValue1, sampleValue2},
+		{OperationSet, sampleKey2, sampleValue2, sampleValue3},
+		{OperationDelete, sampleKey1, sampleValue2, nil},
+	}
+	checkOps := func(s *Store, tid TransactionID, wantOps []Operation) {
+		gotOps, err := s.TransactionLog(tid)
+		if err != nil {
+			t.Errorf("got an error while getting transaction log: %v", err)
+		} else if !reflect.DeepEqual(gotOps, wantOps) {
+			t.Errorf("did not get expected operations. expected=%v, actual=%v", wantOps, gotOps)
+		}
+	}
+	checkOps(s, tid, wantOps)
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	abortedTID := s.BeginTransaction()
+	if err := s.Set(abortedTID, sampleKey2, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Abort(abortedTID); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	wantAbortedOps := []Operation{
+		{OperationSet, sampleKey2, sampleValue3, sampleValue1},
+		{OperationUndo, sampleKey2, sampleValue1, sampleValue3},
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkOps(s, tid, wantOps)
+		checkOps(s, abortedTID, wantAbortedOps)
+	}
+
+	if _, err := s.TransactionLog(TransactionID(1000)); err == nil {
+		t.Errorf("did not get an error while getting log of unknown transaction.")
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	if _, err := s.TransactionLog(tid); err == nil {
+		t.Errorf("did not get an error while getting log of transaction after compaction.")
+	}
+}
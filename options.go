@@ -0,0 +1,420 @@
+package gostore
+
+import (
+	"os"
+	"time"
+)
+
+// config holds the configuration of a store.
+type config struct {
+	compressLog  bool        // whether log files are compressed when flushed
+	deltaEncode  bool        // whether new values are delta-encoded in log files
+	validateKeys bool        // whether keys are validated
+	maxKeyLength int         // the maximum length of a key, if positive and keys are validated
+	fileMode     os.FileMode // the permissions of log files
+	dirMode      os.FileMode // the permissions of the log directory, if it is created
+
+	maxTransactions int // the maximum number of running transactions, if positive
+	maxTxnKeys      int // the maximum number of keys modified by a transaction, if positive
+
+	compactionThreshold float64 // the fraction of superseded log entries above which the log is compacted, if positive
+	compactOnClose      bool    // whether the log is compacted when the store is closed
+
+	batchCommits  int           // the number of unflushed commits after which the log is flushed, if commits are batched
+	batchInterval time.Duration // the interval at which the log is flushed, if commits are batched
+
+	storage StorageBackend // the backend in which log files are created
+	metrics Metrics        // the receiver of measurements of operations
+
+	recoveryConflicts RecoveryConflictHandler // decides how recovery handles log entries that conflict with the store
+	mmapLog           bool                    // whether log files are mapped into memory when they are read
+
+	isolation   IsolationLevel // the isolation level of transactions begun without options
+	lockTimeout time.Duration  // the maximum time to wait for a lock, if positive
+	fsync       bool           // whether log files are synced to stable storage when they are written
+	flushRetry  RetryPolicy    // how writing a log file is retried if it fails
+	inMemory    bool           // whether the log is kept in memory only
+
+	flushOnContention bool // whether the log is flushed when a transaction has to wait for a lock
+
+	compareKeys KeyComparator // the order of keys returned by ordered operations, if not byte-wise
+
+	logFileFmt  string // the format of the names of log files
+	shardSize   int    // the number of LSNs covered by each subdirectory of log files, if positive
+	maxSegments int    // the number of log files above which they are merged, if positive
+
+	maxSegmentBytes int64 // the maximum size of a log file written by a flush, if positive
+
+	maxKeys  int            // the maximum number of keys, above which keys are evicted, if positive
+	eviction EvictionPolicy // the policy by which keys are chosen for eviction
+
+	clock Clock // the source of time
+
+	hashLockTable bool // whether the lock tables of transactions are keyed on hashes of keys
+
+	defaultDir string // the log directory, if none is given when the store is opened
+
+	chunkThreshold int // the size of values above which they are stored as chunks, if positive
+
+	authorizer Authorizer // decides whether transactions may access keys, if set
+}
+
+func defaultConfig() config {
+	return config{
+		fileMode: 0644,
+		dirMode:  0755,
+		storage:  osStorage{},
+		metrics:  noMetrics{},
+		clock:    realClock{},
+
+		defaultDir: DefaultLogDir,
+
+		recoveryConflicts: abortOnRecoveryConflict,
+		logFileFmt:        logFileFmt,
+
+		fsync: true,
+	}
+}
+
+// DefaultLogDir is the log directory of a store opened without one (and
+// without WithDefaultDir). It is relative to the working directory of the
+// process when the store is opened.
+const DefaultLogDir = "./data"
+
+// Option configures a store when it is opened.
+type Option func(*config)
+
+// WithLogCompression sets whether log files are compressed (with gzip) when
+// they are flushed to disk. Compressed and uncompressed log files can be read
+// back regardless of this option. It is disabled by default.
+func WithLogCompression(enabled bool) Option {
+	return func(c *config) {
+		c.compressLog = enabled
+	}
+}
+
+// WithDeltaEncoding sets whether the new values of updates are written to log
+// files as deltas from their old values, when the delta is smaller. It reduces
+// the size of the log when values are updated with small changes. Log files
+// written with and without delta encoding can be read back regardless of this
+// option. It is disabled by default.
+func WithDeltaEncoding(enabled bool) Option {
+	return func(c *config) {
+		c.deltaEncode = enabled
+	}
+}
+
+// WithKeyValidation enables validation of the keys used in transactions. Keys
+// containing NUL bytes, or longer than maxKeyLength bytes (if it is positive),
+// are rejected with ErrInvalidKey. Without validation, keys may be arbitrary
+// byte strings.
+func WithKeyValidation(maxKeyLength int) Option {
+	return func(c *config) {
+		c.validateKeys = true
+		c.maxKeyLength = maxKeyLength
+	}
+}
+
+// WithFileMode sets the permissions of log files. The permissions are set
+// exactly, regardless of the umask of the process. The default is 0644.
+func WithFileMode(mode os.FileMode) Option {
+	return func(c *config) {
+		c.fileMode = mode
+	}
+}
+
+// WithDirMode sets the permissions of the log directory if it does not exist
+// and is created. The permissions are set exactly, regardless of the umask of
+// the process. The default is 0755.
+func WithDirMode(mode os.FileMode) Option {
+	return func(c *config) {
+		c.dirMode = mode
+	}
+}
+
+// WithMaxTransactions limits the number of transactions that can be running
+// at once to n. When the limit is reached, beginning a transaction waits until
+// a running transaction is committed or aborted (or fails immediately with
+// ErrTooManyTransactions, for TryBeginTransaction). By default, the number of
+// transactions is not limited.
+func WithMaxTransactions(n int) Option {
+	return func(c *config) {
+		c.maxTransactions = n
+	}
+}
+
+// WithMaxTransactionKeys limits the number of distinct keys that a single
+// transaction can set or delete to n, since a transaction holds a lock and undo
+// state for each key it modifies until it ends. Modifying another key fails
+// with ErrTransactionTooLarge, and the transaction should be aborted. By
+// default, the number of keys is not limited.
+func WithMaxTransactionKeys(n int) Option {
+	return func(c *config) {
+		c.maxTxnKeys = n
+	}
+}
+
+// WithCompactionThreshold enables automatic compaction of the log. After a
+// transaction is committed, the log is compacted if the fraction of its
+// entries that are superseded (by later updates of the same keys) exceeds
+// threshold, which should be between 0 and 1. By default, the log is only
+// compacted by Store.Compact.
+func WithCompactionThreshold(threshold float64) Option {
+	return func(c *config) {
+		c.compactionThreshold = threshold
+	}
+}
+
+// WithCompactOnClose makes closing the store compact the log once it has been
+// flushed, so that the compacted log serves as a checkpoint from which the
+// store is quickly recovered when it is next opened.
+func WithCompactOnClose(enabled bool) Option {
+	return func(c *config) {
+		c.compactOnClose = enabled
+	}
+}
+
+// WithCommitBatching enables batching of commits. Committing a transaction
+// does not wait for the log to be flushed to disk. Instead, the log is flushed
+// in the background once maxCommits commits are unflushed (if it is positive),
+// and at least every interval (if it is positive). Transactions reported as
+// committed may be lost if the process exits before the log is flushed, unless
+// the store is closed with Store.Close. By default, the log is flushed before
+// every commit returns.
+func WithCommitBatching(maxCommits int, interval time.Duration) Option {
+	return func(c *config) {
+		c.batchCommits = maxCommits
+		c.batchInterval = interval
+	}
+}
+
+// WithStorageBackend sets the backend in which log files are created. By
+// default, they are created in the file system.
+func WithStorageBackend(storage StorageBackend) Option {
+	return func(c *config) {
+		c.storage = storage
+	}
+}
+
+// WithMetrics sets the receiver of measurements of the operations of the
+// store, such as the latency of writing log files. By default, measurements
+// are discarded.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *config) {
+		c.metrics = metrics
+	}
+}
+
+// WithRecoveryConflictHandler sets the handler that decides how recovery
+// handles a log entry that conflicts with the state of the store being
+// recovered, e.g. because log files are corrupted or were replaced. By
+// default, recovery fails with ErrRecoveryConflict. A nil handler disables
+// the detection of conflicts.
+func WithRecoveryConflictHandler(handler RecoveryConflictHandler) Option {
+	return func(c *config) {
+		c.recoveryConflicts = handler
+	}
+}
+
+// WithMmapRecovery sets whether log files are mapped into memory, instead of
+// being read into memory, when the log is retrieved as the store is opened.
+// This avoids holding a copy of the contents of each log file while it is
+// unmarshalled. On platforms where files can not be mapped into memory, they
+// are read regardless. It is disabled by default.
+func WithMmapRecovery(enabled bool) Option {
+	return func(c *config) {
+		c.mmapLog = enabled
+	}
+}
+
+// WithDefaultIsolation sets the isolation level of transactions begun without
+// options (by Store.BeginTransaction and Store.Begin). The default is
+// Serializable.
+func WithDefaultIsolation(isolation IsolationLevel) Option {
+	return func(c *config) {
+		c.isolation = isolation
+	}
+}
+
+// WithLockTimeout limits the time that a transaction waits for a lock on a key
+// to timeout. If the lock can not be taken in time, the operation fails with
+// ErrTimeout (and a read lock held on the key, if it was being upgraded, is
+// still held), and the transaction should be aborted. If the locks on the keys
+// written by a transaction with blind writes can not be taken when it is
+// committed, it is aborted. By default, transactions wait for locks
+// indefinitely.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = timeout
+	}
+}
+
+// WithFlushOnContention sets whether the log is flushed (in the background)
+// when a transaction has to wait for a lock on a key. The entries written by
+// the transaction holding the lock are then flushed before it commits, so that
+// its commit, which flushes the log before releasing its locks, is quicker.
+// It is disabled by default.
+func WithFlushOnContention(enabled bool) Option {
+	return func(c *config) {
+		c.flushOnContention = enabled
+	}
+}
+
+// WithFsync sets whether log files are synced to stable storage when they are
+// written. Without syncing, committed transactions may be lost if the system
+// crashes (but not if only the process exits). It is enabled by default.
+func WithFsync(enabled bool) Option {
+	return func(c *config) {
+		c.fsync = enabled
+	}
+}
+
+// WithFlushRetry sets how writing a log file is retried when the log is
+// flushed, if creating, writing or syncing it fails, e.g. because of a
+// transient error of a network file system. The log file is written up to
+// policy.MaxAttempts times, with exponentially growing delays in between,
+// before the flush (and so the commit that flushed the log) fails. Each retry
+// is counted in MetricLogWriteRetries. Unlike Store.Transact, which retries
+// transactions, this retries only the storage operations of a flush. By
+// default, writing a log file is not retried.
+func WithFlushRetry(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.flushRetry = policy
+	}
+}
+
+// WithInMemory sets whether the log is kept in memory only. An in-memory store
+// does not read or write its log directory, so its contents are lost when it
+// is no longer used. It is disabled by default.
+func WithInMemory(enabled bool) Option {
+	return func(c *config) {
+		c.inMemory = enabled
+	}
+}
+
+// WithKeyComparator sets the order in which operations that return keys in
+// order (such as Store.TransactionReadWriteSets) return them, e.g. so that
+// numeric keys are ordered numerically. It does not affect how keys are looked
+// up. By default, keys are ordered byte-wise.
+func WithKeyComparator(cmp KeyComparator) Option {
+	return func(c *config) {
+		c.compareKeys = cmp
+	}
+}
+
+// WithLogFileFormat sets the format of the names of log files. It is formatted
+// with the LSNs of the first and last entries of each log file, and must allow
+// them to be parsed back (with fmt.Sscanf). The store must be opened with the
+// same format each time. The default is "%012d_%012d.log".
+func WithLogFileFormat(format string) Option {
+	return func(c *config) {
+		c.logFileFmt = format
+	}
+}
+
+// WithShardedSegments stores log files (segments) in subdirectories of the log
+// directory, each holding the log files whose first LSN is in a range of n
+// LSNs, so that large logs do not put many files in a single directory. Log
+// files are found in subdirectories regardless of this option, so it can be
+// changed between openings of a store. By default, log files are stored
+// directly in the log directory.
+func WithShardedSegments(n int) Option {
+	return func(c *config) {
+		c.shardSize = n
+	}
+}
+
+// WithMaxSegments limits the number of log files (segments) in the log
+// directory to about n. Since the log is flushed as a new log file each time,
+// many small log files accumulate; once there are more than n, runs of
+// adjacent small log files are merged in the background into larger log files
+// covering the same entries, which makes the store quicker to open. By
+// default, log files are only replaced by compacting the log.
+func WithMaxSegments(n int) Option {
+	return func(c *config) {
+		c.maxSegments = n
+	}
+}
+
+// WithMaxSegmentSize limits the size of the log files (segments) written when
+// the log is flushed to about n bytes. The entries to be flushed are split
+// across as many log files as needed, covering contiguous ranges of LSNs, so
+// that a large transaction does not produce a huge log file. The size of a log
+// file is measured before it is compressed or delta encoded, and a log entry
+// larger than n is written as a log file of its own. Log files written by
+// merging (see WithMaxSegments) or compacting the log are not limited. By
+// default, each flush writes a single log file.
+func WithMaxSegmentSize(n int64) Option {
+	return func(c *config) {
+		c.maxSegmentBytes = n
+	}
+}
+
+// WithChunkStore stores values larger than threshold bytes out of memory and
+// out of the log, as content-addressed chunk files in a subdirectory of the log
+// directory. The store and the log hold a small reference to the chunk in
+// place of such a value, and the value is read from its chunk file only when
+// it is read. This suits large, blob-like values, which are then neither held
+// in memory nor copied by each operation. Chunk files are removed once the log
+// no longer refers to them, when the log is compacted (or
+// Store.CollectChunks is called). Watch events and Store.TransactionLog carry
+// the values themselves, but the log entries read by Store.LogTail (and so by
+// replicas) carry the references of chunked values. It has no effect on
+// in-memory stores. By default, all values are held in memory.
+func WithChunkStore(threshold int) Option {
+	return func(c *config) {
+		c.chunkThreshold = threshold
+	}
+}
+
+// WithMaxKeys limits the number of keys in the store to n, for cache-style
+// usage. When a committed transaction leaves more than n keys in the store,
+// keys chosen by policy are deleted (in a transaction of their own, so the
+// deletes are logged) until there are n. Keys locked by running transactions
+// are not evicted, so the store may briefly exceed the limit. By default, the
+// number of keys is not limited.
+func WithMaxKeys(n int, policy EvictionPolicy) Option {
+	return func(c *config) {
+		c.maxKeys = n
+		c.eviction = policy
+	}
+}
+
+// WithClock sets the source of time of the store, e.g. to a fake clock in
+// tests. By default, time is read from the time package.
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithHashedLockTable sets whether the lock tables of transactions are keyed on
+// hashes of the keys they lock, rather than on the keys themselves. For
+// workloads with very long keys, this keeps the lock tables from holding on to
+// copies of the keys passed to each operation: the lock of each key records
+// the key held by the store instead. Keys whose hashes collide are still locked
+// independently. By default, lock tables are keyed on keys.
+func WithHashedLockTable(enabled bool) Option {
+	return func(c *config) {
+		c.hashLockTable = enabled
+	}
+}
+
+// WithDefaultDir sets the log directory used if the store is opened with an
+// empty directory name. By default, it is DefaultLogDir.
+func WithDefaultDir(dir string) Option {
+	return func(c *config) {
+		c.defaultDir = dir
+	}
+}
+
+// WithAuthorizer makes the store call authorizer on each read, write and
+// deletion of a key by a transaction, with the identity the transaction was
+// begun with. Operations it denies fail with ErrAccessDenied before any lock is
+// taken. Reads outside transactions (e.g. SnapshotRead and GetAsOf) are not
+// authorized.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(c *config) {
+		c.authorizer = authorizer
+	}
+}
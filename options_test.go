@@ -0,0 +1,247 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreOptions(t *testing.T) {
+	storage := &testStorage{}
+	timeout := 50 * time.Millisecond
+	opts := []Option{WithStorageBackend(storage), WithFsync(false), WithLockTimeout(timeout), WithDefaultIsolation(ReadCommitted)}
+	s := newStoreForTest(t, opts...)
+
+	// Transactions begun without options have the default isolation level
+	for _, tid := range []TransactionID{s.BeginTransaction(), beginTxnForTest(t, s).ID()} {
+		if gotIsolation := s.lm.transactions[tid].isolation; gotIsolation != ReadCommitted {
+			t.Errorf("did not get expected isolation level. expected=%v, actual=%v", ReadCommitted, gotIsolation)
+		}
+		s.Abort(tid)
+	}
+
+	// Log files are not synced
+	setForTest(t, s, sampleKey1, sampleValue1)
+	if storage.syncs != 0 {
+		t.Errorf("found that log files were synced. syncs=%d", storage.syncs)
+	}
+	s = reopenStoreForTest(t, s, opts...)
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Waiting for locks times out
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	for _, opts := range []TransactionOptions{{}, {Isolation: ReadCommitted}, deferWritesOptions} {
+		otherTID := s.BeginTransactionWithOptions(opts)
+		start := time.Now()
+		if _, err := s.Get(otherTID, sampleKey1); !errors.Is(err, ErrTimeout) {
+			t.Errorf("did not get expected error while getting value for key='%s'. expected=%v, actual=%v", sampleKey1, ErrTimeout, err)
+		}
+		if err := s.Set(otherTID, sampleKey1, CopyByteArray(sampleValue3)); !errors.Is(err, ErrTimeout) {
+			t.Errorf("did not get expected error while setting value for key='%s'. expected=%v, actual=%v", sampleKey1, ErrTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed < 2*timeout {
+			t.Errorf("found that lock waits did not wait for timeout. elapsed=%v", elapsed)
+		}
+		if err := s.Abort(otherTID); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue2)
+
+	// Log files are synced by default
+	s = newStoreForTest(t, WithStorageBackend(storage))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	if storage.syncs == 0 {
+		t.Errorf("found that log files were not synced.")
+	}
+}
+
+func TestInMemoryStore(t *testing.T) {
+	dir := filepath.Join(testLogDir, "in_memory")
+	s, err := NewStore(dir, WithInMemory(true))
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	overwriteForTest(t, s, 3, sampleKey1, sampleKey2)
+	if err := s.Compact(); err != nil {
+		t.Errorf("got an error while compacting log: %v", err)
+	}
+	setForTest(t, s, sampleKey3, sampleValue3)
+	checkStoreValue(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, 2)))
+	checkStoreValue(t, s, sampleKey3, sampleValue3)
+	if stats := s.Stats(); stats.Segments != 0 || stats.UnflushedEntries != 0 {
+		t.Errorf("did not get expected log stats. actual=%+v", stats)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("found that log directory of in-memory store was created. err=%v", err)
+	}
+}
+
+func TestKeyComparator(t *testing.T) {
+	// Orders keys numerically, then byte-wise
+	numeric := func(a, b Key) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(string(a), string(b))
+	}
+	keys := []Key{"10", "9", "100", "2"}
+	tests := []struct {
+		opts      []Option
+		wantOrder []Key
+	}{
+		{nil, []Key{"10", "100", "2", "9"}},
+		{[]Option{WithKeyComparator(numeric)}, []Key{"2", "9", "10", "100"}},
+	}
+
+	for _, test := range tests {
+		s := newStoreForTest(t, test.opts...)
+		tid := s.BeginTransaction()
+		for _, k := range keys {
+			if err := s.Set(tid, k, CopyByteArray(sampleValue1)); err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			}
+		}
+		if _, gotOrder, err := s.TransactionReadWriteSets(tid); err != nil {
+			t.Errorf("got an error while getting read and write sets: %v", err)
+		} else if !reflect.DeepEqual(gotOrder, test.wantOrder) {
+			t.Errorf("did not get expected order of keys. expected=%v, actual=%v", test.wantOrder, gotOrder)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		// Lookups are not affected
+		for _, k := range keys {
+			checkStoreValue(t, s, k, sampleValue1)
+		}
+	}
+}
+
+func TestMaxTransactionKeys(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, {BlindWrites: true}} {
+		s := newStoreForTest(t, WithMaxTransactionKeys(3))
+		tid := s.BeginTransactionWithOptions(opts)
+		for i := 0; i < 5; i++ {
+			k := Key(fmt.Sprintf("key_%d", i))
+			err := s.Set(tid, k, CopyByteArray(sampleValue1))
+			if i < 3 && err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			} else if i >= 3 && !errors.Is(err, ErrTransactionTooLarge) {
+				t.Errorf("did not get expected error while setting value for key='%s'. expected=%v, actual=%v", k, ErrTransactionTooLarge, err)
+			}
+		}
+		// Keys already modified can be modified again
+		if err := s.Delete(tid, "key_0"); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", "key_0", err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		checkStoreValue(t, s, "key_0", nil)
+		checkStoreValue(t, s, "key_2", sampleValue1)
+		checkStoreValue(t, s, "key_3", nil)
+	}
+}
+
+func TestFlushOnContention(t *testing.T) {
+	s := newStoreForTest(t, WithFlushOnContention(true))
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	s.lm.logLock.Lock()
+	updateLSN := s.lm.nextLSN - 1
+	s.lm.logLock.Unlock()
+
+	// A transaction waiting for the lock flushes the entries of the holder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waiter := s.BeginTransaction()
+		if _, err := s.Get(waiter, sampleKey1); err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Commit(waiter); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.lm.logLock.Lock()
+		flushed := s.lm.nextLSNToFlush > updateLSN
+		s.lm.logLock.Unlock()
+		if flushed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("found that log was not flushed while a transaction waited for a lock.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	<-done
+	checkStoreValue(t, s, sampleKey1, sampleValue2)
+}
+
+// benchmarkLockContention measures the time taken for a transaction waiting
+// for the lock on a key, held by a transaction that has written a large value
+// to it, to get the lock once the holder starts to commit.
+func benchmarkLockContention(b *testing.B, flushOnContention bool) {
+	dir, err := ioutil.TempDir(testLogDir, "bench_")
+	if err != nil {
+		b.Fatalf("could not create log directory: %v", err)
+	}
+	s, err := NewStore(dir, WithFlushOnContention(flushOnContention))
+	if err != nil {
+		b.Fatalf("could not create store instance: %v", err)
+	}
+	value := bytes.Repeat(sampleValue1, 1<<16)
+	tid := s.BeginTransaction()
+	s.Set(tid, sampleKey1, value)
+	s.Commit(tid)
+
+	var wait time.Duration
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tid := s.BeginTransaction()
+		s.Set(tid, sampleKey1, value)
+		acquired := make(chan time.Time)
+		go func() {
+			waiter := s.BeginTransaction()
+			s.Get(waiter, sampleKey1)
+			acquired <- time.Now()
+			s.Commit(waiter)
+		}()
+		// The holder does some more work before committing
+		time.Sleep(5 * time.Millisecond)
+		start := time.Now()
+		s.Commit(tid)
+		wait += (<-acquired).Sub(start)
+	}
+	b.ReportMetric(float64(wait.Nanoseconds())/float64(b.N), "release-ns/op")
+}
+
+func BenchmarkLockContention(b *testing.B) {
+	benchmarkLockContention(b, false)
+}
+
+func BenchmarkLockContentionFlushOnContention(b *testing.B) {
+	benchmarkLockContention(b, true)
+}
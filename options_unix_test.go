@@ -0,0 +1,65 @@
+//go:build unix
+
+package gostore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileModes(t *testing.T) {
+	oldUmask := syscall.Umask(0077)
+	defer syscall.Umask(oldUmask)
+
+	tests := []struct {
+		opts         []Option
+		wantFileMode os.FileMode
+		wantDirMode  os.FileMode
+	}{
+		{
+			wantFileMode: 0644,
+			wantDirMode:  0755,
+		},
+		{
+			opts:         []Option{WithFileMode(0600), WithDirMode(0700)},
+			wantFileMode: 0600,
+			wantDirMode:  0700,
+		},
+		{
+			opts:         []Option{WithFileMode(0664), WithDirMode(0775)},
+			wantFileMode: 0664,
+			wantDirMode:  0775,
+		},
+	}
+
+	for _, test := range tests {
+		parent, err := ioutil.TempDir(testLogDir, "modes_")
+		if err != nil {
+			t.Fatalf("could not create temporary directory: %v", err)
+		}
+		dir := filepath.Join(parent, "a", "logs")
+		s, err := NewStore(dir, test.opts...)
+		if err != nil {
+			t.Fatalf("could not create store instance: %v", err)
+		}
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		if fi, err := os.Stat(dir); err != nil {
+			t.Errorf("could not stat log directory: %v", err)
+		} else if gotMode := fi.Mode().Perm(); gotMode != test.wantDirMode {
+			t.Errorf("did not get expected log directory mode. expected=%v, actual=%v", test.wantDirMode, gotMode)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) == 0 {
+			t.Fatalf("could not find log files: %v", err)
+		}
+		for _, fi := range files {
+			if gotMode := fi.Mode().Perm(); gotMode != test.wantFileMode {
+				t.Errorf("did not get expected log file mode for %s. expected=%v, actual=%v", fi.Name(), test.wantFileMode, gotMode)
+			}
+		}
+	}
+}
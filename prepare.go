@@ -0,0 +1,153 @@
+package gostore
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"sort"
+)
+
+// A transaction taking part in a two-phase commit is first prepared: its
+// writes are locked and logged, and a PREPARE entry is flushed. From then on,
+// it can no longer be modified, and it is committed or aborted only when the
+// coordinator of the two-phase commit decides to. A prepared transaction that
+// was neither committed nor aborted when the store was closed (in doubt) is
+// restored as prepared, with its locks held, when the store is next opened.
+
+// prepareTransaction prepares a transaction to be committed. If it can not be
+// prepared, it is aborted.
+func (lm *logManager) prepareTransaction(tid TransactionID) error {
+	lm.stateLock.Lock()
+	_, ok := lm.currMutexes[tid]
+	ts := lm.transactions[tid]
+	lm.stateLock.Unlock()
+	if !ok {
+		return fmt.Errorf("transaction with ID %d is not currently running", tid)
+	}
+	if ts.prepared {
+		return nil
+	}
+	if ts.bulkLoad {
+		return fmt.Errorf("transaction with ID %d is a bulk load, which can not be prepared", tid)
+	}
+	if err := lm.checkStorageAvailable(); err != nil {
+		lm.abortTransaction(tid)
+		return err
+	}
+	if ts.blind {
+		// The writes are now locked and logged, as deferred writes are.
+		if err := lm.logBlindWrites(tid, ts); err != nil {
+			lm.abortTransaction(tid)
+			return fmt.Errorf("transaction was aborted: %w", err)
+		}
+		ts.blind = false
+	}
+	if err := lm.runPreCommitHooks(tid, lm.hookWriteSet(ts)); err != nil {
+		lm.abortTransaction(tid)
+		return fmt.Errorf("transaction was aborted: %w", err)
+	}
+
+	if err := lm.addTransactionLogEntry(tid, ts, &pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_PREPARE.Enum(),
+	}); err != nil {
+		return err
+	}
+	if err := lm.flushLog(); err != nil {
+		lm.abortTransaction(tid)
+		return fmt.Errorf("%w: transaction was aborted: %v", ErrStorageUnavailable, err)
+	}
+
+	lm.stateLock.Lock()
+	ts.prepared = true
+	lm.stateLock.Unlock()
+	return nil
+}
+
+// checkNotPrepared returns an error if transaction ts is prepared, and so can
+// not be modified.
+func checkNotPrepared(tid TransactionID, ts *transactionState) error {
+	if ts != nil && ts.prepared {
+		return fmt.Errorf("transaction with ID %d is prepared", tid)
+	}
+	return nil
+}
+
+// endPreparedTransaction commits or aborts a prepared transaction.
+func (lm *logManager) endPreparedTransaction(tid TransactionID, commit bool) error {
+	lm.stateLock.Lock()
+	ts, ok := lm.transactions[tid]
+	lm.stateLock.Unlock()
+	if !ok {
+		return fmt.Errorf("transaction with ID %d is not currently running", tid)
+	}
+	if !ts.prepared {
+		return fmt.Errorf("transaction with ID %d is not prepared", tid)
+	}
+	if commit {
+		return lm.commitTransaction(tid)
+	}
+	return lm.abortTransaction(tid)
+}
+
+// restorePreparedTransaction restores an in-doubt transaction as prepared
+// during recovery, taking the write locks on the keys it updated. Its updates
+// have already been redone.
+func (lm *logManager) restorePreparedTransaction(tid TransactionID) {
+	cm, ts := lm.currMutexes[tid], lm.transactions[tid]
+	for _, e := range ts.updates {
+		k := Key(e.GetKey())
+		smv, _ := lm.store.storeMapValue(k, true)
+		cm.getWrappedRWMutex(k, smv).wLock()
+		ts.modifiedKeys[k] = struct{}{}
+	}
+	ts.prepared = true
+}
+
+// preparedTransactions returns the IDs of the prepared transactions, in
+// order.
+func (lm *logManager) preparedTransactions() []TransactionID {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	var tids []TransactionID
+	for tid, ts := range lm.transactions {
+		if ts.prepared {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	return tids
+}
+
+// Participant is a transaction in a store taking part in a two-phase commit.
+type Participant struct {
+	Store *Store
+	TID   TransactionID
+}
+
+// CommitParticipants atomically commits transactions in several stores using
+// two-phase commit. All the transactions are prepared first. If any of them
+// can not be prepared, all of them are aborted and the error is returned.
+// Otherwise, all of them are committed.
+func CommitParticipants(ps ...Participant) error {
+	for i, p := range ps {
+		if err := p.Store.Prepare(p.TID); err != nil {
+			for j, q := range ps {
+				if j < i {
+					q.Store.AbortPrepared(q.TID)
+				} else if j > i {
+					q.Store.Abort(q.TID)
+				}
+			}
+			return fmt.Errorf("could not prepare transaction with ID %d: %w", p.TID, err)
+		}
+	}
+
+	var err error
+	for _, p := range ps {
+		if cerr := p.Store.CommitPrepared(p.TID); cerr != nil && err == nil {
+			err = fmt.Errorf("could not commit prepared transaction with ID %d: %w", p.TID, cerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package gostore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// participantsForTest begins a transaction in each of two new stores, setting
+// key k to v in both.
+func participantsForTest(t *testing.T, k Key, v Value, opts ...Option) []Participant {
+	var ps []Participant
+	for _, s := range []*Store{newStoreForTest(t), newStoreForTest(t, opts...)} {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, k, CopyByteArray(v)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		ps = append(ps, Participant{s, tid})
+	}
+	return ps
+}
+
+func TestCommitParticipants(t *testing.T) {
+	ps := participantsForTest(t, sampleKey1, sampleValue1)
+	if err := CommitParticipants(ps...); err != nil {
+		t.Fatalf("got an error while committing participants: %v", err)
+	}
+	for _, p := range ps {
+		for _, s := range []*Store{p.Store, reopenStoreForTest(t, p.Store)} {
+			checkStoreValue(t, s, sampleKey1, sampleValue1)
+			if got := s.PreparedTransactions(); len(got) != 0 {
+				t.Errorf("found prepared transactions: %v", got)
+			}
+		}
+	}
+}
+
+func TestCommitParticipantsPrepareFailure(t *testing.T) {
+	storage := &testStorage{}
+	ps := participantsForTest(t, sampleKey1, sampleValue1, WithStorageBackend(storage))
+	storage.writeErr = errors.New("disk full")
+	if err := CommitParticipants(ps...); !errors.Is(err, ErrStorageUnavailable) {
+		t.Fatalf("did not get expected error while committing participants. expected=%v, actual=%v", ErrStorageUnavailable, err)
+	}
+	storage.writeErr = nil
+	for _, p := range ps {
+		for _, s := range []*Store{p.Store, reopenStoreForTest(t, p.Store)} {
+			checkStoreValue(t, s, sampleKey1, nil)
+			if got := s.PreparedTransactions(); len(got) != 0 {
+				t.Errorf("found prepared transactions: %v", got)
+			}
+		}
+	}
+}
+
+func TestAbortPrepared(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	tid := s.BeginTransaction()
+	if err := s.AbortPrepared(tid); err == nil {
+		t.Errorf("did not get an error while aborting transaction that is not prepared.")
+	}
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Prepare(tid); err != nil {
+		t.Fatalf("got an error while preparing transaction: %v", err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err == nil {
+		t.Errorf("did not get an error while setting value in prepared transaction.")
+	}
+	if got, want := s.PreparedTransactions(), []TransactionID{tid}; !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected prepared transactions. expected=%v, actual=%v", want, got)
+	}
+	if err := s.AbortPrepared(tid); err != nil {
+		t.Errorf("got an error while aborting prepared transaction: %v", err)
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, nil)
+	}
+}
+
+func TestInDoubtRecovery(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	committedTID := s.BeginTransaction()
+	if err := s.Set(committedTID, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	abortedTID := s.BeginTransactionWithOptions(blindWritesOptions)
+	if err := s.Delete(abortedTID, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	for _, tid := range []TransactionID{committedTID, abortedTID} {
+		if err := s.Prepare(tid); err != nil {
+			t.Fatalf("got an error while preparing transaction: %v", err)
+		}
+	}
+
+	// In-doubt transactions are rolled back when the log is replayed
+	if _, _, gotStore := replayLogDirForTest(t, s.lm.logDir); !reflect.DeepEqual(gotStore, map[Key]Value{sampleKey2: sampleValue2}) {
+		t.Errorf("did not get the expected store. expected=%v, actual=%v", map[Key]Value{sampleKey2: sampleValue2}, gotStore)
+	}
+
+	// but are restored as prepared when the store is opened
+	s = reopenStoreForTest(t, s)
+	want := []TransactionID{committedTID, abortedTID}
+	if got := s.PreparedTransactions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("did not get expected prepared transactions. expected=%v, actual=%v", want, got)
+	}
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := s.CommitPrepared(committedTID); err != nil {
+		t.Errorf("got an error while committing prepared transaction: %v", err)
+	}
+	if err := s.AbortPrepared(abortedTID); err != nil {
+		t.Errorf("got an error while aborting prepared transaction: %v", err)
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, sampleValue2)
+		checkStoreValue(t, s, sampleKey3, sampleValue3)
+		if got := s.PreparedTransactions(); len(got) != 0 {
+			t.Errorf("found prepared transactions: %v", got)
+		}
+	}
+}
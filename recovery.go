@@ -0,0 +1,249 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io"
+	"io/ioutil"
+)
+
+// apply sets the value of key k to v with metadata meta, or deletes k if v is
+// nil. It is used while replaying the log, when no transactions are running
+// and so no locks need to be taken.
+func (sm storeMap) apply(k Key, v Value, meta *pb.Meta) {
+	if v == nil {
+		delete(sm, k)
+		return
+	}
+	smv, _ := sm.storeMapValue(k, true)
+	smv.value = v
+	smv.meta = meta
+}
+
+// RecoveryInfo summarizes the recovery of a store from its log when it was
+// opened.
+type RecoveryInfo struct {
+	Segments       int             // the number of log files read
+	Entries        int             // the number of log entries read and replayed
+	RolledForward  []TransactionID // the committed transactions that had not ended, which were ended
+	RolledBack     []TransactionID // the transactions that had neither committed nor finished aborting, which were aborted
+	InDoubt        []TransactionID // the prepared transactions that were restored, awaiting the decision of their coordinator
+	LastLSN        int64           // the LSN of the last entry in the log after recovery, or -1 if the log is empty
+	StaleLockOwner string          // the process ID recorded in a stale lock on the log directory that was stolen, or "" if there was none
+}
+
+// RecoveryDecision is the decision of a RecoveryConflictHandler about a log
+// entry that conflicts with the state of the store during recovery.
+type RecoveryDecision int
+
+const (
+	RecoveryAbort  RecoveryDecision = iota // fail recovery, so the store is not opened
+	RecoverySkip                           // ignore the entry
+	RecoveryAccept                         // apply the entry regardless
+)
+
+// RecoveryConflictHandler decides how recovery handles a log entry that does
+// not match the state of the store being recovered: an UNDO entry whose
+// OldValue is not the current value of its key. It is called with the entry
+// and the current value (nil if the key does not exist).
+type RecoveryConflictHandler func(e *pb.LogEntry, current Value) RecoveryDecision
+
+// abortOnRecoveryConflict is the default RecoveryConflictHandler.
+func abortOnRecoveryConflict(*pb.LogEntry, Value) RecoveryDecision {
+	return RecoveryAbort
+}
+
+// transactionStatus is the status of a transaction as recorded in the log.
+type transactionStatus int
+
+const (
+	statusActive    transactionStatus = iota // begun, but neither committed nor aborted
+	statusCommitted                          // COMMIT entry written
+	statusAborted                            // ABORT entry written
+	statusPrepared                           // PREPARE entry written, but neither committed nor aborted
+)
+
+// transactionAnalysis summarizes the entries of a transaction in the log.
+type transactionAnalysis struct {
+	status   transactionStatus
+	ended    bool // whether an END entry was written
+	bulkLoad bool // whether the transaction is a bulk load (see TransactionOptions)
+}
+
+// discarded returns whether the updates of the transaction were never applied
+// to the store, and are not to be replayed: it was aborted and ended, or it is
+// a bulk load that was not committed, whose writes were deferred and which has
+// no old values to undo them with.
+func (ta *transactionAnalysis) discarded() bool {
+	return (ta.status == statusAborted && ta.ended) || (ta.bulkLoad && ta.status != statusCommitted)
+}
+
+// crashed returns whether the transaction was in flight when the log ended,
+// i.e. it must be rolled back.
+func (ta *transactionAnalysis) crashed() bool {
+	return !ta.ended && ta.status != statusCommitted && ta.status != statusPrepared
+}
+
+// inDoubt returns whether the transaction was prepared, but neither committed
+// nor aborted, when the log ended. Its outcome is decided by the coordinator
+// of the two-phase commit.
+func (ta *transactionAnalysis) inDoubt() bool {
+	return !ta.ended && ta.status == statusPrepared
+}
+
+// analyzeLogEntries classifies each transaction in a log as committed, aborted,
+// prepared or crashed (in flight without a terminal entry).
+func analyzeLogEntries(entries []*pb.LogEntry) map[TransactionID]*transactionAnalysis {
+	analysis := make(map[TransactionID]*transactionAnalysis)
+	for _, e := range entries {
+		tid := TransactionID(e.GetTid())
+		ta, ok := analysis[tid]
+		if !ok {
+			ta = &transactionAnalysis{}
+			analysis[tid] = ta
+		}
+		switch e.GetEntryType() {
+		case pb.LogEntry_BEGIN:
+			ta.bulkLoad = e.GetBulkLoad()
+		case pb.LogEntry_COMMIT:
+			ta.status = statusCommitted
+		case pb.LogEntry_ABORT:
+			ta.status = statusAborted
+		case pb.LogEntry_PREPARE:
+			ta.status = statusPrepared
+		case pb.LogEntry_END:
+			ta.ended = true
+		}
+	}
+	return analysis
+}
+
+// redoLogEntries replays the UPDATE and UNDO entries of a log over sm in order.
+// Entries of transactions that were aborted and ended are skipped, since their
+// updates were either undone (by UNDO entries) or never applied (if their
+// writes were deferred), as are the entries of bulk loads that were not
+// committed. Since transactions hold write locks until they end,
+// once crashed transactions are rolled back sm reflects only the effects of
+// committed transactions. The versions of keys are set only by the updates of
+// committed transactions. If handler is not nil, it is consulted for UNDO
+// entries whose OldValue does not match the value in sm, and an error is
+// returned if it decides to abort recovery.
+func redoLogEntries(entries []*pb.LogEntry, analysis map[TransactionID]*transactionAnalysis, sm storeMap, handler RecoveryConflictHandler) error {
+	for _, e := range entries {
+		ta := analysis[TransactionID(e.GetTid())]
+		if ta != nil && ta.discarded() {
+			continue
+		}
+		switch e.GetEntryType() {
+		case pb.LogEntry_UPDATE:
+			k := Key(e.GetKey())
+			sm.apply(k, Value(CopyByteArray(e.NewValue)), e.NewMeta)
+			if smv, ok := sm[k]; ok && ta != nil && ta.status == statusCommitted {
+				if e.Version != nil {
+					smv.version = e.GetVersion()
+				} else {
+					// Logged before versions were recorded
+					smv.version++
+				}
+			}
+		case pb.LogEntry_UNDO:
+			if handler != nil {
+				var current Value
+				if smv, ok := sm[Key(e.GetKey())]; ok {
+					current = smv.value
+				}
+				if !bytes.Equal(current, e.OldValue) {
+					switch handler(e, CopyByteArray(current)) {
+					case RecoverySkip:
+						continue
+					case RecoveryAbort:
+						return fmt.Errorf("%w: UNDO entry with LSN %d expected %q, found %q for key %q",
+							ErrRecoveryConflict, e.GetLsn(), e.OldValue, current, e.GetKey())
+					}
+				}
+			}
+			sm.apply(Key(e.GetKey()), Value(CopyByteArray(e.NewValue)), e.NewMeta)
+		}
+	}
+	return nil
+}
+
+// pendingUpdates returns the UPDATE entries of each transaction in tids that
+// have not been undone by an UNDO entry, in the order they were written.
+func pendingUpdates(entries []*pb.LogEntry, tids map[TransactionID]struct{}) map[TransactionID][]*pb.LogEntry {
+	undoneLSNs := make(map[int64]struct{})
+	for _, e := range entries {
+		if _, ok := tids[TransactionID(e.GetTid())]; ok && e.GetEntryType() == pb.LogEntry_UNDO {
+			undoneLSNs[e.GetUndoLsn()] = struct{}{}
+		}
+	}
+
+	updates := make(map[TransactionID][]*pb.LogEntry)
+	for _, e := range entries {
+		tid := TransactionID(e.GetTid())
+		if _, ok := tids[tid]; !ok || e.GetEntryType() != pb.LogEntry_UPDATE {
+			continue
+		}
+		if _, ok := undoneLSNs[e.GetLsn()]; !ok {
+			updates[tid] = append(updates[tid], e)
+		}
+	}
+	return updates
+}
+
+// replayLogEntries reconstructs the committed state of the store described by
+// the entries of a log. Transactions that were neither committed nor ended,
+// including in-doubt prepared transactions, are rolled back (bulk loads by not
+// replaying their updates).
+func replayLogEntries(entries []*pb.LogEntry) storeMap {
+	sm := make(storeMap)
+	analysis := analyzeLogEntries(entries)
+	redoLogEntries(entries, analysis, sm, nil)
+	crashed := make(map[TransactionID]struct{})
+	for tid, ta := range analysis {
+		if (ta.crashed() || ta.inDoubt()) && !ta.bulkLoad {
+			crashed[tid] = struct{}{}
+		}
+	}
+	for _, updates := range pendingUpdates(entries, crashed) {
+		for i := len(updates) - 1; i >= 0; i-- {
+			sm.apply(Key(updates[i].GetKey()), Value(CopyByteArray(updates[i].OldValue)), updates[i].OldMeta)
+		}
+	}
+	return sm
+}
+
+// ReplayLog reads a marshalled log from r and reconstructs the state of the
+// store that it describes. The data may be the contents of a single log file,
+// or of several consecutive log files concatenated together. Transactions that
+// were neither committed nor ended are rolled back, as they would be during
+// recovery. In-doubt prepared transactions are rolled back too, since their
+// outcome is not known. It returns the entries of the log, including the time
+// at which each was added when it was recorded, and the final committed value
+// of each key. Compressed log files (see WithLogCompression) are
+// decompressed, as long as they precede any uncompressed ones.
+func ReplayLog(r io.Reader) ([]*pb.LogEntry, map[Key]Value, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read log: %v", err)
+	}
+	if data, err = decompressLogData(data); err != nil {
+		return nil, nil, fmt.Errorf("could not read log: %v", err)
+	}
+	var log pb.Log
+	if err := proto.Unmarshal(data, &log); err != nil {
+		return nil, nil, fmt.Errorf("could not unmarshal log: %v", err)
+	}
+	if err := deltaDecodeLogEntries(log.Entry); err != nil {
+		return nil, nil, fmt.Errorf("could not read log: %v", err)
+	}
+
+	sm := replayLogEntries(log.Entry)
+	values := make(map[Key]Value, len(sm))
+	for k, smv := range sm {
+		values[k] = smv.value
+	}
+	return log.Entry, values, nil
+}
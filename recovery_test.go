@@ -0,0 +1,391 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newLogEntries numbers entries with consecutive LSNs and returns them.
+func newLogEntries(entries ...*pb.LogEntry) []*pb.LogEntry {
+	for i, e := range entries {
+		e.Lsn = proto.Int64(int64(i))
+	}
+	return entries
+}
+
+func newTestLogEntry(tid int64, entryType pb.LogEntry_LogEntryType) *pb.LogEntry {
+	return &pb.LogEntry{
+		Tid:       proto.Int64(tid),
+		EntryType: entryType.Enum(),
+	}
+}
+
+func newTestUpdateLogEntry(tid int64, entryType pb.LogEntry_LogEntryType, k Key, oldValue, newValue Value) *pb.LogEntry {
+	e := newTestLogEntry(tid, entryType)
+	e.Key = proto.String(string(k))
+	e.OldValue = CopyByteArray(oldValue)
+	e.NewValue = CopyByteArray(newValue)
+	return e
+}
+
+// sampleRecoveryLog contains a committed, an aborted, a committed (delete), a
+// crashed and a committed (but not ended) transaction.
+func sampleRecoveryLog() []*pb.LogEntry {
+	return newLogEntries(
+		newTestLogEntry(1, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey1, nil, sampleValue1),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey2, nil, sampleValue2),
+		newTestLogEntry(1, pb.LogEntry_COMMIT),
+		newTestLogEntry(1, pb.LogEntry_END),
+		newTestLogEntry(2, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(2, pb.LogEntry_UPDATE, sampleKey1, sampleValue1, sampleValue3),
+		newTestLogEntry(2, pb.LogEntry_ABORT),
+		newTestUpdateLogEntry(2, pb.LogEntry_UNDO, sampleKey1, sampleValue3, sampleValue1),
+		newTestLogEntry(2, pb.LogEntry_END),
+		newTestLogEntry(3, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(3, pb.LogEntry_UPDATE, sampleKey2, sampleValue2, nil),
+		newTestLogEntry(3, pb.LogEntry_COMMIT),
+		newTestLogEntry(3, pb.LogEntry_END),
+		newTestLogEntry(4, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(4, pb.LogEntry_UPDATE, sampleKey3, nil, sampleValue3),
+		newTestLogEntry(5, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(5, pb.LogEntry_UPDATE, sampleKey4, nil, sampleValue2),
+		newTestLogEntry(5, pb.LogEntry_COMMIT),
+		newTestUpdateLogEntry(4, pb.LogEntry_UPDATE, sampleKey1, sampleValue1, sampleValue2),
+	)
+}
+
+func mustMarshalLog(t *testing.T, entries []*pb.LogEntry) []byte {
+	data, err := proto.Marshal(&pb.Log{Entry: entries})
+	if err != nil {
+		t.Fatalf("could not marshal log: %v", err)
+	}
+	return data
+}
+
+// writeLogFileForTest writes entries out as a log file in dir.
+func writeLogFileForTest(t *testing.T, dir string, entries []*pb.LogEntry) {
+	data := mustMarshalLog(t, entries)
+	filename := fmt.Sprintf(logFileFmt, entries[0].GetLsn(), entries[len(entries)-1].GetLsn())
+	if err := ioutil.WriteFile(filepath.Join(dir, filename), data, 0644); err != nil {
+		t.Fatalf("could not write log file: %v", err)
+	}
+}
+
+func TestReplayLog(t *testing.T) {
+	entries := sampleRecoveryLog()
+	wantStore := map[Key]Value{
+		sampleKey1: sampleValue1,
+		sampleKey4: sampleValue2,
+	}
+
+	// Marshal the log as two consecutive log files, either of which may be
+	// compressed (as long as the compressed files come first).
+	for _, compressed := range [][]bool{{false, false}, {true, false}, {true, true}} {
+		var data []byte
+		for i, part := range [][]*pb.LogEntry{entries[:7], entries[7:]} {
+			d := mustMarshalLog(t, part)
+			if compressed[i] {
+				var err error
+				if d, err = compressLogData(d); err != nil {
+					t.Fatalf("got an error while compressing log data: %v", err)
+				}
+			}
+			data = append(data, d...)
+		}
+
+		gotEntries, gotStore, err := ReplayLog(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("got an error while replaying log (compressed=%v): %v", compressed, err)
+		}
+		if len(gotEntries) != len(entries) {
+			t.Errorf("did not get expected number of entries (compressed=%v). expected=%d, actual=%d", compressed, len(entries), len(gotEntries))
+		} else {
+			for i := range entries {
+				if !proto.Equal(gotEntries[i], entries[i]) {
+					t.Errorf("did not get the expected log entry. expected=(%+v), actual=(%+v)", entries[i], gotEntries[i])
+				}
+			}
+		}
+		if !reflect.DeepEqual(gotStore, wantStore) {
+			t.Errorf("did not get the expected store (compressed=%v). expected=%v, actual=%v", compressed, wantStore, gotStore)
+		}
+	}
+
+	if _, _, err := ReplayLog(bytes.NewReader([]byte{0xff, 0xff})); err == nil {
+		t.Error("did not get an error while replaying malformed log.")
+	}
+}
+
+func TestAnalyzeLogEntries(t *testing.T) {
+	wantAnalysis := map[TransactionID]transactionAnalysis{
+		1: {status: statusCommitted, ended: true},
+		2: {status: statusAborted, ended: true},
+		3: {status: statusCommitted, ended: true},
+		4: {status: statusActive, ended: false},
+		5: {status: statusCommitted, ended: false},
+	}
+	wantCrashed := map[TransactionID]bool{4: true}
+
+	gotAnalysis := analyzeLogEntries(sampleRecoveryLog())
+	if len(gotAnalysis) != len(wantAnalysis) {
+		t.Errorf("did not get expected number of transactions. expected=%d, actual=%d", len(wantAnalysis), len(gotAnalysis))
+	}
+	for tid, want := range wantAnalysis {
+		got, ok := gotAnalysis[tid]
+		if !ok {
+			t.Errorf("did not find transaction %d in analysis.", tid)
+			continue
+		}
+		if *got != want {
+			t.Errorf("did not get expected analysis for transaction %d. expected=%+v, actual=%+v", tid, want, *got)
+		}
+		if got.crashed() != wantCrashed[tid] {
+			t.Errorf("did not get expected crashed status for transaction %d. expected=%t", tid, wantCrashed[tid])
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "recovery_")
+	if err != nil {
+		t.Fatalf("could not create log directory: %v", err)
+	}
+	entries := sampleRecoveryLog()
+	writeLogFileForTest(t, dir, entries)
+
+	lm, err := newLogManager(dir)
+	if err != nil {
+		t.Fatalf("could not create log manager instance: %v", err)
+	}
+	// Check storeMap
+	wantStore := map[Key]Value{
+		sampleKey1: sampleValue1,
+		sampleKey4: sampleValue2,
+	}
+	if lm.numKeys != len(wantStore) {
+		t.Errorf("did not get expected number of keys. expected=%d, actual=%d", len(wantStore), lm.numKeys)
+	}
+	for k, v := range wantStore {
+		if smv, ok := lm.store[k]; !ok {
+			t.Errorf("did not find value for key='%s' in storeMap.", k)
+		} else if !bytes.Equal(smv.value, v) {
+			t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, v, smv.value)
+		}
+	}
+	// Check log: crashed transaction 4 is aborted and committed transaction 5 is ended
+	wantNewEntries := map[TransactionID][]pb.LogEntry_LogEntryType{
+		4: {pb.LogEntry_ABORT, pb.LogEntry_UNDO, pb.LogEntry_UNDO, pb.LogEntry_END},
+		5: {pb.LogEntry_END},
+	}
+	gotNewEntries := make(map[TransactionID][]pb.LogEntry_LogEntryType)
+	for _, e := range lm.log.Entry[len(entries):] {
+		tid := TransactionID(e.GetTid())
+		gotNewEntries[tid] = append(gotNewEntries[tid], e.GetEntryType())
+	}
+	if !reflect.DeepEqual(gotNewEntries, wantNewEntries) {
+		t.Errorf("did not get expected recovery log entries. expected=%v, actual=%v", wantNewEntries, gotNewEntries)
+	}
+	if lm.nextLSNToFlush != lm.nextLSN {
+		t.Error("found that log was not flushed.")
+	}
+	if len(lm.currMutexes) != 0 {
+		t.Errorf("found %d running transactions after recovery.", len(lm.currMutexes))
+	}
+
+	// Recovering again does not change anything
+	abandonDirForTest(dir)
+	lm2, err := newLogManager(dir)
+	if err != nil {
+		t.Fatalf("could not create log manager instance: %v", err)
+	}
+	if lm2.nextLSN != lm.nextLSN {
+		t.Errorf("found that recovery wrote new log entries. expected nextLSN=%d, actual=%d", lm.nextLSN, lm2.nextLSN)
+	}
+}
+
+func TestRecoveryResumesAbort(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "recovery_")
+	if err != nil {
+		t.Fatalf("could not create log directory: %v", err)
+	}
+	// Transaction 2 crashed after undoing its second update.
+	entries := newLogEntries(
+		newTestLogEntry(1, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey1, nil, sampleValue1),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey2, nil, sampleValue2),
+		newTestLogEntry(1, pb.LogEntry_COMMIT),
+		newTestLogEntry(1, pb.LogEntry_END),
+		newTestLogEntry(2, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(2, pb.LogEntry_UPDATE, sampleKey1, sampleValue1, sampleValue2),
+		newTestUpdateLogEntry(2, pb.LogEntry_UPDATE, sampleKey2, sampleValue2, sampleValue3),
+		newTestLogEntry(2, pb.LogEntry_ABORT),
+		newTestUpdateLogEntry(2, pb.LogEntry_UNDO, sampleKey2, sampleValue3, sampleValue2),
+	)
+	entries[len(entries)-1].UndoLsn = proto.Int64(7)
+	writeLogFileForTest(t, dir, entries)
+
+	wantStore := map[Key]Value{
+		sampleKey1: sampleValue1,
+		sampleKey2: sampleValue2,
+	}
+	if _, gotStore, err := ReplayLog(bytes.NewReader(mustMarshalLog(t, entries))); err != nil {
+		t.Errorf("got an error while replaying log: %v", err)
+	} else if !reflect.DeepEqual(gotStore, wantStore) {
+		t.Errorf("did not get the expected store. expected=%v, actual=%v", wantStore, gotStore)
+	}
+
+	for i := 0; i < 2; i++ {
+		abandonDirForTest(dir)
+		lm, err := newLogManager(dir)
+		if err != nil {
+			t.Fatalf("could not create log manager instance: %v", err)
+		}
+		for k, v := range wantStore {
+			if smv, ok := lm.store[k]; !ok {
+				t.Errorf("did not find value for key='%s' in storeMap.", k)
+			} else if !bytes.Equal(smv.value, v) {
+				t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, v, smv.value)
+			}
+		}
+		// Only the first update is undone, and the abort is completed
+		wantNewEntries := []*pb.LogEntry{
+			newTestUpdateLogEntry(2, pb.LogEntry_UNDO, sampleKey1, sampleValue2, sampleValue1),
+			newTestLogEntry(2, pb.LogEntry_END),
+		}
+		wantNewEntries[0].Lsn = proto.Int64(int64(len(entries)))
+		wantNewEntries[0].UndoLsn = proto.Int64(6)
+		wantNewEntries[1].Lsn = proto.Int64(int64(len(entries) + 1))
+		gotNewEntries := lm.log.Entry[len(entries):]
+		if len(gotNewEntries) != len(wantNewEntries) {
+			t.Errorf("did not get expected number of recovery log entries. expected=%d, actual=%d", len(wantNewEntries), len(gotNewEntries))
+			continue
+		}
+		for j := range wantNewEntries {
+			testLogEntry(t, gotNewEntries[j], wantNewEntries[j])
+		}
+	}
+}
+
+func TestRecoveryConflictHandler(t *testing.T) {
+	// Transaction 2 crashed while aborting, after an UNDO entry whose
+	// OldValue does not match the value set by its update.
+	entries := newLogEntries(
+		newTestLogEntry(1, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey1, nil, sampleValue1),
+		newTestLogEntry(1, pb.LogEntry_COMMIT),
+		newTestLogEntry(1, pb.LogEntry_END),
+		newTestLogEntry(2, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(2, pb.LogEntry_UPDATE, sampleKey1, sampleValue1, sampleValue2),
+		newTestLogEntry(2, pb.LogEntry_ABORT),
+		newTestUpdateLogEntry(2, pb.LogEntry_UNDO, sampleKey1, sampleValue3, sampleValue1),
+	)
+	entries[len(entries)-1].UndoLsn = proto.Int64(5)
+
+	testCases := []struct {
+		handler   bool // whether a handler returning decision is set
+		decision  RecoveryDecision
+		wantErr   bool
+		wantValue Value
+	}{
+		{false, 0, true, nil}, // the default handler aborts recovery
+		{true, RecoveryAbort, true, nil},
+		{true, RecoverySkip, false, sampleValue2},
+		{true, RecoveryAccept, false, sampleValue1},
+	}
+	for i, tc := range testCases {
+		dir, err := ioutil.TempDir(testLogDir, "recovery_")
+		if err != nil {
+			t.Fatalf("could not create log directory: %v", err)
+		}
+		writeLogFileForTest(t, dir, entries)
+
+		var opts []Option
+		var calls int
+		if tc.handler {
+			decision := tc.decision
+			opts = append(opts, WithRecoveryConflictHandler(func(e *pb.LogEntry, current Value) RecoveryDecision {
+				calls++
+				if e.GetLsn() != 7 || !bytes.Equal(current, sampleValue2) {
+					t.Errorf("did not get expected conflict. expected=(%d %v), actual=(%d %v)", 7, sampleValue2, e.GetLsn(), current)
+				}
+				return decision
+			}))
+		}
+		s, err := NewStore(dir, opts...)
+		if tc.wantErr {
+			if !errors.Is(err, ErrRecoveryConflict) {
+				t.Errorf("did not get expected error for case %d. expected=%v, actual=%v", i, ErrRecoveryConflict, err)
+			}
+		} else if err != nil {
+			t.Errorf("got an error for case %d while opening store: %v", i, err)
+		} else {
+			checkStoreValue(t, s, sampleKey1, tc.wantValue)
+		}
+		if tc.handler && calls != 1 {
+			t.Errorf("did not get expected number of calls to handler for case %d. expected=%d, actual=%d", i, 1, calls)
+		}
+	}
+}
+
+func TestRecoveryInfo(t *testing.T) {
+	dir, err := ioutil.TempDir(testLogDir, "recovery_")
+	if err != nil {
+		t.Fatalf("could not create log directory: %v", err)
+	}
+	// Transaction 1 committed but did not end, and transaction 2 crashed.
+	entries := newLogEntries(
+		newTestLogEntry(1, pb.LogEntry_BEGIN),
+		newTestUpdateLogEntry(1, pb.LogEntry_UPDATE, sampleKey1, nil, sampleValue1),
+		newTestLogEntry(2, pb.LogEntry_BEGIN),
+		newTestLogEntry(1, pb.LogEntry_COMMIT),
+		newTestUpdateLogEntry(2, pb.LogEntry_UPDATE, sampleKey2, nil, sampleValue2),
+	)
+	writeLogFileForTest(t, dir, entries[:2])
+	writeLogFileForTest(t, dir, entries[2:])
+
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	// END for transaction 1, and ABORT, UNDO and END for transaction 2
+	wantInfo := RecoveryInfo{
+		Segments:      2,
+		Entries:       len(entries),
+		RolledForward: []TransactionID{1},
+		RolledBack:    []TransactionID{2},
+		LastLSN:       int64(len(entries) + 3),
+	}
+	if info := s.RecoveryInfo(); !reflect.DeepEqual(info, wantInfo) {
+		t.Errorf("did not get expected recovery info. expected=%+v, actual=%+v", wantInfo, info)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	s.Close()
+
+	// Nothing is recovered once the store has been closed
+	s, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	info := s.RecoveryInfo()
+	if info.LastLSN != int64(info.Entries)-1 || info.RolledForward != nil || info.RolledBack != nil || info.InDoubt != nil {
+		t.Errorf("did not get expected recovery info after store was closed. actual=%+v", info)
+	}
+	s.Close()
+
+	// An in-memory store recovers nothing
+	s, err = NewStore(dir, WithInMemory(true))
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	if info, wantInfo := s.RecoveryInfo(), (RecoveryInfo{LastLSN: -1}); !reflect.DeepEqual(info, wantInfo) {
+		t.Errorf("did not get expected recovery info for in-memory store. expected=%+v, actual=%+v", wantInfo, info)
+	}
+}
@@ -0,0 +1,199 @@
+package gostore
+
+import (
+	"fmt"
+	pb "github.com/mDibyo/gostore/pb"
+	"sync"
+)
+
+// Replica is a read-only copy of a store that is kept up to date by tailing
+// the log of the store (its primary). It applies the effects of each
+// committed transaction atomically, once the COMMIT entry of the transaction
+// has been flushed on the primary, and serves reads from the replicated state.
+// Writes are rejected with ErrReadOnlyReplica.
+type Replica struct {
+	primary *logManager
+
+	lock   sync.RWMutex
+	values map[Key]Value
+	lsn    int64 // the LSN of the COMMIT entry of the last transaction applied
+	// the number of times the log of the primary had been compacted when
+	// the last transaction applied was delivered
+	compactions int
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewReplica starts a replica of primary, which replays the log of primary
+// from the beginning and then follows it until either is closed.
+func NewReplica(primary *Store) *Replica {
+	r := &Replica{
+		primary: primary.lm,
+		values:  make(map[Key]Value),
+		lsn:     -1,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if !primary.lm.maintenance.start(r.replicate) {
+		// The primary has been closed.
+		close(r.stopped)
+	}
+	return r
+}
+
+// replicate applies the transactions delivered by a tail of the log of the
+// primary until the replica is closed, or stopping is closed (when the
+// primary is closed). When the log of the primary is
+// compacted, the tail is closed (since the entries are replaced), and
+// the log is replayed again from the beginning. The first transaction in a
+// compacted log sets the committed value of every key, so the replicated state
+// is replaced by it rather than being cleared while the log is replayed.
+func (r *Replica) replicate(stopping <-chan struct{}) {
+	defer close(r.stopped)
+	for {
+		c, cancel, compactions := r.primary.tailLogWithCompactions(0)
+		replace := true
+		var updates []*pb.LogEntry
+		for {
+			var e pb.LogEntry
+			var ok bool
+			select {
+			case e, ok = <-c:
+			case <-r.stop:
+				cancel()
+				return
+			case <-stopping:
+				cancel()
+				return
+			}
+			if !ok {
+				break
+			}
+			if e.GetEntryType() == pb.LogEntry_UPDATE {
+				updates = append(updates, &e)
+				continue
+			}
+			r.apply(updates, e.GetLsn(), compactions, replace)
+			replace = false
+			updates = nil
+		}
+		cancel()
+		select {
+		case <-stopping:
+			return
+		default:
+		}
+	}
+}
+
+// apply applies the updates of a committed transaction whose COMMIT entry has
+// LSN lsn, in the log as of the given number of compactions. If replace is
+// set, the replicated state is replaced by the effects of the transaction.
+func (r *Replica) apply(updates []*pb.LogEntry, lsn int64, compactions int, replace bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if replace {
+		r.values = make(map[Key]Value)
+	}
+	for _, e := range updates {
+		if e.NewValue == nil {
+			delete(r.values, Key(e.GetKey()))
+		} else {
+			r.values[Key(e.GetKey())] = Value(e.NewValue)
+		}
+	}
+	r.lsn = lsn
+	r.compactions = compactions
+}
+
+// Close stops the replica from following the log of its primary (if the
+// primary has not been closed already). The
+// replicated state can still be read once the replica is closed.
+func (r *Replica) Close() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	<-r.stopped
+}
+
+// Get retrieves the replicated value of a key.
+func (r *Replica) Get(k Key) (Value, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	v, ok := r.values[k]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, k)
+	}
+	return Value(CopyByteArray(v)), nil
+}
+
+// Set returns ErrReadOnlyReplica, since replicas can not be written to.
+func (r *Replica) Set(k Key, v Value) error {
+	return ErrReadOnlyReplica
+}
+
+// Delete returns ErrReadOnlyReplica, since replicas can not be written to.
+func (r *Replica) Delete(k Key) error {
+	return ErrReadOnlyReplica
+}
+
+// LSN returns the LSN (in the log of the primary) of the COMMIT entry of the
+// last transaction applied to the replica, or -1 if none has been applied.
+func (r *Replica) LSN() int64 {
+	lsn, _ := r.position()
+	return lsn
+}
+
+// position returns the LSN of the last transaction applied to the replica,
+// and the number of compactions of the log to which it refers.
+func (r *Replica) position() (int64, int) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.lsn, r.compactions
+}
+
+// Lag returns the number of LSNs by which the replica lags behind its
+// primary: the difference between the LSN of the COMMIT entry of the last
+// committed transaction flushed on the primary and that of the last
+// transaction applied to the replica. It is 0 once the replica has caught up.
+// LSNs are not reused when the log is compacted, so the lag is measured across
+// compactions.
+func (r *Replica) Lag() int64 {
+	lsn, compactions := r.position()
+	last, _ := r.primary.lastCommitLSN(lsn, compactions)
+	if last > lsn {
+		return last - lsn
+	}
+	return 0
+}
+
+// lastCommitLSN returns the LSN of the COMMIT entry of the last committed
+// transaction in the flushed part of the log, and the number of times the log
+// has been compacted. If the log has not been compacted since the given
+// number of compactions, it looks back no further than the entry after LSN
+// after, and returns after if there is no such entry.
+func (lm *logManager) lastCommitLSN(after int64, compactions int) (int64, int) {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	if lm.compactions != compactions {
+		after = -1
+	}
+	// The COMMIT entry of a transaction that could not be flushed is followed
+	// by an ABORT entry.
+	aborted := make(map[int64]struct{})
+	for lsn := int64(lm.nextLSNToFlush) - 1; lsn > after && lsn >= int64(lm.firstLSN); lsn-- {
+		e := lm.logEntry(lsn)
+		switch e.GetEntryType() {
+		case pb.LogEntry_ABORT:
+			aborted[e.GetTid()] = struct{}{}
+		case pb.LogEntry_COMMIT:
+			if _, ok := aborted[e.GetTid()]; !ok {
+				return lsn, lm.compactions
+			}
+		}
+	}
+	return after, lm.compactions
+}
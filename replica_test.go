@@ -0,0 +1,98 @@
+package gostore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForReplicaForTest waits until a replica has caught up with its primary,
+// failing the test if it does not in time.
+func waitForReplicaForTest(t *testing.T, r *Replica) {
+	deadline := time.Now().Add(time.Second)
+	for r.LSN() < 0 || r.Lag() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("found that replica did not catch up. lsn=%d, lag=%d", r.LSN(), r.Lag())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// checkReplicaValueForTest checks the value of key k in a replica. A nil v
+// means that the key should not exist.
+func checkReplicaValueForTest(t *testing.T, r *Replica, k Key, v Value) {
+	gotValue, err := r.Get(k)
+	if v == nil {
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("did not get expected error for key='%s'. expected=%v, actual=%v", k, ErrKeyNotFound, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", k, err)
+	} else if string(gotValue) != string(v) {
+		t.Errorf("did not get expected value for key='%s'. expected=%q, actual=%q", k, v, gotValue)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+
+	r := NewReplica(s)
+	defer r.Close()
+	waitForReplicaForTest(t, r)
+	checkReplicaValueForTest(t, r, sampleKey1, sampleValue1)
+	checkReplicaValueForTest(t, r, sampleKey2, sampleValue2)
+
+	// Committed transactions are replicated, others are not
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	runningTID := s.BeginTransaction()
+	if err := s.Set(runningTID, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	waitForReplicaForTest(t, r)
+	checkReplicaValueForTest(t, r, sampleKey1, sampleValue3)
+	checkReplicaValueForTest(t, r, sampleKey2, nil)
+	checkReplicaValueForTest(t, r, sampleKey3, nil)
+
+	// The replica follows the log through compactions
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	if err := s.Commit(runningTID); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	setForTest(t, s, sampleKey4, sampleValue2)
+	waitForReplicaForTest(t, r)
+	checkReplicaValueForTest(t, r, sampleKey1, sampleValue3)
+	checkReplicaValueForTest(t, r, sampleKey2, nil)
+	checkReplicaValueForTest(t, r, sampleKey3, sampleValue3)
+	checkReplicaValueForTest(t, r, sampleKey4, sampleValue2)
+
+	// Writes are rejected
+	if err := r.Set(sampleKey1, sampleValue1); !errors.Is(err, ErrReadOnlyReplica) {
+		t.Errorf("did not get expected error while setting value in replica. expected=%v, actual=%v", ErrReadOnlyReplica, err)
+	}
+	if err := r.Delete(sampleKey1); !errors.Is(err, ErrReadOnlyReplica) {
+		t.Errorf("did not get expected error while deleting key in replica. expected=%v, actual=%v", ErrReadOnlyReplica, err)
+	}
+
+	// The replicated state can be read once the replica is closed
+	r.Close()
+	setForTest(t, s, sampleKey1, sampleValue1)
+	checkReplicaValueForTest(t, r, sampleKey1, sampleValue3)
+	if lag := r.Lag(); lag <= 0 {
+		t.Errorf("found that closed replica did not lag behind primary. lag=%d", lag)
+	}
+}
@@ -0,0 +1,58 @@
+package gostore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy configures how Store.Transact retries a transaction that fails
+// with a retryable error (ErrDeadlock or ErrTimeout), or how writing a log file
+// is retried (see WithFlushRetry).
+type RetryPolicy struct {
+	MaxAttempts  int           // the maximum number of times the transaction is run (or the log file written); once if not positive
+	InitialDelay time.Duration // the delay before the first retry
+	MaxDelay     time.Duration // the maximum delay before a retry, if positive
+	Multiplier   float64       // the factor by which the delay grows after each retry; 2 if less than 1
+}
+
+// delay returns the delay before retry n (starting from 0).
+func (p RetryPolicy) delay(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(p.InitialDelay)
+	for i := 0; i < n; i++ {
+		d *= multiplier
+		if p.MaxDelay > 0 && d >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	return time.Duration(d)
+}
+
+// isRetryable returns whether a transaction that failed with err may succeed
+// if it is retried.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrDeadlock) || errors.Is(err, ErrTimeout)
+}
+
+// Transact runs fn in a new transaction on Store, as WithTransaction does. If
+// the transaction fails with a retryable error (ErrDeadlock or ErrTimeout), it
+// is aborted and fn is run again in a new transaction, after a delay that grows
+// exponentially, until it succeeds, fails with an error that is not retryable,
+// or has been run policy.MaxAttempts times. The error of the last attempt is
+// returned.
+func (s *Store) Transact(fn func(txn *Txn) error, policy RetryPolicy) error {
+	for attempt := 1; ; attempt++ {
+		err := s.WithTransaction(fn)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			return fmt.Errorf("transaction failed after %d attempts: %w", attempt, err)
+		}
+		time.Sleep(policy.delay(attempt - 1))
+	}
+}
@@ -0,0 +1,83 @@
+package gostore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	testCases := []struct {
+		policy RetryPolicy
+		delays []time.Duration
+	}{
+		{RetryPolicy{InitialDelay: time.Millisecond}, []time.Duration{1, 2, 4, 8}},
+		{RetryPolicy{InitialDelay: time.Millisecond, Multiplier: 3}, []time.Duration{1, 3, 9, 27}},
+		{RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}, []time.Duration{1, 2, 4, 5}},
+	}
+	for _, tc := range testCases {
+		for n, want := range tc.delays {
+			if got := tc.policy.delay(n); got != want*time.Millisecond {
+				t.Errorf("did not get expected delay for retry %d of %+v. expected=%v, actual=%v", n, tc.policy, want*time.Millisecond, got)
+			}
+		}
+	}
+}
+
+func TestTransact(t *testing.T) {
+	s := newStoreForTest(t)
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: 10 * time.Millisecond}
+
+	// The first attempt deadlocks and the retry succeeds
+	attempts := 0
+	start := time.Now()
+	if err := s.Transact(func(txn *Txn) error {
+		attempts++
+		if err := txn.Set(sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return ErrDeadlock
+		}
+		return nil
+	}, policy); err != nil {
+		t.Errorf("got an error while running transaction: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("did not get expected number of attempts. expected=%d, actual=%d", 2, attempts)
+	}
+	if elapsed := time.Since(start); elapsed < policy.InitialDelay {
+		t.Errorf("found that transaction was retried without delay. elapsed=%v", elapsed)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Every attempt times out
+	attempts = 0
+	err := s.Transact(func(txn *Txn) error {
+		attempts++
+		if err := txn.Set(sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+			return err
+		}
+		return ErrTimeout
+	}, policy)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("did not get expected error. expected=%v, actual=%v", ErrTimeout, err)
+	}
+	if attempts != policy.MaxAttempts {
+		t.Errorf("did not get expected number of attempts. expected=%d, actual=%d", policy.MaxAttempts, attempts)
+	}
+	checkStoreValue(t, s, sampleKey2, nil)
+
+	// Errors that are not retryable are not retried
+	attempts = 0
+	errFn := errors.New("fn failed")
+	if err := s.Transact(func(txn *Txn) error {
+		attempts++
+		return errFn
+	}, policy); err != errFn {
+		t.Errorf("did not get expected error. expected=%v, actual=%v", errFn, err)
+	}
+	if attempts != 1 {
+		t.Errorf("did not get expected number of attempts. expected=%d, actual=%d", 1, attempts)
+	}
+}
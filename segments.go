@@ -0,0 +1,136 @@
+package gostore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SegmentInfo describes a log file (segment) in the log directory of a store.
+type SegmentInfo struct {
+	Name     string    // the path of the log file, relative to the log directory
+	StartLSN int       // the LSN of the first entry in the log file
+	EndLSN   int       // the LSN of the last entry in the log file
+	Size     int64     // the size of the log file in bytes
+	ModTime  time.Time // the modification time of the log file
+}
+
+// logShardFmt is the format of the names of the subdirectories of the log
+// directory in which log files are stored, if they are sharded. A shard is
+// named after the first LSN of the range of LSNs it covers.
+var logShardFmt = "%012d"
+
+// parseLogFileName returns the LSNs of the first and last entries in the log
+// file with the given name, and whether the name is that of a log file.
+func parseLogFileName(name string) (startLSN, endLSN int, ok bool) {
+	return parseLogFileNameFmt(name, logFileFmt)
+}
+
+// parseLogFileNameFmt is like parseLogFileName, for log file names in the
+// given format.
+func parseLogFileNameFmt(name, format string) (startLSN, endLSN int, ok bool) {
+	if _, err := fmt.Sscanf(name, format, &startLSN, &endLSN); err != nil {
+		return 0, 0, false
+	}
+	// Sscanf ignores anything following the format.
+	if name != fmt.Sprintf(format, startLSN, endLSN) {
+		return 0, 0, false
+	}
+	return startLSN, endLSN, true
+}
+
+// validLogFileFmt returns whether format can be used to name log files: it
+// must produce file names from which the LSNs can be parsed back.
+func validLogFileFmt(format string) bool {
+	name := fmt.Sprintf(format, 12, 345)
+	if filepath.Base(name) != name || name == "." {
+		return false
+	}
+	startLSN, endLSN, ok := parseLogFileNameFmt(name, format)
+	return ok && startLSN == 12 && endLSN == 345
+}
+
+// logFilePath returns the path, relative to the log directory, of the log file
+// holding the entries with LSNs from startLSN to endLSN.
+func (lm *logManager) logFilePath(startLSN, endLSN int) string {
+	name := fmt.Sprintf(lm.config.logFileFmt, startLSN, endLSN)
+	if n := lm.config.shardSize; n > 0 {
+		return filepath.Join(fmt.Sprintf(logShardFmt, startLSN/n*n), name)
+	}
+	return name
+}
+
+// logFile is a log file in the log directory.
+type logFile struct {
+	path     string // relative to the log directory
+	startLSN int
+	endLSN   int
+	info     os.FileInfo
+}
+
+// isLogShardName returns whether name is the name of a shard directory.
+func isLogShardName(name string) bool {
+	var lsn int
+	if _, err := fmt.Sscanf(name, logShardFmt, &lsn); err != nil {
+		return false
+	}
+	return name == fmt.Sprintf(logShardFmt, lsn)
+}
+
+// logFiles returns the log files in the log directory and its shard
+// directories, in order of LSN. Shard directories are searched regardless of
+// whether log files are sharded, so that the log is found if sharding is
+// changed.
+func (lm *logManager) logFiles() ([]logFile, error) {
+	var files []logFile
+	err := filepath.Walk(lm.logDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != lm.logDir && (filepath.Dir(path) != filepath.Clean(lm.logDir) || !isLogShardName(info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		startLSN, endLSN, ok := parseLogFileNameFmt(info.Name(), lm.config.logFileFmt)
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(lm.logDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, logFile{rel, startLSN, endLSN, info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].startLSN < files[j].startLSN })
+	return files, nil
+}
+
+// segments returns the log files in the log directory, in order of LSN.
+func (lm *logManager) segments() ([]SegmentInfo, error) {
+	if lm.config.inMemory {
+		return nil, nil
+	}
+	files, err := lm.logFiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not list log files: %v", err)
+	}
+	var segments []SegmentInfo
+	for _, file := range files {
+		segments = append(segments, SegmentInfo{
+			Name:     file.path,
+			StartLSN: file.startLSN,
+			EndLSN:   file.endLSN,
+			Size:     file.info.Size(),
+			ModTime:  file.info.ModTime(),
+		})
+	}
+	return segments, nil
+}
@@ -0,0 +1,187 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLogFileName(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		wantStartLSN int
+		wantEndLSN   int
+		wantOK       bool
+	}{
+		{"000000000000_000000000004.log", 0, 4, true},
+		{"000000000005_000000000123.log", 5, 123, true},
+		{"000000000000_000000000004.log.bak", 0, 0, false},
+		{"0_4.log", 0, 0, false},
+		{"compacted_000000000000_000000000004.log", 0, 0, false},
+		{"000000000000_000000000004.txt", 0, 0, false},
+		{"notes.txt", 0, 0, false},
+	} {
+		gotStartLSN, gotEndLSN, gotOK := parseLogFileName(test.name)
+		if gotOK != test.wantOK || gotStartLSN != test.wantStartLSN || gotEndLSN != test.wantEndLSN {
+			t.Errorf("did not parse log file name %q as expected. expected=(%d, %d, %v), actual=(%d, %d, %v)",
+				test.name, test.wantStartLSN, test.wantEndLSN, test.wantOK, gotStartLSN, gotEndLSN, gotOK)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	for _, name := range []string{"notes.txt", "000000000000_000000000003.log.bak"} {
+		if err := ioutil.WriteFile(filepath.Join(s.lm.logDir, name), []byte{1}, 0644); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(s.lm.logDir, "000000000100_000000000200.log"), 0755); err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+
+	segments, err := s.Segments()
+	if err != nil {
+		t.Fatalf("got an error while listing segments: %v", err)
+	}
+	wantLSNs := [][2]int{{0, 3}, {4, 7}}
+	if len(segments) != len(wantLSNs) {
+		t.Fatalf("did not get expected number of segments. expected=%d, actual=%d", len(wantLSNs), len(segments))
+	}
+	for i, segment := range segments {
+		if segment.StartLSN != wantLSNs[i][0] || segment.EndLSN != wantLSNs[i][1] {
+			t.Errorf("did not get expected segment LSNs. expected=%v, actual=(%d, %d)", wantLSNs[i], segment.StartLSN, segment.EndLSN)
+		}
+		info, err := os.Stat(filepath.Join(s.lm.logDir, segment.Name))
+		if err != nil {
+			t.Errorf("could not stat segment %s: %v", segment.Name, err)
+			continue
+		}
+		if segment.Size != info.Size() || !segment.ModTime.Equal(info.ModTime()) {
+			t.Errorf("did not get expected segment size and modification time. expected=(%d, %v), actual=(%d, %v)",
+				info.Size(), info.ModTime(), segment.Size, segment.ModTime)
+		}
+	}
+}
+
+func TestShardedSegments(t *testing.T) {
+	opts := []Option{WithShardedSegments(8), WithLogFileFormat("segment_%d_%d")}
+	s := newStoreForTest(t, opts...)
+	for i := 0; i < 5; i++ {
+		setForTest(t, s, sampleKey1, Value(fmt.Sprintf("%s_%d", sampleKey1, i)))
+	}
+
+	wantSegments := []string{
+		filepath.Join("000000000000", "segment_0_3"),
+		filepath.Join("000000000000", "segment_4_7"),
+		filepath.Join("000000000008", "segment_8_11"),
+		filepath.Join("000000000008", "segment_12_15"),
+		filepath.Join("000000000016", "segment_16_19"),
+	}
+	checkSegments := func(s *Store, wantSegments []string) {
+		segments, err := s.Segments()
+		if err != nil {
+			t.Fatalf("got an error while listing segments: %v", err)
+		}
+		var gotSegments []string
+		for _, segment := range segments {
+			gotSegments = append(gotSegments, segment.Name)
+		}
+		if !reflect.DeepEqual(gotSegments, wantSegments) {
+			t.Errorf("did not get expected segments. expected=%v, actual=%v", wantSegments, gotSegments)
+		}
+	}
+	checkSegments(s, wantSegments)
+
+	// Recovery finds and orders all segments, whether or not the store is
+	// reopened with sharding. (The recovered store is inspected directly, since
+	// reading through a transaction would add to the log.)
+	wantValue := Value(fmt.Sprintf("%s_%d", sampleKey1, 4))
+	for _, opts := range [][]Option{opts, opts[1:]} {
+		s := reopenStoreForTest(t, s, opts...)
+		checkSegments(s, wantSegments)
+		if gotValue := s.lm.store[sampleKey1].value; !bytes.Equal(gotValue, wantValue) {
+			t.Errorf("did not get expected value for key='%s'. expected=%v, actual=%v", sampleKey1, wantValue, gotValue)
+		}
+	}
+
+	// Compaction replaces the shards, numbering the compacted log on from the
+	// LSNs of the log it replaces
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	wantSegments = []string{filepath.Join("000000000016", "segment_20_23")}
+	checkSegments(s, wantSegments)
+	if _, err := os.Stat(filepath.Join(s.lm.logDir, "000000000008")); !os.IsNotExist(err) {
+		t.Errorf("found that empty shard directory was not removed. err=%v", err)
+	}
+	checkStoreValue(t, reopenStoreForTest(t, s, opts...), sampleKey1, wantValue)
+}
+
+func TestInvalidLogFileFormat(t *testing.T) {
+	for _, format := range []string{"segment.log", "%d.log", "%d/%d.log", "%s_%s"} {
+		dir, err := ioutil.TempDir(testLogDir, "store_")
+		if err != nil {
+			t.Fatalf("could not create log directory for store: %v", err)
+		}
+		if _, err := NewStore(dir, WithLogFileFormat(format)); err == nil {
+			t.Errorf("did not get an error while opening store with log file format %q.", format)
+		}
+	}
+}
+
+func TestMaxSegmentSize(t *testing.T) {
+	maxSize := int64(256)
+	s := newStoreForTest(t, WithMaxSegmentSize(maxSize))
+	tid := s.BeginTransaction()
+	wantStore := make(map[Key]Value)
+	for i := 0; i < 10; i++ {
+		k := Key(fmt.Sprintf("%s_%d", sampleKey1, i))
+		wantStore[k] = bytes.Repeat([]byte{byte(i)}, 64)
+	}
+	// An entry larger than the maximum segment size
+	wantStore[sampleKey2] = bytes.Repeat([]byte{1}, 2*int(maxSize))
+	for k, v := range wantStore {
+		if err := s.Set(tid, k, CopyByteArray(v)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Fatalf("got an error while committing transaction: %v", err)
+	}
+
+	segments, err := s.Segments()
+	if err != nil {
+		t.Fatalf("got an error while listing segments: %v", err)
+	}
+	if len(segments) < 2 {
+		t.Errorf("found that flush was not split into segments. segments=%v", segments)
+	}
+	nextLSN := 0
+	for _, segment := range segments {
+		if segment.StartLSN != nextLSN {
+			t.Errorf("did not get contiguous segments. expected start LSN=%d, actual=%d", nextLSN, segment.StartLSN)
+		}
+		if wantName := fmt.Sprintf(logFileFmt, segment.StartLSN, segment.EndLSN); segment.Name != wantName {
+			t.Errorf("did not get expected segment name. expected=%s, actual=%s", wantName, segment.Name)
+		}
+		if segment.Size > maxSize && segment.EndLSN != segment.StartLSN {
+			t.Errorf("found segment %s larger than the maximum segment size. size=%d", segment.Name, segment.Size)
+		}
+		nextLSN = segment.EndLSN + 1
+	}
+	if nextLSN != s.lm.nextLSNToFlush {
+		t.Errorf("did not get segments covering flushed entries. expected end LSN=%d, actual=%d", s.lm.nextLSNToFlush-1, nextLSN-1)
+	}
+
+	reopened := reopenStoreForTest(t, s)
+	for k, v := range wantStore {
+		checkStoreValue(t, reopened, k, v)
+	}
+}
@@ -0,0 +1,142 @@
+package gostore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Session is a connection to a Store, such as that of a client of a server
+// exposing the store over the network. The transactions begun through a
+// session are owned by it: operations on them through another session fail
+// with ErrTransactionNotOwned, so that a client can not use (or guess) the ID
+// of a transaction begun by another client. When a session is closed, the
+// transactions it owns that are still running are aborted.
+type Session struct {
+	s      *Store
+	lock   sync.Mutex                 // lock to synchronize access to tids and closed
+	tids   map[TransactionID]struct{} // the running transactions owned by the session
+	closed bool                       // whether the session has been closed
+}
+
+// NewSession opens a new session on the store.
+func (s *Store) NewSession() *Session {
+	return &Session{s: s, tids: make(map[TransactionID]struct{})}
+}
+
+// Begin begins a new transaction configured by opts, owned by the session.
+// ErrSessionClosed is returned if the session has been closed, and
+// ErrStoreClosed if the store has been.
+func (ss *Session) Begin(opts TransactionOptions) (TransactionID, error) {
+	ss.lock.Lock()
+	closed := ss.closed
+	ss.lock.Unlock()
+	if closed {
+		return 0, ErrSessionClosed
+	}
+	tid := ss.s.lm.nextTransactionID()
+	if err := ss.s.lm.beginTransactionWithOptions(tid, opts); err != nil {
+		return 0, err
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.closed {
+		// Closed while the transaction was begun
+		ss.s.Abort(tid)
+		return 0, ErrSessionClosed
+	}
+	ss.tids[tid] = struct{}{}
+	return tid, nil
+}
+
+// checkOwned returns an error unless transaction tid is owned by the session.
+func (ss *Session) checkOwned(tid TransactionID) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.closed {
+		return ErrSessionClosed
+	}
+	if _, ok := ss.tids[tid]; !ok {
+		return fmt.Errorf("%w: transaction with ID %d", ErrTransactionNotOwned, tid)
+	}
+	return nil
+}
+
+// Get retrieves the value of a key in a transaction owned by the session.
+func (ss *Session) Get(tid TransactionID, k Key) (Value, error) {
+	if err := ss.checkOwned(tid); err != nil {
+		return nil, err
+	}
+	return ss.s.Get(tid, k)
+}
+
+// Set sets the value of a key in a transaction owned by the session.
+func (ss *Session) Set(tid TransactionID, k Key, v Value) error {
+	if err := ss.checkOwned(tid); err != nil {
+		return err
+	}
+	return ss.s.Set(tid, k, v)
+}
+
+// Delete deletes a key in a transaction owned by the session.
+func (ss *Session) Delete(tid TransactionID, k Key) error {
+	if err := ss.checkOwned(tid); err != nil {
+		return err
+	}
+	return ss.s.Delete(tid, k)
+}
+
+// Commit commits a transaction owned by the session.
+func (ss *Session) Commit(tid TransactionID) error {
+	return ss.end(tid, ss.s.Commit)
+}
+
+// Abort aborts a transaction owned by the session.
+func (ss *Session) Abort(tid TransactionID) error {
+	return ss.end(tid, ss.s.Abort)
+}
+
+// end ends transaction tid with end, if it is owned by the session. The
+// session no longer owns the transaction once it is no longer running.
+func (ss *Session) end(tid TransactionID, end func(TransactionID) error) error {
+	if err := ss.checkOwned(tid); err != nil {
+		return err
+	}
+	err := end(tid)
+	if err != nil && ss.s.lm.running(tid) {
+		return err
+	}
+	ss.lock.Lock()
+	delete(ss.tids, tid)
+	ss.lock.Unlock()
+	return err
+}
+
+// Close closes the session, aborting the transactions it owns that are still
+// running. The session can not be used once it is closed. If a transaction can
+// not be aborted, the others are still aborted and the first error is
+// returned.
+func (ss *Session) Close() error {
+	ss.lock.Lock()
+	if ss.closed {
+		ss.lock.Unlock()
+		return nil
+	}
+	ss.closed = true
+	tids := make([]TransactionID, 0, len(ss.tids))
+	for tid := range ss.tids {
+		tids = append(tids, tid)
+	}
+	ss.tids = nil
+	ss.lock.Unlock()
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+
+	var firstErr error
+	for _, tid := range tids {
+		if err := ss.s.Abort(tid); err != nil && ss.s.lm.running(tid) && firstErr == nil {
+			firstErr = fmt.Errorf("could not abort transaction with ID %d: %w", tid, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,95 @@
+package gostore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionOwnership(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	owner, other := s.NewSession(), s.NewSession()
+
+	tid, err := owner.Begin(TransactionOptions{})
+	if err != nil {
+		t.Fatalf("got an error while beginning transaction: %v", err)
+	}
+	for _, test := range []struct {
+		name string
+		op   func() error
+	}{
+		{"Get", func() error { _, err := other.Get(tid, sampleKey1); return err }},
+		{"Set", func() error { return other.Set(tid, sampleKey1, CopyByteArray(sampleValue2)) }},
+		{"Delete", func() error { return other.Delete(tid, sampleKey1) }},
+		{"Commit", func() error { return other.Commit(tid) }},
+		{"Abort", func() error { return other.Abort(tid) }},
+	} {
+		if err := test.op(); !errors.Is(err, ErrTransactionNotOwned) {
+			t.Errorf("did not get expected error from %s through another session. expected=%v, actual=%v", test.name, ErrTransactionNotOwned, err)
+		}
+	}
+
+	// The transaction is unaffected, and can still be used by its owner
+	if err := owner.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := owner.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue2)
+	if _, err := owner.Get(tid, sampleKey1); !errors.Is(err, ErrTransactionNotOwned) {
+		t.Errorf("did not get expected error after transaction was committed. expected=%v, actual=%v", ErrTransactionNotOwned, err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	s := newStoreForTest(t, WithLockTimeout(100*time.Millisecond))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	ss := s.NewSession()
+
+	var tids []TransactionID
+	for _, k := range []Key{sampleKey1, sampleKey2} {
+		tid, err := ss.Begin(TransactionOptions{})
+		if err != nil {
+			t.Fatalf("got an error while beginning transaction: %v", err)
+		}
+		if err := ss.Set(tid, k, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		tids = append(tids, tid)
+	}
+	committed, err := ss.Begin(TransactionOptions{})
+	if err != nil {
+		t.Fatalf("got an error while beginning transaction: %v", err)
+	}
+	if err := ss.Commit(committed); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	if err := ss.Close(); err != nil {
+		t.Errorf("got an error while closing session: %v", err)
+	}
+	for _, tid := range tids {
+		if s.lm.running(tid) {
+			t.Errorf("found transaction with ID %d running after session was closed.", tid)
+		}
+	}
+	// The locks of the aborted transactions are released
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	tid := s.BeginTransaction()
+	if _, err := s.Get(tid, sampleKey2); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for key='%s'. expected=%v, actual=%v", sampleKey2, ErrKeyNotFound, err)
+	}
+	s.Commit(tid)
+
+	if _, err := ss.Begin(TransactionOptions{}); err != ErrSessionClosed {
+		t.Errorf("did not get expected error after session was closed. expected=%v, actual=%v", ErrSessionClosed, err)
+	}
+	if _, err := ss.Get(tids[0], sampleKey1); err != ErrSessionClosed {
+		t.Errorf("did not get expected error after session was closed. expected=%v, actual=%v", ErrSessionClosed, err)
+	}
+	if err := ss.Close(); err != nil {
+		t.Errorf("got an error while closing session again: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io"
+	"sort"
+	"strings"
+)
+
+// snapshotRead returns the committed values of keys (omitting keys that do not
+// exist), and the LSN of the last log entry at the time they were read. The
+// values are read outside of any transaction: read locks are taken on all of
+// the keys at once, in key order, so no transaction can be partway through
+// writing any of them, and released as soon as the values are copied. No locks
+// are held while waiting for a key that is write locked. Keys that do not exist
+// are locked too (as for write locks, they are added to the store), so that
+// they can not be created while the others are read.
+func (lm *logManager) snapshotRead(keys []Key) (map[Key]Value, int64, error) {
+	for _, k := range keys {
+		if err := lm.validateKey(k); err != nil {
+			return nil, 0, err
+		}
+	}
+	sorted := make([]Key, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for {
+		smvs := make(map[Key]*storeMapValue, len(sorted))
+		var locked []*storeMapValue
+		unlock := func() {
+			for _, smv := range locked {
+				smv.lock.RUnlock()
+			}
+		}
+		current := true
+		for i, k := range sorted {
+			if i > 0 && k == sorted[i-1] {
+				continue
+			}
+			lm.stateLock.Lock()
+			smv, _ := lm.store.storeMapValue(k, true)
+			lm.stateLock.Unlock()
+			if !smv.lock.TryRLock() {
+				// A transaction promoting its read lock on a key that is
+				// already locked would wait for this read, so the locks are
+				// released while waiting for the key, and taken again.
+				unlock()
+				if !lockWithTimeout(lm.config.clock, smv.lock.TryRLock, smv.lock.RLock, lm.config.lockTimeout) {
+					return nil, 0, lockTimeoutError(k)
+				}
+				smv.lock.RUnlock()
+				current = false
+				break
+			}
+			locked = append(locked, smv)
+			smvs[k] = smv
+		}
+		if !current {
+			continue
+		}
+
+		// A key may have been deleted (and its value removed from the store)
+		// before it was locked, in which case the locks are taken again.
+		lm.stateLock.Lock()
+		for k, smv := range smvs {
+			if lm.store[k] != smv {
+				current = false
+				break
+			}
+		}
+		lm.stateLock.Unlock()
+		if !current {
+			unlock()
+			continue
+		}
+
+		lm.logLock.Lock()
+		lsn := int64(lm.nextLSN - 1)
+		lm.logLock.Unlock()
+		values := make(map[Key]Value, len(smvs))
+		for k, smv := range smvs {
+			if smv.value != nil {
+				values[k] = CopyByteArray(smv.value)
+			}
+		}
+		unlock()
+		if err := lm.resolveChunks(values); err != nil {
+			return nil, 0, err
+		}
+		return values, lsn, nil
+	}
+}
+
+// prefixSnapshot returns the committed values of the keys starting with prefix
+// as of the last entry in the log, and the LSN of that entry. No locks are
+// taken on the keys: the values are reconstructed from the log, as for
+// getValueAsOf. Only copying the entries that are needed (the UPDATE and UNDO
+// entries of the keys, and the entries that decide the outcome of each
+// transaction) is done with logLock held; they are replayed once it is
+// released. The entries are copied field by field, since a compaction may
+// renumber the entries of running transactions in place.
+func (lm *logManager) prefixSnapshot(prefix Key) (map[Key]Value, int64, error) {
+	lm.logLock.Lock()
+	lsn := int64(lm.nextLSN - 1)
+	var entries []*pb.LogEntry
+	for _, e := range lm.log.Entry {
+		switch e.GetEntryType() {
+		case pb.LogEntry_UPDATE, pb.LogEntry_UNDO:
+			if !strings.HasPrefix(e.GetKey(), string(prefix)) {
+				continue
+			}
+		}
+		entries = append(entries, &pb.LogEntry{
+			Lsn:       e.Lsn,
+			Tid:       e.Tid,
+			EntryType: e.EntryType,
+			Key:       e.Key,
+			OldValue:  e.OldValue,
+			NewValue:  e.NewValue,
+			UndoLsn:   e.UndoLsn,
+			BulkLoad:  e.BulkLoad,
+		})
+	}
+	lm.logLock.Unlock()
+
+	sm := replayLogEntries(entries)
+	values := make(map[Key]Value, len(sm))
+	for k, smv := range sm {
+		values[k] = smv.value
+	}
+	if err := lm.resolveChunks(values); err != nil {
+		return nil, 0, err
+	}
+	return values, lsn, nil
+}
+
+// snapshotAt returns a marshalled log that sets the values (with their
+// metadata and versions) returned by flushedSnapshot, and the LSN that it
+// returns.
+func (lm *logManager) snapshotAt() (io.Reader, int64, error) {
+	sm, lsn, err := lm.flushedSnapshot()
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := proto.Marshal(&pb.Log{Entry: snapshotLogEntries(sm, lm.nextTransactionID())})
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not marshal snapshot: %v", err)
+	}
+	return bytes.NewReader(data), lsn, nil
+}
+
+// flushedSnapshot returns the committed values of all keys as of the flushed
+// part of the log, with their metadata and versions, and the LSN of the first
+// entry after it. The entries are copied and replayed like in prefixSnapshot.
+// A COMMIT entry that has been flushed is never superseded by an ABORT entry,
+// so the values include the effects of exactly the transactions committed in
+// the flushed part of the log.
+func (lm *logManager) flushedSnapshot() (storeMap, int64, error) {
+	lm.logLock.Lock()
+	lsn := int64(lm.nextLSNToFlush)
+	entries := make([]*pb.LogEntry, 0, lsn)
+	for _, e := range lm.log.Entry[:lsn-int64(lm.firstLSN)] {
+		entries = append(entries, &pb.LogEntry{
+			Lsn:       e.Lsn,
+			Tid:       e.Tid,
+			EntryType: e.EntryType,
+			Key:       e.Key,
+			OldValue:  e.OldValue,
+			NewValue:  e.NewValue,
+			OldMeta:   e.OldMeta,
+			NewMeta:   e.NewMeta,
+			Version:   e.Version,
+			UndoLsn:   e.UndoLsn,
+			BulkLoad:  e.BulkLoad,
+		})
+	}
+	lm.logLock.Unlock()
+
+	sm := replayLogEntries(entries)
+	for k, smv := range sm {
+		if smv.value == nil {
+			delete(sm, k)
+			continue
+		}
+		v, err := lm.resolveChunk(smv.value)
+		if err != nil {
+			return nil, 0, err
+		}
+		smv.value = v
+	}
+	return sm, lsn, nil
+}
+
+// snapshotLogEntries returns the entries of a log in which transaction tid sets
+// the values of the keys in sm, with their metadata and versions.
+func snapshotLogEntries(sm storeMap, tid TransactionID) []*pb.LogEntry {
+	keys := make([]string, 0, len(sm))
+	for k := range sm {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+
+	entries := make([]*pb.LogEntry, 0, len(keys)+3)
+	addEntry := func(e *pb.LogEntry) {
+		e.Lsn = proto.Int64(int64(len(entries)))
+		e.Tid = proto.Int64(int64(tid))
+		entries = append(entries, e)
+	}
+	addEntry(&pb.LogEntry{EntryType: pb.LogEntry_BEGIN.Enum()})
+	for _, k := range keys {
+		smv := sm[Key(k)]
+		addEntry(&pb.LogEntry{
+			EntryType: pb.LogEntry_UPDATE.Enum(),
+			Key:       proto.String(k),
+			NewValue:  smv.value,
+			NewMeta:   smv.meta,
+			Version:   proto.Uint64(smv.version),
+		})
+	}
+	addEntry(&pb.LogEntry{EntryType: pb.LogEntry_COMMIT.Enum()})
+	addEntry(&pb.LogEntry{EntryType: pb.LogEntry_END.Enum()})
+	return entries
+}
@@ -0,0 +1,322 @@
+package gostore
+
+import (
+	"fmt"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotRead(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+
+	// Uncommitted writes are not read
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	var values map[Key]Value
+	var lsn int64
+	var err error
+	done := make(chan struct{})
+	go func() {
+		values, lsn, err = s.SnapshotRead([]Key{sampleKey2, sampleKey1, sampleKey3, sampleKey4})
+		close(done)
+	}()
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	<-done
+	if err != nil {
+		t.Fatalf("got an error while reading snapshot: %v", err)
+	}
+	wantValues := map[Key]Value{sampleKey1: sampleValue1, sampleKey2: sampleValue2}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("did not get expected snapshot. expected=%v, actual=%v", wantValues, values)
+	}
+	if lsn < 0 || lsn >= int64(s.lm.nextLSN) {
+		t.Errorf("did not get LSN in the log. actual=%d", lsn)
+	}
+}
+
+func TestSnapshotReadConsistency(t *testing.T) {
+	s := newStoreForTest(t)
+	keys := []Key{sampleKey1, sampleKey2, sampleKey3}
+	tid := s.BeginTransaction()
+	for _, k := range keys {
+		if err := s.Set(tid, k, Value("100")); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Transfer amounts between keys, keeping their total constant
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			from, to := keys[i%3], keys[(i+1)%3]
+			err := s.Transact(func(txn *Txn) error {
+				for _, d := range []struct {
+					k     Key
+					delta int
+				}{{from, -1}, {to, 1}} {
+					v, err := txn.Get(d.k)
+					if err != nil {
+						return err
+					}
+					n, _ := strconv.Atoi(string(v))
+					if err := txn.Set(d.k, Value(strconv.Itoa(n+d.delta))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, RetryPolicy{MaxAttempts: 10})
+			if err != nil {
+				t.Errorf("got an error while transferring: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		values, lsn, err := s.SnapshotRead(keys)
+		if err != nil {
+			t.Fatalf("got an error while reading snapshot: %v", err)
+		}
+		total := 0
+		for _, v := range values {
+			n, _ := strconv.Atoi(string(v))
+			total += n
+		}
+		if total != 300 {
+			t.Errorf("found inconsistent snapshot. values=%q", values)
+		}
+		// The values are those as of the LSN
+		for _, k := range keys {
+			if v, err := s.GetAsOf(k, lsn); err != nil || !reflect.DeepEqual(v, values[k]) {
+				t.Errorf("did not get value as of snapshot LSN %d for key='%s'. expected=%q, actual=(%q, %v)", lsn, k, values[k], v, err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestPrefixSnapshot(t *testing.T) {
+	s := newStoreForTest(t)
+	keys := []Key{"acct_1", "acct_2", "acct_3"}
+	tid := s.BeginTransaction()
+	for _, k := range append([]Key{"other"}, keys...) {
+		if err := s.Set(tid, k, Value("100")); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Keys locked by running transactions are read without waiting, and
+	// their uncommitted writes are not read
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, keys[0], Value("0")); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", keys[0], err)
+	}
+	if err := s.Set(tid, "acct_4", Value("0")); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", "acct_4", err)
+	}
+	values, _, err := s.PrefixSnapshot("acct_")
+	if err != nil {
+		t.Fatalf("got an error while reading snapshot: %v", err)
+	}
+	wantValues := map[Key]Value{keys[0]: Value("100"), keys[1]: Value("100"), keys[2]: Value("100")}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("did not get expected snapshot. expected=%q, actual=%q", wantValues, values)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// Transfer amounts between keys, keeping their total constant
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			from, to := keys[i%3], keys[(i+1)%3]
+			err := s.Transact(func(txn *Txn) error {
+				for _, d := range []struct {
+					k     Key
+					delta int
+				}{{from, -1}, {to, 1}} {
+					v, err := txn.Get(d.k)
+					if err != nil {
+						return err
+					}
+					n, _ := strconv.Atoi(string(v))
+					if err := txn.Set(d.k, Value(strconv.Itoa(n+d.delta))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, RetryPolicy{MaxAttempts: 10})
+			if err != nil {
+				t.Errorf("got an error while transferring: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		values, lsn, err := s.PrefixSnapshot("acct_")
+		if err != nil {
+			t.Fatalf("got an error while reading snapshot: %v", err)
+		}
+		if len(values) != len(keys) {
+			t.Errorf("did not get expected number of keys. expected=%d, actual=%d", len(keys), len(values))
+		}
+		total := 0
+		for _, v := range values {
+			n, _ := strconv.Atoi(string(v))
+			total += n
+		}
+		if total != 300 {
+			t.Errorf("found inconsistent snapshot. values=%q", values)
+		}
+		for _, k := range keys {
+			if v, err := s.GetAsOf(k, lsn); err != nil || !reflect.DeepEqual(v, values[k]) {
+				t.Errorf("did not get value as of snapshot LSN %d for key='%s'. expected=%q, actual=(%q, %v)", lsn, k, values[k], v, err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestSnapshotAt(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	tid := s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	// A transaction updated before the snapshot, and committed after it
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Errorf("got an error while flushing log: %v", err)
+	}
+
+	r, lsn, err := s.SnapshotAt()
+	if err != nil {
+		t.Fatalf("got an error while taking snapshot: %v", err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	setForTest(t, s, sampleKey1, sampleValue2)
+
+	// A follower loads the snapshot, then applies the tail from its LSN
+	_, values, err := ReplayLog(r)
+	if err != nil {
+		t.Fatalf("got an error while loading snapshot: %v", err)
+	}
+	if want := map[Key]Value{sampleKey1: sampleValue1}; !reflect.DeepEqual(values, want) {
+		t.Errorf("did not get expected snapshot. expected=%v, actual=%v", want, values)
+	}
+	c, cancel := s.LogTail(lsn)
+	defer cancel()
+	for commits := 0; commits < 2; {
+		e := receiveLogEntryForTest(t, c)
+		switch e.GetEntryType() {
+		case pb.LogEntry_UPDATE:
+			if e.NewValue == nil {
+				delete(values, Key(e.GetKey()))
+			} else {
+				values[Key(e.GetKey())] = Value(e.NewValue)
+			}
+		case pb.LogEntry_COMMIT:
+			if e.GetLsn() < lsn {
+				t.Errorf("received COMMIT entry included in snapshot. lsn=%d, snapshot=%d", e.GetLsn(), lsn)
+			}
+			commits++
+		}
+	}
+	if want := map[Key]Value{sampleKey1: sampleValue2, sampleKey3: sampleValue3}; !reflect.DeepEqual(values, want) {
+		t.Errorf("did not get expected follower state. expected=%v, actual=%v", want, values)
+	}
+}
+
+func TestSnapshotAtMetaAndVersions(t *testing.T) {
+	s := newStoreForTest(t)
+	meta := Meta{ContentType: "text/plain"}
+	tid := s.BeginTransaction()
+	if err := s.SetWithMeta(tid, sampleKey1, CopyByteArray(sampleValue1), meta); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	setForTest(t, s, sampleKey1, sampleValue2)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	if err := s.Sync(); err != nil {
+		t.Errorf("got an error while flushing log: %v", err)
+	}
+
+	// A follower opened from the snapshot agrees with the store on metadata
+	// and versions
+	r, _, err := s.SnapshotAt()
+	if err != nil {
+		t.Fatalf("got an error while taking snapshot: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("could not read snapshot: %v", err)
+	}
+	dir, err := ioutil.TempDir(testLogDir, "follower_")
+	if err != nil {
+		t.Fatalf("could not create log directory for follower: %v", err)
+	}
+	filename := filepath.Join(dir, fmt.Sprintf(logFileFmt, 0, 4))
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatalf("could not write snapshot: %v", err)
+	}
+	follower, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("could not open follower from snapshot: %v", err)
+	}
+	defer follower.Close()
+	for _, s := range []*Store{s, follower} {
+		checkMetaForTest(t, s, sampleKey1, sampleValue2, meta)
+		checkMetaForTest(t, s, sampleKey2, sampleValue2, Meta{})
+		checkVersion(t, s, sampleKey1, 2)
+		checkVersion(t, s, sampleKey2, 1)
+	}
+}
@@ -0,0 +1,129 @@
+package gostore
+
+import (
+	"fmt"
+	pb "github.com/mDibyo/gostore/pb"
+	"sync/atomic"
+	"time"
+)
+
+// StoreStats is a snapshot of statistics about a store.
+type StoreStats struct {
+	Keys               int   // the number of keys in the store
+	ValueBytes         int64 // the total size of the values in the store, in bytes
+	ActiveTransactions int   // the number of running transactions
+	UnflushedEntries   int   // the number of log entries not yet flushed to disk
+	Segments           int   // the number of log files in the log directory
+	Tombstones         int   // the number of deleted keys kept in memory until the log is next compacted
+}
+
+// setStoreValue sets the value of key k, whose value in the store is smv, to v
+// with metadata meta (or deletes k if v is nil), keeping the counts of keys,
+// value bytes and tombstones up to date. It must be called with stateLock
+// held. A deleted key keeps its value in the store as a tombstone, since
+// transactions may hold its lock; setting the key again (or undoing the
+// delete) clears the tombstone. Tombstones are removed by collectTombstones.
+func (lm *logManager) setStoreValue(k Key, smv *storeMapValue, v Value, meta *pb.Meta) {
+	if smv.value != nil {
+		lm.numKeys--
+		lm.valueBytes -= int64(len(smv.value))
+	}
+	smv.value = v
+	smv.meta = meta
+	if v != nil {
+		lm.numKeys++
+		lm.valueBytes += int64(len(v))
+	}
+	if deleted := v == nil; deleted != smv.deleted {
+		smv.deleted = deleted
+		if deleted {
+			lm.tombstones++
+		} else {
+			lm.tombstones--
+		}
+	}
+}
+
+// countStoreValues counts the keys and value bytes in the store. It is used
+// once the store has been rebuilt from the log, after which the counts are
+// kept up to date by setStoreValue.
+func (lm *logManager) countStoreValues() {
+	lm.numKeys, lm.valueBytes = 0, 0
+	for _, smv := range lm.store {
+		if smv.value != nil {
+			lm.numKeys++
+			lm.valueBytes += int64(len(smv.value))
+		}
+	}
+}
+
+// stats returns a snapshot of statistics about the store.
+func (lm *logManager) stats() StoreStats {
+	var stats StoreStats
+	lm.stateLock.Lock()
+	stats.Keys = lm.numKeys
+	stats.ValueBytes = lm.valueBytes
+	stats.ActiveTransactions = len(lm.transactions)
+	stats.Tombstones = lm.tombstones
+	lm.stateLock.Unlock()
+
+	lm.logLock.Lock()
+	stats.UnflushedEntries = lm.nextLSN - lm.nextLSNToFlush
+	lm.logLock.Unlock()
+
+	if segments, err := lm.segments(); err == nil {
+		stats.Segments = len(segments)
+	}
+	return stats
+}
+
+// keyStats returns the number of reads and writes of key k since it was
+// created or the store was opened.
+func (lm *logManager) keyStats(k Key) (reads, writes uint64, err error) {
+	lm.stateLock.Lock()
+	smv, err := lm.store.storeMapValue(k, false)
+	lm.stateLock.Unlock()
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not retrieve key stats: %w", err)
+	}
+	return atomic.LoadUint64(&smv.reads), atomic.LoadUint64(&smv.writes), nil
+}
+
+// logEntryMemory returns the approximate number of bytes held by a log entry of
+// a transaction. The write buffer of a transaction that defers its writes
+// holds the same new values as its UPDATE entries, so it is not counted
+// separately.
+func logEntryMemory(e *pb.LogEntry) int64 {
+	return int64(len(e.GetKey()) + len(e.OldValue) + len(e.NewValue))
+}
+
+// transactionMemory returns the approximate number of bytes held by a running
+// transaction, in the old and new values of its updates and in its write
+// buffer.
+func (lm *logManager) transactionMemory(tid TransactionID) (int64, error) {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	ts, ok := lm.transactions[tid]
+	if !ok {
+		return 0, fmt.Errorf("transaction with ID %d is not currently running", tid)
+	}
+	return ts.memory, nil
+}
+
+// oldestActiveTransaction returns the running transaction that was begun
+// first, and how long it has been running. ok is false if no transactions are
+// running.
+func (lm *logManager) oldestActiveTransaction() (tid TransactionID, age time.Duration, ok bool) {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	var begun time.Time
+	for t, ts := range lm.transactions {
+		if !ok || ts.begun.Before(begun) || (ts.begun.Equal(begun) && t < tid) {
+			tid, begun, ok = t, ts.begun, true
+		}
+	}
+	if !ok {
+		return 0, 0, false
+	}
+	return tid, lm.config.clock.Now().Sub(begun), true
+}
@@ -0,0 +1,200 @@
+package gostore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// checkStatsForTest checks the counts of keys and value bytes of a store.
+func checkStatsForTest(t *testing.T, s *Store, keys int, valueBytes int64) {
+	stats := s.Stats()
+	if stats.Keys != keys {
+		t.Errorf("did not get expected number of keys. expected=%d, actual=%d", keys, stats.Keys)
+	}
+	if stats.ValueBytes != valueBytes {
+		t.Errorf("did not get expected value bytes. expected=%d, actual=%d", valueBytes, stats.ValueBytes)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := newStoreForTest(t)
+	checkStatsForTest(t, s, 0, 0)
+	if stats := s.Stats(); stats.Segments != 0 || stats.ActiveTransactions != 0 {
+		t.Errorf("did not get expected stats for empty store. actual=%+v", stats)
+	}
+
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	size1, size2 := int64(len(sampleValue1)), int64(len(sampleValue2))
+	checkStatsForTest(t, s, 2, size1+size2)
+	if stats := s.Stats(); stats.Segments != 2 || stats.UnflushedEntries != 0 {
+		t.Errorf("did not get expected log stats. expected=%+v, actual=%+v", StoreStats{Segments: 2}, stats)
+	}
+
+	// Overwrite and delete in running transactions
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	deferredTID := s.BeginTransactionWithOptions(TransactionOptions{DeferWrites: true})
+	if err := s.Delete(deferredTID, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	size3 := int64(len(sampleValue3))
+	checkStatsForTest(t, s, 2, size3+size2)
+	if stats := s.Stats(); stats.ActiveTransactions != 2 || stats.UnflushedEntries == 0 {
+		t.Errorf("did not get expected transaction stats. actual=%+v", stats)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	if err := s.Commit(deferredTID); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStatsForTest(t, s, 1, size1)
+
+	// A placeholder for a key locked before it is set is not counted
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Delete(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+	}
+	checkStatsForTest(t, s, 1, size1)
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	checkStatsForTest(t, reopenStoreForTest(t, s), 1, size1)
+}
+
+func TestKeyStats(t *testing.T) {
+	s := newStoreForTest(t)
+	if _, _, err := s.KeyStats(sampleKey1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for missing key. expected=%v, actual=%v", ErrKeyNotFound, err)
+	}
+
+	setForTest(t, s, sampleKey1, sampleValue1)
+	tid := s.BeginTransaction()
+	for i := 0; i < 3; i++ {
+		if _, err := s.Get(tid, sampleKey1); err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+		}
+	}
+	for _, v := range []Value{sampleValue2, sampleValue3} {
+		if err := s.Set(tid, sampleKey1, CopyByteArray(v)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	reads, writes, err := s.KeyStats(sampleKey1)
+	if err != nil {
+		t.Fatalf("got an error while getting key stats: %v", err)
+	}
+	if reads != 3 || writes != 3 {
+		t.Errorf("did not get expected key stats. expected=(%d %d), actual=(%d %d)", 3, 3, reads, writes)
+	}
+}
+
+func TestOldestActiveTransaction(t *testing.T) {
+	s := newStoreForTest(t)
+	if _, _, ok := s.OldestActiveTransaction(); ok {
+		t.Errorf("found an active transaction in empty store.")
+	}
+
+	older := s.BeginTransaction()
+	time.Sleep(20 * time.Millisecond)
+	newer := s.BeginTransaction()
+	for _, want := range []TransactionID{older, newer} {
+		gotTID, gotAge, ok := s.OldestActiveTransaction()
+		if !ok || gotTID != want {
+			t.Errorf("did not get expected oldest active transaction. expected=%d, actual=(%d, %v)", want, gotTID, ok)
+		}
+		if want == older && gotAge < 20*time.Millisecond {
+			t.Errorf("did not get expected age of oldest active transaction. expected>=%v, actual=%v", 20*time.Millisecond, gotAge)
+		}
+		if err := s.Commit(want); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+	if _, _, ok := s.OldestActiveTransaction(); ok {
+		t.Errorf("found an active transaction after all were committed.")
+	}
+}
+
+func TestTransactionMemory(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		for _, end := range []func(TransactionID) error{s.Commit, s.Abort} {
+			tid := s.BeginTransactionWithOptions(opts)
+			checkMemory := func(want int64) {
+				gotMemory, err := s.TransactionMemory(tid)
+				if err != nil {
+					t.Errorf("got an error while getting transaction memory: %v", err)
+				} else if gotMemory != want {
+					t.Errorf("did not get expected transaction memory. options=%+v, expected=%d, actual=%d", opts, want, gotMemory)
+				}
+			}
+			checkMemory(0)
+
+			// The memory held grows with each write
+			var before int64
+			checkGrown := func() {
+				gotMemory, _ := s.TransactionMemory(tid)
+				if gotMemory <= before {
+					t.Errorf("found that transaction memory did not grow. options=%+v, before=%d, after=%d", opts, before, gotMemory)
+				}
+				before = gotMemory
+			}
+			for _, k := range []Key{sampleKey2, sampleKey3} {
+				if err := s.Set(tid, k, CopyByteArray(sampleValue2)); err != nil {
+					t.Errorf("got an error while setting value for key='%s': %v", k, err)
+				}
+				checkGrown()
+			}
+			if err := s.Delete(tid, sampleKey1); err != nil {
+				t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+			}
+			checkGrown()
+
+			// The memory is released when the transaction ends
+			if err := end(tid); err != nil {
+				t.Errorf("got an error while ending transaction: %v", err)
+			}
+			if _, err := s.TransactionMemory(tid); err == nil {
+				t.Errorf("did not get an error while getting memory of transaction that ended.")
+			}
+			setForTest(t, s, sampleKey1, sampleValue1)
+		}
+	}
+
+	// The memory held by undone updates is released as they are undone
+	tid := s.BeginTransaction()
+	for _, k := range []Key{sampleKey2, sampleKey3} {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	before, _ := s.TransactionMemory(tid)
+	err := s.AbortWithProgress(context.Background(), tid, func(undone, total int) {
+		gotMemory, _ := s.TransactionMemory(tid)
+		if gotMemory >= before {
+			t.Errorf("found that transaction memory was not released. before=%d, after=%d", before, gotMemory)
+		}
+		if undone == total && gotMemory != 0 {
+			t.Errorf("did not get expected transaction memory. expected=%d, actual=%d", 0, gotMemory)
+		}
+		before = gotMemory
+	})
+	if err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package gostore
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io"
+	"os"
+)
+
+// StorageBackend creates the log files of a store. Other operations on the log
+// directory (listing, reading, renaming and removing files) are performed
+// directly on the file system.
+type StorageBackend interface {
+	// Create creates (or truncates) the named file for writing, with
+	// permissions perm.
+	Create(name string, perm os.FileMode) (StorageFile, error)
+}
+
+// StorageFile is a log file being written to a StorageBackend.
+type StorageFile interface {
+	io.Writer
+	// Sync commits the contents of the file to stable storage.
+	Sync() error
+	// Close closes the file.
+	Close() error
+}
+
+// osStorage is the StorageBackend that writes files to the file system.
+type osStorage struct{}
+
+func (osStorage) Create(name string, perm os.FileMode) (StorageFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	// Set the permissions exactly, regardless of the umask of the process.
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not set permissions: %v", err)
+	}
+	return f, nil
+}
+
+// writeLogFile writes data to the named log file and syncs it (unless syncing
+// is disabled), recording the latency of the write and the sync with the
+// metrics of the store. If the file can not be written, it is removed.
+func (lm *logManager) writeLogFile(name string, data []byte) error {
+	return lm.writeLogFileSyncing(name, data, lm.config.fsync)
+}
+
+// writeLogFileSyncing is writeLogFile, but syncs the file only if sync is set.
+func (lm *logManager) writeLogFileSyncing(name string, data []byte, sync bool) error {
+	f, err := lm.config.storage.Create(name, lm.config.fileMode)
+	if err != nil {
+		lm.config.metrics.IncCounter(MetricLogWriteFailures)
+		return err
+	}
+
+	start := lm.config.clock.Now()
+	_, err = f.Write(data)
+	lm.config.metrics.ObserveDuration(MetricLogWriteLatency, lm.config.clock.Now().Sub(start))
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		lm.config.metrics.IncCounter(MetricLogWriteFailures)
+		return err
+	}
+
+	if sync {
+		start = lm.config.clock.Now()
+		err = f.Sync()
+		lm.config.metrics.ObserveDuration(MetricLogSyncLatency, lm.config.clock.Now().Sub(start))
+		if err != nil {
+			f.Close()
+			os.Remove(name)
+			lm.config.metrics.IncCounter(MetricLogSyncFailures)
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		lm.config.metrics.IncCounter(MetricLogWriteFailures)
+		return err
+	}
+	return nil
+}
+
+// writeLogFileWithRetries writes log file name with contents data, retrying
+// according to the flush retry policy of the store if it fails (see
+// WithFlushRetry). The error of the last attempt is returned.
+func (lm *logManager) writeLogFileWithRetries(name string, data []byte) error {
+	policy := lm.config.flushRetry
+	for attempt := 1; ; attempt++ {
+		err := lm.writeLogFile(name, data)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		lm.config.metrics.IncCounter(MetricLogWriteRetries)
+		lm.config.clock.Sleep(policy.delay(attempt - 1))
+	}
+}
+
+// setStorageErr records the error with which the log failed to be flushed, or
+// clears it if err is nil.
+func (lm *logManager) setStorageErr(err error) {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	lm.storageErr = err
+}
+
+// checkStorageAvailable returns ErrStorageUnavailable if the log failed to be
+// flushed and still can not be flushed. Writes are not accepted until the
+// log has been flushed, since they could not be committed. If the store has
+// degraded to read-only, it returns ErrReadOnly without trying to flush the
+// log.
+func (lm *logManager) checkStorageAvailable() error {
+	lm.logLock.Lock()
+	err, readOnly := lm.storageErr, lm.readOnly
+	lm.logLock.Unlock()
+	if readOnly {
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	if err == nil {
+		return nil
+	}
+	if err := lm.flushLog(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorageUnavailable, err)
+	}
+	return nil
+}
+
+// probeLogDir checks that files can be created in the log directory, by
+// creating (and removing) an empty probe file. Unlike ping, nothing is written
+// to the file, so that running out of space is not mistaken for the directory
+// being unwritable.
+func (lm *logManager) probeLogDir() error {
+	if lm.config.inMemory {
+		return nil
+	}
+	filename := fmt.Sprintf("%s/%s", lm.logDir, pingFileName)
+	f, err := lm.config.storage.Create(filename, lm.config.fileMode)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	os.Remove(filename)
+	return err
+}
+
+// degradeIfUnwritable degrades the store to read-only if the log directory is
+// not writable, after the log failed to be flushed. Writes then fail fast with
+// ErrReadOnly, instead of each trying to flush the log, until writes are
+// resumed. Other failures (e.g. running out of space) do not degrade the store,
+// and writes are accepted again as soon as the log can be flushed.
+func (lm *logManager) degradeIfUnwritable() {
+	if lm.probeLogDir() == nil {
+		return
+	}
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	lm.readOnly = true
+}
+
+// isReadOnly returns whether the store has degraded to read-only.
+func (lm *logManager) isReadOnly() bool {
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	return lm.readOnly
+}
+
+// resumeWrites returns the store to read-write, if it has degraded to
+// read-only and the log directory is writable again and the log can be
+// flushed. Otherwise, the store stays read-only, and the error is returned.
+func (lm *logManager) resumeWrites() error {
+	if !lm.isReadOnly() {
+		return nil
+	}
+	if err := lm.probeLogDir(); err != nil {
+		return fmt.Errorf("%w: log directory is not writable: %v", ErrReadOnly, err)
+	}
+	if err := lm.flushLog(); err != nil {
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	lm.logLock.Lock()
+	defer lm.logLock.Unlock()
+	lm.readOnly = false
+	return nil
+}
+
+// abortUnflushedCommit writes an ABORT entry for transaction tid, whose COMMIT
+// entry could not be flushed, and returns true. If the COMMIT entry has since
+// been flushed (by another flush of the log), the transaction is committed
+// and it returns false.
+func (lm *logManager) abortUnflushedCommit(tid TransactionID, ts *transactionState, commit *pb.LogEntry) bool {
+	// No other flush can write out the COMMIT entry without the ABORT entry.
+	lm.flushLock.Lock()
+	defer lm.flushLock.Unlock()
+
+	lm.logLock.Lock()
+	flushed := commit.GetLsn() < int64(lm.nextLSNToFlush)
+	lm.logLock.Unlock()
+	if flushed {
+		return false
+	}
+
+	lm.stateLock.Lock()
+	ts.aborted = true
+	lm.stateLock.Unlock()
+	lm.addTransactionLogEntry(tid, ts, &pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_ABORT.Enum(),
+	})
+	return true
+}
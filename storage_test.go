@@ -0,0 +1,303 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testStorage is a StorageBackend that creates files in the file system, but
+// can run a function before each write, and fail creates, writes and syncs.
+type testStorage struct {
+	beforeWrite func() // run before each write, if not nil
+	createErr   error  // returned by creates, if not nil
+	writeErr    error  // returned by writes, if not nil
+	syncErr     error  // returned by syncs, if not nil
+	failSyncs   int    // the number of syncs that fail with syncErr, if positive (otherwise, all of them do)
+	creates     int    // the number of creates
+	syncs       int    // the number of syncs
+}
+
+type testFile struct {
+	StorageFile
+	storage *testStorage
+}
+
+func (s *testStorage) Create(name string, perm os.FileMode) (StorageFile, error) {
+	s.creates++
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
+	f, err := osStorage{}.Create(name, perm)
+	if err != nil {
+		return nil, err
+	}
+	return testFile{f, s}, nil
+}
+
+func (f testFile) Write(data []byte) (int, error) {
+	if f.storage.beforeWrite != nil {
+		f.storage.beforeWrite()
+	}
+	if f.storage.writeErr != nil {
+		return 0, f.storage.writeErr
+	}
+	return f.StorageFile.Write(data)
+}
+
+func (f testFile) Sync() error {
+	f.storage.syncs++
+	if err := f.storage.syncErr; err != nil {
+		if f.storage.failSyncs > 0 {
+			if f.storage.failSyncs--; f.storage.failSyncs == 0 {
+				f.storage.syncErr = nil
+			}
+		}
+		return err
+	}
+	return f.StorageFile.Sync()
+}
+
+// testMetrics records the measurements it receives.
+type testMetrics struct {
+	lock      sync.Mutex
+	durations map[string][]time.Duration
+	counters  map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		durations: make(map[string][]time.Duration),
+		counters:  make(map[string]int),
+	}
+}
+
+func (m *testMetrics) ObserveDuration(name string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.durations[name] = append(m.durations[name], d)
+}
+
+func (m *testMetrics) IncCounter(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[name]++
+}
+
+func TestLogIOMetrics(t *testing.T) {
+	delay := 20 * time.Millisecond
+	storage := &testStorage{beforeWrite: func() { time.Sleep(delay) }}
+	metrics := newTestMetrics()
+	s := newStoreForTest(t, WithStorageBackend(storage), WithMetrics(metrics))
+
+	setForTest(t, s, sampleKey1, sampleValue1)
+	if got := metrics.durations[MetricLogWriteLatency]; len(got) != 1 || got[0] < delay {
+		t.Errorf("did not get expected write latency. expected=[>=%v], actual=%v", delay, got)
+	}
+	if got := metrics.durations[MetricLogSyncLatency]; len(got) != 1 {
+		t.Errorf("did not get expected number of sync latencies. expected=%d, actual=%d", 1, len(got))
+	}
+
+	// Failures are counted separately
+	for _, test := range []struct {
+		writeErr, syncErr error
+		wantCounter       string
+	}{
+		{errors.New("disk on fire"), nil, MetricLogWriteFailures},
+		{nil, errors.New("disk on fire"), MetricLogSyncFailures},
+	} {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		storage.writeErr, storage.syncErr = test.writeErr, test.syncErr
+		if err := s.Commit(tid); err == nil {
+			t.Error("did not get expected error while committing transaction.")
+		}
+		if got := metrics.counters[test.wantCounter]; got == 0 {
+			t.Errorf("did not find failures counted in %s.", test.wantCounter)
+		}
+		storage.writeErr, storage.syncErr = nil, nil
+	}
+
+	// Log files that could not be written are removed
+	s = reopenStoreForTest(t, s)
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	checkStoreValue(t, s, sampleKey2, nil)
+}
+
+func TestStorageUnavailable(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		storage := &testStorage{}
+		s := newStoreForTest(t, WithStorageBackend(storage))
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+		}
+		storage.writeErr = syscall.ENOSPC
+		if err := s.Commit(tid); !errors.Is(err, ErrStorageUnavailable) {
+			t.Errorf("did not get expected error while committing transaction. expected=%v, actual=%v", ErrStorageUnavailable, err)
+		}
+		if err := s.Commit(tid); err == nil {
+			t.Error("did not get expected error while committing aborted transaction.")
+		}
+
+		// Reads are allowed, but writes are not
+		tid = s.BeginTransactionWithOptions(opts)
+		if gotV, err := s.Get(tid, sampleKey1); err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+		} else if !bytes.Equal(gotV, sampleValue1) {
+			t.Errorf("did not get back the committed value. expected=%v, actual=%v", sampleValue1, gotV)
+		}
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); !errors.Is(err, ErrStorageUnavailable) {
+			t.Errorf("did not get expected error while setting value. expected=%v, actual=%v", ErrStorageUnavailable, err)
+		}
+
+		// Writes are accepted again once space is reclaimed
+		storage.writeErr = nil
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, sampleValue1)
+			checkStoreValue(t, s, sampleKey2, nil)
+			checkStoreValue(t, s, sampleKey3, sampleValue3)
+		}
+	}
+}
+
+func TestReadOnlyDegradation(t *testing.T) {
+	storage := &testStorage{}
+	s := newStoreForTest(t, WithStorageBackend(storage))
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	// Running out of space does not degrade the store
+	storage.writeErr = syscall.ENOSPC
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("did not get expected error while committing transaction. expected=%v, actual=%v", ErrStorageUnavailable, err)
+	}
+	if s.IsReadOnly() {
+		t.Error("found that store degraded to read-only when it ran out of space.")
+	}
+	storage.writeErr = nil
+
+	// The log directory becoming unwritable does
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	storage.createErr = syscall.EACCES
+	if err := s.Commit(tid); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("did not get expected error while committing transaction. expected=%v, actual=%v", ErrStorageUnavailable, err)
+	}
+	if !s.IsReadOnly() {
+		t.Error("did not find store degraded to read-only.")
+	}
+
+	// Reads are served, and writes fail fast, without trying to flush the log
+	tid = s.BeginTransaction()
+	if gotV, err := s.Get(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	} else if !bytes.Equal(gotV, sampleValue1) {
+		t.Errorf("did not get back the committed value. expected=%v, actual=%v", sampleValue1, gotV)
+	}
+	creates := storage.creates
+	for i := 0; i < 3; i++ {
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("did not get expected error while setting value. expected=%v, actual=%v", ErrReadOnly, err)
+		}
+	}
+	if storage.creates != creates {
+		t.Errorf("found that writes tried to flush the log. creates=%d", storage.creates-creates)
+	}
+	if err := s.ResumeWrites(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("did not get expected error while resuming writes. expected=%v, actual=%v", ErrReadOnly, err)
+	}
+
+	// The store stays read-only until writes are resumed
+	storage.createErr = nil
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("did not get expected error while setting value. expected=%v, actual=%v", ErrReadOnly, err)
+	}
+	if err := s.ResumeWrites(); err != nil {
+		t.Errorf("got an error while resuming writes: %v", err)
+	}
+	if s.IsReadOnly() {
+		t.Error("found store read-only after writes were resumed.")
+	}
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		checkStoreValue(t, s, sampleKey2, nil)
+		checkStoreValue(t, s, sampleKey3, sampleValue3)
+	}
+}
+
+func TestFlushRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: 10 * time.Millisecond}
+	for _, test := range []struct {
+		failSyncs   int
+		wantErr     bool
+		wantRetries int
+		wantDelay   time.Duration
+	}{
+		{failSyncs: 2, wantErr: false, wantRetries: 2, wantDelay: 30 * time.Millisecond},
+		{failSyncs: 3, wantErr: true, wantRetries: 2, wantDelay: 30 * time.Millisecond},
+	} {
+		storage := &testStorage{}
+		metrics := newTestMetrics()
+		clock := newFakeClock()
+		s := newStoreForTest(t, WithStorageBackend(storage), WithMetrics(metrics), WithClock(clock), WithFlushRetry(policy))
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		storage.syncErr = errors.New("transient error")
+		storage.failSyncs = test.failSyncs
+		creates := storage.creates
+		start := clock.Now()
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		err := s.Commit(tid)
+		if test.wantErr != (err != nil) {
+			t.Errorf("did not get expected commit result with %d failed syncs. expected error=%v, actual=%v", test.failSyncs, test.wantErr, err)
+		}
+		// (A failed commit also creates files to probe the log directory and to
+		// flush its abort.)
+		if gotCreates := storage.creates - creates; !test.wantErr && gotCreates != test.wantRetries+1 {
+			t.Errorf("did not get expected number of attempts to write log file. expected=%d, actual=%d", test.wantRetries+1, gotCreates)
+		}
+		if gotRetries := metrics.counters[MetricLogWriteRetries]; gotRetries != test.wantRetries {
+			t.Errorf("did not get expected number of retries. expected=%d, actual=%d", test.wantRetries, gotRetries)
+		}
+		if gotDelay := clock.Now().Sub(start); gotDelay != test.wantDelay {
+			t.Errorf("did not get expected delay between retries. expected=%v, actual=%v", test.wantDelay, gotDelay)
+		}
+		if !test.wantErr {
+			checkStoreValue(t, reopenStoreForTest(t, s), sampleKey1, sampleValue2)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package gostore
+
+import (
+	"context"
+	"errors"
+	pb "github.com/mDibyo/gostore/pb"
+	"io"
+	"time"
+)
+
+// Store is a handle to a gostore database backed by a log directory. All
+// operations on a Store are performed within transactions identified by a
+// TransactionID.
+type Store struct {
+	lm *logManager
+}
+
+// NewStore opens (or creates) the store whose log is kept in dir, configured by
+// opts. If dir is empty, the directory set by WithDefaultDir (by default,
+// DefaultLogDir) is used. The state of the store is recovered from any log
+// files already present in dir. The directory is locked until the store is
+// closed: opening another store over it fails with ErrDirectoryLocked.
+func NewStore(dir string, opts ...Option) (*Store, error) {
+	lm, err := newLogManager(dir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{lm}, nil
+}
+
+// Close flushes any part of the log that has not yet been flushed, and stops
+// the goroutines working in the background: the flusher (if commits are
+// batched), tails of the log and replicas. No transactions are begun once the
+// store is closed, and running transactions are rolled back when it is next
+// opened.
+func (s *Store) Close() error {
+	return s.lm.close()
+}
+
+// Sync flushes every entry added to the log so far, and returns once they are
+// durable. With commit batching (see WithCommitBatching), it makes the
+// transactions committed before it was called durable, without waiting for
+// the log to be flushed in the background.
+func (s *Store) Sync() error {
+	return s.lm.sync()
+}
+
+// WaitDurable waits until the entry with LSN lsn (e.g. as returned by
+// CommitWithLSN) has been flushed to disk. With commit batching (see
+// WithCommitBatching), it can be used to confirm that a committed transaction
+// is durable.
+func (s *Store) WaitDurable(lsn int64) error {
+	return s.lm.waitDurable(lsn)
+}
+
+// Ping checks that the store is operational, for health checks. It returns
+// ErrStoreClosed if the store has been closed, and ErrStorageUnavailable
+// (wrapped) if the log can not be flushed or a probe file can not be written to
+// the log directory. It also fails if the background flusher (if commits are
+// batched) has stopped.
+func (s *Store) Ping() error {
+	return s.lm.ping()
+}
+
+// IsReadOnly returns whether the store has degraded to read-only, because its
+// log directory became unwritable when the log was flushed. Reads are still
+// served from memory, but writes fail with ErrReadOnly until ResumeWrites
+// succeeds.
+func (s *Store) IsReadOnly() bool {
+	return s.lm.isReadOnly()
+}
+
+// ResumeWrites returns a store that has degraded to read-only to read-write,
+// once its log directory is writable again. It flushes the log, and returns an
+// error wrapping ErrReadOnly (leaving the store read-only) if the directory is
+// still not writable or the log can not be flushed.
+func (s *Store) ResumeWrites() error {
+	return s.lm.resumeWrites()
+}
+
+// BeginTransaction begins a new transaction on Store with the default
+// isolation level of the store (Serializable, unless configured otherwise) and
+// returns its ID. See BeginTransactionWithOptions for when no transaction is
+// begun.
+func (s *Store) BeginTransaction() TransactionID {
+	return s.BeginTransactionWithOptions(s.lm.defaultTransactionOptions())
+}
+
+// BeginTransactionWithOptions begins a new transaction configured by opts on
+// Store and returns its ID. If the maximum number of transactions are running,
+// it waits until one of them is committed or aborted. If the store has been
+// closed, or no longer holds the lock on its log directory, no transaction is
+// begun, and operations on the returned ID fail since it is not running; use
+// Begin or TryBeginTransaction to get the error instead.
+func (s *Store) BeginTransactionWithOptions(opts TransactionOptions) TransactionID {
+	tid := s.lm.nextTransactionID()
+	s.lm.beginTransactionWithOptions(tid, opts)
+	return tid
+}
+
+// TryBeginTransaction begins a new transaction configured by opts on Store
+// like BeginTransactionWithOptions, but returns ErrTooManyTransactions instead
+// of waiting if the maximum number of transactions are running. It also fails
+// with ErrStoreClosed if the store has been closed, and with
+// ErrDirectoryLocked if the store no longer holds the lock on its log
+// directory.
+func (s *Store) TryBeginTransaction(opts TransactionOptions) (TransactionID, error) {
+	tid := s.lm.nextTransactionID()
+	if err := s.lm.tryBeginTransactionWithOptions(tid, opts); err != nil {
+		return 0, err
+	}
+	return tid, nil
+}
+
+// Commit commits and ends the transaction.
+func (s *Store) Commit(tid TransactionID) error {
+	return s.lm.commitTransaction(tid)
+}
+
+// CommitWithLSN commits and ends the transaction, and returns the LSN of the
+// END entry of the transaction in the log. Once the log has been flushed up to
+// that LSN, the transaction is durable. LSNs increase with every entry added
+// to the log, and are not reused when the log is compacted.
+func (s *Store) CommitWithLSN(tid TransactionID) (int64, error) {
+	return s.lm.commitTransactionWithLSN(tid)
+}
+
+// Abort aborts and ends the transaction. If the transaction is already being
+// aborted, Abort waits for that abort to finish, and returns its result.
+func (s *Store) Abort(tid TransactionID) error {
+	return s.lm.abortTransaction(tid)
+}
+
+// AbortAll aborts every running transaction, releasing their locks, e.g. to
+// drain the store before it is compacted or closed in an emergency. Prepared
+// transactions are left to the coordinator of their two-phase commit (they are
+// restored when the store is next opened, if it is closed). Operations of the
+// aborted transactions fail as for any ended transaction. If a transaction can
+// not be aborted, the others are still aborted and the first error is
+// returned.
+func (s *Store) AbortAll() error {
+	return s.lm.abortAll()
+}
+
+// AbortWithProgress aborts and ends the transaction like Abort, but calls
+// progress (if it is not nil) as the updates of the transaction are undone,
+// with the number of updates undone so far and the total number to be undone.
+// If ctx is done before the abort is finished, it returns the context's error
+// (wrapped), leaving the transaction partially undone and still running. The
+// abort can then be resumed by aborting the transaction again, or by recovery
+// when the store is next opened.
+func (s *Store) AbortWithProgress(ctx context.Context, tid TransactionID, progress func(undone, total int)) error {
+	return s.lm.abortTransactionWithProgress(ctx, tid, progress)
+}
+
+// Get retrieves the value of a key in the transaction. ErrKeyNotFound is
+// returned if the key does not exist; otherwise the value is not nil.
+func (s *Store) Get(tid TransactionID, k Key) (Value, error) {
+	return s.lm.getValue(tid, k)
+}
+
+// TryGet retrieves the value of a key in the transaction like Get, but does
+// not wait for the key to be unlocked: if it is write-locked by another
+// transaction, false is returned (with no error) immediately, so that the
+// caller can make a best-effort read.
+func (s *Store) TryGet(tid TransactionID, k Key) (Value, bool, error) {
+	return s.lm.tryGetValue(tid, k)
+}
+
+// GetRange retrieves length bytes of the value of a key in the transaction,
+// starting at offset. Only the requested bytes are copied, so it can be used
+// to read parts of large values. ErrRangeOutOfBounds is returned if the range
+// does not lie within the value.
+func (s *Store) GetRange(tid TransactionID, k Key, offset, length int) (Value, error) {
+	return s.lm.getValueRange(tid, k, offset, length)
+}
+
+// GetOrDefault retrieves the value of a key in the transaction, or a copy of
+// def if the key does not exist.
+func (s *Store) GetOrDefault(tid TransactionID, k Key, def Value) (Value, error) {
+	v, err := s.lm.getValue(tid, k)
+	if errors.Is(err, ErrKeyNotFound) {
+		return Value(CopyByteArray(def)), nil
+	}
+	return v, err
+}
+
+// Set sets the value of a key in the transaction. The value must not be nil
+// (ErrNilValue is returned), but may be empty: a key set to an empty value is
+// present, and is only removed by Delete.
+func (s *Store) Set(tid TransactionID, k Key, v Value) error {
+	return s.lm.setValue(tid, k, v)
+}
+
+// SetWithMeta sets the value of a key in the transaction, along with its
+// metadata (e.g. its content type). The metadata is kept by later writes of
+// the key that do not set it, and removed when the key is deleted.
+func (s *Store) SetWithMeta(tid TransactionID, k Key, v Value, meta Meta) error {
+	return s.lm.setValueWithMeta(tid, k, v, meta)
+}
+
+// GetWithMeta retrieves the value of a key in the transaction, along with its
+// metadata.
+func (s *Store) GetWithMeta(tid TransactionID, k Key) (Value, Meta, error) {
+	return s.lm.getValueWithMeta(tid, k)
+}
+
+// Append appends suffix to the value of a key in the transaction, creating the
+// key if it does not exist, and returns the new length of the value. The key
+// is locked for writing once, so there is no read-modify-write round trip.
+func (s *Store) Append(tid TransactionID, k Key, suffix Value) (int, error) {
+	return s.lm.appendValue(tid, k, suffix)
+}
+
+// Update sets the value of a key in the transaction to the result of calling
+// fn with its current value (nil if the key does not exist). The key is locked
+// for writing before fn is called, so the value can not change between the
+// read and the write. If fn returns nil, the key is deleted. If fn returns an
+// error, the key is not updated and the error is returned.
+func (s *Store) Update(tid TransactionID, k Key, fn func(old Value) (Value, error)) error {
+	return s.lm.updateValueFunc(tid, k, fn)
+}
+
+// GetWithVersion retrieves the value of a key in the transaction, along with
+// its version. The version of a key is the number of committed transactions
+// that have set it since it was created, so it increases whenever a committed
+// change is made to the key. A key that is deleted and set again starts again
+// from version 1. Writes made by the transaction itself are not reflected in
+// the version until it is committed.
+func (s *Store) GetWithVersion(tid TransactionID, k Key) (Value, uint64, error) {
+	return s.lm.getValueWithVersion(tid, k)
+}
+
+// SetIfVersion sets the value of a key in the transaction if its version (as
+// returned by GetWithVersion) is expected, or returns ErrVersionMismatch. An
+// expected version of 0 requires that the key does not exist. The key is
+// locked for writing before its version is checked.
+func (s *Store) SetIfVersion(tid TransactionID, k Key, v Value, expected uint64) error {
+	return s.lm.setValueIfVersion(tid, k, v, expected)
+}
+
+// SetIfChanged sets the value of a key in the transaction unless it already
+// has that value, and returns whether it was set. An unchanged value is only
+// locked for reading, and no log entry is written for it. Since its value is
+// read, the key must also be authorized for reading.
+func (s *Store) SetIfChanged(tid TransactionID, k Key, v Value) (bool, error) {
+	return s.lm.setValueIfChanged(tid, k, v)
+}
+
+// Rename moves the value of key from to key to in the transaction, deleting
+// from. It fails with ErrKeyNotFound if from does not exist. If to exists, it
+// is overwritten if overwrite is set, and otherwise Rename fails with
+// ErrKeyExists.
+func (s *Store) Rename(tid TransactionID, from, to Key, overwrite bool) error {
+	return s.lm.renameValue(tid, from, to, overwrite)
+}
+
+// Delete deletes a key in the transaction.
+func (s *Store) Delete(tid TransactionID, k Key) error {
+	return s.lm.deleteValue(tid, k)
+}
+
+// DeleteIf deletes a key in the transaction if its current value is expected,
+// and returns whether it was deleted. The key is locked for writing before its
+// value is compared, so it can not be changed by another transaction in
+// between. ErrKeyNotFound is returned if the key does not exist.
+func (s *Store) DeleteIf(tid TransactionID, k Key, expected Value) (bool, error) {
+	return s.lm.deleteValueIf(tid, k, expected)
+}
+
+// CheckAndSet sets the keys in sets to their values in the transaction if every
+// key in checks currently has the given value, and returns whether they were
+// set. A nil value in checks matches a key that does not exist. The keys are
+// locked for writing before any of them is compared, so they can not be
+// changed by another transaction in between. If the values do not match, no
+// key is changed. Since they are locked for writing, the keys in checks must
+// also be authorized for writing.
+func (s *Store) CheckAndSet(tid TransactionID, checks, sets map[Key]Value) (bool, error) {
+	return s.lm.checkAndSet(tid, checks, sets)
+}
+
+// DeletePrefix deletes all keys starting with prefix in the transaction, and
+// returns the number of keys deleted. The keys are locked for writing, and a
+// delete is logged for each, so aborting the transaction restores them.
+func (s *Store) DeletePrefix(tid TransactionID, prefix Key) (int, error) {
+	return s.lm.deletePrefix(tid, prefix)
+}
+
+// GetDelete deletes a key in the transaction and returns the value it had. It
+// takes the write lock on the key directly, instead of a read lock that is
+// later upgraded as Get followed by Delete would. If the key does not exist,
+// ErrKeyNotFound is returned.
+func (s *Store) GetDelete(tid TransactionID, k Key) (Value, error) {
+	return s.lm.getDeleteValue(tid, k)
+}
+
+// Compact rewrites the log of the store so that it contains only the latest
+// committed value of each key, and the entries of running transactions. The
+// log files are replaced by a single log file.
+func (s *Store) Compact() error {
+	return s.lm.compact()
+}
+
+// CollectChunks removes the chunk files of chunked values (see WithChunkStore)
+// that are no longer referred to by the store or the log, and returns the
+// number of chunk files removed. Chunks are also collected when the log is
+// compacted.
+func (s *Store) CollectChunks() (int, error) {
+	return s.lm.collectChunks()
+}
+
+// Segments returns information about the log files (segments) in the log
+// directory of the store, in order of LSN. Files in the log directory that are
+// not log files are ignored.
+func (s *Store) Segments() ([]SegmentInfo, error) {
+	return s.lm.segments()
+}
+
+// TransactionReadWriteSets returns the keys read and written by a running
+// transaction, in the order of the store (see WithKeyComparator). A key that
+// is both read and written is reported only as written, as is a key the
+// transaction holds a write lock on without having modified it (e.g. one
+// checked by a DeleteIf or CheckAndSet whose condition failed). Keys read by a
+// ReadCommitted transaction are not reported, since it does not hold locks on
+// them.
+func (s *Store) TransactionReadWriteSets(tid TransactionID) (reads, writes []Key, err error) {
+	return s.lm.readWriteSets(tid)
+}
+
+// OnPreCommit registers a hook called just before each transaction commits
+// (or, for a transaction taking part in a two-phase commit, is prepared), with
+// its write set. If the hook returns an error, the transaction is aborted
+// instead, and the commit fails with the error (wrapped). Hooks are called in
+// the order in which they are registered. The returned function unregisters
+// the hook.
+func (s *Store) OnPreCommit(hook PreCommitHook) func() {
+	return s.lm.hooks.addPreCommit(hook)
+}
+
+// OnPostCommit registers a hook called just after each transaction commits,
+// with its write set. Hooks are called in the order in which they are
+// registered. The returned function unregisters the hook.
+func (s *Store) OnPostCommit(hook PostCommitHook) func() {
+	return s.lm.hooks.addPostCommit(hook)
+}
+
+// Watch subscribes to changes to a key. An event is delivered on the returned
+// channel whenever a committed transaction sets or deletes the key. The
+// returned function cancels the subscription and closes the channel.
+func (s *Store) Watch(k Key) (<-chan WatchEvent, func()) {
+	return s.lm.watch(k)
+}
+
+// Prepare prepares the transaction to be committed, as the first phase of a
+// two-phase commit. Once Prepare returns successfully, the transaction can no
+// longer be modified, and it is guaranteed to be committed by CommitPrepared
+// even if the store is closed and reopened in between. If the transaction can
+// not be prepared, it is aborted.
+func (s *Store) Prepare(tid TransactionID) error {
+	return s.lm.prepareTransaction(tid)
+}
+
+// CommitPrepared commits and ends a prepared transaction.
+func (s *Store) CommitPrepared(tid TransactionID) error {
+	return s.lm.endPreparedTransaction(tid, true)
+}
+
+// AbortPrepared aborts and ends a prepared transaction.
+func (s *Store) AbortPrepared(tid TransactionID) error {
+	return s.lm.endPreparedTransaction(tid, false)
+}
+
+// PreparedTransactions returns the IDs of the transactions that are prepared,
+// but neither committed nor aborted, in order. This includes the transactions
+// that were in doubt when the store was opened.
+func (s *Store) PreparedTransactions() []TransactionID {
+	return s.lm.preparedTransactions()
+}
+
+// Stats returns a snapshot of statistics about the store. The counts of keys
+// and value bytes include the writes of running transactions that are applied
+// to the store (i.e. that are not deferred), and are maintained as writes are
+// made, so getting them does not iterate the store. If the log directory can
+// not be read, the number of segments is reported as 0.
+func (s *Store) Stats() StoreStats {
+	return s.lm.stats()
+}
+
+// RecoveryInfo returns a summary of the recovery of the store from its log
+// when it was opened, e.g. so that transactions that were rolled back can be
+// reported.
+func (s *Store) RecoveryInfo() RecoveryInfo {
+	return s.lm.recovery
+}
+
+// SnapshotRead reads the committed values of keys outside of any transaction,
+// e.g. for a point-in-time read without a long-running transaction. The values
+// are consistent with each other: they are those that the keys had as of the
+// returned LSN, the LSN of the last entry in the log when they were read. Keys
+// that do not exist are omitted. The keys are locked for reading only while
+// they are read.
+func (s *Store) SnapshotRead(keys []Key) (map[Key]Value, int64, error) {
+	return s.lm.snapshotRead(keys)
+}
+
+// PrefixSnapshot reads the committed values of all of the keys starting with
+// prefix, as of the returned LSN (the LSN of the last entry in the log when
+// they were read). Unlike SnapshotRead, it does not lock the keys, so it does
+// not wait for (or hold up) the transactions writing them: the values are
+// reconstructed from the log, which takes time proportional to the length of
+// the log.
+func (s *Store) PrefixSnapshot(prefix Key) (map[Key]Value, int64, error) {
+	return s.lm.prefixSnapshot(prefix)
+}
+
+// GetAsOf returns the committed value that a key had as of an LSN of the log,
+// i.e. the value it would have had if the store had been closed after the log
+// entry with that LSN was written. Transactions that had not committed by then
+// are ignored. If the key did not exist then, ErrKeyNotFound is returned. Since
+// compaction discards the history of the log, values from before the last
+// compaction can not be read: ErrCompacted is returned for their LSNs.
+func (s *Store) GetAsOf(k Key, lsn int64) (Value, error) {
+	return s.lm.getValueAsOf(k, lsn)
+}
+
+// LogTail streams the effects of committed transactions from the log, for
+// change data capture. For each transaction that commits at fromLSN or later,
+// all of its UPDATE entries are delivered on the returned channel in
+// order, followed by its COMMIT entry. Entries are delivered once they have
+// been flushed, including entries added after LogTail is called; the entries
+// of aborted and running transactions are never delivered. The returned
+// function stops the stream and closes the channel. The channel is also closed
+// if the log is compacted, since that replaces its entries, and when the
+// store is closed. If fromLSN (other than 0) is from before the last
+// compaction, the channel is closed at once, since the transactions removed
+// by the compaction would be missed.
+func (s *Store) LogTail(fromLSN int64) (<-chan pb.LogEntry, func()) {
+	return s.lm.tailLog(fromLSN)
+}
+
+// SnapshotAt returns a snapshot of the committed values of all keys, and the
+// LSN from which the log is to be tailed (see LogTail) to follow the store from
+// the snapshot onward. The snapshot is a marshalled log, which ReplayLog reads
+// back. It holds the effects of exactly the transactions whose COMMIT entries
+// had been flushed when it was taken, and the tail delivers every transaction
+// committed after them, so a follower that loads the snapshot and applies the
+// tail misses no transaction and applies none twice, unless the log is
+// compacted in between (which closes the tail). The metadata and versions of
+// the keys are kept in the snapshot.
+func (s *Store) SnapshotAt() (io.Reader, int64, error) {
+	return s.lm.snapshotAt()
+}
+
+// Verify checks the consistency of the store: it replays the committed
+// entries of the log into a separate copy of the store, and reports every key
+// whose value or version differs from the store. It should be run when no
+// transactions are running, and fails if any are.
+func (s *Store) Verify() (*VerifyReport, error) {
+	return s.lm.verify()
+}
+
+// OldestActiveTransaction returns the running transaction that has been
+// running longest, and how long it has been running, e.g. to detect
+// transactions that were never committed or aborted. ok is false if no
+// transactions are running. Transactions restored when the store was opened
+// (prepared transactions) are counted from when it was opened.
+func (s *Store) OldestActiveTransaction() (tid TransactionID, age time.Duration, ok bool) {
+	return s.lm.oldestActiveTransaction()
+}
+
+// TransactionMemory returns the approximate number of bytes of memory held by
+// a running transaction: the copies of the old and new values of the keys it
+// has written, and its buffered writes. It is released when the transaction
+// is committed or aborted.
+func (s *Store) TransactionMemory(tid TransactionID) (int64, error) {
+	return s.lm.transactionMemory(tid)
+}
+
+// TransactionLog returns the operations performed by a transaction, in order,
+// for auditing. For a running transaction, these are the writes it has made
+// so far (writes of a transaction with blind writes are only logged when it is
+// committed). For a transaction that has ended, they are read from the log,
+// and include the undoing of its writes if it was aborted; they are no longer
+// available once the log has been compacted.
+func (s *Store) TransactionLog(tid TransactionID) ([]Operation, error) {
+	return s.lm.transactionLog(tid)
+}
+
+// KeyStats returns the number of times a key has been read and written by
+// transactions, for identifying hot keys. The counts are kept in memory only,
+// from when the key was created or the store was opened; they are reset when
+// the key is deleted. Writes are counted when they are made, whether or not
+// their transactions commit. If the key does not exist, ErrKeyNotFound is
+// returned.
+func (s *Store) KeyStats(k Key) (reads, writes uint64, err error) {
+	return s.lm.keyStats(k)
+}
@@ -0,0 +1,1487 @@
+package gostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStoreForTest creates a Store configured by opts, backed by a fresh log
+// directory.
+func newStoreForTest(t *testing.T, opts ...Option) *Store {
+	dir, err := ioutil.TempDir(testLogDir, "store_")
+	if err != nil {
+		t.Fatalf("could not create log directory for store: %v", err)
+	}
+	s, err := NewStore(dir, opts...)
+	if err != nil {
+		t.Fatalf("could not create store instance: %v", err)
+	}
+	return s
+}
+
+// abandonDirForTest releases the lock on log directory dir held by a store
+// that has not been closed, as if the process of that store had crashed, so
+// that the directory can be opened again. The lock file is removed, so that
+// the stale lock is not reported each time.
+func abandonDirForTest(dir string) {
+	abs, _ := filepath.Abs(dir)
+	lockedDirs.lock.Lock()
+	defer lockedDirs.lock.Unlock()
+	if lm, ok := lockedDirs.dirs[abs]; ok && lm.lockFile != nil {
+		os.Remove(filepath.Join(abs, lockFileName))
+		lm.lockFile.Close()
+		lm.lockFile = nil
+	}
+	delete(lockedDirs.dirs, abs)
+}
+
+// reopenStoreForTest opens a new Store, configured by opts, over the log
+// directory of s. If the store open over the directory has not been closed, it
+// is abandoned as if its process had crashed.
+func reopenStoreForTest(t *testing.T, s *Store, opts ...Option) *Store {
+	abandonDirForTest(s.lm.logDir)
+	s, err := NewStore(s.lm.logDir, opts...)
+	if err != nil {
+		t.Fatalf("could not reopen store instance: %v", err)
+	}
+	return s
+}
+
+// setForTest sets the value of a key in a new committed transaction.
+func setForTest(t *testing.T, s *Store, k Key, v Value) {
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, k, CopyByteArray(v)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", k, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+}
+
+// beginForTest begins a transaction on s like BeginTransaction, even if s has
+// been closed or its log directory abandoned, so that its state can still be
+// checked.
+func beginForTest(s *Store) TransactionID {
+	lm := s.lm
+	tid := lm.nextTransactionID()
+	lm.stateLock.Lock()
+	lm.currMutexes[tid] = newCurrentMutexesMap(lm.config.hashLockTable)
+	lm.transactions[tid] = newTransactionState(lm.defaultTransactionOptions(), lm.config.clock.Now())
+	lm.stateLock.Unlock()
+	lm.addLogEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_BEGIN.Enum(),
+	})
+	return tid
+}
+
+// checkStoreValue checks the committed value of a key in a new transaction. A
+// nil v means that the key should not exist.
+func checkStoreValue(t *testing.T, s *Store, k Key, v Value) {
+	tid := beginForTest(s)
+	defer s.Abort(tid)
+	gotV, err := s.Get(tid, k)
+	if v == nil {
+		if err == nil {
+			t.Errorf("found value for key='%s': %v", k, gotV)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", k, err)
+	} else if !bytes.Equal(gotV, v) {
+		t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, v, gotV)
+	}
+}
+
+func TestStoreOperations(t *testing.T) {
+	s := newStoreForTest(t)
+
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	tid = s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	tid = s.BeginTransaction()
+	for k, v := range map[Key]Value{sampleKey1: sampleValue1, sampleKey2: sampleValue2} {
+		if gotV, err := s.Get(tid, k); err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", k, err)
+		} else if !bytes.Equal(gotV, v) {
+			t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, v, gotV)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+}
+
+func TestIsolationLevels(t *testing.T) {
+	tests := []struct {
+		isolation    IsolationLevel
+		wantBlocking bool
+	}{
+		{
+			isolation:    Serializable,
+			wantBlocking: true,
+		},
+		{
+			isolation:    ReadCommitted,
+			wantBlocking: false,
+		},
+	}
+
+	blockTimeout := 100 * time.Millisecond
+	for _, test := range tests {
+		s := newStoreForTest(t)
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		// Read the key in a transaction with the given isolation level
+		readerTID := s.BeginTransactionWithOptions(TransactionOptions{Isolation: test.isolation})
+		if gotV, err := s.Get(readerTID, sampleKey1); err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+		} else if !bytes.Equal(gotV, sampleValue1) {
+			t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue1, gotV)
+		}
+
+		// Write the key in another transaction
+		writerTID := s.BeginTransaction()
+		done := make(chan error)
+		go func() {
+			done <- s.Set(writerTID, sampleKey1, CopyByteArray(sampleValue2))
+		}()
+		select {
+		case err := <-done:
+			if test.wantBlocking {
+				t.Errorf("found that writer was not blocked by reader with isolation=%d.", test.isolation)
+			}
+			if err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+			}
+		case <-time.After(blockTimeout):
+			if !test.wantBlocking {
+				t.Errorf("found that writer was blocked by reader with isolation=%d.", test.isolation)
+			}
+			if err := s.Commit(readerTID); err != nil {
+				t.Errorf("got an error while committing transaction: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+			}
+			readerTID = 0
+		}
+		if err := s.Commit(writerTID); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		if readerTID != 0 {
+			// Read committed reads see the latest committed value.
+			if gotV, err := s.Get(readerTID, sampleKey1); err != nil {
+				t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+			} else if !bytes.Equal(gotV, sampleValue2) {
+				t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue2, gotV)
+			}
+			if err := s.Commit(readerTID); err != nil {
+				t.Errorf("got an error while committing transaction: %v", err)
+			}
+		}
+	}
+}
+
+func TestBinaryKeys(t *testing.T) {
+	keys := []Key{
+		Key("with\x00nul"),
+		Key("\x00"),
+		Key([]byte{0xff, 0xfe, 0x00, 0x01}),
+		Key("with\x00nul\x00"),
+	}
+
+	s := newStoreForTest(t)
+	for i, k := range keys {
+		setForTest(t, s, k, Value{byte(i)})
+	}
+	tid := s.BeginTransaction()
+	if err := s.Delete(tid, keys[3]); err != nil {
+		t.Errorf("got an error while deleting key=%q: %v", keys[3], err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		for i, k := range keys[:3] {
+			checkStoreValue(t, s, k, Value{byte(i)})
+		}
+		checkStoreValue(t, s, keys[3], nil)
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	maxKeyLength := 8
+	tests := []struct {
+		key       Key
+		wantError bool
+	}{
+		{key: Key("key"), wantError: false},
+		{key: Key([]byte{0xff, 0x01}), wantError: false},
+		{key: Key("12345678"), wantError: false},
+		{key: Key("123456789"), wantError: true},
+		{key: Key("k\x00y"), wantError: true},
+	}
+
+	s := newStoreForTest(t, WithKeyValidation(maxKeyLength))
+	for _, test := range tests {
+		tid := s.BeginTransaction()
+		errs := []error{s.Set(tid, test.key, CopyByteArray(sampleValue1))}
+		_, err := s.Get(tid, test.key)
+		errs = append(errs, err, s.Delete(tid, test.key))
+		for _, err := range errs {
+			if gotInvalid := errors.Is(err, ErrInvalidKey); gotInvalid != test.wantError {
+				t.Errorf("did not get expected key validation for key=%q. expected=%t, actual error=%v", test.key, test.wantError, err)
+			}
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	def := CopyByteArray(sampleValue3)
+
+	tid := s.BeginTransaction()
+	tests := []struct {
+		tid       TransactionID
+		key       Key
+		wantValue Value
+		wantError bool
+	}{
+		{ // Present key
+			tid:       tid,
+			key:       sampleKey1,
+			wantValue: sampleValue1,
+		},
+		{ // Absent key
+			tid:       tid,
+			key:       sampleKey2,
+			wantValue: sampleValue3,
+		},
+		{ // Unknown transaction
+			tid:       tid + 1,
+			key:       sampleKey2,
+			wantError: true,
+		},
+	}
+	for _, test := range tests {
+		gotV, err := s.GetOrDefault(test.tid, test.key, def)
+		if test.wantError {
+			if err == nil {
+				t.Errorf("did not get expected error for key='%s'.", test.key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", test.key, err)
+		} else if !bytes.Equal(gotV, test.wantValue) {
+			t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", test.key, test.wantValue, gotV)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// The default is copied
+	tid = s.BeginTransaction()
+	if gotV, _ := s.GetOrDefault(tid, sampleKey2, def); len(gotV) > 0 {
+		gotV[0]++
+		if !bytes.Equal(def, sampleValue3) {
+			t.Error("found that default value was not copied.")
+		}
+	}
+	s.Commit(tid)
+}
+
+func TestUpdate(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	appendByte := func(old Value) (Value, error) {
+		return append(CopyByteArray(old), 9), nil
+	}
+	errUpdate := errors.New("update failed")
+
+	tid := s.BeginTransaction()
+	// Existing key (previously read in the transaction)
+	if _, err := s.Get(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Update(tid, sampleKey1, appendByte); err != nil {
+		t.Errorf("got an error while updating key='%s': %v", sampleKey1, err)
+	}
+	// Non-existent key
+	var gotOld Value = Value{}
+	if err := s.Update(tid, sampleKey2, func(old Value) (Value, error) {
+		gotOld = old
+		return appendByte(old)
+	}); err != nil {
+		t.Errorf("got an error while updating key='%s': %v", sampleKey2, err)
+	}
+	if gotOld != nil {
+		t.Errorf("did not get nil old value for non-existent key. actual=%v", gotOld)
+	}
+	// Failing update
+	lenLogBefore := len(s.lm.log.Entry)
+	for _, k := range []Key{sampleKey1, sampleKey3} {
+		if err := s.Update(tid, k, func(old Value) (Value, error) {
+			return nil, errUpdate
+		}); err != errUpdate {
+			t.Errorf("did not get expected error while updating key='%s'. expected=%v, actual=%v", k, errUpdate, err)
+		}
+	}
+	if gotLenLogAfter := len(s.lm.log.Entry); gotLenLogAfter != lenLogBefore {
+		t.Errorf("did not get expected log length. expected=%d, actual=%d.", lenLogBefore, gotLenLogAfter)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	checkStoreValue(t, s, sampleKey1, append(CopyByteArray(sampleValue1), 9))
+	checkStoreValue(t, s, sampleKey2, Value{9})
+	checkStoreValue(t, s, sampleKey3, nil)
+
+	// Update to nil deletes the key
+	tid = s.BeginTransaction()
+	if err := s.Update(tid, sampleKey2, func(old Value) (Value, error) {
+		return nil, nil
+	}); err != nil {
+		t.Errorf("got an error while updating key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey2, nil)
+}
+
+func TestMaxTransactions(t *testing.T) {
+	maxTransactions := 2
+	blockTimeout := 100 * time.Millisecond
+	s := newStoreForTest(t, WithMaxTransactions(maxTransactions))
+
+	var tids []TransactionID
+	for i := 0; i < maxTransactions; i++ {
+		tids = append(tids, s.BeginTransaction())
+	}
+
+	// Fail fast
+	if _, err := s.TryBeginTransaction(TransactionOptions{}); err != ErrTooManyTransactions {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrTooManyTransactions, err)
+	}
+
+	// Block until a transaction is committed
+	began := make(chan TransactionID)
+	go func() {
+		began <- s.BeginTransaction()
+	}()
+	select {
+	case <-began:
+		t.Error("found that transaction began beyond the limit.")
+	case <-time.After(blockTimeout):
+	}
+	if err := s.Commit(tids[0]); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	select {
+	case tid := <-began:
+		tids[0] = tid
+	case <-time.After(time.Second):
+		t.Fatal("found that transaction did not begin after another was committed.")
+	}
+
+	// Slots are freed by aborts
+	if err := s.Abort(tids[1]); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	tid, err := s.TryBeginTransaction(TransactionOptions{})
+	if err != nil {
+		t.Errorf("got an error while beginning transaction: %v", err)
+	}
+	for _, tid := range []TransactionID{tids[0], tid} {
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+}
+
+// checkVersion checks the committed version of a key in a new transaction.
+func checkVersion(t *testing.T, s *Store, k Key, version uint64) {
+	tid := beginForTest(s)
+	defer s.Abort(tid)
+	if _, gotVersion, err := s.GetWithVersion(tid, k); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", k, err)
+	} else if gotVersion != version {
+		t.Errorf("did not get expected version for key='%s'. expected=%d, actual=%d", k, version, gotVersion)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := newStoreForTest(t)
+
+	tid := s.BeginTransaction()
+	if _, _, err := s.GetWithVersion(tid, sampleKey1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error while getting missing key. expected=%v, actual=%v", ErrKeyNotFound, err)
+	}
+	if err := s.SetIfVersion(tid, sampleKey1, CopyByteArray(sampleValue1), 0); err != nil {
+		t.Errorf("got an error while setting value for missing key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkVersion(t, s, sampleKey1, 1)
+
+	// Versions increase with committed updates only
+	setForTest(t, s, sampleKey1, sampleValue2)
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	checkVersion(t, s, sampleKey1, 2)
+	tid = s.BeginTransactionWithOptions(deferWritesOptions)
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if gotV, gotVersion, err := s.GetWithVersion(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	} else if !bytes.Equal(gotV, sampleValue3) || gotVersion != 2 {
+		t.Errorf("did not get expected value and version. expected=(%v, %d), actual=(%v, %d)", sampleValue3, 2, gotV, gotVersion)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkVersion(t, s, sampleKey1, 3)
+
+	// Conditional sets
+	for _, test := range []struct {
+		expected  uint64
+		wantError error
+	}{
+		{0, ErrVersionMismatch},
+		{2, ErrVersionMismatch},
+		{3, nil},
+	} {
+		tid := s.BeginTransaction()
+		if err := s.SetIfVersion(tid, sampleKey1, CopyByteArray(sampleValue1), test.expected); !errors.Is(err, test.wantError) {
+			t.Errorf("did not get expected error while setting value for expected version=%d. expected=%v, actual=%v", test.expected, test.wantError, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Deleted keys start again from version 1
+	setForTest(t, s, sampleKey2, sampleValue2)
+	setForTest(t, s, sampleKey2, sampleValue2)
+	tid = s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	setForTest(t, s, sampleKey2, sampleValue3)
+
+	// Versions are recovered, including from a compacted log
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkVersion(t, s, sampleKey1, 4)
+		checkVersion(t, s, sampleKey2, 1)
+	}
+	if err := s.Compact(); err != nil {
+		t.Errorf("got an error while compacting log: %v", err)
+	}
+	s = reopenStoreForTest(t, s)
+	checkVersion(t, s, sampleKey1, 4)
+	checkVersion(t, s, sampleKey2, 1)
+}
+
+func TestTransactionReadWriteSets(t *testing.T) {
+	s := newStoreForTest(t)
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		setForTest(t, s, k, sampleValue1)
+	}
+
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		tid := s.BeginTransactionWithOptions(opts)
+		for _, k := range []Key{sampleKey3, sampleKey1} {
+			if _, err := s.Get(tid, k); err != nil {
+				t.Errorf("got an error while getting value for key='%s': %v", k, err)
+			}
+		}
+		if err := s.Set(tid, sampleKey4, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey4, err)
+		}
+		if err := s.Delete(tid, sampleKey3); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+		}
+		// A failed check leaves the key write-locked but unmodified
+		if ok, err := s.CheckAndSet(tid, map[Key]Value{sampleKey2: sampleValue2}, nil); err != nil || ok {
+			t.Errorf("did not get expected check result for key='%s'. expected=false, actual=%v, err=%v", sampleKey2, ok, err)
+		}
+
+		reads, writes, err := s.TransactionReadWriteSets(tid)
+		if err != nil {
+			t.Errorf("got an error while getting read and write sets: %v", err)
+		}
+		if wantReads := []Key{sampleKey1}; !reflect.DeepEqual(reads, wantReads) {
+			t.Errorf("did not get expected read set. expected=%v, actual=%v", wantReads, reads)
+		}
+		if wantWrites := []Key{sampleKey2, sampleKey3, sampleKey4}; !reflect.DeepEqual(writes, wantWrites) {
+			t.Errorf("did not get expected write set. expected=%v, actual=%v", wantWrites, writes)
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		if _, _, err := s.TransactionReadWriteSets(tid); err == nil {
+			t.Error("did not get expected error while getting read and write sets of ended transaction.")
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		from, to   Key
+		overwrite  bool
+		wantError  error
+		wantValues map[Key]Value
+	}{
+		{ // Rename to new key
+			from: sampleKey1,
+			to:   sampleKey4,
+			wantValues: map[Key]Value{
+				sampleKey1: nil,
+				sampleKey4: sampleValue1,
+			},
+		},
+		{ // Missing source
+			from:      sampleKey4,
+			to:        sampleKey1,
+			wantError: ErrKeyNotFound,
+			wantValues: map[Key]Value{
+				sampleKey1: sampleValue1,
+				sampleKey4: nil,
+			},
+		},
+		{ // Existing destination
+			from:      sampleKey2,
+			to:        sampleKey1,
+			wantError: ErrKeyExists,
+			wantValues: map[Key]Value{
+				sampleKey1: sampleValue1,
+				sampleKey2: sampleValue2,
+			},
+		},
+		{ // Existing destination overwritten
+			from:      sampleKey2,
+			to:        sampleKey1,
+			overwrite: true,
+			wantValues: map[Key]Value{
+				sampleKey1: sampleValue2,
+				sampleKey2: nil,
+			},
+		},
+	}
+
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		for _, test := range tests {
+			s := newStoreForTest(t)
+			setForTest(t, s, sampleKey1, sampleValue1)
+			setForTest(t, s, sampleKey2, sampleValue2)
+
+			tid := s.BeginTransactionWithOptions(opts)
+			if err := s.Rename(tid, test.from, test.to, test.overwrite); !errors.Is(err, test.wantError) {
+				t.Errorf("did not get expected error while renaming key='%s' to key='%s'. expected=%v, actual=%v", test.from, test.to, test.wantError, err)
+			}
+			if err := s.Commit(tid); err != nil {
+				t.Errorf("got an error while committing transaction: %v", err)
+			}
+			for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+				for k, v := range test.wantValues {
+					checkStoreValue(t, s, k, v)
+				}
+			}
+		}
+	}
+}
+
+func TestGetDelete(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		if gotV, err := s.GetDelete(tid, sampleKey1); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+		} else if !bytes.Equal(gotV, sampleValue1) {
+			t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue1, gotV)
+		}
+		for _, k := range []Key{sampleKey1, sampleKey2} {
+			if _, err := s.GetDelete(tid, k); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("did not get expected error while deleting key='%s'. expected=%v, actual=%v", k, ErrKeyNotFound, err)
+			}
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, nil)
+			checkStoreValue(t, s, sampleKey2, nil)
+		}
+	}
+}
+
+func TestAbortWithProgress(t *testing.T) {
+	keys := []Key{sampleKey1, sampleKey2, sampleKey3, sampleKey4}
+	setAllForTest := func(s *Store) TransactionID {
+		tid := s.BeginTransaction()
+		for _, k := range keys {
+			if err := s.Set(tid, k, CopyByteArray(sampleValue3)); err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			}
+		}
+		return tid
+	}
+
+	// Progress is reported for each undone update
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	tid := setAllForTest(s)
+	var gotProgress [][2]int
+	if err := s.AbortWithProgress(context.Background(), tid, func(undone, total int) {
+		gotProgress = append(gotProgress, [2]int{undone, total})
+	}); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	wantProgress := [][2]int{{1, 4}, {2, 4}, {3, 4}, {4, 4}}
+	if !reflect.DeepEqual(gotProgress, wantProgress) {
+		t.Errorf("did not get expected progress. expected=%v, actual=%v", wantProgress, gotProgress)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// A cancelled abort is resumed by aborting again, or by recovery
+	for _, resume := range []string{"abort", "recovery"} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey1, sampleValue1)
+		tid := setAllForTest(s)
+		ctx, cancel := context.WithCancel(context.Background())
+		err := s.AbortWithProgress(ctx, tid, func(undone, total int) {
+			if undone == 2 {
+				cancel()
+			}
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("did not get expected error while aborting transaction. expected=%v, actual=%v", context.Canceled, err)
+		}
+
+		if resume == "abort" {
+			if err := s.Abort(tid); err != nil {
+				t.Errorf("got an error while resuming abort: %v", err)
+			}
+		} else {
+			s = reopenStoreForTest(t, s)
+		}
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+		for _, k := range keys[1:] {
+			checkStoreValue(t, s, k, nil)
+		}
+		if resume == "abort" {
+			// Each update is undone exactly once
+			var undos int
+			for _, e := range s.lm.log.Entry {
+				if e.GetEntryType() == pb.LogEntry_UNDO {
+					undos++
+				}
+			}
+			if undos != len(keys) {
+				t.Errorf("did not get expected number of UNDO entries. expected=%d, actual=%d", len(keys), undos)
+			}
+		}
+	}
+}
+
+func TestEmptyValue(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		s := newStoreForTest(t, WithLogCompression(opts.DeferWrites))
+		setForTest(t, s, sampleKey2, sampleValue2)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		for _, k := range []Key{sampleKey1, sampleKey2} {
+			if err := s.Set(tid, k, Value{}); err != nil {
+				t.Errorf("got an error while setting value for key='%s': %v", k, err)
+			}
+		}
+		if err := s.Set(tid, sampleKey3, nil); err == nil {
+			t.Errorf("did not get an error while setting nil value.")
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		reopened := reopenStoreForTest(t, s)
+		for _, s := range []*Store{s, reopened} {
+			for _, k := range []Key{sampleKey1, sampleKey2} {
+				tid := beginForTest(s)
+				if gotV, err := s.Get(tid, k); err != nil {
+					t.Errorf("got an error while getting value for key='%s': %v", k, err)
+				} else if gotV == nil || len(gotV) != 0 {
+					t.Errorf("did not get back an empty value for key='%s'. actual=%#v", k, gotV)
+				}
+				s.Abort(tid)
+			}
+			checkStoreValue(t, s, sampleKey3, nil)
+		}
+		s = reopened
+
+		// Overwriting an empty value is undone back to the empty value
+		tid = s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		if err := s.Compact(); err != nil {
+			t.Errorf("got an error while compacting log: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, Value{})
+		}
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	// Nil values can not be set
+	tid := s.BeginTransaction()
+	setters := map[string]func() error{
+		"Set": func() error { return s.Set(tid, sampleKey1, nil) },
+		"SetWithMeta": func() error {
+			return s.SetWithMeta(tid, sampleKey1, nil, Meta{ContentType: "text/plain"})
+		},
+		"SetIfVersion": func() error { return s.SetIfVersion(tid, sampleKey1, nil, 1) },
+		"SetIfChanged": func() error {
+			_, err := s.SetIfChanged(tid, sampleKey1, nil)
+			return err
+		},
+	}
+	for name, set := range setters {
+		if err := set(); !errors.Is(err, ErrNilValue) {
+			t.Errorf("did not get expected error from %s with nil value. expected=%v, actual=%v", name, ErrNilValue, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Absent and deleted keys are not found
+	tid = s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	for _, k := range []Key{sampleKey1, sampleKey2} {
+		tid := s.BeginTransaction()
+		if v, err := s.Get(tid, k); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("did not get expected error for key='%s'. expected=%v, actual=(%v, %v)", k, ErrKeyNotFound, v, err)
+		}
+		s.Abort(tid)
+	}
+
+	// A key whose creation is aborted while it is being read is not found
+	for _, opts := range []TransactionOptions{{}, {Isolation: ReadCommitted}} {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+		}
+		read := make(chan error)
+		go func() {
+			tid := s.BeginTransactionWithOptions(opts)
+			defer s.Abort(tid)
+			v, err := s.Get(tid, sampleKey3)
+			if err == nil && v == nil {
+				err = fmt.Errorf("got nil value")
+			}
+			read <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		if err := <-read; !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("did not get expected error for key='%s'. expected=%v, actual=%v", sampleKey3, ErrKeyNotFound, err)
+		}
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	tests := []struct {
+		offset, length int
+		wantValue      Value
+		wantErr        error
+	}{
+		{0, len(sampleValue1), sampleValue1, nil},
+		{1, 3, sampleValue1[1:4], nil},
+		{3, len(sampleValue1) - 3, sampleValue1[3:], nil},
+		{2, 0, Value{}, nil},
+		{len(sampleValue1), 0, Value{}, nil},
+		{0, len(sampleValue1) + 1, nil, ErrRangeOutOfBounds},
+		{len(sampleValue1) + 1, 0, nil, ErrRangeOutOfBounds},
+		{4, 2, nil, ErrRangeOutOfBounds},
+		{-1, 2, nil, ErrRangeOutOfBounds},
+		{1, -1, nil, ErrRangeOutOfBounds},
+	}
+
+	tid := s.BeginTransaction()
+	for _, test := range tests {
+		gotValue, err := s.GetRange(tid, sampleKey1, test.offset, test.length)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("did not get expected error for range [%d, %d). expected=%v, actual=%v", test.offset, test.offset+test.length, test.wantErr, err)
+		}
+		if !reflect.DeepEqual(gotValue, test.wantValue) {
+			t.Errorf("did not get expected value for range [%d, %d). expected=%v, actual=%v", test.offset, test.offset+test.length, test.wantValue, gotValue)
+		}
+	}
+	// The returned range is a copy
+	if gotValue, err := s.GetRange(tid, sampleKey1, 0, 2); err != nil {
+		t.Errorf("got an error while getting range of key='%s': %v", sampleKey1, err)
+	} else {
+		gotValue[0] = 0
+	}
+	if _, err := s.GetRange(tid, sampleKey2, 0, 0); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for missing key='%s'. expected=%v, actual=%v", sampleKey2, ErrKeyNotFound, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+}
+
+func TestAppend(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		tests := []struct {
+			k         Key
+			suffix    Value
+			wantLen   int
+			wantValue Value
+		}{
+			{sampleKey1, sampleValue2, 10, append(CopyByteArray(sampleValue1), sampleValue2...)},
+			{sampleKey1, nil, 10, append(CopyByteArray(sampleValue1), sampleValue2...)},
+			{sampleKey2, sampleValue3, 5, sampleValue3},
+			{sampleKey3, nil, 0, Value{}},
+		}
+
+		// Appends are committed
+		tid := s.BeginTransactionWithOptions(opts)
+		for _, test := range tests {
+			if gotLen, err := s.Append(tid, test.k, test.suffix); err != nil {
+				t.Errorf("got an error while appending to key='%s': %v", test.k, err)
+			} else if gotLen != test.wantLen {
+				t.Errorf("did not get expected length after append to key='%s'. expected=%d, actual=%d", test.k, test.wantLen, gotLen)
+			}
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		reopened := reopenStoreForTest(t, s)
+		for _, s := range []*Store{s, reopened} {
+			for _, test := range tests {
+				checkStoreValue(t, s, test.k, test.wantValue)
+			}
+		}
+		s = reopened
+
+		// Aborted appends are undone
+		tid = s.BeginTransactionWithOptions(opts)
+		for _, k := range []Key{sampleKey1, sampleKey4} {
+			if _, err := s.Append(tid, k, sampleValue1); err != nil {
+				t.Errorf("got an error while appending to key='%s': %v", k, err)
+			}
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, tests[0].wantValue)
+			checkStoreValue(t, s, sampleKey4, nil)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t)
+		values := map[Key]Value{
+			"user/1":  sampleValue1,
+			"user/2":  sampleValue2,
+			"user/20": sampleValue3,
+			"users":   sampleValue1,
+			"group/1": sampleValue2,
+		}
+		for k, v := range values {
+			setForTest(t, s, k, v)
+		}
+
+		// Aborted deletes are undone
+		tid := s.BeginTransactionWithOptions(opts)
+		if err := s.Set(tid, "user/3", CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", "user/3", err)
+		}
+		if gotN, err := s.DeletePrefix(tid, "user/"); err != nil {
+			t.Errorf("got an error while deleting prefix: %v", err)
+		} else if gotN != 4 {
+			t.Errorf("did not get expected number of deleted keys. expected=%d, actual=%d", 4, gotN)
+		}
+		if _, err := s.Get(tid, "user/1"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("did not get expected error for deleted key='%s'. expected=%v, actual=%v", "user/1", ErrKeyNotFound, err)
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		reopened := reopenStoreForTest(t, s)
+		for _, s := range []*Store{s, reopened} {
+			for k, v := range values {
+				checkStoreValue(t, s, k, v)
+			}
+			checkStoreValue(t, s, "user/3", nil)
+		}
+		s = reopened
+
+		// Committed deletes remove only the matching keys
+		tid = s.BeginTransactionWithOptions(opts)
+		if gotN, err := s.DeletePrefix(tid, "user/"); err != nil {
+			t.Errorf("got an error while deleting prefix: %v", err)
+		} else if gotN != 3 {
+			t.Errorf("did not get expected number of deleted keys. expected=%d, actual=%d", 3, gotN)
+		}
+		if gotN, err := s.DeletePrefix(tid, "user/"); err != nil || gotN != 0 {
+			t.Errorf("did not get expected result of deleting prefix again. expected=(0, <nil>), actual=(%d, %v)", gotN, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			for k, v := range values {
+				if strings.HasPrefix(string(k), "user/") {
+					v = nil
+				}
+				checkStoreValue(t, s, k, v)
+			}
+		}
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey1, sampleValue1)
+		setForTest(t, s, sampleKey2, sampleValue2)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		tests := []struct {
+			k           Key
+			expected    Value
+			wantDeleted bool
+			wantError   error
+		}{
+			{sampleKey1, sampleValue1, true, nil},             // matching value
+			{sampleKey2, sampleValue1, false, nil},            // mismatching value
+			{sampleKey3, sampleValue1, false, ErrKeyNotFound}, // missing key
+			{sampleKey1, sampleValue1, false, ErrKeyNotFound}, // deleted by the transaction
+		}
+		for _, test := range tests {
+			gotDeleted, err := s.DeleteIf(tid, test.k, test.expected)
+			if !errors.Is(err, test.wantError) {
+				t.Errorf("did not get expected error while deleting key='%s'. expected=%v, actual=%v", test.k, test.wantError, err)
+			}
+			if gotDeleted != test.wantDeleted {
+				t.Errorf("did not get expected result of deleting key='%s'. expected=%v, actual=%v", test.k, test.wantDeleted, gotDeleted)
+			}
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, nil)
+			checkStoreValue(t, s, sampleKey2, sampleValue2)
+			checkStoreValue(t, s, sampleKey3, nil)
+		}
+	}
+}
+
+func TestCheckAndSet(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t)
+		setForTest(t, s, sampleKey1, sampleValue1)
+		setForTest(t, s, sampleKey2, sampleValue2)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		tests := []struct {
+			checks  map[Key]Value
+			sets    map[Key]Value
+			wantSet bool
+		}{
+			// all values match
+			{map[Key]Value{sampleKey1: sampleValue1, sampleKey2: sampleValue2}, map[Key]Value{sampleKey1: sampleValue3, sampleKey4: sampleValue3}, true},
+			// some values do not match
+			{map[Key]Value{sampleKey1: sampleValue3, sampleKey2: sampleValue1}, map[Key]Value{sampleKey1: sampleValue1, sampleKey2: sampleValue1}, false},
+			// missing key checked for a value
+			{map[Key]Value{sampleKey1: sampleValue3, sampleKey3: sampleValue1}, map[Key]Value{sampleKey2: sampleValue3}, false},
+			// missing key checked for not existing
+			{map[Key]Value{sampleKey3: nil}, map[Key]Value{sampleKey3: sampleValue1, sampleKey5: sampleValue1}, true},
+			// existing key checked for not existing
+			{map[Key]Value{sampleKey2: nil}, map[Key]Value{sampleKey2: sampleValue1}, false},
+		}
+		for i, test := range tests {
+			gotSet, err := s.CheckAndSet(tid, test.checks, test.sets)
+			if err != nil {
+				t.Errorf("got an error while checking and setting keys (test %d): %v", i, err)
+			}
+			if gotSet != test.wantSet {
+				t.Errorf("did not get expected result of checking and setting keys (test %d). expected=%v, actual=%v", i, test.wantSet, gotSet)
+			}
+		}
+		if _, err := s.CheckAndSet(tid, nil, map[Key]Value{sampleKey1: nil}); !errors.Is(err, ErrNilValue) {
+			t.Errorf("did not get expected error while checking and setting keys. expected=%v, actual=%v", ErrNilValue, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, sampleValue3)
+			checkStoreValue(t, s, sampleKey2, sampleValue2)
+			checkStoreValue(t, s, sampleKey3, sampleValue1)
+			checkStoreValue(t, s, sampleKey4, sampleValue3)
+			checkStoreValue(t, s, sampleKey5, sampleValue1)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue2)
+
+	tid := s.BeginTransaction()
+	ts := s.lm.transactions[tid]
+	checkGet := func(k Key, want Value) {
+		gotV, err := s.Get(tid, k)
+		if want == nil {
+			if err == nil {
+				t.Errorf("found value for key='%s': %v", k, gotV)
+			}
+			return
+		}
+		if err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", k, err)
+		} else if !bytes.Equal(gotV, want) {
+			t.Errorf("did not get back the correct value. key='%s', expected=%v, actual=%v.", k, want, gotV)
+		}
+	}
+	checkCached := func(k Key, wantCached bool) {
+		if _, gotCached := ts.readCache[k]; gotCached != wantCached {
+			t.Errorf("did not get expected caching of key='%s'. expected=%v, actual=%v", k, wantCached, gotCached)
+		}
+	}
+
+	// Repeated reads are consistent, and served from the cache
+	for i := 0; i < 2; i++ {
+		checkGet(sampleKey1, sampleValue1)
+		checkCached(sampleKey1, true)
+	}
+	// Reads reflect writes made by the transaction
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	checkCached(sampleKey1, false)
+	checkGet(sampleKey1, sampleValue3)
+	checkCached(sampleKey1, true)
+	checkGet(sampleKey2, sampleValue2)
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	checkCached(sampleKey2, false)
+	checkGet(sampleKey2, nil)
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// Reads of ReadCommitted transactions that do not hold locks are not
+	// cached
+	tid = s.BeginTransactionWithOptions(TransactionOptions{Isolation: ReadCommitted})
+	ts = s.lm.transactions[tid]
+	checkGet(sampleKey1, sampleValue1)
+	checkCached(sampleKey1, false)
+	setForTest(t, s, sampleKey1, sampleValue2)
+	checkGet(sampleKey1, sampleValue2)
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+}
+
+func TestCommitWithLSN(t *testing.T) {
+	s := newStoreForTest(t)
+	lastLSN := int64(-1)
+	for i, k := range []Key{sampleKey1, sampleKey2, sampleKey1} {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, k, Value(fmt.Sprintf("%s_%d", k, i))); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		lsn, err := s.CommitWithLSN(tid)
+		if err != nil {
+			t.Fatalf("got an error while committing transaction: %v", err)
+		}
+		if lsn <= lastLSN {
+			t.Errorf("found that commit LSN did not increase. previous=%d, actual=%d", lastLSN, lsn)
+		}
+		lastLSN = lsn
+		e := s.lm.log.Entry[lsn]
+		if e.GetEntryType() != pb.LogEntry_END || TransactionID(e.GetTid()) != tid {
+			t.Errorf("did not find END entry of transaction %d at commit LSN %d. actual=(%+v)", tid, lsn, e)
+		}
+	}
+	if _, err := s.CommitWithLSN(TransactionID(1000)); err == nil {
+		t.Errorf("did not get an error while committing transaction that is not running.")
+	}
+}
+
+func TestDeleteThenSet(t *testing.T) {
+	for _, opts := range []TransactionOptions{{}, deferWritesOptions} {
+		s := newStoreForTest(t, WithLockTimeout(20*time.Millisecond))
+		setForTest(t, s, sampleKey1, sampleValue1)
+
+		tid := s.BeginTransactionWithOptions(opts)
+		if err := s.Delete(tid, sampleKey1); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		// The lock held by the transaction is the lock of the key in the
+		// store, so other transactions can not take it
+		if rw, _ := s.lm.currMutexes[tid].get(sampleKey1); rw == nil || rw.smvLock != &s.lm.store[sampleKey1].lock {
+			t.Errorf("found that transaction does not hold the lock of key='%s' in the store.", sampleKey1)
+		}
+		other := s.BeginTransaction()
+		if _, err := s.Get(other, sampleKey1); !errors.Is(err, ErrTimeout) {
+			t.Errorf("did not get expected error while getting locked key='%s'. expected=%v, actual=%v", sampleKey1, ErrTimeout, err)
+		}
+		if err := s.Abort(other); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+
+		// An aborted delete-then-set restores the value and its version
+		tid = s.BeginTransactionWithOptions(opts)
+		if err := s.Delete(tid, sampleKey1); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+		for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+			checkStoreValue(t, s, sampleKey1, sampleValue2)
+			if report, err := s.Verify(); err != nil || !report.OK() {
+				t.Errorf("did not get expected report for store. actual=(%+v, %v)", report, err)
+			}
+		}
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	s := newStoreForTest(t)
+	for _, k := range []Key{sampleKey1, sampleKey2, sampleKey3} {
+		setForTest(t, s, k, sampleValue1)
+	}
+	tid := s.BeginTransaction()
+	for _, k := range []Key{sampleKey1, sampleKey2} {
+		if err := s.Delete(tid, k); err != nil {
+			t.Errorf("got an error while deleting key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkTombstones := func(want int) {
+		if got := s.Stats().Tombstones; got != want {
+			t.Errorf("did not get expected number of tombstones. expected=%d, actual=%d", want, got)
+		}
+	}
+	checkTombstones(2)
+
+	// Tombstoned keys are not found
+	tid = s.BeginTransaction()
+	if _, err := s.Get(tid, sampleKey1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for deleted key='%s'. expected=%v, actual=%v", sampleKey1, ErrKeyNotFound, err)
+	}
+	if _, _, err := s.KeyStats(sampleKey1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not get expected error for stats of deleted key='%s'. expected=%v, actual=%v", sampleKey1, ErrKeyNotFound, err)
+	}
+	// Undoing a delete clears the tombstone
+	if err := s.Delete(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+	}
+	checkTombstones(3)
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	checkTombstones(2)
+	checkStoreValue(t, s, sampleKey3, sampleValue1)
+	// Setting a tombstoned key clears the tombstone
+	setForTest(t, s, sampleKey2, sampleValue2)
+	checkTombstones(1)
+
+	// Compaction collects tombstones that running transactions do not refer
+	// to
+	tid = s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+	}
+	smv := s.lm.store[sampleKey3]
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	checkTombstones(1)
+	if _, ok := s.lm.store[sampleKey1]; ok {
+		t.Errorf("found tombstone of key='%s' after compaction.", sampleKey1)
+	}
+	if s.lm.store[sampleKey3] != smv {
+		t.Errorf("found that tombstone of key='%s' referred to by a running transaction was collected.", sampleKey3)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	checkTombstones(0)
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		checkStoreValue(t, s, sampleKey1, nil)
+		checkStoreValue(t, s, sampleKey2, sampleValue2)
+		checkStoreValue(t, s, sampleKey3, sampleValue1)
+	}
+}
+
+func TestSetIfChanged(t *testing.T) {
+	metrics := newTestMetrics()
+	s := newStoreForTest(t, WithMetrics(metrics), WithLockTimeout(100*time.Millisecond))
+	setForTest(t, s, sampleKey1, sampleValue1)
+	logEntries := func() int {
+		metrics.lock.Lock()
+		defer metrics.lock.Unlock()
+		return metrics.counters[MetricLogEntries]
+	}
+
+	tid := s.BeginTransaction()
+	tests := []struct {
+		k           Key
+		v           Value
+		wantSet     bool
+		wantEntries int
+	}{
+		{sampleKey1, sampleValue1, false, 0}, // unchanged
+		{sampleKey1, sampleValue2, true, 1},  // changed
+		{sampleKey1, sampleValue2, false, 0}, // unchanged by the transaction
+		{sampleKey2, sampleValue2, true, 1},  // new key
+	}
+	for _, test := range tests {
+		before := logEntries()
+		gotSet, err := s.SetIfChanged(tid, test.k, CopyByteArray(test.v))
+		if err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", test.k, err)
+		}
+		if gotSet != test.wantSet {
+			t.Errorf("did not get expected result of setting value for key='%s'. expected=%v, actual=%v", test.k, test.wantSet, gotSet)
+		}
+		if gotEntries := logEntries() - before; gotEntries != test.wantEntries {
+			t.Errorf("did not get expected number of log entries written. expected=%d, actual=%d", test.wantEntries, gotEntries)
+		}
+	}
+	if _, err := s.SetIfChanged(tid, sampleKey1, nil); err == nil {
+		t.Errorf("did not get an error while setting nil value.")
+	}
+
+	// An unchanged key is only locked for reading
+	other := s.BeginTransaction()
+	if gotSet, err := s.SetIfChanged(other, sampleKey3, CopyByteArray(sampleValue3)); err != nil || !gotSet {
+		t.Errorf("did not get expected result of setting value for key='%s'. expected=(true, <nil>), actual=(%v, %v)", sampleKey3, gotSet, err)
+	}
+	if err := s.Commit(other); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	tid = s.BeginTransaction()
+	other = s.BeginTransaction()
+	for _, tid := range []TransactionID{tid, other} {
+		if gotSet, err := s.SetIfChanged(tid, sampleKey3, CopyByteArray(sampleValue3)); err != nil || gotSet {
+			t.Errorf("did not get expected result of setting value for key='%s'. expected=(false, <nil>), actual=(%v, %v)", sampleKey3, gotSet, err)
+		}
+	}
+	for _, tid := range []TransactionID{tid, other} {
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue2)
+	checkStoreValue(t, s, sampleKey2, sampleValue2)
+	checkStoreValue(t, s, sampleKey3, sampleValue3)
+}
+
+func TestAbortAll(t *testing.T) {
+	s := newStoreForTest(t, WithLockTimeout(100*time.Millisecond))
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	var tids []TransactionID
+	for i, opts := range []TransactionOptions{{}, deferWritesOptions, blindWritesOptions} {
+		tid := s.BeginTransactionWithOptions(opts)
+		k := Key(fmt.Sprintf("key_abort_%d", i))
+		if err := s.Set(tid, k, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+		tids = append(tids, tid)
+	}
+	readTID := s.BeginTransaction()
+	if _, err := s.Get(readTID, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	}
+	tids = append(tids, readTID)
+	writeTID := s.BeginTransaction()
+	if err := s.Set(writeTID, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	tids = append(tids, writeTID)
+	preparedTID := s.BeginTransaction()
+	if err := s.Set(preparedTID, sampleKey3, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Prepare(preparedTID); err != nil {
+		t.Errorf("got an error while preparing transaction: %v", err)
+	}
+
+	if err := s.AbortAll(); err != nil {
+		t.Errorf("got an error while aborting all transactions: %v", err)
+	}
+	if _, ok := s.lm.currMutexes[preparedTID]; !ok || len(s.lm.currMutexes) != 1 {
+		t.Errorf("did not get expected running transactions. expected=[%d], actual=%v", preparedTID, s.lm.currMutexes)
+	}
+	for _, tid := range tids {
+		if err := s.Abort(tid); err == nil {
+			t.Errorf("did not get an error while aborting aborted transaction with ID %d.", tid)
+		}
+	}
+
+	// The locks held by the aborted transactions are released
+	tid := s.BeginTransaction()
+	for _, k := range []Key{sampleKey1, sampleKey2, "key_abort_0"} {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	for _, k := range []Key{"key_abort_1", "key_abort_2"} {
+		checkStoreValue(t, s, k, nil)
+	}
+	if err := s.CommitPrepared(preparedTID); err != nil {
+		t.Errorf("got an error while committing prepared transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey3, sampleValue3)
+}
+
+func TestTryGet(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	writerTID := s.BeginTransaction()
+	if err := s.Set(writerTID, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if v, ok, err := s.TryGet(writerTID, sampleKey1); err != nil || !ok || !bytes.Equal(v, sampleValue2) {
+		t.Errorf("did not get own write for key='%s'. expected=(%v, true), actual=(%v, %t, %v)", sampleKey1, sampleValue2, v, ok, err)
+	}
+
+	for _, isolation := range []IsolationLevel{Serializable, ReadCommitted} {
+		tid := s.BeginTransactionWithOptions(TransactionOptions{Isolation: isolation})
+		start := time.Now()
+		v, ok, err := s.TryGet(tid, sampleKey1)
+		if err != nil || ok || v != nil {
+			t.Errorf("did not get expected result for write-locked key='%s' with isolation=%d. expected=(nil, false, nil), actual=(%v, %t, %v)", sampleKey1, isolation, v, ok, err)
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("found that read of write-locked key was blocked. elapsed=%v", elapsed)
+		}
+		if _, _, err := s.TryGet(tid, sampleKey2); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("did not get expected error for key='%s'. expected=%v, actual=%v", sampleKey2, ErrKeyNotFound, err)
+		}
+		s.Abort(tid)
+	}
+
+	if err := s.Commit(writerTID); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	tid := s.BeginTransaction()
+	if v, ok, err := s.TryGet(tid, sampleKey1); err != nil || !ok || !bytes.Equal(v, sampleValue2) {
+		t.Errorf("did not get value for key='%s'. expected=(%v, true), actual=(%v, %t, %v)", sampleKey1, sampleValue2, v, ok, err)
+	}
+	// The read lock is held, as for Get
+	if rw, _ := s.lm.currMutexes[tid].get(sampleKey1); !rw.rLocked() {
+		t.Errorf("did not find read lock held on key='%s'.", sampleKey1)
+	}
+	s.Abort(tid)
+}
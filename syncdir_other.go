@@ -0,0 +1,8 @@
+//go:build !unix
+
+package gostore
+
+// syncDir does nothing, since directories are not synced on this platform.
+var syncDir = func(dir string) error {
+	return nil
+}
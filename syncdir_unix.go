@@ -0,0 +1,19 @@
+//go:build unix
+
+package gostore
+
+import "os"
+
+// syncDir syncs directory dir, so that the files created in, renamed into and
+// removed from it are durable.
+var syncDir = func(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,118 @@
+package gostore
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"sync"
+)
+
+// signalLogFlushedUnsafe wakes up the tails of the log waiting for entries to
+// be flushed. It must be called with logLock held.
+func (lm *logManager) signalLogFlushedUnsafe() {
+	close(lm.logFlushed)
+	lm.logFlushed = make(chan struct{})
+}
+
+// tailLog returns a channel on which the UPDATE and COMMIT entries of
+// committed transactions whose COMMIT entries have LSNs from fromLSN onward
+// are delivered (including UPDATE entries before fromLSN), and a function that
+// stops the tail and closes the channel. The channel is also
+// closed when the store is closed. Entries are delivered
+// once they have been flushed, so a COMMIT entry that is followed by an ABORT
+// entry (because it could not be flushed) is always seen along with it.
+func (lm *logManager) tailLog(fromLSN int64) (<-chan pb.LogEntry, func()) {
+	c, cancel, _ := lm.tailLogWithCompactions(fromLSN)
+	return c, cancel
+}
+
+// tailLogWithCompactions starts a tail of the log like tailLog, and also
+// returns the number of times the log had been compacted when the tail was
+// started. The tail is closed once the log is compacted again. A tail from an
+// LSN (other than 0) before the first LSN of the log is closed at once, since
+// the entries removed by the compaction would not be delivered; a tail from 0
+// delivers the whole log, whose first transaction sets every key if it has
+// been compacted.
+func (lm *logManager) tailLogWithCompactions(fromLSN int64) (<-chan pb.LogEntry, func(), int) {
+	c := make(chan pb.LogEntry)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+
+	lm.logLock.Lock()
+	compactions, firstLSN := lm.compactions, int64(lm.firstLSN)
+	lm.logLock.Unlock()
+	if fromLSN > 0 && fromLSN < firstLSN {
+		close(c)
+		return c, cancel, compactions
+	}
+	started := lm.maintenance.start(func(stopping <-chan struct{}) {
+		defer close(c)
+		// The log is read from the beginning, since transactions committed
+		// from fromLSN onward may have been updated before it.
+		next := firstLSN
+		updates := make(map[TransactionID][]*pb.LogEntry)
+		for {
+			lm.logLock.Lock()
+			if lm.compactions != compactions {
+				// The entries have been replaced.
+				lm.logLock.Unlock()
+				return
+			}
+			var entries []*pb.LogEntry
+			for lsn := next; lsn < int64(lm.nextLSNToFlush); lsn++ {
+				entries = append(entries, proto.Clone(lm.logEntry(lsn)).(*pb.LogEntry))
+			}
+			if int64(lm.nextLSNToFlush) > next {
+				next = int64(lm.nextLSNToFlush)
+			}
+			flushed := lm.logFlushed
+			lm.logLock.Unlock()
+
+			// A transaction whose COMMIT entry could not be flushed is
+			// aborted before the log is next flushed, so its ABORT entry is
+			// flushed along with its COMMIT entry.
+			analysis := analyzeLogEntries(entries)
+			for _, e := range entries {
+				tid := TransactionID(e.GetTid())
+				var emit []*pb.LogEntry
+				switch e.GetEntryType() {
+				case pb.LogEntry_UPDATE:
+					updates[tid] = append(updates[tid], e)
+				case pb.LogEntry_COMMIT:
+					if analysis[tid].status == statusCommitted && e.GetLsn() >= fromLSN {
+						emit = append(updates[tid], e)
+					}
+					delete(updates, tid)
+				case pb.LogEntry_ABORT:
+					delete(updates, tid)
+				}
+				for _, e := range emit {
+					select {
+					case c <- *e:
+					case <-done:
+						return
+					case <-stopping:
+						return
+					}
+				}
+			}
+
+			select {
+			case <-flushed:
+			case <-done:
+				return
+			case <-stopping:
+				return
+			}
+		}
+	})
+	if !started {
+		// The store has been closed.
+		close(c)
+	}
+	return c, cancel, compactions
+}
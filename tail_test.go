@@ -0,0 +1,91 @@
+package gostore
+
+import (
+	"bytes"
+	pb "github.com/mDibyo/gostore/pb"
+	"testing"
+	"time"
+)
+
+// receiveLogEntryForTest receives an entry from a log tail, failing the test
+// if none is delivered in time.
+func receiveLogEntryForTest(t *testing.T, c <-chan pb.LogEntry) pb.LogEntry {
+	select {
+	case e, ok := <-c:
+		if !ok {
+			t.Fatalf("found that log tail was closed.")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("did not receive log entry from log tail.")
+	}
+	return pb.LogEntry{}
+}
+
+func TestLogTail(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey3, sampleValue3)
+	s.lm.logLock.Lock()
+	fromLSN := int64(s.lm.nextLSN)
+	s.lm.logLock.Unlock()
+
+	c, cancel := s.LogTail(fromLSN)
+	abortedTID := s.BeginTransaction()
+	if err := s.Set(abortedTID, sampleKey3, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Abort(abortedTID); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	want := []struct {
+		entryType pb.LogEntry_LogEntryType
+		key       Key
+		value     Value
+	}{
+		{pb.LogEntry_UPDATE, sampleKey1, sampleValue1},
+		{pb.LogEntry_UPDATE, sampleKey2, sampleValue2},
+		{pb.LogEntry_COMMIT, "", nil},
+	}
+	for _, w := range want {
+		e := receiveLogEntryForTest(t, c)
+		if e.GetTid() != int64(tid) || e.GetEntryType() != w.entryType || Key(e.GetKey()) != w.key || !bytes.Equal(e.NewValue, w.value) {
+			t.Errorf("did not get expected log entry. expected=(%d %v %s %v), actual=%v", tid, w.entryType, w.key, w.value, &e)
+		}
+	}
+
+	cancel()
+	for range c {
+	}
+}
+
+func TestLogTailCompaction(t *testing.T) {
+	s := newStoreForTest(t)
+	c, cancel := s.LogTail(0)
+	defer cancel()
+	setForTest(t, s, sampleKey1, sampleValue1)
+	for i := 0; i < 2; i++ {
+		receiveLogEntryForTest(t, c)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("got an error while compacting log: %v", err)
+	}
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Errorf("received log entry after compaction.")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("found that log tail was not closed after compaction.")
+	}
+}
@@ -1,39 +1,108 @@
 package gostore
 
+// IsolationLevel determines how a transaction's reads interact with other
+// transactions.
+type IsolationLevel int
+
+const (
+	// Serializable transactions hold read locks on the keys they read until
+	// they are committed or aborted (Strict 2PL).
+	Serializable IsolationLevel = iota
+	// ReadCommitted transactions hold read locks only for the duration of a
+	// read, so they do not block writers. Repeated reads of a key may return
+	// different committed values.
+	ReadCommitted
+)
+
+// TransactionOptions configures a transaction when it begins.
+type TransactionOptions struct {
+	Isolation IsolationLevel // the isolation level of the transaction
+
+	// DeferWrites buffers the writes of the transaction and applies them to
+	// the store only when it is committed. This avoids copying values to undo
+	// the writes, and makes aborting the transaction cheap.
+	DeferWrites bool
+
+	// BlindWrites buffers the writes of the transaction like DeferWrites, but
+	// takes the write locks on the written keys only while the writes are
+	// applied, when it is committed. Concurrent transactions can write the same
+	// keys without waiting for each other, and the value of the last one to be
+	// committed wins. Blind writes do not protect against lost updates: a key
+	// read by the transaction may be overwritten by another transaction before
+	// it is committed.
+	BlindWrites bool
+
+	// BulkLoad defers the writes of the transaction like DeferWrites, and
+	// omits the old values of the written keys from its log entries, for
+	// loading data that can be loaded again from scratch if it fails. This
+	// shrinks the log, but the transaction can not be prepared, and if it is
+	// aborted (or the store is closed before it is committed) its writes are
+	// discarded rather than undone.
+	BulkLoad bool
+
+	// Identity identifies the user of the transaction to the authorizer of the
+	// store (see WithAuthorizer).
+	Identity string
+}
+
 // Transaction is an atomic operation or set of operations on the store.
 type Transaction struct {
 	tid TransactionID
+	err error // the error opening the package-level store, if any
 }
 
-// New Transaction creates a new transaction and returns it.
+// New Transaction creates a new transaction and returns it. If the
+// package-level store can not be opened, the operations of the transaction
+// return the error.
 func NewTransaction() Transaction {
-	t := Transaction{lmInstance.nextTransactionID()}
-	lmInstance.beginTransaction(t.tid)
+	lm, err := defaultLogManager()
+	if err != nil {
+		return Transaction{err: err}
+	}
+	t := Transaction{tid: lm.nextTransactionID()}
+	if err := lm.beginTransaction(t.tid); err != nil {
+		return Transaction{err: err}
+	}
 	return t
 }
 
 // Commit commits and ends Transaction.
 func (t Transaction) Commit() (err error) {
+	if t.err != nil {
+		return t.err
+	}
 	return lmInstance.commitTransaction(t.tid)
 }
 
 // Commit aborts and ends Transaction.
 func (t Transaction) Abort() (err error) {
+	if t.err != nil {
+		return t.err
+	}
 	return lmInstance.abortTransaction(t.tid)
 }
 
 // Get retrieves the value of a key in Transaction.
 func (t Transaction) Get(key Key) (value Value, err error) {
+	if t.err != nil {
+		return nil, t.err
+	}
 	return lmInstance.getValue(t.tid, key)
 }
 
 // Set sets the value of a key in Transaction.
 func (t Transaction) Set(key Key, value Value) (err error) {
+	if t.err != nil {
+		return t.err
+	}
 	return lmInstance.setValue(t.tid, key, value)
 }
 
 // Delete deletes a key in Transaction.
 func (t Transaction) Delete(key Key) (err error) {
+	if t.err != nil {
+		return t.err
+	}
 	return lmInstance.deleteValue(t.tid, key)
 }
 
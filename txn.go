@@ -0,0 +1,128 @@
+package gostore
+
+import (
+	"errors"
+	"sync"
+)
+
+// Txn is a handle to a transaction on a Store. Its methods operate on the
+// transaction it was begun with. Once it is committed or aborted, a Txn can no
+// longer be used, and its methods return ErrTransactionFinished.
+type Txn struct {
+	s        *Store
+	tid      TransactionID
+	lock     sync.Mutex // lock to synchronize finishing the transaction
+	finished bool       // whether the transaction has been committed or aborted
+}
+
+// Begin begins a new transaction on Store with the default isolation level of
+// the store (Serializable, unless configured otherwise) and returns a handle to
+// it. It fails with ErrStoreClosed if the store has been closed, with
+// ErrTooManyTransactions (instead of waiting) if the maximum number of
+// transactions are running, and with ErrDirectoryLocked if the store no longer
+// holds the lock on its log directory.
+func (s *Store) Begin() (*Txn, error) {
+	return s.BeginWithOptions(s.lm.defaultTransactionOptions())
+}
+
+// BeginWithOptions begins a new transaction configured by opts on Store like
+// Begin, and returns a handle to it.
+func (s *Store) BeginWithOptions(opts TransactionOptions) (*Txn, error) {
+	tid, err := s.TryBeginTransaction(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{s: s, tid: tid}, nil
+}
+
+// ID returns the ID of the transaction.
+func (txn *Txn) ID() TransactionID {
+	return txn.tid
+}
+
+func (txn *Txn) checkFinished() error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.finished {
+		return ErrTransactionFinished
+	}
+	return nil
+}
+
+// finish ends the transaction with end if it has not been finished.
+func (txn *Txn) finish(end func(TransactionID) error) error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.finished {
+		return ErrTransactionFinished
+	}
+	if err := end(txn.tid); err != nil {
+		return err
+	}
+	txn.finished = true
+	return nil
+}
+
+// Commit commits and ends the transaction.
+func (txn *Txn) Commit() error {
+	return txn.finish(txn.s.Commit)
+}
+
+// Abort aborts and ends the transaction.
+func (txn *Txn) Abort() error {
+	return txn.finish(txn.s.Abort)
+}
+
+// Get retrieves the value of a key in the transaction.
+func (txn *Txn) Get(k Key) (Value, error) {
+	if err := txn.checkFinished(); err != nil {
+		return nil, err
+	}
+	return txn.s.Get(txn.tid, k)
+}
+
+// Set sets the value of a key in the transaction.
+func (txn *Txn) Set(k Key, v Value) error {
+	if err := txn.checkFinished(); err != nil {
+		return err
+	}
+	return txn.s.Set(txn.tid, k, v)
+}
+
+// Delete deletes a key in the transaction.
+func (txn *Txn) Delete(k Key) error {
+	if err := txn.checkFinished(); err != nil {
+		return err
+	}
+	return txn.s.Delete(txn.tid, k)
+}
+
+// WithTransaction runs fn in a new transaction on Store. The transaction is
+// committed if fn returns nil, and aborted if fn returns an error or panics,
+// so its locks are always released. If fn returns an error, it is returned
+// joined with any error from aborting the transaction. If fn panics, the panic
+// is propagated after the transaction is aborted. Unlike Begin, it waits for a
+// running transaction to finish if the maximum number of them are running.
+func (s *Store) WithTransaction(fn func(txn *Txn) error) (err error) {
+	tid := s.lm.nextTransactionID()
+	if err := s.lm.beginTransaction(tid); err != nil {
+		return err
+	}
+	txn := &Txn{s: s, tid: tid}
+	defer func() {
+		if r := recover(); r != nil {
+			txn.Abort()
+			panic(r)
+		}
+	}()
+
+	if err = fn(txn); err != nil {
+		if abortErr := txn.Abort(); abortErr != nil && abortErr != ErrTransactionFinished {
+			err = errors.Join(err, abortErr)
+		}
+		return err
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,187 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	pb "github.com/mDibyo/gostore/pb"
+	"testing"
+)
+
+// beginTxnForTest begins a new transaction on s and returns a handle to it.
+func beginTxnForTest(t *testing.T, s *Store) *Txn {
+	txn, err := s.Begin()
+	if err != nil {
+		t.Fatalf("got an error while beginning transaction: %v", err)
+	}
+	return txn
+}
+
+func TestTxn(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey2, sampleValue2)
+
+	txn := beginTxnForTest(t, s)
+	if _, ok := s.lm.currMutexes[txn.ID()]; !ok {
+		t.Errorf("did not find transaction %d in current mutexes map.", txn.ID())
+	}
+	if err := txn.Set(sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if gotV, err := txn.Get(sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	} else if !bytes.Equal(gotV, sampleValue1) {
+		t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue1, gotV)
+	}
+	if err := txn.Delete(sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+	checkStoreValue(t, s, sampleKey2, nil)
+
+	txn = beginTxnForTest(t, s)
+	if err := txn.Set(sampleKey1, CopyByteArray(sampleValue3)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := txn.Abort(); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+}
+
+func TestBegin(t *testing.T) {
+	s := newStoreForTest(t, WithMaxTransactions(1))
+	txn := beginTxnForTest(t, s)
+	if err := txn.Set(sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+
+	// Too many transactions
+	if _, err := s.Begin(); !errors.Is(err, ErrTooManyTransactions) {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrTooManyTransactions, err)
+	}
+	if _, err := s.BeginWithOptions(TransactionOptions{Isolation: ReadCommitted}); !errors.Is(err, ErrTooManyTransactions) {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrTooManyTransactions, err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Log directory locked by another store
+	reopened := reopenStoreForTest(t, s, WithMaxTransactions(1))
+	if _, err := s.Begin(); !errors.Is(err, ErrDirectoryLocked) {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrDirectoryLocked, err)
+	}
+	if err := s.WithTransaction(func(*Txn) error { return nil }); !errors.Is(err, ErrDirectoryLocked) {
+		t.Errorf("did not get expected error while running transaction. expected=%v, actual=%v", ErrDirectoryLocked, err)
+	}
+
+	// Store closed
+	if err := beginTxnForTest(t, reopened).Abort(); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Errorf("got an error while closing store: %v", err)
+	}
+	if _, err := reopened.Begin(); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrStoreClosed, err)
+	}
+	if err := reopened.WithTransaction(func(*Txn) error { return nil }); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("did not get expected error while running transaction. expected=%v, actual=%v", ErrStoreClosed, err)
+	}
+	if _, err := reopened.NewSession().Begin(TransactionOptions{}); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("did not get expected error while beginning transaction. expected=%v, actual=%v", ErrStoreClosed, err)
+	}
+
+	// Transactions begun without an error are not begun either
+	n := len(reopened.lm.log.Entry)
+	tid := reopened.BeginTransaction()
+	if err := reopened.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err == nil {
+		t.Errorf("found that transaction was begun on closed store")
+	}
+	if gotLenLog := len(reopened.lm.log.Entry); gotLenLog != n {
+		t.Errorf("did not get expected log length. expected=%d, actual=%d", n, gotLenLog)
+	}
+
+	// In-memory stores hold no lock on their log directories
+	s = newStoreForTest(t, WithInMemory(true))
+	if err := beginTxnForTest(t, s).Abort(); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+}
+
+func TestTxnFinished(t *testing.T) {
+	s := newStoreForTest(t)
+	for _, finish := range []func(*Txn) error{(*Txn).Commit, (*Txn).Abort} {
+		txn := beginTxnForTest(t, s)
+		if err := finish(txn); err != nil {
+			t.Errorf("got an error while finishing transaction: %v", err)
+		}
+
+		_, getErr := txn.Get(sampleKey1)
+		errs := []error{
+			getErr,
+			txn.Set(sampleKey1, CopyByteArray(sampleValue1)),
+			txn.Delete(sampleKey1),
+			txn.Commit(),
+			txn.Abort(),
+		}
+		for i, err := range errs {
+			if err != ErrTransactionFinished {
+				t.Errorf("did not get expected error for operation %d. expected=%v, actual=%v", i, ErrTransactionFinished, err)
+			}
+		}
+	}
+	checkStoreValue(t, s, sampleKey1, nil)
+}
+
+func TestWithTransaction(t *testing.T) {
+	s := newStoreForTest(t)
+	var tid TransactionID
+	errFn := errors.New("fn failed")
+	panicValue := "fn panicked"
+
+	// Success
+	if err := s.WithTransaction(func(txn *Txn) error {
+		return txn.Set(sampleKey1, CopyByteArray(sampleValue1))
+	}); err != nil {
+		t.Errorf("got an error while running transaction: %v", err)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Error
+	if err := s.WithTransaction(func(txn *Txn) error {
+		tid = txn.ID()
+		txn.Set(sampleKey1, CopyByteArray(sampleValue2))
+		return errFn
+	}); !errors.Is(err, errFn) {
+		t.Errorf("did not get expected error while running transaction. expected=%v, actual=%v", errFn, err)
+	}
+	if _, ok := s.lm.currMutexes[tid]; ok {
+		t.Error("found transaction in current mutexes map after error.")
+	}
+	if e := s.lm.log.Entry[len(s.lm.log.Entry)-1]; e.GetTid() != int64(tid) || e.GetEntryType() != pb.LogEntry_END {
+		t.Errorf("did not find END entry for aborted transaction. actual=%+v", e)
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+
+	// Panic
+	func() {
+		defer func() {
+			if r := recover(); r != panicValue {
+				t.Errorf("did not get expected panic. expected=%v, actual=%v", panicValue, r)
+			}
+		}()
+		s.WithTransaction(func(txn *Txn) error {
+			tid = txn.ID()
+			txn.Set(sampleKey1, CopyByteArray(sampleValue3))
+			panic(panicValue)
+		})
+	}()
+	if _, ok := s.lm.currMutexes[tid]; ok {
+		t.Error("found transaction in current mutexes map after panic.")
+	}
+	checkStoreValue(t, s, sampleKey1, sampleValue1)
+}
@@ -1,6 +1,9 @@
 package gostore
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // CopyByteArray returns a copy of src byte array
 func CopyByteArray(src []byte) []byte {
@@ -12,15 +15,49 @@ func CopyByteArray(src []byte) []byte {
 	return dst
 }
 
+// keyLock is the lock of a key in the store. Besides the read-write mutex, it
+// counts the read locks taken by transactions and the write locks they are
+// waiting for, so that a transaction can promote its read lock to a write
+// lock without releasing it in between (see rwMutexWrapper.upgrade).
+type keyLock struct {
+	sync.RWMutex
+	countLock sync.Mutex      // lock to synchronize the counts with the promotion of read locks
+	readers   int             // the number of read locks held (or being taken) by transactions
+	writers   int             // the number of write locks being waited for by transactions
+	upgrader  *rwMutexWrapper // the read lock being promoted, if any
+}
+
+// addReaders adds n to the number of read locks of the key.
+func (l *keyLock) addReaders(n int) {
+	l.countLock.Lock()
+	l.readers += n
+	l.countLock.Unlock()
+}
+
+// lockWriter takes the write lock of the key (which must not be read-locked
+// by the caller) by calling lock, and returns whether it was taken. Read locks
+// are not promoted while a transaction waits for the write lock.
+func (l *keyLock) lockWriter(lock func() bool) bool {
+	l.countLock.Lock()
+	l.writers++
+	l.countLock.Unlock()
+	locked := lock()
+	l.countLock.Lock()
+	l.writers--
+	l.countLock.Unlock()
+	return locked
+}
+
 // rwMutexWrapper is a thread-safe convenience wrapper for sync.RWMutex used in StoreMapValue.
 type rwMutexWrapper struct {
-	selfLock sync.Mutex    // Self Lock to synchronize lock and unlock operations.
-	smvLock  *sync.RWMutex // the lock being wrapped.
-	held     bool          // Whether the lock is held.
-	wAllowed bool          // Whether writes are allowed.
+	selfLock sync.Mutex // Self Lock to synchronize lock and unlock operations.
+	smvLock  *keyLock   // the lock being wrapped.
+	key      Key        // the key whose lock is wrapped.
+	held     bool       // Whether the lock is held.
+	wAllowed bool       // Whether writes are allowed.
 }
 
-func wrapRWMutex(l *sync.RWMutex) rwMutexWrapper {
+func wrapRWMutex(l *keyLock) rwMutexWrapper {
 	return rwMutexWrapper{smvLock: l}
 }
 
@@ -49,6 +86,7 @@ func (rw *rwMutexWrapper) rLock() {
 }
 
 func (rw *rwMutexWrapper) rLockUnsafe() {
+	rw.smvLock.addReaders(1)
 	rw.smvLock.RLock()
 	rw.held = true
 }
@@ -65,23 +103,15 @@ func (rw *rwMutexWrapper) rUnlock() {
 
 func (rw *rwMutexWrapper) rUnlockUnsafe() {
 	rw.smvLock.RUnlock()
+	rw.smvLock.addReaders(-1)
 	rw.held = false
 }
 
-func (rw *rwMutexWrapper) wLock() {
-	rw.selfLock.Lock()
-	defer rw.selfLock.Unlock()
-
-	if rw.held && rw.wAllowed {
-		return
-	}
-	rw.wLockUnsafe()
-}
-
-func (rw *rwMutexWrapper) wLockUnsafe() {
-	rw.smvLock.Lock()
-	rw.held = true
-	rw.wAllowed = true
+// wLock takes the write lock, waiting indefinitely. A read lock held is
+// promoted (see upgrade), which fails with ErrDeadlock if another transaction
+// is waiting to write the key.
+func (rw *rwMutexWrapper) wLock() error {
+	return rw.wLockTimeout(realClock{}, 0)
 }
 
 func (rw *rwMutexWrapper) wUnlock() {
@@ -100,15 +130,54 @@ func (rw *rwMutexWrapper) wUnlockUnsafe() {
 	rw.wAllowed = false
 }
 
-func (rw *rwMutexWrapper) promote() {
-	rw.selfLock.Lock()
-	defer rw.selfLock.Unlock()
-
-	if rw.wAllowed {
-		return
+// upgrade promotes the read lock held to a write lock without releasing it in
+// between, so that the key can not be written by another transaction after it
+// was read. It waits for at most timeout (if it is positive) for the other
+// transactions reading the key to release their read locks, and fails with
+// ErrDeadlock if another transaction is waiting to write the key (or to
+// promote its own read lock), since that transaction waits for this read
+// lock. If the read lock can not be promoted, it is still held.
+func (rw *rwMutexWrapper) upgrade(clock Clock, timeout time.Duration) error {
+	l := rw.smvLock
+	var err error
+	try := func() bool {
+		l.countLock.Lock()
+		defer l.countLock.Unlock()
+		if l.writers > 0 || (l.upgrader != nil && l.upgrader != rw) {
+			err = ErrDeadlock
+			return true
+		}
+		if l.readers > 1 {
+			l.upgrader = rw
+			return false
+		}
+		// No other transaction can take the lock in between, since taking a
+		// lock needs countLock first; a read that is not by a transaction
+		// (and so is not counted) is waited for.
+		l.upgrader = nil
+		l.readers--
+		l.RUnlock()
+		l.Lock()
+		return true
 	}
-	rw.rUnlockUnsafe()
-	rw.wLockUnsafe()
+	lock := func() {
+		for !try() {
+			clock.Sleep(time.Millisecond)
+		}
+	}
+	if !lockWithTimeout(clock, try, lock, timeout) {
+		err = ErrTimeout
+	}
+	if err != nil {
+		l.countLock.Lock()
+		if l.upgrader == rw {
+			l.upgrader = nil
+		}
+		l.countLock.Unlock()
+		return err
+	}
+	rw.wAllowed = true
+	return nil
 }
 
 func (rw *rwMutexWrapper) unlock() {
@@ -125,3 +194,114 @@ func (rw *rwMutexWrapper) unlock() {
 		rw.rUnlockUnsafe()
 	}
 }
+
+// lockWithTimeout takes a lock by calling try until it succeeds, for at most
+// timeout as measured by clock, and returns whether the lock was taken. If
+// timeout is not positive, it takes the lock by calling lock instead, waiting
+// indefinitely.
+func lockWithTimeout(clock Clock, try func() bool, lock func(), timeout time.Duration) bool {
+	if timeout <= 0 {
+		lock()
+		return true
+	}
+	deadline := clock.Now().Add(timeout)
+	delay := 100 * time.Microsecond
+	for !try() {
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return false
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		clock.Sleep(delay)
+		if delay < 10*time.Millisecond {
+			delay *= 2
+		}
+	}
+	return true
+}
+
+// rLockTimeout is rLock, but waits for at most timeout (if it is positive) and
+// returns whether the lock is held.
+func (rw *rwMutexWrapper) rLockTimeout(clock Clock, timeout time.Duration) bool {
+	rw.selfLock.Lock()
+	defer rw.selfLock.Unlock()
+
+	if rw.held {
+		return true
+	}
+	rw.smvLock.addReaders(1)
+	if !lockWithTimeout(clock, rw.smvLock.TryRLock, rw.smvLock.RLock, timeout) {
+		rw.smvLock.addReaders(-1)
+		return false
+	}
+	rw.held = true
+	return true
+}
+
+// wLockTimeout is wLock, but waits for at most timeout (if it is positive),
+// failing with ErrTimeout if the lock can not be taken in time. If a read lock
+// was being promoted and the write lock could not be taken, the read lock is
+// still held.
+func (rw *rwMutexWrapper) wLockTimeout(clock Clock, timeout time.Duration) error {
+	rw.selfLock.Lock()
+	defer rw.selfLock.Unlock()
+
+	if rw.held && rw.wAllowed {
+		return nil
+	}
+	if rw.held {
+		return rw.upgrade(clock, timeout)
+	}
+	if !rw.smvLock.lockWriter(func() bool {
+		return lockWithTimeout(clock, rw.smvLock.TryLock, rw.smvLock.Lock, timeout)
+	}) {
+		return ErrTimeout
+	}
+	rw.held = true
+	rw.wAllowed = true
+	return nil
+}
+
+// tryRLock is rLock, but returns false instead of waiting if the lock can not
+// be taken immediately.
+func (rw *rwMutexWrapper) tryRLock() bool {
+	rw.selfLock.Lock()
+	defer rw.selfLock.Unlock()
+
+	if rw.held {
+		return true
+	}
+	rw.smvLock.addReaders(1)
+	if !rw.smvLock.TryRLock() {
+		rw.smvLock.addReaders(-1)
+		return false
+	}
+	rw.held = true
+	return true
+}
+
+// tryWLock is wLock, but returns false instead of waiting if the lock can not
+// be taken immediately.
+func (rw *rwMutexWrapper) tryWLock() bool {
+	rw.selfLock.Lock()
+	defer rw.selfLock.Unlock()
+
+	if rw.held && rw.wAllowed {
+		return true
+	}
+	if rw.held {
+		return false
+	}
+	// The lock is not taken while a read lock is being promoted (see upgrade).
+	rw.smvLock.countLock.Lock()
+	locked := rw.smvLock.TryLock()
+	rw.smvLock.countLock.Unlock()
+	if !locked {
+		return false
+	}
+	rw.held = true
+	rw.wAllowed = true
+	return true
+}
@@ -2,7 +2,9 @@ package gostore
 
 import (
 	"bytes"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestCopyByteArray(t *testing.T) {
@@ -21,3 +23,69 @@ func TestCopyByteArray(t *testing.T) {
 		t.Error("copy of nil was not nil")
 	}
 }
+
+// waitForLockForTest waits until cond holds for the counts of lock l.
+func waitForLockForTest(l *keyLock, cond func() bool) {
+	for {
+		l.countLock.Lock()
+		ok := cond()
+		l.countLock.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRWMutexWrapperUpgrade(t *testing.T) {
+	var l keyLock
+	rw1, rw2, rw3 := wrapRWMutex(&l), wrapRWMutex(&l), wrapRWMutex(&l)
+	rw1.rLock()
+	rw2.rLock()
+
+	// The promotion times out while another transaction reads the key, and
+	// the read lock is kept
+	if err := rw1.wLockTimeout(newFakeClock(), 10*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("did not get expected error while promoting read lock. expected=%v, actual=%v", ErrTimeout, err)
+	}
+	if !rw1.rLocked() {
+		t.Error("found that read lock was released when its promotion timed out.")
+	}
+
+	// The promotion waits for the other read lock to be released, and the
+	// promotion of the other read lock deadlocks
+	promoted := make(chan error, 1)
+	go func() { promoted <- rw1.wLock() }()
+	waitForLockForTest(&l, func() bool { return l.upgrader == &rw1 })
+	if err := rw2.wLock(); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("did not get expected error while promoting read lock. expected=%v, actual=%v", ErrDeadlock, err)
+	}
+	if !rw2.rLocked() {
+		t.Error("found that read lock was released when its promotion failed.")
+	}
+	rw2.unlock()
+	if err := <-promoted; err != nil {
+		t.Errorf("got an error while promoting read lock: %v", err)
+	}
+	if !rw1.wLocked() {
+		t.Error("did not find write lock held after promotion.")
+	}
+	rw1.unlock()
+
+	// A promotion deadlocks with a transaction waiting to write the key
+	rw1.rLock()
+	written := make(chan error, 1)
+	go func() { written <- rw3.wLock() }()
+	waitForLockForTest(&l, func() bool { return l.writers == 1 })
+	if err := rw1.wLock(); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("did not get expected error while promoting read lock. expected=%v, actual=%v", ErrDeadlock, err)
+	}
+	rw1.unlock()
+	if err := <-written; err != nil {
+		t.Errorf("got an error while taking write lock: %v", err)
+	}
+	rw3.unlock()
+	if l.readers != 0 || l.writers != 0 || l.upgrader != nil {
+		t.Errorf("did not get expected lock counts. readers=%d, writers=%d, upgrader=%v", l.readers, l.writers, l.upgrader)
+	}
+}
@@ -0,0 +1,37 @@
+package gostore
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Values are byte strings, and the store does not interpret them. The helpers
+// below define the encodings of other types as values, so that the code
+// storing a value and the code reading it agree: strings are stored as their
+// bytes, and integers as their decimal representation (so they can also be
+// read as strings).
+
+// StringValue returns the value holding string s.
+func StringValue(s string) Value {
+	return Value(s)
+}
+
+// String returns the value as a string.
+func (v Value) String() string {
+	return string(v)
+}
+
+// IntValue returns the value holding integer i.
+func IntValue(i int64) Value {
+	return Value(strconv.AppendInt(nil, i, 10))
+}
+
+// Int returns the integer held by the value. ErrInvalidValue is returned if the
+// value is not an integer encoded by IntValue.
+func (v Value) Int() (int64, error) {
+	i, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not an integer", ErrInvalidValue, v)
+	}
+	return i, nil
+}
@@ -0,0 +1,67 @@
+package gostore
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestStringValue(t *testing.T) {
+	for _, s := range []string{"", "abc", "a\x00b"} {
+		v := StringValue(s)
+		if v == nil {
+			t.Errorf("got nil value for string %q.", s)
+		}
+		if got := v.String(); got != s {
+			t.Errorf("did not get back the correct string. expected=%q, actual=%q", s, got)
+		}
+	}
+}
+
+func TestIntValue(t *testing.T) {
+	testCases := []struct {
+		i    int64
+		want Value
+	}{
+		{0, Value("0")},
+		{42, Value("42")},
+		{-7, Value("-7")},
+		{math.MaxInt64, Value("9223372036854775807")},
+		{math.MinInt64, Value("-9223372036854775808")},
+	}
+	for _, tc := range testCases {
+		v := IntValue(tc.i)
+		if !bytes.Equal(v, tc.want) {
+			t.Errorf("did not get expected value for %d. expected=%q, actual=%q", tc.i, tc.want, v)
+		}
+		if got, err := v.Int(); err != nil {
+			t.Errorf("got an error while converting value %q: %v", v, err)
+		} else if got != tc.i {
+			t.Errorf("did not get back the correct integer. expected=%d, actual=%d", tc.i, got)
+		}
+	}
+}
+
+func TestIntValueMalformed(t *testing.T) {
+	for _, v := range []Value{nil, {}, Value("abc"), Value("12a"), Value(" 12"), Value("1.5"), Value("9223372036854775808"), {0, 1}} {
+		if _, err := v.Int(); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("did not get expected error for value %q. expected=%v, actual=%v", v, ErrInvalidValue, err)
+		}
+	}
+}
+
+func TestIntValueStore(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, IntValue(-12))
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		tid := beginForTest(s)
+		v, err := s.Get(tid, sampleKey1)
+		if err != nil {
+			t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+		} else if i, err := v.Int(); err != nil || i != -12 {
+			t.Errorf("did not get back the correct integer. expected=%d, actual=(%d, %v)", -12, i, err)
+		}
+		s.Abort(tid)
+	}
+}
@@ -0,0 +1,78 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// VerifyReport is the result of cross-checking the state of a store against
+// its log.
+type VerifyReport struct {
+	Keys       int              // the number of keys checked
+	Mismatches []VerifyMismatch // the keys whose state differs from the state implied by the log, in order
+}
+
+// OK returns whether no mismatches were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// VerifyMismatch describes a key whose state in a store differs from the state
+// implied by its log.
+type VerifyMismatch struct {
+	Key        Key
+	Value      Value  // the value in the store (nil if the key does not exist)
+	LogValue   Value  // the value implied by the log (nil if the key does not exist)
+	Version    uint64 // the version in the store
+	LogVersion uint64 // the version implied by the log
+}
+
+// verify replays the committed entries of the log into a shadow store and
+// compares it with the store. It fails if transactions are running, since the
+// store then holds their uncommitted writes.
+func (lm *logManager) verify() (*VerifyReport, error) {
+	lm.stateLock.Lock()
+	if n := len(lm.transactions); n > 0 {
+		lm.stateLock.Unlock()
+		return nil, fmt.Errorf("could not verify store: %d transactions are running", n)
+	}
+	stored := make(storeMap, len(lm.store))
+	for k, smv := range lm.store {
+		if smv.value != nil {
+			stored[k] = &storeMapValue{value: CopyByteArray(smv.value), version: smv.version}
+		}
+	}
+	lm.stateLock.Unlock()
+
+	lm.logLock.Lock()
+	logged := replayLogEntries(lm.log.Entry)
+	lm.logLock.Unlock()
+
+	keys := make([]Key, 0, len(stored))
+	for k := range stored {
+		keys = append(keys, k)
+	}
+	for k := range logged {
+		if _, ok := stored[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	report := &VerifyReport{Keys: len(keys)}
+	for _, k := range keys {
+		var m VerifyMismatch
+		if smv, ok := stored[k]; ok {
+			m.Value, m.Version = smv.value, smv.version
+		}
+		if smv, ok := logged[k]; ok {
+			m.LogValue, m.LogVersion = smv.value, smv.version
+		}
+		if (m.Value == nil) != (m.LogValue == nil) || !bytes.Equal(m.Value, m.LogValue) || m.Version != m.LogVersion {
+			m.Key = k
+			report.Mismatches = append(report.Mismatches, m)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,70 @@
+package gostore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	s := newStoreForTest(t)
+	overwriteForTest(t, s, 3, sampleKey1, sampleKey2)
+	setForTest(t, s, sampleKey3, sampleValue3)
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey3, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Set(tid, sampleKey4, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey4, err)
+	}
+
+	// Verification fails while transactions are running
+	if _, err := s.Verify(); err == nil {
+		t.Errorf("did not get an error while verifying store with running transactions.")
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// A clean store reports no mismatches
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		report, err := s.Verify()
+		if err != nil {
+			t.Fatalf("got an error while verifying store: %v", err)
+		}
+		if !report.OK() || report.Keys != 3 {
+			t.Errorf("did not get expected report for clean store. actual=%+v", report)
+		}
+	}
+
+	// Corruptions of the store are reported
+	s.lm.store[sampleKey1].value = CopyByteArray(sampleValue1)
+	s.lm.store[sampleKey2].version++
+	delete(s.lm.store, sampleKey3)
+	s.lm.store[sampleKey5] = &storeMapValue{value: CopyByteArray(sampleValue2)}
+	report, err := s.Verify()
+	if err != nil {
+		t.Fatalf("got an error while verifying store: %v", err)
+	}
+	tests := []struct {
+		k            Key
+		wantValue    Value
+		wantLogValue Value
+		wantVersions [2]uint64
+	}{
+		{sampleKey1, sampleValue1, Value("key_1_2"), [2]uint64{3, 3}},
+		{sampleKey2, Value("key_2_2"), Value("key_2_2"), [2]uint64{4, 3}},
+		{sampleKey3, nil, sampleValue3, [2]uint64{0, 1}},
+		{sampleKey5, sampleValue2, nil, [2]uint64{0, 0}},
+	}
+	if report.OK() || len(report.Mismatches) != len(tests) {
+		t.Fatalf("did not get expected number of mismatches. expected=%d, actual=%+v", len(tests), report.Mismatches)
+	}
+	for i, test := range tests {
+		m := report.Mismatches[i]
+		if m.Key != test.k || !bytes.Equal(m.Value, test.wantValue) || !bytes.Equal(m.LogValue, test.wantLogValue) ||
+			m.Version != test.wantVersions[0] || m.LogVersion != test.wantVersions[1] {
+			t.Errorf("did not get expected mismatch. expected=(%s, %v, %v, %v), actual=%+v",
+				test.k, test.wantValue, test.wantLogValue, test.wantVersions, m)
+		}
+	}
+}
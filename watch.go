@@ -0,0 +1,134 @@
+package gostore
+
+import "sync"
+
+// WatchEvent describes a committed change to a watched key.
+type WatchEvent struct {
+	Key     Key
+	Value   Value // the new value of the key (nil if deleted)
+	Deleted bool  // whether the key was deleted
+}
+
+// watchBufferSize is the number of undelivered events buffered for each
+// watcher. When the buffer is full, the oldest event is dropped so that slow
+// consumers never block commits.
+var watchBufferSize = 16
+
+type watcher struct {
+	lock   sync.Mutex      // lock to synchronize sends with close
+	c      chan WatchEvent // the channel on which events are delivered
+	closed bool            // whether c has been closed
+}
+
+func newWatcher() *watcher {
+	return &watcher{c: make(chan WatchEvent, watchBufferSize)}
+}
+
+// notify delivers e without blocking, dropping the oldest buffered event if
+// the buffer is full.
+func (w *watcher) notify(e WatchEvent) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return
+	}
+	for {
+		select {
+		case w.c <- e:
+			return
+		default:
+		}
+		select {
+		case <-w.c:
+		default:
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.c)
+	}
+}
+
+type watchersMap map[Key]map[*watcher]struct{}
+
+func (lm *logManager) watch(k Key) (<-chan WatchEvent, func()) {
+	w := newWatcher()
+	lm.watchersLock.Lock()
+	ws, ok := lm.watchers[k]
+	if !ok {
+		ws = make(map[*watcher]struct{})
+		lm.watchers[k] = ws
+	}
+	ws[w] = struct{}{}
+	lm.watchersLock.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			lm.watchersLock.Lock()
+			delete(lm.watchers[k], w)
+			if len(lm.watchers[k]) == 0 {
+				delete(lm.watchers, k)
+			}
+			lm.watchersLock.Unlock()
+			w.close()
+		})
+	}
+	return w.c, cancel
+}
+
+// watchedValues returns the stored values that the keys modified by
+// transaction ts that have watchers are being committed with, with chunked
+// values resolved (a chunk that can not be read is left as its reference). It
+// is called before the commit is applied, while ts still holds the write locks
+// on the keys, so that their chunks are not read with stateLock held. Watchers
+// added after it is called are not notified of the commit.
+func (lm *logManager) watchedValues(ts *transactionState) map[Key]Value {
+	var values map[Key]Value
+	lm.stateLock.Lock()
+	lm.watchersLock.Lock()
+	for k := range ts.modifiedKeys {
+		if len(lm.watchers[k]) == 0 {
+			continue
+		}
+		v, staged := ts.writeBuffer[k]
+		if smv, ok := lm.store[k]; ok && !staged {
+			v = smv.value
+		}
+		if values == nil {
+			values = make(map[Key]Value)
+		}
+		values[k] = v
+	}
+	lm.watchersLock.Unlock()
+	lm.stateLock.Unlock()
+
+	for k, v := range values {
+		if resolved, err := lm.resolveChunk(v); err == nil {
+			values[k] = resolved
+		}
+	}
+	return values
+}
+
+// notifyWatchers delivers an event for a committed change of key k to value v
+// (nil if the key was deleted) to all watchers of k.
+func (lm *logManager) notifyWatchers(k Key, v Value) {
+	lm.watchersLock.Lock()
+	defer lm.watchersLock.Unlock()
+
+	for w := range lm.watchers[k] {
+		w.notify(WatchEvent{
+			Key:     k,
+			Value:   CopyByteArray(v),
+			Deleted: v == nil,
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package gostore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var watchTimeout = time.Second
+
+func receiveWatchEvent(t *testing.T, c <-chan WatchEvent) (e WatchEvent, ok bool) {
+	select {
+	case e, ok = <-c:
+		if !ok {
+			t.Error("found that watch channel was closed.")
+		}
+	case <-time.After(watchTimeout):
+		t.Error("did not receive watch event.")
+	}
+	return
+}
+
+func TestWatch(t *testing.T) {
+	s := newStoreForTest(t)
+	c, cancel := s.Watch(sampleKey1)
+	defer cancel()
+
+	// Set operation
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if e, ok := receiveWatchEvent(t, c); ok {
+		if e.Key != sampleKey1 || e.Deleted || !bytes.Equal(e.Value, sampleValue1) {
+			t.Errorf("did not get the expected watch event. actual=%+v", e)
+		}
+	}
+
+	// Delete operation
+	tid = s.BeginTransaction()
+	if err := s.Delete(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if e, ok := receiveWatchEvent(t, c); ok {
+		if e.Key != sampleKey1 || !e.Deleted || e.Value != nil {
+			t.Errorf("did not get the expected watch event. actual=%+v", e)
+		}
+	}
+
+	// No other events
+	select {
+	case e := <-c:
+		t.Errorf("got an unexpected watch event: %+v", e)
+	default:
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	s := newStoreForTest(t)
+	c, cancel := s.Watch(sampleKey1)
+	cancel()
+	cancel()
+
+	if _, ok := <-c; ok {
+		t.Error("found that watch channel was not closed after cancel.")
+	}
+	if _, ok := s.lm.watchers[sampleKey1]; ok {
+		t.Error("found watcher for key after cancel.")
+	}
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	s := newStoreForTest(t)
+	c, cancel := s.Watch(sampleKey1)
+	defer cancel()
+
+	numCommits := watchBufferSize + 5
+	for i := 0; i < numCommits; i++ {
+		tid := s.BeginTransaction()
+		if err := s.Set(tid, sampleKey1, Value{byte(i)}); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		if err := s.Commit(tid); err != nil {
+			t.Errorf("got an error while committing transaction: %v", err)
+		}
+	}
+
+	if gotLen := len(c); gotLen != watchBufferSize {
+		t.Errorf("did not get expected number of buffered events. expected=%d, actual=%d", watchBufferSize, gotLen)
+	}
+	// The oldest events should have been dropped.
+	wantValue := Value{byte(numCommits - watchBufferSize)}
+	if e := <-c; !bytes.Equal(e.Value, wantValue) {
+		t.Errorf("did not get the expected oldest event value. expected=%v, actual=%v", wantValue, e.Value)
+	}
+}
+
+func TestWatchOnlyAfterCommit(t *testing.T) {
+	s := newStoreForTest(t)
+	c, cancel := s.Watch(sampleKey1)
+	defer cancel()
+
+	// Aborted set operation
+	tid := s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue1)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	select {
+	case e := <-c:
+		t.Errorf("got a watch event before commit: %+v", e)
+	default:
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+	select {
+	case e := <-c:
+		t.Errorf("got a watch event for aborted transaction: %+v", e)
+	default:
+	}
+
+	// Committed set operation
+	tid = s.BeginTransaction()
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+	if e, ok := receiveWatchEvent(t, c); ok && !bytes.Equal(e.Value, sampleValue2) {
+		t.Errorf("did not get the expected watch event value. expected=%v, actual=%v", sampleValue2, e.Value)
+	}
+	if n := len(c); n != 0 {
+		t.Errorf("got %d unexpected watch events.", n)
+	}
+}
+
+func TestWatchChunkStore(t *testing.T) {
+	threshold := 1024
+	s := newStoreForTest(t, WithChunkStore(threshold))
+	c, cancel := s.Watch(sampleKey1)
+	defer cancel()
+
+	// Watchers get the chunked value, not its chunk reference
+	large := bytes.Repeat([]byte("large value "), 1<<12)
+	setForTest(t, s, sampleKey1, large)
+	if e, ok := receiveWatchEvent(t, c); ok {
+		if e.Key != sampleKey1 || e.Deleted || !bytes.Equal(e.Value, large) {
+			t.Errorf("did not get the expected watch event. expected %d bytes, actual=%d bytes (deleted=%v)", len(large), len(e.Value), e.Deleted)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package gostore
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+)
+
+// A transaction that defers its writes holds them in a write buffer (the
+// writeBuffer of its transactionState) until it is committed, instead of
+// applying them to the store immediately. The write lock on each key is still
+// taken when it is first written. Committed values are never modified in
+// place, so the log entries of deferred writes can refer to them instead of
+// copying them, and an abort only needs to discard the buffer.
+
+// stageValue buffers the update of key k to value v with metadata meta (or the
+// deletion of k if v is nil) in transaction tid. It returns the old and new
+// values and metadata of k to be logged.
+func (lm *logManager) stageValue(cm currentMutexesMap, ts *transactionState, k Key, v Value, meta *pb.Meta) (oldValue, newValue []byte, oldMeta, newMeta *pb.Meta, err error) {
+	lm.stateLock.Lock()
+	smv, err := lm.store.storeMapValue(k, true)
+	if err != nil {
+		lm.stateLock.Unlock()
+		return nil, nil, nil, nil, fmt.Errorf("could not retrieve value: %w", err)
+	}
+	rw := cm.getWrappedRWMutex(k, smv)
+	lm.stateLock.Unlock()
+
+	if err := lm.wLock(ts, rw, k); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	if staged, ok := ts.writeBuffer[k]; ok {
+		oldValue, oldMeta = staged, ts.metaBuffer[k]
+	} else {
+		oldValue, oldMeta = smv.value, smv.meta
+	}
+	newValue, newMeta = CopyByteArray(v), resolveMeta(meta, oldMeta, v)
+	ts.writeBuffer[k] = newValue
+	ts.metaBuffer[k] = newMeta
+	return
+}
+
+// commitWriteBuffer applies the buffered writes of a transaction to the store.
+// It must be called with stateLock held.
+func (lm *logManager) commitWriteBuffer(ts *transactionState) {
+	for k, v := range ts.writeBuffer {
+		if smv, ok := lm.store[k]; ok {
+			lm.setStoreValue(k, smv, v, resolveMeta(ts.metaBuffer[k], smv.meta, v))
+		}
+	}
+	ts.writeBuffer = nil
+	ts.metaBuffer = nil
+}
+
+// discardWriteBuffer discards the buffered writes of a transaction. Keys that
+// were created only to be locked are left in the store, since other
+// transactions may be waiting for their locks, until tombstones are collected.
+// It must be called with stateLock held.
+func (lm *logManager) discardWriteBuffer(ts *transactionState) {
+	ts.writeBuffer = nil
+	ts.metaBuffer = nil
+}
+
+// abortDeferredTransaction aborts a transaction that defers its writes. Since
+// the store was never modified, no updates need to be undone, and recovery
+// ignores the UPDATE entries of transactions that were aborted and ended.
+func (lm *logManager) abortDeferredTransaction(tid TransactionID, cm currentMutexesMap, ts *transactionState) error {
+	lm.stateLock.Lock()
+	aborted := ts.aborted
+	ts.aborted = true
+	lm.stateLock.Unlock()
+	if !aborted {
+		lm.addTransactionLogEntry(tid, ts, &pb.LogEntry{
+			Tid:       proto.Int64(int64(tid)),
+			EntryType: pb.LogEntry_ABORT.Enum(),
+		})
+	}
+
+	lm.addLogEntry(&pb.LogEntry{
+		Tid:       proto.Int64(int64(tid)),
+		EntryType: pb.LogEntry_END.Enum(),
+	})
+
+	// Flush out log
+	lm.flushLog()
+
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+	lm.discardWriteBuffer(ts)
+	lm.endTransaction(tid, cm, ts)
+	return nil
+}
@@ -0,0 +1,218 @@
+package gostore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/mDibyo/gostore/pb"
+	"io/ioutil"
+	"testing"
+)
+
+var deferWritesOptions = TransactionOptions{DeferWrites: true}
+
+func TestDeferredWrites(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey3, sampleValue3)
+
+	tid := s.BeginTransactionWithOptions(deferWritesOptions)
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Delete(tid, sampleKey3); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey3, err)
+	}
+	if err := s.Delete(tid, sampleKey3); err == nil {
+		t.Errorf("did not get expected error when deleting deleted key='%s'", sampleKey3)
+	}
+	// Check storeMap is not modified before commit
+	if smv, ok := s.lm.store[sampleKey1]; !ok || !bytes.Equal(smv.value, sampleValue1) {
+		t.Errorf("found that value for key='%s' was modified before commit.", sampleKey1)
+	}
+	if _, ok := s.lm.store[sampleKey3]; !ok {
+		t.Errorf("found that key='%s' was deleted before commit.", sampleKey3)
+	}
+	// Check reads see the transaction's own writes
+	if gotV, err := s.Get(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while getting value for key='%s': %v", sampleKey1, err)
+	} else if !bytes.Equal(gotV, sampleValue2) {
+		t.Errorf("did not get back the buffered value. expected=%v, actual=%v.", sampleValue2, gotV)
+	}
+	if _, err := s.Get(tid, sampleKey3); err == nil {
+		t.Errorf("did not get expected error when getting deleted key='%s'", sampleKey3)
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	wantStore := map[Key]Value{
+		sampleKey1: sampleValue2,
+		sampleKey2: sampleValue2,
+		sampleKey3: nil,
+	}
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		for k, v := range wantStore {
+			checkStoreValue(t, s, k, v)
+		}
+	}
+}
+
+func TestDeferredWritesAbort(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+
+	tid := s.BeginTransactionWithOptions(deferWritesOptions)
+	lenLogBefore := len(s.lm.log.Entry)
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Set(tid, sampleKey2, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Delete(tid, sampleKey1); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Abort(tid); err != nil {
+		t.Errorf("got an error while aborting transaction: %v", err)
+	}
+
+	// Check log: no UNDO entries are written
+	wantEntryTypes := []pb.LogEntry_LogEntryType{
+		pb.LogEntry_UPDATE, pb.LogEntry_UPDATE, pb.LogEntry_UPDATE, pb.LogEntry_ABORT, pb.LogEntry_END,
+	}
+	gotEntries := s.lm.log.Entry[lenLogBefore:]
+	if len(gotEntries) != len(wantEntryTypes) {
+		t.Errorf("did not get expected log length. expected=%d, actual=%d.", len(wantEntryTypes), len(gotEntries))
+	} else {
+		for i, e := range gotEntries {
+			if e.GetEntryType() != wantEntryTypes[i] {
+				t.Errorf("did not get expected log entry type. expected=%v, actual=%v", wantEntryTypes[i], e.GetEntryType())
+			}
+		}
+	}
+	// Check currMutexes
+	if _, ok := s.lm.currMutexes[tid]; ok {
+		t.Error("found transaction in current mutexes map.")
+	}
+
+	for _, s := range []*Store{s, reopenStoreForTest(t, s)} {
+		if smv, ok := s.lm.store[sampleKey2]; ok && smv.value != nil {
+			t.Errorf("found key='%s' in storeMap after abort.", sampleKey2)
+		}
+		checkStoreValue(t, s, sampleKey1, sampleValue1)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	s := newStoreForTest(t)
+	setForTest(t, s, sampleKey1, sampleValue1)
+	setForTest(t, s, sampleKey2, sampleValue1)
+
+	// UPDATE entries are smaller without old values
+	updateSizes := make(map[bool]int)
+	for _, bulkLoad := range []bool{false, true} {
+		tid := s.BeginTransactionWithOptions(TransactionOptions{BulkLoad: bulkLoad})
+		if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+		}
+		s.lm.logLock.Lock()
+		begin := s.lm.log.Entry[len(s.lm.log.Entry)-2]
+		update := s.lm.log.Entry[len(s.lm.log.Entry)-1]
+		s.lm.logLock.Unlock()
+		if begin.GetEntryType() != pb.LogEntry_BEGIN || begin.GetBulkLoad() != bulkLoad {
+			t.Errorf("did not get expected BEGIN entry. bulk load=%v, actual=%v", bulkLoad, begin)
+		}
+		if bulkLoad && (update.OldValue != nil || update.OldMeta != nil) {
+			t.Errorf("found old value in UPDATE entry of bulk load: %v", update)
+		}
+		updateSizes[bulkLoad] = proto.Size(update)
+		if err := s.Abort(tid); err != nil {
+			t.Errorf("got an error while aborting transaction: %v", err)
+		}
+	}
+	if updateSizes[true] >= updateSizes[false] {
+		t.Errorf("found that UPDATE entry of bulk load was not smaller. bulk load=%d, regular=%d", updateSizes[true], updateSizes[false])
+	}
+
+	// Committed bulk loads are applied, and can not be prepared
+	tid := s.BeginTransactionWithOptions(TransactionOptions{BulkLoad: true})
+	if err := s.Set(tid, sampleKey1, CopyByteArray(sampleValue2)); err != nil {
+		t.Errorf("got an error while setting value for key='%s': %v", sampleKey1, err)
+	}
+	if err := s.Delete(tid, sampleKey2); err != nil {
+		t.Errorf("got an error while deleting key='%s': %v", sampleKey2, err)
+	}
+	if err := s.Prepare(tid); err == nil {
+		t.Errorf("did not get an error while preparing bulk load.")
+	}
+	if err := s.Commit(tid); err != nil {
+		t.Errorf("got an error while committing transaction: %v", err)
+	}
+
+	// Bulk loads that are not committed are discarded by recovery
+	tid = s.BeginTransactionWithOptions(TransactionOptions{BulkLoad: true})
+	for _, k := range []Key{sampleKey1, sampleKey3} {
+		if err := s.Set(tid, k, CopyByteArray(sampleValue3)); err != nil {
+			t.Errorf("got an error while setting value for key='%s': %v", k, err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Errorf("got an error while flushing log: %v", err)
+	}
+	s.lm.logLock.Lock()
+	lsn := int64(s.lm.nextLSN - 1)
+	s.lm.logLock.Unlock()
+	if v, err := s.GetAsOf(sampleKey1, lsn); err != nil {
+		t.Errorf("got an error while getting value for key='%s' as of LSN %d: %v", sampleKey1, lsn, err)
+	} else if !bytes.Equal(v, sampleValue2) {
+		t.Errorf("did not get back the correct value. expected=%v, actual=%v.", sampleValue2, v)
+	}
+	reopened := reopenStoreForTest(t, s)
+	if info := reopened.RecoveryInfo(); len(info.RolledBack) != 1 || info.RolledBack[0] != tid {
+		t.Errorf("did not get expected rolled back transactions. expected=%v, actual=%v", []TransactionID{tid}, info.RolledBack)
+	}
+	for _, s := range []*Store{reopened, reopenStoreForTest(t, reopened)} {
+		checkStoreValue(t, s, sampleKey1, sampleValue2)
+		checkStoreValue(t, s, sampleKey2, nil)
+		checkStoreValue(t, s, sampleKey3, nil)
+	}
+}
+
+func benchmarkSetAbort(b *testing.B, opts TransactionOptions) {
+	dir, err := ioutil.TempDir(testLogDir, "bench_")
+	if err != nil {
+		b.Fatalf("could not create log directory: %v", err)
+	}
+	s, err := NewStore(dir)
+	if err != nil {
+		b.Fatalf("could not create store instance: %v", err)
+	}
+	value := bytes.Repeat(sampleValue1, 1000)
+	tid := s.BeginTransaction()
+	for i := 0; i < 10; i++ {
+		s.Set(tid, Key(fmt.Sprintf("key_%d", i)), CopyByteArray(value))
+	}
+	s.Commit(tid)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		tid := s.BeginTransactionWithOptions(opts)
+		for i := 0; i < 10; i++ {
+			s.Set(tid, Key(fmt.Sprintf("key_%d", i)), value)
+		}
+		s.Abort(tid)
+	}
+}
+
+func BenchmarkSetAbort(b *testing.B) {
+	benchmarkSetAbort(b, TransactionOptions{})
+}
+
+func BenchmarkSetAbortDeferWrites(b *testing.B) {
+	benchmarkSetAbort(b, deferWritesOptions)
+}